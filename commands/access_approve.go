@@ -0,0 +1,88 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var accessApproveFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "access approve" | yellow }} approves the pending access request; the user is linked to the requested host account until the requested duration passes, and the link is removed after expired.
+  * {{ "$ sault access approve 5f2b1c9a" | magenta }}
+		`,
+		nil,
+	)
+
+	accessApproveFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "access approve",
+		Name:         "approve",
+		Help:         "approve the access request",
+		Usage:        "<request id>",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseAccessDecisionCommandFlags,
+	}
+
+	sault.Commands[accessApproveFlagsTemplate.ID] = &accessApproveCommand{}
+}
+
+type accessApproveCommand struct{}
+
+func (c *accessApproveCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var request saultregistry.AccessRequestRegistry
+	_, err = runCommand(
+		allFlags[0],
+		accessApproveFlagsTemplate.ID,
+		thisFlags.Values["RequestID"].(string),
+		&request,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printAccessRequestsData(
+		"access-approved",
+		[]saultregistry.AccessRequestRegistry{request},
+		nil,
+	))
+
+	return nil
+}
+
+func (c *accessApproveCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var id string
+	err = msg.GetData(&id)
+	if err != nil {
+		return err
+	}
+
+	var request saultregistry.AccessRequestRegistry
+	if request, err = registry.ApproveAccessRequest(id, user.ID); err != nil {
+		return
+	}
+
+	registry.Save()
+
+	log.Debugf("access request approved by '%s': %s", user.ID, request)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		request,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
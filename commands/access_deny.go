@@ -0,0 +1,88 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var accessDenyFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "access deny" | yellow }} denies the pending access request.
+  * {{ "$ sault access deny 5f2b1c9a" | magenta }}
+		`,
+		nil,
+	)
+
+	accessDenyFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "access deny",
+		Name:         "deny",
+		Help:         "deny the access request",
+		Usage:        "<request id>",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseAccessDecisionCommandFlags,
+	}
+
+	sault.Commands[accessDenyFlagsTemplate.ID] = &accessDenyCommand{}
+}
+
+type accessDenyCommand struct{}
+
+func (c *accessDenyCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var request saultregistry.AccessRequestRegistry
+	_, err = runCommand(
+		allFlags[0],
+		accessDenyFlagsTemplate.ID,
+		thisFlags.Values["RequestID"].(string),
+		&request,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printAccessRequestsData(
+		"access-denied",
+		[]saultregistry.AccessRequestRegistry{request},
+		nil,
+	))
+
+	return nil
+}
+
+func (c *accessDenyCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var id string
+	err = msg.GetData(&id)
+	if err != nil {
+		return err
+	}
+
+	var request saultregistry.AccessRequestRegistry
+	if request, err = registry.DenyAccessRequest(id, user.ID); err != nil {
+		return
+	}
+
+	registry.Save()
+
+	log.Debugf("access request denied by '%s': %s", user.ID, request)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		request,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
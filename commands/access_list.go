@@ -0,0 +1,98 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var accessListFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "access list" | yellow }} shows the pending and approved access requests. With {{ "-all" | yellow }}, the denied and expired requests are also shown. The user, who is not admin, can see only the own requests.
+		`,
+		nil,
+	)
+
+	accessListFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "access list",
+		Name:        "list",
+		Help:        "list the access requests",
+		Usage:       "[flags]",
+		Description: description,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "All",
+				Help:  "show all the requests",
+				Value: false,
+			},
+		},
+	}
+
+	sault.Commands[accessListFlagsTemplate.ID] = &accessListCommand{}
+}
+
+type accessListRequestData struct {
+	All bool
+}
+
+type accessListCommand struct{}
+
+func (c *accessListCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var requests []saultregistry.AccessRequestRegistry
+	_, err = runCommand(
+		allFlags[0],
+		accessListFlagsTemplate.ID,
+		accessListRequestData{All: thisFlags.Values["All"].(bool)},
+		&requests,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printAccessRequestsData("access-request-list", requests, nil))
+
+	return nil
+}
+
+func (c *accessListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data accessListRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var userID string
+	if !user.IsAdmin {
+		userID = user.ID
+	}
+
+	var statuses []saultregistry.AccessRequestStatus
+	if !data.All {
+		statuses = []saultregistry.AccessRequestStatus{
+			saultregistry.AccessRequestPending,
+			saultregistry.AccessRequestApproved,
+		}
+	}
+
+	result := []saultregistry.AccessRequestRegistry{}
+	result = append(result, registry.GetAccessRequests(userID, statuses...)...)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		result,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
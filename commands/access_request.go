@@ -0,0 +1,164 @@
+package saultcommands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var accessRequestFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "access request" | yellow }} requests the temporary link to the host account; this can be run by the users, who are not admin. The request is pending until the admin approves it by {{ "access approve" | yellow }}, and the approved link expires after the requested duration.
+
+{{ "-for" | yellow }} and {{ "-reason" | yellow }} must be set.
+  * {{ "$ sault access request prometeus ubuntu -for 4h -reason \"deploy the hotfix\"" | magenta }}
+		`,
+		nil,
+	)
+
+	var accessRequestForFlag flagUserLinkExpires
+
+	accessRequestFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "access request",
+		Name:         "request",
+		Help:         "request the temporary link to the host",
+		Usage:        "<host id> <account> -for <duration> -reason <reason> [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "For",
+				Help:  "duration of link, like '4h'",
+				Value: &accessRequestForFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Reason",
+				Help:  "reason of request",
+				Value: "",
+			},
+		},
+		ParseFunc: parseAccessRequestCommandFlags,
+	}
+
+	sault.Commands[accessRequestFlagsTemplate.ID] = &accessRequestCommand{}
+}
+
+func parseAccessRequestCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 2 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	hostID, account := subArgs[0], subArgs[1]
+	if !saultcommon.CheckHostID(hostID) {
+		err = &saultcommon.InvalidHostIDError{ID: hostID}
+		return
+	}
+	if !saultcommon.CheckAccountName(account) {
+		err = &saultcommon.InvalidAccountNameError{Name: account}
+		return
+	}
+
+	duration := f.Values["For"].(flagUserLinkExpires)
+	if !duration.IsSet || duration.Value <= 0 {
+		err = fmt.Errorf("'-for' must be set, like '4h'")
+		return
+	}
+
+	reason := strings.TrimSpace(f.Values["Reason"].(string))
+	if len(reason) < 1 {
+		err = fmt.Errorf("'-reason' must be set")
+		return
+	}
+
+	f.Values["Request"] = accessRequestRequestData{
+		HostID:   hostID,
+		Account:  account,
+		Duration: duration.Value,
+		Reason:   reason,
+	}
+
+	return nil
+}
+
+type accessRequestRequestData struct {
+	HostID   string
+	Account  string
+	Duration time.Duration
+	Reason   string
+}
+
+type accessRequestCommand struct{}
+
+func (c *accessRequestCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var request saultregistry.AccessRequestRegistry
+	_, err = runCommand(
+		allFlags[0],
+		accessRequestFlagsTemplate.ID,
+		thisFlags.Values["Request"].(accessRequestRequestData),
+		&request,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printAccessRequestsData(
+		"access-requested",
+		[]saultregistry.AccessRequestRegistry{request},
+		nil,
+	))
+
+	return nil
+}
+
+func (c *accessRequestCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data accessRequestRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var request saultregistry.AccessRequestRegistry
+	request, err = registry.AddAccessRequest(user.ID, data.HostID, data.Account, data.Duration, data.Reason)
+	if err != nil {
+		return
+	}
+
+	registry.Save()
+
+	log.Debugf("access requested by '%s': %s", user.ID, request)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		request,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
+
+func parseAccessDecisionCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	f.Values["RequestID"] = subArgs[0]
+
+	return nil
+}
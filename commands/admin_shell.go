@@ -0,0 +1,544 @@
+package saultcommands
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/saultssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// maxAdminShellHistory is the maximum number of lines in the history of
+// admin shell
+var maxAdminShellHistory = 1000
+
+var adminShellBuiltins = []string{"exit", "help", "history"}
+
+var adminShellDescription = `{{ "sault" | yellow }} admin shell runs the commands in sault server without {{ "sault" | yellow }} client. For examples,
+
+{{ "user list" | magenta }}, {{ "host add prometeus ubuntu@192.168.99.110" | magenta }}
+
+Press {{ "tab" | yellow }} to complete the commands and flags, {{ "↑ ↓" | yellow }} to find the history.
+  * {{ "help [<command>...]" | yellow }} shows the help of command
+  * {{ "history" | yellow }} shows the history
+  * {{ "exit" | yellow }} or {{ "ctrl-d" | yellow }} exits the shell
+`
+
+func init() {
+	sault.AdminShell = runAdminShell
+}
+
+// filterFlagsTemplate returns the copy of FlagsTemplate without the given
+// subcommands
+func filterFlagsTemplate(t *saultflags.FlagsTemplate, excludes ...*saultflags.FlagsTemplate) *saultflags.FlagsTemplate {
+	n := *t
+	n.Subcommands = nil
+
+	for _, s := range t.Subcommands {
+		var excluded bool
+		for _, e := range excludes {
+			if s == e {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			n.Subcommands = append(n.Subcommands, s)
+		}
+	}
+
+	return &n
+}
+
+// newAdminShellFlagsTemplate returns the commands of admin shell; the
+// commands, which should be run in local, like 'server run' are excluded.
+func newAdminShellFlagsTemplate() *saultflags.FlagsTemplate {
+	return &saultflags.FlagsTemplate{
+		Name:        "sault",
+		Usage:       "<command> [flags]",
+		Description: adminShellDescription,
+		Subcommands: []*saultflags.FlagsTemplate{
+			filterFlagsTemplate(ServerFlagsTemplate, serverRunFlagsTemplate, serverInitFlagsTemplate),
+			UserFlagsTemplate,
+			filterFlagsTemplate(HostFlagsTemplate, hostInjectFlagsTemplate),
+			ServiceFlagsTemplate,
+			SessionFlagsTemplate,
+			AccessFlagsTemplate,
+			VersionFlagsTemplate,
+		},
+	}
+}
+
+// splitAdminShellArgs splits the line like shell; the single and double
+// quotes and the backslash escape are supported.
+func splitAdminShellArgs(line string) (args []string, err error) {
+	var arg []rune
+	var inArg, escaped bool
+	var quote rune
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			arg = append(arg, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+				continue
+			}
+			arg = append(arg, r)
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, string(arg))
+				arg = nil
+				inArg = false
+			}
+		default:
+			arg = append(arg, r)
+			inArg = true
+		}
+	}
+
+	if escaped || quote != 0 {
+		err = fmt.Errorf("unexpected end of line")
+		return
+	}
+
+	if inArg {
+		args = append(args, string(arg))
+	}
+
+	return
+}
+
+// completeAdminShell completes the last word of line by the commands and
+// flags; the candidates are returned if the word can not be completed
+// uniquely.
+func completeAdminShell(root *saultflags.FlagsTemplate, line string) (completed string, candidates []string) {
+	words := strings.Fields(line)
+
+	var current string
+	if len(words) > 0 && !strings.HasSuffix(line, " ") {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	t := root
+	for _, w := range words {
+		if strings.HasPrefix(w, "-") {
+			continue
+		}
+
+		var found *saultflags.FlagsTemplate
+		for _, s := range t.Subcommands {
+			if saultcommon.MakeFirstLowerCase(s.Name) == w {
+				found = s
+				break
+			}
+		}
+		if found == nil {
+			break
+		}
+		t = found
+	}
+
+	var names []string
+	if strings.HasPrefix(current, "-") {
+		for _, f := range t.Flags {
+			names = append(names, "-"+strings.ToLower(f.Name))
+		}
+		names = append(names, "-help")
+	} else {
+		for _, s := range t.Subcommands {
+			names = append(names, saultcommon.MakeFirstLowerCase(s.Name))
+		}
+		if t == root && len(words) < 1 {
+			names = append(names, adminShellBuiltins...)
+		}
+	}
+
+	for _, n := range names {
+		if strings.HasPrefix(n, current) {
+			candidates = append(candidates, n)
+		}
+	}
+	sort.Strings(candidates)
+
+	completed = line
+	if len(candidates) < 1 {
+		return
+	}
+
+	prefix := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+
+	completed = line[:len(line)-len(current)] + prefix
+	if len(candidates) == 1 {
+		completed += " "
+	}
+
+	return
+}
+
+// adminShellInput is the only reader of the pty; the input is delivered to
+// the terminal or the running command.
+type adminShellInput struct {
+	sync.Mutex
+	data    chan []byte
+	closed  chan struct{}
+	pending []byte
+}
+
+func newAdminShellInput(r io.Reader) *adminShellInput {
+	i := &adminShellInput{
+		data:   make(chan []byte),
+		closed: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(i.data)
+
+		b := make([]byte, 1024)
+		for {
+			n, err := r.Read(b)
+			if n > 0 {
+				c := make([]byte, n)
+				copy(c, b[:n])
+
+				select {
+				case i.data <- c:
+				case <-i.closed:
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return i
+}
+
+func (i *adminShellInput) close() {
+	close(i.closed)
+}
+
+// read reads the input until done is closed
+func (i *adminShellInput) read(p []byte, done <-chan struct{}) (int, error) {
+	i.Lock()
+	defer i.Unlock()
+
+	if len(i.pending) < 1 {
+		select {
+		case b, ok := <-i.data:
+			if !ok {
+				return 0, io.EOF
+			}
+			i.pending = b
+		case <-done:
+			return 0, io.EOF
+		}
+	}
+
+	n := copy(p, i.pending)
+	i.pending = i.pending[n:]
+
+	return n, nil
+}
+
+type adminShellTerminalReader struct {
+	input *adminShellInput
+}
+
+// Read reads the input for terminal; ctrl-c clears the line instead of
+// exiting the shell.
+func (r adminShellTerminalReader) Read(p []byte) (int, error) {
+	n, err := r.input.read(p, nil)
+	for i := 0; i < n; i++ {
+		if p[i] == 0x03 {
+			p[i] = 0x15
+		}
+	}
+
+	return n, err
+}
+
+// adminShellChannel is the channel for the command in admin shell; the
+// response is written to the pipe and the input comes from pty.
+type adminShellChannel struct {
+	saultssh.Channel
+	out   *io.PipeWriter
+	input *adminShellInput
+	done  chan struct{}
+}
+
+func (c *adminShellChannel) Read(p []byte) (int, error) {
+	return c.input.read(p, c.done)
+}
+
+func (c *adminShellChannel) Write(p []byte) (int, error) {
+	return c.out.Write(p)
+}
+
+func (c *adminShellChannel) Close() error {
+	return c.out.Close()
+}
+
+func (c *adminShellChannel) CloseWrite() error {
+	return c.out.Close()
+}
+
+func (c *adminShellChannel) SendRequest(name string, wantReply bool, payload []byte) (bool, error) {
+	return true, nil
+}
+
+func (c *adminShellChannel) SetProxy(bool) {}
+
+// adminShellRunner runs the commands in sault server thru
+// AdminShellSession
+type adminShellRunner struct {
+	session *sault.AdminShellSession
+	input   *adminShellInput
+}
+
+func (r *adminShellRunner) start(msg *saultcommon.CommandMsg) (stdout io.Reader, wait func() error, closeFunc func()) {
+	reader, writer := io.Pipe()
+
+	channel := &adminShellChannel{
+		Channel: r.session.Channel,
+		out:     writer,
+		input:   r.input,
+		done:    make(chan struct{}),
+	}
+
+	go func() {
+		defer close(channel.done)
+
+		if err := r.session.Run(channel, *msg); err != nil {
+			log.Debugf("failed to run command in admin shell: %v", err)
+		}
+		writer.Close()
+	}()
+
+	stdout = reader
+	wait = func() error {
+		<-channel.done
+		return nil
+	}
+	closeFunc = func() {
+		reader.Close()
+		<-channel.done
+	}
+
+	return
+}
+
+type adminShell struct {
+	session  *sault.AdminShellSession
+	input    *adminShellInput
+	terminal *terminal.Terminal
+	template *saultflags.FlagsTemplate
+	history  []string
+}
+
+func newAdminShell(session *sault.AdminShellSession) *adminShell {
+	input := newAdminShellInput(session.Channel)
+
+	var prompt string
+	if session.Config != nil {
+		prompt, _ = saultcommon.SimpleTemplating(
+			`{{ .user | colorUserID }}@{{ .server | blue }}> `,
+			map[string]interface{}{"user": session.User.ID, "server": session.Config.Server.SaultServerName},
+		)
+	} else {
+		prompt = "> "
+	}
+
+	s := &adminShell{
+		session:  session,
+		input:    input,
+		template: newAdminShellFlagsTemplate(),
+		terminal: terminal.NewTerminal(
+			struct {
+				io.Reader
+				io.Writer
+			}{adminShellTerminalReader{input: input}, session.Channel},
+			prompt,
+		),
+	}
+	s.terminal.AutoCompleteCallback = s.complete
+
+	if size := session.Size(); size.Columns > 0 && size.Rows > 0 {
+		s.terminal.SetSize(size.Columns, size.Rows)
+	}
+
+	return s
+}
+
+func (s *adminShell) write(t string, values interface{}) {
+	rendered, _ := saultcommon.SimpleTemplating(t, values)
+	s.terminal.Write([]byte(rendered))
+}
+
+func (s *adminShell) complete(line string, pos int, key rune) (newLine string, newPos int, ok bool) {
+	if key != '\t' {
+		return
+	}
+
+	completed, candidates := completeAdminShell(s.template, line[:pos])
+	if completed == line[:pos] && len(candidates) > 1 {
+		s.terminal.Write([]byte(strings.Join(candidates, "  ") + "\n"))
+	}
+
+	return completed + line[pos:], len(completed), true
+}
+
+func (s *adminShell) addHistory(line string) {
+	s.history = append(s.history, line)
+	if len(s.history) > maxAdminShellHistory {
+		s.history = s.history[len(s.history)-maxAdminShellHistory:]
+	}
+}
+
+func (s *adminShell) newFlags() *saultflags.Flags {
+	f := saultflags.NewFlags(s.template, nil)
+	f.SetOutput(s.terminal)
+
+	return f
+}
+
+func (s *adminShell) help(args []string) {
+	f := s.newFlags()
+	for _, a := range args {
+		var found *saultflags.Flags
+		for _, c := range f.Subcommands {
+			if saultcommon.MakeFirstLowerCase(c.Name) == a {
+				found = c
+				break
+			}
+		}
+		if found == nil {
+			s.write("{{ \"error\" | red }} unknown command, '{{ . }}'\n", a)
+			return
+		}
+		f = found
+	}
+
+	f.PrintHelp(nil)
+}
+
+// run runs the command by it's Request like sault client
+func (s *adminShell) run(args []string) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Errorf("panic in admin shell, %v: %v", args, r)
+			s.write("{{ \"error\" | red }} unexpected error occured\n", nil)
+		}
+	}()
+
+	f := s.newFlags()
+	if err := f.Parse(args); err != nil {
+		// the help is already printed
+		return
+	}
+
+	saultServer := saultcommon.FlagSaultServer{SaultServerName: sault.DefaultSaultServerName}
+	if s.session.Config != nil {
+		saultServer.SaultServerName = s.session.Config.Server.SaultServerName
+		saultServer.Address = s.session.Config.Server.Bind
+	}
+	f.Values["Sault"] = saultServer
+	f.Values["Runner"] = &adminShellRunner{session: s.session, input: s.input}
+	f.Values["Output"] = s.terminal
+
+	subcommandFlags := f.GetSubcommands()
+	thisCommandFlags := subcommandFlags[len(subcommandFlags)-1]
+
+	command, ok := sault.Commands[thisCommandFlags.ID]
+	if !ok {
+		s.write("{{ \"error\" | red }} unknown command\n", nil)
+		return
+	}
+
+	if err := command.Request(subcommandFlags, thisCommandFlags); err != nil {
+		s.write("{{ \"error\" | red }} {{ . }}\n", err.Error())
+	}
+}
+
+func (s *adminShell) loop() error {
+	s.write(`{{ "* sault" | blue }} admin shell, {{ "help" | yellow }} shows the commands
+`, nil)
+
+	for {
+		line, err := s.terminal.ReadLine()
+		if err == io.EOF {
+			return nil
+		} else if err != nil && err != terminal.ErrPasteIndicator {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if len(line) < 1 {
+			continue
+		}
+		s.addHistory(line)
+
+		args, err := splitAdminShellArgs(line)
+		if err != nil {
+			s.write("{{ \"error\" | red }} {{ . }}\n", err.Error())
+			continue
+		}
+
+		switch args[0] {
+		case "exit", "quit":
+			return nil
+		case "help":
+			s.help(args[1:])
+		case "history":
+			for i, h := range s.history {
+				s.write("{{ index . 0 | sprintf \"%5d\" | dim }}  {{ index . 1 }}\n", []interface{}{i + 1, h})
+			}
+		default:
+			s.run(args)
+		}
+	}
+}
+
+// runAdminShell runs the interactive shell for admin
+func runAdminShell(session *sault.AdminShellSession) error {
+	s := newAdminShell(session)
+	defer s.input.close()
+
+	stopped := make(chan struct{})
+	defer close(stopped)
+
+	go func() {
+		for {
+			select {
+			case size := <-session.Resized():
+				s.terminal.SetSize(size.Columns, size.Rows)
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	return s.loop()
+}
@@ -0,0 +1,100 @@
+package saultcommands
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitAdminShellArgs(t *testing.T) {
+	{
+		args, err := splitAdminShellArgs("  user   list ")
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"user", "list"}, args)
+	}
+
+	{
+		args, err := splitAdminShellArgs(`host update prometeus -banner 'hi {{ .User }}' -accounts "ubuntu root"`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"host", "update", "prometeus", "-banner", "hi {{ .User }}", "-accounts", "ubuntu root"}, args)
+	}
+
+	{
+		args, err := splitAdminShellArgs(`a\ b '' "c\"d"`)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"a b", "", `c"d`}, args)
+	}
+
+	{
+		_, err := splitAdminShellArgs(`host update 'prometeus`)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestCompleteAdminShell(t *testing.T) {
+	root := newAdminShellFlagsTemplate()
+
+	{
+		completed, candidates := completeAdminShell(root, "us")
+		assert.Equal(t, "user ", completed)
+		assert.Equal(t, []string{"user"}, candidates)
+	}
+
+	{
+		// builtins
+		completed, _ := completeAdminShell(root, "hi")
+		assert.Equal(t, "history ", completed)
+	}
+
+	{
+		// common prefix
+		completed, candidates := completeAdminShell(root, "user l")
+		assert.Equal(t, "user li", completed)
+		assert.Equal(t, []string{"link", "link-service", "list"}, candidates)
+	}
+
+	{
+		// the local commands are excluded
+		_, candidates := completeAdminShell(root, "host ")
+		assert.NotContains(t, candidates, "inject")
+
+		_, candidates = completeAdminShell(root, "server ")
+		assert.NotContains(t, candidates, "run")
+		assert.Contains(t, candidates, "bans")
+	}
+
+	{
+		// flags
+		completed, _ := completeAdminShell(root, "host update prometeus -ban")
+		assert.Equal(t, "host update prometeus -banner ", completed)
+	}
+
+	{
+		completed, candidates := completeAdminShell(root, "unknown")
+		assert.Equal(t, "unknown", completed)
+		assert.Empty(t, candidates)
+	}
+}
+
+func TestAdminShellInput(t *testing.T) {
+	input := newAdminShellInput(strings.NewReader("user\x03list"))
+	defer input.close()
+
+	{
+		// ctrl-c clears the line in terminal
+		b, err := ioutil.ReadAll(adminShellTerminalReader{input: input})
+		assert.Nil(t, err)
+		assert.Equal(t, "user\x15list", string(b))
+	}
+
+	{
+		done := make(chan struct{})
+		close(done)
+
+		n, err := input.read(make([]byte, 10), done)
+		assert.Equal(t, 0, n)
+		assert.NotNil(t, err)
+	}
+}
@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -21,10 +20,15 @@ By default, the sault server tries to check the connection to your host. If auth
 
 * {{ "host inject" | yellow }} helps to inject the internal client key to remote host
 * or with {{ "-f" | yellow }} flag, you can force to add the host.
+
+{{ "-addresses" | yellow }} sets the fallback addresses, which are tried in order when the address of host is not reachable.
+  * {{ "$ sault host add prometeus ubuntu@10.0.0.2:22 -addresses \"10.0.1.2:22 10.0.2.2:22\"" | magenta }}
 		`,
 		nil,
 	)
 
+	var hostAddAddressesFlag flagHostAddresses
+
 	hostAddFlagsTemplate = &saultflags.FlagsTemplate{
 		ID:           "host add",
 		Name:         "add",
@@ -43,6 +47,11 @@ By default, the sault server tries to check the connection to your host. If auth
 				Help:  "set active user",
 				Value: true,
 			},
+			saultflags.FlagTemplate{
+				Name:  "Addresses",
+				Help:  "fallback addresses, \"<hostname or ip>:<port> ...\"",
+				Value: &hostAddAddressesFlag,
+			},
 		},
 		ParseFunc: parseHostAddCommandFlags,
 	}
@@ -89,23 +98,25 @@ func parseHostAddCommandFlags(f *saultflags.Flags, args []string) (err error) {
 	}
 
 	f.Values["Host"] = hostAddRequestData{
-		ID:       hostID,
-		HostName: hostName,
-		Port:     port,
-		Accounts: accounts,
-		IsActive: f.Values["IsActive"].(bool),
-		SkipTest: f.Values["SkipTest"].(bool),
+		ID:        hostID,
+		HostName:  hostName,
+		Port:      port,
+		Accounts:  accounts,
+		Addresses: f.Values["Addresses"].(flagHostAddresses).Value,
+		IsActive:  f.Values["IsActive"].(bool),
+		SkipTest:  f.Values["SkipTest"].(bool),
 	}
 
 	return nil
 }
 
 type hostAddRequestData struct {
-	ID       string
-	HostName string
-	Port     uint64
-	Accounts []string
-	IsActive bool
+	ID        string
+	HostName  string
+	Port      uint64
+	Accounts  []string
+	Addresses []string
+	IsActive  bool
 
 	SkipTest bool
 }
@@ -123,7 +134,7 @@ func (c *hostAddCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultf
 		&host,
 	)
 	if err == nil {
-		fmt.Fprintf(os.Stdout, printHostData(
+		fmt.Fprintf(commandOutput(allFlags[0]), printHostData(
 			"host-added",
 			allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 			host,
@@ -148,7 +159,7 @@ failed to add host, because could not connect to the host.
 	return responseMsgErr
 }
 
-func (c *hostAddCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *hostAddCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data hostAddRequestData
 	err = msg.GetData(&data)
 	if err != nil {
@@ -186,9 +197,12 @@ func (c *hostAddCommand) Response(user saultregistry.UserRegistry, channel sault
 	if host, err = registry.AddHost(data.ID, data.HostName, data.Port, data.Accounts); err != nil {
 		return
 	}
-	if host.IsActive != data.IsActive {
+	if host.IsActive != data.IsActive || len(data.Addresses) > 0 {
 		host.IsActive = data.IsActive
-		host, _ = registry.UpdateHost(host.ID, host)
+		host.Addresses = data.Addresses
+		if host, err = registry.UpdateHost(host.ID, host); err != nil {
+			return
+		}
 	}
 
 	registry.Save()
@@ -3,7 +3,6 @@ package saultcommands
 import (
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"time"
 
@@ -19,10 +18,13 @@ import (
 var hostInjectFlagsTemplate *saultflags.FlagsTemplate
 
 func init() {
-	description, _ := saultcommon.SimpleTemplating(`{{ "host inject" | yellow }} will inject the internal client key to the remote host.
+	description, _ := saultcommon.SimpleTemplating(fmt.Sprintf(`{{ "host inject" | yellow }} will inject the internal client key to the remote host.
 
 {{ "host inject" | yellow }} will authenticate to the remote host by your ssh agent, if failed, will ask your passphrase. This is the same process of {{ "ssh-copy-id" | yellow }}. If you can connect to the remote host in local by public key or passphrase, you can inject the sault internal client key.
-		`,
+
+{{ "-ca" | yellow }} installs the public key of user CA, {{ "server.user_ca" | yellow }} to '%s' and sets {{ "TrustedUserCAKeys" | yellow }} in '%s' instead of the client key, then sault server connects to the host with the short-lived user certificate. The account must be root or can run sudo without password.
+  * {{ "$ sault host inject -ca ubuntu@prometeus.local:22" | magenta }}
+		`, trustedUserCAKeysFile, sshdConfigFile),
 		nil,
 	)
 
@@ -33,7 +35,14 @@ func init() {
 		Usage:        "<account>@<host address, hostname:port> [flags]",
 		Description:  description,
 		IsPositioned: true,
-		ParseFunc:    parseHostInjectCommandFlags,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "CA",
+				Help:  "install the user CA instead of the client key",
+				Value: false,
+			},
+		},
+		ParseFunc: parseHostInjectCommandFlags,
 	}
 
 	sault.Commands[hostInjectFlagsTemplate.ID] = &hostInjectCommand{}
@@ -68,6 +77,7 @@ func parseHostInjectCommandFlags(f *saultflags.Flags, args []string) (err error)
 		HostName: hostName,
 		Port:     port,
 		Account:  account,
+		CA:       f.Values["CA"].(bool),
 	}
 
 	return nil
@@ -77,6 +87,17 @@ type hostInjectRequestData struct {
 	HostName string
 	Port     uint64
 	Account  string
+
+	// CA, if true, the user CA is installed instead of the client key
+	CA bool
+}
+
+func (d hostInjectRequestData) injectedMessage() string {
+	if d.CA {
+		return "successfully the sault user CA was installed to the remote host\n"
+	}
+
+	return "successfully the sault client key was injected to the remote host\n"
 }
 
 type hostInjectCommand struct{}
@@ -92,7 +113,7 @@ func (c *hostInjectCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 		&host,
 	)
 	if err == nil {
-		fmt.Fprintf(os.Stdout, "successfully the sault client key was injected to the remote host\n")
+		fmt.Fprint(commandOutput(allFlags[0]), data.injectedMessage())
 		return nil
 	}
 
@@ -116,7 +137,7 @@ func (c *hostInjectCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 		}
 
 		if err == nil {
-			fmt.Fprintf(os.Stdout, "successfully the sault client key was injected to the remote host\n")
+			fmt.Fprint(commandOutput(allFlags[0]), data.injectedMessage())
 			return
 		}
 	}
@@ -144,7 +165,7 @@ failed to inject the internal client key, because something wrong to inject it t
 	return
 }
 
-func (c *hostInjectCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *hostInjectCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	err = c.response(channel, msg, registry, config)
 	if err != nil {
 		if responseMsgErr, ok := err.(*saultcommon.ResponseMsgError); ok {
@@ -181,6 +202,13 @@ func (c *hostInjectCommand) response(channel saultssh.Channel, msg saultcommon.C
 		return err
 	}
 
+	if data.CA && config.Server.GetUserCASigner() == nil {
+		return saultcommon.NewCommandError(
+			saultcommon.CommandErrorInjectClientKey,
+			"user CA is not set; set 'server.user_ca' of sault server",
+		)
+	}
+
 	address := fmt.Sprintf("%s:%d", data.HostName, data.Port)
 	rlog := log.WithFields(logrus.Fields{
 		"host": address,
@@ -218,7 +246,11 @@ func (c *hostInjectCommand) response(channel saultssh.Channel, msg saultcommon.C
 		return
 	}
 
-	err = injectClientKeyToHost(sc, config.Server.GetClientKeySigner().PublicKey())
+	if data.CA {
+		err = injectUserCAToHost(sc, config.Server.GetUserCASigner().PublicKey())
+	} else {
+		err = injectClientKeyToHost(sc, config.Server.GetClientKeySigner().PublicKey())
+	}
 	if err != nil {
 		rlog.Debug(err)
 		return saultcommon.NewCommandError(saultcommon.CommandErrorInjectClientKey, err.Error())
@@ -232,20 +264,40 @@ func injectClientKeyToHostThruSault(
 	mainFlags *saultflags.Flags,
 	data hostInjectRequestData,
 ) (err error) {
-	log.Debugf("trying to retrieve the client public key from sault server")
-	var clientKeyData serverPrintResponseData
+	kind := "clientkey"
+	if data.CA {
+		kind = "userca"
+	}
+
+	log.Debugf("trying to retrieve the %s from sault server", kind)
+	var keyData serverPrintResponseData
 	_, err = runCommand(
 		mainFlags,
 		serverPrintFlagsTemplate.ID,
-		[]string{"clientkey"},
-		&clientKeyData,
+		[]string{kind},
+		&keyData,
 	)
 	if err != nil {
 		return
 	}
 
-	clientPublicKey, _ := saultcommon.ParsePublicKey(clientKeyData.ClientKey[1])
-	log.Debugf("got the client public key from sault server: %s", clientKeyData.ClientKey[1])
+	var publicKey saultssh.PublicKey
+	if data.CA {
+		if len(keyData.UserCA) < 1 {
+			err = saultcommon.NewCommandError(
+				saultcommon.CommandErrorInjectClientKey,
+				"user CA is not set; set 'server.user_ca' of sault server",
+			)
+			return
+		}
+		publicKey, err = saultcommon.ParsePublicKey(keyData.UserCA)
+	} else {
+		publicKey, err = saultcommon.ParsePublicKey(keyData.ClientKey[1])
+	}
+	if err != nil {
+		return
+	}
+	log.Debugf("got the %s from sault server: %s", kind, saultcommon.GetAuthorizedKey(publicKey))
 
 	log.Debugf("trying to open direct-tcpip connection to sault server")
 	saultServer := mainFlags.Values["Sault"].(saultcommon.FlagSaultServer)
@@ -301,7 +353,11 @@ func injectClientKeyToHostThruSault(
 	}
 
 	log.Debugf("successfully connected to the remote host thru sault server")
-	err = injectClientKeyToHost(sc, clientPublicKey)
+	if data.CA {
+		err = injectUserCAToHost(sc, publicKey)
+	} else {
+		err = injectClientKeyToHost(sc, publicKey)
+	}
 	if err != nil {
 		err = saultcommon.NewCommandError(saultcommon.CommandErrorInjectClientKey, err.Error())
 		return
@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 
@@ -72,6 +71,8 @@ The belowed flags help to get the remote hosts from sault server, by default, it
 
 {{ "-reverse" | yellow }}:
 By default, sault orders the remote hosts by the updated time, that is, the last updated host will be listed at last. This flag will list them by reverse order.
+
+The addresses of host are shown with the last-known reachability, which is updated when sault server connects to the host.
 		`,
 		nil,
 	)
@@ -121,28 +122,33 @@ type hostListRequestData struct {
 	HostIDs []string
 }
 
-type hostListResponseData []saultregistry.HostRegistry
+type hostListResponseHosts []saultregistry.HostRegistry
 
-func (s hostListResponseData) Len() int {
+func (s hostListResponseHosts) Len() int {
 	return len(s)
 }
 
-func (s hostListResponseData) Less(i, j int) bool {
+func (s hostListResponseHosts) Less(i, j int) bool {
 	return s[i].DateUpdated.Before(s[j].DateUpdated)
 }
 
-func (s hostListResponseData) Swap(i, j int) {
+func (s hostListResponseHosts) Swap(i, j int) {
 	s[i], s[j] = s[j], s[i]
 	return
 }
 
+type hostListResponseData struct {
+	Hosts  hostListResponseHosts
+	Health map[string]sault.HostAddressHealth
+}
+
 type hostListCommand struct{}
 
 func (c *hostListCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
 	flagFilter := thisFlags.Values["Filter"].(flagHostFilters)
 	log.Debugf("get hosts, filters: %s HostIDs: %s", flagFilter.Args, thisFlags.Values["HostIDs"])
 
-	var hosts hostListResponseData
+	var result hostListResponseData
 	_, err = runCommand(
 		allFlags[0],
 		hostListFlagsTemplate.ID,
@@ -150,38 +156,46 @@ func (c *hostListCommand) Request(allFlags []*saultflags.Flags, thisFlags *sault
 			Filters: saultregistry.HostFilter(flagFilter.Combined),
 			HostIDs: thisFlags.Values["HostIDs"].([]string),
 		},
-		&hosts,
+		&result,
 	)
 	if err != nil {
 		return
 	}
 
+	hosts := result.Hosts
 	if thisFlags.Values["Reverse"].(bool) {
 		sort.Sort(sort.Reverse(hosts))
 	} else {
 		sort.Sort(hosts)
 	}
 
-	fmt.Fprintf(os.Stdout, printHostsData(
+	fmt.Fprintf(commandOutput(allFlags[0]), printHostsData(
 		"host-list",
 		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 		hosts,
+		result.Health,
 		nil,
 	))
 
 	return nil
 }
 
-func (c *hostListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *hostListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data hostListRequestData
 	err = msg.GetData(&data)
 	if err != nil {
 		return err
 	}
 
-	result := hostListResponseData{}
+	result := hostListResponseData{
+		Hosts:  hostListResponseHosts{},
+		Health: map[string]sault.HostAddressHealth{},
+	}
 	for _, h := range registry.GetHosts(data.Filters, data.HostIDs...) {
-		result = append(result, h)
+		result.Hosts = append(result.Hosts, h)
+		for address, health := range server.HostHealth().GetHealth(h.GetAddresses()...) {
+			result.Health[address] = health
+		}
 	}
 
 	var response []byte
@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -94,8 +93,82 @@ func (f *flagHostUpdateNewAccounts) Set(v string) error {
 	return nil
 }
 
+// flagHostAddresses is the fallback addresses of host; empty value removes
+// them.
+type flagHostAddresses struct {
+	IsSet bool
+	Value []string
+}
+
+func (f *flagHostAddresses) String() string { return strings.Join(f.Value, " ") }
+
+func (f *flagHostAddresses) Set(v string) error {
+	addresses := []string{}
+	for _, a := range strings.FieldsFunc(v, func(r rune) bool { return r == ' ' || r == ',' }) {
+		hostName, port, err := saultcommon.SplitHostPort(a, uint64(22))
+		if err != nil {
+			return &saultcommon.InvalidHostAddressError{Address: a, Err: err}
+		}
+		addresses = append(addresses, fmt.Sprintf("%s:%d", hostName, port))
+	}
+
+	f.Value = addresses
+	f.IsSet = true
+	return nil
+}
+
+// flagSessionTimeout is the session timeout of host and link; empty value
+// removes it.
+type flagSessionTimeout struct {
+	IsSet bool
+	Value string
+}
+
+func (f *flagSessionTimeout) String() string { return f.Value }
+
+func (f *flagSessionTimeout) Set(v string) error {
+	v = strings.TrimSpace(v)
+	if _, err := saultregistry.ParseSessionTimeout(v); err != nil {
+		return err
+	}
+
+	f.Value = v
+	f.IsSet = true
+	return nil
+}
+
+// flagHostBanner is the banner of host; empty value removes it.
+type flagHostBanner struct {
+	IsSet bool
+	Value string
+}
+
+func (f *flagHostBanner) String() string { return f.Value }
+
+func (f *flagHostBanner) Set(v string) error {
+	if _, err := saultcommon.SimpleTemplating(v, nil); err != nil {
+		return fmt.Errorf("invalid banner: %v", err)
+	}
+
+	f.Value = v
+	f.IsSet = true
+	return nil
+}
+
 func init() {
 	description, _ := saultcommon.SimpleTemplating(`{{ "host update" | yellow }} will update the host in the registry of sault server.
+
+{{ "-addresses" | yellow }} sets the fallback addresses, which are tried in order when the address of host is not reachable. '' removes them.
+  * {{ "$ sault host update prometeus -addresses \"10.0.1.2:22 10.0.2.2:22\"" | magenta }}
+
+{{ "-idletimeout" | yellow }} and {{ "-maxduration" | yellow }} override the session timeouts of sault server for this host, like '30m'. '0' disables the timeout and '' follows the sault server.
+  * {{ "$ sault host update prometeus -idletimeout 30m -maxduration 12h" | magenta }}
+
+{{ "-banner" | yellow }} sets the banner, which is shown in the pty sessions of this host after the banner of sault server. It can have the variables, {{ "{{ .User }}" | cyan }}, {{ "{{ .Host }}" | cyan }}, {{ "{{ .Account }}" | cyan }}, {{ "{{ .LinkExpires }}" | cyan }} and {{ "{{ .SessionID }}" | cyan }} and the colors like {{ "{{ .User | green }}" | cyan }}. '' removes it.
+  * {{ "$ sault host update prometeus -banner 'Hello {{ .User | green }}, prometeus is production.'" | magenta }}
+
+{{ "-sensitive" | yellow }} marks the host as sensitive; with {{ "server.totp = \"sensitive\"" | cyan }}, the users of sensitive host are required the TOTP. The users must give the reason or ticket ID of session by the {{ "SAULT_REASON" | cyan }} env, like {{ "ssh -o SetEnv=SAULT_REASON=JIRA-1234" | cyan }}, or by the prompt on the pty before connecting to the sensitive host.
+  * {{ "$ sault host update prometeus -sensitive true" | magenta }}
 		`,
 		nil,
 	)
@@ -104,6 +177,11 @@ func init() {
 	var hostUpdateNewIsActiveFlag flagHostUpdateNewIsActive
 	var hostUpdateNewAddress flagHostUpdateNewAddress
 	var hostUpdateNewAccounts flagHostUpdateNewAccounts
+	var hostUpdateNewAddresses flagHostAddresses
+	var hostUpdateNewIdleTimeout flagSessionTimeout
+	var hostUpdateNewMaxDuration flagSessionTimeout
+	var hostUpdateNewBanner flagHostBanner
+	var hostUpdateNewSensitive flagHostUpdateNewIsActive
 	hostUpdateFlagsTemplate = &saultflags.FlagsTemplate{
 		ID:           "host update",
 		Name:         "update",
@@ -132,6 +210,31 @@ func init() {
 				Help:  "set host adddress, \"<hostname or ip>:<port default 22>\"",
 				Value: &hostUpdateNewAddress,
 			},
+			saultflags.FlagTemplate{
+				Name:  "Addresses",
+				Help:  "set fallback addresses, \"<hostname or ip>:<port> ...\"",
+				Value: &hostUpdateNewAddresses,
+			},
+			saultflags.FlagTemplate{
+				Name:  "IdleTimeout",
+				Help:  "set idle timeout of sessions, like '30m'",
+				Value: &hostUpdateNewIdleTimeout,
+			},
+			saultflags.FlagTemplate{
+				Name:  "MaxDuration",
+				Help:  "set maximum session duration, like '12h'",
+				Value: &hostUpdateNewMaxDuration,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Banner",
+				Help:  "set banner of pty sessions",
+				Value: &hostUpdateNewBanner,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Sensitive",
+				Help:  "set sensitive host [true false]",
+				Value: &hostUpdateNewSensitive,
+			},
 			saultflags.FlagTemplate{
 				Name:  "SkipTest",
 				Help:  "skip connectivity check, only available with the new address",
@@ -185,6 +288,37 @@ func parseHostUpdateCommandFlags(f *saultflags.Flags, args []string) (err error)
 		}
 	}
 
+	{
+		v := f.Values["Addresses"].(flagHostAddresses)
+		if v.IsSet {
+			newHost.NewAddresses = v
+		}
+	}
+	{
+		v := f.Values["IdleTimeout"].(flagSessionTimeout)
+		if v.IsSet {
+			newHost.NewIdleTimeout = v
+		}
+	}
+	{
+		v := f.Values["MaxDuration"].(flagSessionTimeout)
+		if v.IsSet {
+			newHost.NewMaxDuration = v
+		}
+	}
+	{
+		v := f.Values["Banner"].(flagHostBanner)
+		if v.IsSet {
+			newHost.NewBanner = v
+		}
+	}
+	{
+		v := f.Values["Sensitive"].(flagHostUpdateNewIsActive)
+		if v.IsSet {
+			newHost.NewSensitive = v
+		}
+	}
+
 	f.Values["NewHost"] = newHost
 
 	return nil
@@ -196,7 +330,14 @@ type hostUpdateRequestData struct {
 	NewAddress  flagHostUpdateNewAddress
 	NewAccounts flagHostUpdateNewAccounts
 	NewIsActive flagHostUpdateNewIsActive
-	SkipTest    bool
+
+	NewAddresses   flagHostAddresses
+	NewIdleTimeout flagSessionTimeout
+	NewMaxDuration flagSessionTimeout
+	NewBanner      flagHostBanner
+	NewSensitive   flagHostUpdateNewIsActive
+
+	SkipTest bool
 }
 
 type hostUpdateResponsetData struct {
@@ -222,7 +363,7 @@ func (c *hostUpdateCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 			resultErr = fmt.Errorf(result.Err)
 		}
 
-		fmt.Fprintf(os.Stdout, printHostData(
+		fmt.Fprintf(commandOutput(allFlags[0]), printHostData(
 			"host-updated",
 			allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 			result.Host,
@@ -247,7 +388,7 @@ failed to update host, because could not connect to the host.
 	return responseMsgErr
 }
 
-func (c *hostUpdateCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *hostUpdateCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data hostUpdateRequestData
 	err = msg.GetData(&data)
 	if err != nil {
@@ -259,7 +400,7 @@ func (c *hostUpdateCommand) Response(user saultregistry.UserRegistry, channel sa
 		return
 	}
 
-	if !data.SkipTest {
+	if !data.SkipTest && data.NewAddress.IsSet {
 		newAddress := fmt.Sprintf("%s:%d", data.NewAddress.HostName, data.NewAddress.Port)
 		if host.GetAddress() != newAddress {
 			err = checkConnectivity(
@@ -298,6 +439,21 @@ func (c *hostUpdateCommand) Response(user saultregistry.UserRegistry, channel sa
 	if data.NewIsActive.IsSet {
 		host.IsActive = data.NewIsActive.Value
 	}
+	if data.NewAddresses.IsSet {
+		host.Addresses = data.NewAddresses.Value
+	}
+	if data.NewIdleTimeout.IsSet {
+		host.IdleTimeout = data.NewIdleTimeout.Value
+	}
+	if data.NewMaxDuration.IsSet {
+		host.MaxDuration = data.NewMaxDuration.Value
+	}
+	if data.NewBanner.IsSet {
+		host.Banner = data.NewBanner.Value
+	}
+	if data.NewSensitive.IsSet {
+		host.Sensitive = data.NewSensitive.Value
+	}
 
 	var errString string
 	var notUpdated bool
@@ -1,9 +1,11 @@
 package saultcommands
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 
 	"github.com/Sirupsen/logrus"
@@ -16,6 +18,8 @@ var log *logrus.Logger
 
 var sshDirectory = "~/.ssh"
 var authorizedKeyFile = "~/.ssh/authorized_keys"
+var sshdConfigFile = "/etc/ssh/sshd_config"
+var trustedUserCAKeysFile = "/etc/ssh/sault_user_ca.pub"
 
 func init() {
 	log = logrus.New()
@@ -49,6 +53,8 @@ func sshAgentAuthMethod(signer saultssh.Signer) ([]saultssh.AuthMethod, error) {
 
 	return []saultssh.AuthMethod{
 		saultssh.PublicKeysCallback(signerCallback),
+		// the second factor, like TOTP, after the public key
+		saultssh.KeyboardInteractive(saultcommon.ReadKeyboardInteractive),
 	}, nil
 }
 
@@ -100,12 +106,34 @@ func responseMsgFromJSON(b []byte, data interface{}) (*saultcommon.ResponseMsg,
 	return &rm, nil
 }
 
-func runCommand(
+// commandRunner runs the command without connecting to sault server, like in
+// the admin shell of sault server.
+type commandRunner interface {
+	start(msg *saultcommon.CommandMsg) (stdout io.Reader, wait func() error, closeFunc func())
+}
+
+// commandOutput returns the output of command; it is os.Stdout except in the
+// admin shell.
+func commandOutput(mainFlags *saultflags.Flags) io.Writer {
+	if out, ok := mainFlags.Values["Output"].(io.Writer); ok {
+		return out
+	}
+
+	return os.Stdout
+}
+
+// startCommand sends the command to sault server and returns the output of
+// it; closeFunc must be called after the output is consumed.
+func startCommand(
 	mainFlags *saultflags.Flags,
-	command string,
-	data interface{},
-	out interface{},
-) (response *saultcommon.ResponseMsg, err error) {
+	msg *saultcommon.CommandMsg,
+) (stdout io.Reader, wait func() error, closeFunc func(), err error) {
+	if runner, ok := mainFlags.Values["Runner"].(commandRunner); ok {
+		log.Debugf("run command in sault server: %v", msg)
+		stdout, wait, closeFunc = runner.start(msg)
+		return
+	}
+
 	saultServer := mainFlags.Values["Sault"].(saultcommon.FlagSaultServer)
 	identity := mainFlags.Values["Identity"].(saultcommon.FlagPrivateKey).Signer
 
@@ -114,30 +142,67 @@ func runCommand(
 	if err != nil {
 		return
 	}
-	defer connection.Close()
 
-	var msg *saultcommon.CommandMsg
-	msg, err = saultcommon.NewCommandMsg(command, data)
-	if err != nil {
+	var session *saultssh.Session
+	if session, err = connection.NewSession(); err != nil {
+		connection.Close()
 		return
 	}
 
-	var output []byte
+	closeFunc = func() {
+		session.Close()
+		connection.Close()
+	}
 
-	session, err := connection.NewSession()
-	if err != nil {
+	if stdout, err = session.StdoutPipe(); err != nil {
+		closeFunc()
 		return
 	}
-	defer session.Close()
 
 	// marshal command
 	log.Debugf("run command: %v", msg)
-	output, err = session.Output(string(saultssh.Marshal(msg)))
-	if err != nil {
+	if err = session.Start(string(saultssh.Marshal(msg))); err != nil {
+		closeFunc()
+		return
+	}
+
+	wait = func() error {
+		err := session.Wait()
 		if exitError, ok := err.(*saultssh.ExitError); ok {
-			err = fmt.Errorf("ExitError: %v", exitError)
-			return
+			return fmt.Errorf("ExitError: %v", exitError)
 		}
+
+		return err
+	}
+
+	return
+}
+
+func runCommand(
+	mainFlags *saultflags.Flags,
+	command string,
+	data interface{},
+	out interface{},
+) (response *saultcommon.ResponseMsg, err error) {
+	var msg *saultcommon.CommandMsg
+	msg, err = saultcommon.NewCommandMsg(command, data)
+	if err != nil {
+		return
+	}
+
+	var stdout io.Reader
+	var wait func() error
+	var closeFunc func()
+	if stdout, wait, closeFunc, err = startCommand(mainFlags, msg); err != nil {
+		return
+	}
+	defer closeFunc()
+
+	var output []byte
+	if output, err = ioutil.ReadAll(stdout); err != nil {
+		return
+	}
+	if err = wait(); err != nil {
 		return
 	}
 
@@ -153,3 +218,54 @@ func runCommand(
 
 	return
 }
+
+// runStreamCommand is similar to runCommand, but after the first line of
+// response message, the rest of output from sault server is copied to stream.
+func runStreamCommand(
+	mainFlags *saultflags.Flags,
+	command string,
+	data interface{},
+	out interface{},
+	stream io.Writer,
+) (response *saultcommon.ResponseMsg, err error) {
+	var msg *saultcommon.CommandMsg
+	msg, err = saultcommon.NewCommandMsg(command, data)
+	if err != nil {
+		return
+	}
+
+	var stdout io.Reader
+	var wait func() error
+	var closeFunc func()
+	if stdout, wait, closeFunc, err = startCommand(mainFlags, msg); err != nil {
+		return
+	}
+	defer closeFunc()
+
+	reader := bufio.NewReader(stdout)
+
+	var line []byte
+	if line, err = reader.ReadBytes('\n'); err != nil && err != io.EOF {
+		return
+	}
+
+	response, err = responseMsgFromJSON(line, out)
+	if err != nil {
+		return
+	}
+
+	if response.Err != nil {
+		err = response.Err
+		return
+	}
+
+	if _, err = io.Copy(stream, reader); err != nil {
+		return
+	}
+
+	if err = wait(); err != nil {
+		return
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var serverBansClearFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "server bans clear" | yellow }} removes the bans and the failures of the given addresses or public key fingerprints. Without any address or fingerprint, all of them will be removed.
+
+For example,
+  * {{ "$ sault server bans clear 192.168.99.1" | magenta }}
+  * {{ "$ sault server bans clear SHA256:2qdRWsCxQ9y4y2vwzuLkdEw3+oD7I3ZcYNzCDJvlwyI" | magenta }}
+		`,
+		nil,
+	)
+
+	serverBansClearFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "server bans clear",
+		Name:         "clear",
+		Help:         "remove the bans",
+		Usage:        "[<address or fingerprint>...]",
+		Description:  description,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseServerBansClearCommandFlags,
+		IsPositioned: true,
+	}
+
+	sault.Commands[serverBansClearFlagsTemplate.ID] = &serverBansClearCommand{}
+}
+
+func parseServerBansClearCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	f.Values["Keys"] = f.Args()
+
+	return nil
+}
+
+type serverBansClearRequestData struct {
+	Keys []string
+}
+
+type serverBansClearCommand struct{}
+
+func (c *serverBansClearCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var bans []sault.BanInfo
+	_, err = runCommand(
+		allFlags[0],
+		serverBansClearFlagsTemplate.ID,
+		serverBansClearRequestData{Keys: thisFlags.Values["Keys"].([]string)},
+		&bans,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printBansData("ban-cleared", bans, nil))
+
+	return nil
+}
+
+func (c *serverBansClearCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data serverBansClearRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	cleared := server.Bans().Clear(data.Keys...)
+
+	log.Debugf("%d bans cleared by '%s'", len(cleared), user.ID)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		cleared,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package saultcommands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var serverBansListFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "server bans list" | yellow }} shows the source addresses and the public key fingerprints, which failed to authenticate or are banned by the repeated failures.
+		`,
+		nil,
+	)
+
+	serverBansListFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "server bans list",
+		Name:        "list",
+		Help:        "list the banned addresses and public keys",
+		Usage:       "[flags]",
+		Description: description,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "Banned",
+				Help:  "only the banned",
+				Value: false,
+			},
+		},
+	}
+
+	sault.Commands[serverBansListFlagsTemplate.ID] = &serverBansListCommand{}
+}
+
+type serverBansListRequestData struct {
+	Banned bool
+}
+
+type serverBansListCommand struct{}
+
+func (c *serverBansListCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var bans []sault.BanInfo
+	_, err = runCommand(
+		allFlags[0],
+		serverBansListFlagsTemplate.ID,
+		serverBansListRequestData{Banned: thisFlags.Values["Banned"].(bool)},
+		&bans,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printBansData("ban-list", bans, nil))
+
+	return nil
+}
+
+func (c *serverBansListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data serverBansListRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	result := []sault.BanInfo{}
+	for _, b := range server.Bans().GetBans() {
+		if data.Banned && !b.IsBanned(now) {
+			continue
+		}
+		result = append(result, b)
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		result,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spikeekips/sault/common"
@@ -17,6 +16,7 @@ var serverPrintFlagsTemplate *saultflags.FlagsTemplate
 var availableServerPrintKinds = []string{
 	"saultbuildinfo",
 	"clientkey",
+	"userca",
 	"config",
 	"registry",
 }
@@ -30,6 +30,7 @@ func init() {
 		Description: `{{ "server print" | yellow }} prints the sault server informations.
 * {{ "saultbuildinfo" | yellow }}: prints the server build informations, build date, build environment, etc.
 * {{ "cilentkey" | yellow }}: prints the private and public key to connect the host
+* {{ "userca" | yellow }}: prints the public key of user CA, which signs the user certificates to connect the host
 * {{ "config" | yellow }}: prints the current running sault configurations
 * {{ "registry" | yellow }}: prints the current running sault registry
 		`,
@@ -68,6 +69,7 @@ func parseServerPrintCommandFlags(f *saultflags.Flags, args []string) (err error
 type serverPrintResponseData struct {
 	SaultBuildInfo string
 	ClientKey      [][]byte
+	UserCA         []byte
 	Config         []byte
 	Registry       []byte
 }
@@ -88,42 +90,52 @@ func (c *serverPrintCommand) Request(allFlags []*saultflags.Flags, thisFlags *sa
 	}
 
 	line, _ := saultcommon.SimpleTemplating(`{{ line "=" }}`, nil)
-	fmt.Fprintf(os.Stdout, "%s", line)
+	fmt.Fprintf(commandOutput(allFlags[0]), "%s", line)
 	for _, kind := range kinds {
 		switch kind {
 		case "saultbuildinfo":
 			fmt.Fprintf(
-				os.Stdout,
+				commandOutput(allFlags[0]),
 				"%s",
 				printServerKind("default", "sault info", data.SaultBuildInfo),
 			)
 		case "clientkey":
 			fmt.Fprintf(
-				os.Stdout,
+				commandOutput(allFlags[0]),
 				"%s\n%s\n",
 				printServerKind("default", "client private key", strings.TrimSpace(string(data.ClientKey[0]))),
 				printServerKind("default", "client public key", strings.TrimSpace(string(data.ClientKey[1]))),
 			)
+		case "userca":
+			userCA := "user CA is not set"
+			if len(data.UserCA) > 0 {
+				userCA = strings.TrimSpace(string(data.UserCA))
+			}
+			fmt.Fprintf(
+				commandOutput(allFlags[0]),
+				"%s\n",
+				printServerKind("default", "user CA public key", userCA),
+			)
 		case "config":
 			fmt.Fprintf(
-				os.Stdout,
+				commandOutput(allFlags[0]),
 				"%s\n",
 				printServerKind("default", "sault configuration", strings.TrimSpace(string(data.Config))),
 			)
 		case "registry":
 			fmt.Fprintf(
-				os.Stdout,
+				commandOutput(allFlags[0]),
 				"%s\n",
 				printServerKind("default", "sault registry", strings.TrimSpace(string(data.Registry))),
 			)
 		}
 	}
-	fmt.Fprintf(os.Stdout, "%s", line)
+	fmt.Fprintf(commandOutput(allFlags[0]), "%s", line)
 
 	return nil
 }
 
-func (c *serverPrintCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *serverPrintCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data []string
 	err = msg.GetData(&data)
 	if err != nil {
@@ -145,6 +157,10 @@ func (c *serverPrintCommand) Response(user saultregistry.UserRegistry, channel s
 				config.Server.GetClientKey(),
 				encoded,
 			}
+		case "userca":
+			if signer := config.Server.GetUserCASigner(); signer != nil {
+				result.UserCA, _ = saultcommon.EncodePublicKey(signer.PublicKey())
+			}
 		}
 	}
 
@@ -3,7 +3,9 @@ package saultcommands
 import (
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/core"
@@ -100,6 +102,7 @@ func (c *serverRunCommand) Request(allFlags []*saultflags.Flags, thisFlags *saul
 	cr := config.Registry.GetSources()
 
 	registry := saultregistry.NewRegistry()
+	registry.OnSourceError = sault.CountRegistrySourceError
 	if err = registry.AddSource(cr...); err != nil {
 		return
 	}
@@ -119,13 +122,23 @@ func (c *serverRunCommand) Request(allFlags []*saultflags.Flags, thisFlags *saul
 		return err
 	}
 
-	if err = proxy.Run(config.Server.Bind); err != nil {
+	// the all listeners are closed by SIGTERM
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM)
+	go func() {
+		for s := range signals {
+			log.Infof("got signal, %v; closing the listeners", s)
+			proxy.Close()
+		}
+	}()
+
+	if err = proxy.Run(); err != nil {
 		return
 	}
 
 	return nil
 }
 
-func (c *serverRunCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) error {
+func (c *serverRunCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) error {
 	return nil
 }
@@ -0,0 +1,169 @@
+package saultcommands
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var serviceAddFlagsTemplate *saultflags.FlagsTemplate
+
+// flagServiceLabels is the labels of service, "<key>=<value> ..."
+type flagServiceLabels struct {
+	Value map[string]string
+}
+
+func (f *flagServiceLabels) String() string {
+	var labels []string
+	for k, v := range f.Value {
+		labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(labels)
+
+	return strings.Join(labels, " ")
+}
+
+func (f *flagServiceLabels) Set(v string) error {
+	labels := map[string]string{}
+	for _, a := range strings.Fields(v) {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || len(kv[0]) < 1 {
+			return fmt.Errorf("invalid label, '%s'; it must be '<key>=<value>'", a)
+		}
+		labels[kv[0]] = kv[1]
+	}
+
+	f.Value = labels
+	return nil
+}
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "service add" | yellow }} will add the new service in the registry of sault server.
+The service is the tcp address, which the linked users can connect to thru the ssh tunnel of sault server.
+  * {{ "$ sault service add postgres 10.0.0.5:5432 -labels \"env=prod team=data\"" | magenta }}
+
+With {{ "user link-service" | yellow }}, the user can use the service.
+		`,
+		nil,
+	)
+
+	var serviceAddLabelsFlag flagServiceLabels
+
+	serviceAddFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "service add",
+		Name:         "add",
+		Help:         "add new service",
+		Usage:        "<service name> <address, hostname:port> [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "Labels",
+				Help:  "labels of service, \"<key>=<value> ...\"",
+				Value: &serviceAddLabelsFlag,
+			},
+		},
+		ParseFunc: parseServiceAddCommandFlags,
+	}
+
+	sault.Commands[serviceAddFlagsTemplate.ID] = &serviceAddCommand{}
+}
+
+func parseServiceAddCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 2 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	name, address := subArgs[0], subArgs[1]
+	if !saultcommon.CheckServiceName(name) {
+		err = &saultcommon.InvalidServiceNameError{Name: name}
+		return
+	}
+
+	var hostName string
+	var port uint64
+	if hostName, port, err = saultcommon.SplitHostPort(address, uint64(0)); err != nil {
+		return
+	}
+	if port < 1 {
+		err = fmt.Errorf("port must be set in service address")
+		return
+	}
+
+	f.Values["Service"] = serviceAddRequestData{
+		Name:     name,
+		HostName: hostName,
+		Port:     port,
+		Labels:   f.Values["Labels"].(flagServiceLabels).Value,
+	}
+
+	return nil
+}
+
+type serviceAddRequestData struct {
+	Name     string
+	HostName string
+	Port     uint64
+	Labels   map[string]string
+}
+
+type serviceAddCommand struct{}
+
+func (c *serviceAddCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var service saultregistry.ServiceRegistry
+	_, err = runCommand(
+		allFlags[0],
+		serviceAddFlagsTemplate.ID,
+		thisFlags.Values["Service"].(serviceAddRequestData),
+		&service,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printServiceData(
+		"service-added",
+		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
+		service,
+		nil,
+	))
+
+	return nil
+}
+
+func (c *serviceAddCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data serviceAddRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var service saultregistry.ServiceRegistry
+	if service, err = registry.AddService(data.Name, data.HostName, data.Port, data.Labels); err != nil {
+		return
+	}
+
+	registry.Save()
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		service,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package saultcommands
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var serviceListFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "service list" | yellow }} gets the registered services information from sault server.
+By default, it shows all registered services ordered by name.
+		`,
+		nil,
+	)
+
+	serviceListFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "service list",
+		Name:         "list",
+		Help:         "get services information",
+		Usage:        "[<service name>...]",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseServiceListCommandFlags,
+	}
+
+	sault.Commands[serviceListFlagsTemplate.ID] = &serviceListCommand{}
+}
+
+func parseServiceListCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	for _, a := range subArgs {
+		if !saultcommon.CheckServiceName(a) {
+			err = &saultcommon.InvalidServiceNameError{Name: a}
+			return
+		}
+	}
+
+	f.Values["Names"] = subArgs
+
+	return nil
+}
+
+type serviceListResponseServices []saultregistry.ServiceRegistry
+
+func (s serviceListResponseServices) Len() int {
+	return len(s)
+}
+
+func (s serviceListResponseServices) Less(i, j int) bool {
+	return s[i].Name < s[j].Name
+}
+
+func (s serviceListResponseServices) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+	return
+}
+
+type serviceListCommand struct{}
+
+func (c *serviceListCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var services serviceListResponseServices
+	_, err = runCommand(
+		allFlags[0],
+		serviceListFlagsTemplate.ID,
+		thisFlags.Values["Names"].([]string),
+		&services,
+	)
+	if err != nil {
+		return
+	}
+
+	sort.Sort(services)
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printServicesData(
+		"service-list",
+		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
+		services,
+		nil,
+	))
+
+	return nil
+}
+
+func (c *serviceListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data []string
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	services := serviceListResponseServices{}
+	for _, s := range registry.GetServices(data...) {
+		services = append(services, s)
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		services,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
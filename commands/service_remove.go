@@ -0,0 +1,114 @@
+package saultcommands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var serviceRemoveFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "service remove" | yellow }} will remove the services in the registry of sault server. The links of users to the removed services are also removed.
+		`,
+		nil,
+	)
+
+	serviceRemoveFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "service remove",
+		Name:         "remove",
+		Help:         "remove the services",
+		Usage:        "<service name> [<service name>...] [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseServiceRemoveCommandFlags,
+	}
+
+	sault.Commands[serviceRemoveFlagsTemplate.ID] = &serviceRemoveCommand{}
+}
+
+func parseServiceRemoveCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) < 1 {
+		err = fmt.Errorf("set service names")
+		return
+	}
+
+	for _, a := range subArgs {
+		if !saultcommon.CheckServiceName(a) {
+			err = &saultcommon.InvalidServiceNameError{Name: a}
+			return
+		}
+	}
+
+	f.Values["Names"] = subArgs
+
+	return nil
+}
+
+type serviceRemoveCommand struct{}
+
+func (c *serviceRemoveCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	names := thisFlags.Values["Names"].([]string)
+	_, err = runCommand(
+		allFlags[0],
+		serviceRemoveFlagsTemplate.ID,
+		names,
+		nil,
+	)
+	if err != nil {
+		return
+	}
+
+	var m string
+	if len(names) < 2 {
+		m = fmt.Sprintf("service, %s was successfully removed", names[0])
+	} else {
+		m = fmt.Sprintf("services, %s were successfully removed", strings.Join(names, ", "))
+	}
+	fmt.Fprintf(commandOutput(allFlags[0]), m+"\n")
+
+	return nil
+}
+
+func (c *serviceRemoveCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data []string
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range data {
+		if _, err = registry.GetService(s); err != nil {
+			return
+		}
+	}
+
+	for _, s := range data {
+		if err = registry.RemoveService(s); err != nil {
+			return
+		}
+	}
+
+	registry.Save()
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		nil,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
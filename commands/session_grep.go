@@ -0,0 +1,173 @@
+package saultcommands
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionGrepFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session grep" | yellow }} searches the transcripts of the recorded sessions.
+
+{{ "<pattern>" | yellow }} is the regular expression, see https://golang.org/pkg/regexp/syntax/ . The terminal escape sequences are stripped from the transcripts before searching, and the matched line is shown with the recording id and the time offset from the start of the recording.
+
+The same flags with {{ "session recordings" | yellow }} can narrow the recordings to be searched.
+{{ "-i" | yellow }}: case insensitive
+
+For example,
+  * {{ "$ sault session grep -host prometeus 'rm -rf'" | magenta }}
+		`,
+		nil,
+	)
+
+	sessionGrepFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "session grep",
+		Name:        "grep",
+		Help:        "search the transcripts of the recorded sessions",
+		Usage:       "[flags] <pattern>",
+		Description: description,
+		Flags: append(
+			sessionFilterFlags(),
+			saultflags.FlagTemplate{
+				Name:  "I",
+				Help:  "case insensitive",
+				Value: false,
+			},
+		),
+		ParseFunc:    parseSessionGrepCommandFlags,
+		IsPositioned: true,
+	}
+
+	sault.Commands[sessionGrepFlagsTemplate.ID] = &sessionGrepCommand{}
+}
+
+func parseSessionGrepCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	if err = parseSessionFilterFlags(f, args); err != nil {
+		return
+	}
+
+	subArgs := f.Args()
+	if len(subArgs) != 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	pattern := subArgs[0]
+	if f.Values["I"].(bool) {
+		pattern = "(?i)" + pattern
+	}
+
+	if _, err = regexp.Compile(pattern); err != nil {
+		err = fmt.Errorf("invalid <pattern>, '%s': %v", subArgs[0], err)
+		return
+	}
+
+	f.Values["Pattern"] = pattern
+
+	return nil
+}
+
+type sessionGrepRequestData struct {
+	Filter  sessionFilterData
+	Pattern string
+}
+
+type sessionGrepMatchData struct {
+	RecordingID string
+	UserID      string
+	HostID      string
+	Account     string
+	TimeStarted time.Time
+	Offset      time.Duration
+	Line        string
+}
+
+type sessionGrepCommand struct{}
+
+func (c *sessionGrepCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var matches []sessionGrepMatchData
+	_, err = runCommand(
+		allFlags[0],
+		sessionGrepFlagsTemplate.ID,
+		sessionGrepRequestData{
+			Filter:  newSessionFilterData(thisFlags),
+			Pattern: thisFlags.Values["Pattern"].(string),
+		},
+		&matches,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printRecordingsData("recording-grep", matches, nil))
+
+	return nil
+}
+
+func (c *sessionGrepCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionGrepRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var re *regexp.Regexp
+	if re, err = regexp.Compile(data.Pattern); err != nil {
+		return
+	}
+
+	var recordings []sault.RecordingInfo
+	if recordings, err = getFilteredRecordings(config, data.Filter); err != nil {
+		return
+	}
+
+	result := []sessionGrepMatchData{}
+	for _, info := range recordings {
+		err := sault.ReadRecordingLines(
+			config.Recording.GetDirectory(),
+			info.ID,
+			func(line sault.RecordingLine) error {
+				if !re.MatchString(line.Line) {
+					return nil
+				}
+
+				result = append(result, sessionGrepMatchData{
+					RecordingID: info.ID,
+					UserID:      info.Header.User,
+					HostID:      info.Header.Host,
+					Account:     info.Header.Account,
+					TimeStarted: info.TimeStarted(),
+					Offset:      line.Offset / time.Millisecond * time.Millisecond,
+					Line:        line.Line,
+				})
+
+				return nil
+			},
+		)
+		if err != nil {
+			log.Errorf("failed to read recording, '%s': %v", info.ID, err)
+		}
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		result,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
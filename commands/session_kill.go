@@ -0,0 +1,157 @@
+package saultcommands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionKillFlagsTemplate *saultflags.FlagsTemplate
+
+var defaultSessionKillReason = "killed by admin"
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session kill" | yellow }} closes the active sessions. The affected users will get the reason before closing.
+
+The sessions can be selected by the session id, which can be found by {{ "session list" | yellow }}, or by the user and host.
+{{ "-user <user id>" | yellow }}: the proxied sessions of user
+{{ "-host <host id>" | yellow }}: the proxied sessions to host
+{{ "-reason <reason>" | yellow }}: the reason, which will be shown to the users
+
+For example,
+  * {{ "$ sault session kill 8e9ad7c07f3bc0a6a6e0c20a1a5dbbbc" | magenta }}
+  * {{ "$ sault session kill -user spikeekips -host prometeus -reason \"maintenance\"" | magenta }}
+		`,
+		nil,
+	)
+
+	sessionKillFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "session kill",
+		Name:        "kill",
+		Help:        "close the active sessions",
+		Usage:       "[<session id>] [flags]",
+		Description: description,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "User",
+				Help:  "user id",
+				Value: "",
+			},
+			saultflags.FlagTemplate{
+				Name:  "Host",
+				Help:  "host id",
+				Value: "",
+			},
+			saultflags.FlagTemplate{
+				Name:  "Reason",
+				Help:  "reason to be shown to the users",
+				Value: defaultSessionKillReason,
+			},
+		},
+		ParseFunc:    parseSessionKillCommandFlags,
+		IsPositioned: true,
+	}
+
+	sault.Commands[sessionKillFlagsTemplate.ID] = &sessionKillCommand{}
+}
+
+func parseSessionKillCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	if err = parseSessionListCommandFlags(f, args); err != nil {
+		return
+	}
+
+	subArgs := f.Args()
+	if len(subArgs) > 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	var sessionID string
+	if len(subArgs) == 1 {
+		sessionID = subArgs[0]
+	}
+
+	hasFilter := len(f.Values["User"].(string)) > 0 || len(f.Values["Host"].(string)) > 0
+	if len(sessionID) > 0 && hasFilter {
+		err = fmt.Errorf("<session id> can not be with '-user' or '-host'")
+		return
+	}
+	if len(sessionID) < 1 && !hasFilter {
+		err = fmt.Errorf("<session id> or '-user' or '-host' must be given")
+		return
+	}
+
+	if len(strings.TrimSpace(f.Values["Reason"].(string))) < 1 {
+		f.Values["Reason"] = defaultSessionKillReason
+	}
+
+	f.Values["SessionID"] = sessionID
+
+	return nil
+}
+
+type sessionKillRequestData struct {
+	SessionID string
+	UserID    string
+	HostID    string
+	Reason    string
+}
+
+type sessionKillCommand struct{}
+
+func (c *sessionKillCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var sessions []sault.SessionInfo
+	_, err = runCommand(
+		allFlags[0],
+		sessionKillFlagsTemplate.ID,
+		sessionKillRequestData{
+			SessionID: thisFlags.Values["SessionID"].(string),
+			UserID:    thisFlags.Values["User"].(string),
+			HostID:    thisFlags.Values["Host"].(string),
+			Reason:    thisFlags.Values["Reason"].(string),
+		},
+		&sessions,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printSessionsData("session-killed", sessions, nil))
+
+	return nil
+}
+
+func (c *sessionKillCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionKillRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var killed []sault.SessionInfo
+	killed, err = server.Sessions().Kill(data.SessionID, data.UserID, data.HostID, data.Reason)
+	if err != nil {
+		return
+	}
+
+	log.Debugf("%d sessions killed by '%s': %s", len(killed), user.ID, data.Reason)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		killed,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
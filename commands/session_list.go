@@ -0,0 +1,121 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionListFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session list" | yellow }} shows the active sessions in sault server with the open channels and the transferred bytes.
+
+The belowed flags help to filter the sessions, by default, it shows all the active sessions.
+{{ "-user <user id>" | yellow }}: sessions of user
+{{ "-host <host id>" | yellow }}: sessions to host
+		`,
+		nil,
+	)
+
+	sessionListFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "session list",
+		Name:        "list",
+		Help:        "list the active sessions",
+		Usage:       "[flags]",
+		Description: description,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "User",
+				Help:  "user id",
+				Value: "",
+			},
+			saultflags.FlagTemplate{
+				Name:  "Host",
+				Help:  "host id",
+				Value: "",
+			},
+		},
+		ParseFunc: parseSessionListCommandFlags,
+	}
+
+	sault.Commands[sessionListFlagsTemplate.ID] = &sessionListCommand{}
+}
+
+func parseSessionListCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	if userID := f.Values["User"].(string); len(userID) > 0 && !saultcommon.CheckUserID(userID) {
+		err = &saultcommon.InvalidUserIDError{ID: userID}
+		return
+	}
+
+	if hostID := f.Values["Host"].(string); len(hostID) > 0 && !saultcommon.CheckHostID(hostID) {
+		err = &saultcommon.InvalidHostIDError{ID: hostID}
+		return
+	}
+
+	return nil
+}
+
+type sessionListRequestData struct {
+	UserID string
+	HostID string
+}
+
+type sessionListCommand struct{}
+
+func (c *sessionListCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var sessions []sault.SessionInfo
+	_, err = runCommand(
+		allFlags[0],
+		sessionListFlagsTemplate.ID,
+		sessionListRequestData{
+			UserID: thisFlags.Values["User"].(string),
+			HostID: thisFlags.Values["Host"].(string),
+		},
+		&sessions,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printSessionsData("session-list", sessions, nil))
+
+	return nil
+}
+
+func (c *sessionListCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionListRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	result := []sault.SessionInfo{}
+	for _, s := range server.Sessions().GetSessions() {
+		if len(data.UserID) > 0 && s.UserID != data.UserID {
+			continue
+		}
+		if len(data.HostID) > 0 && s.HostID != data.HostID {
+			continue
+		}
+		result = append(result, s)
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		result,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package saultcommands
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionPlayFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session play" | yellow }} replays the recorded pty session from sault server.
+
+The recording is streamed from sault server with the original timing.
+{{ "-speed <speed>" | yellow }}: playback speed, {{ "2" | yellow }} is 2 times faster than the original.
+{{ "-idle <seconds>" | yellow }}: limits the idle time between the outputs, by default, it is not limited.
+
+For example,
+  * {{ "$ sault session play 8e9ad7c07f3bc0a6a6e0c20a1a5dbbbc-1 -speed 2 -idle 1" | magenta }}
+		`,
+		nil,
+	)
+
+	sessionPlayFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "session play",
+		Name:        "play",
+		Help:        "replay the recorded session",
+		Usage:       "<recording id> [flags]",
+		Description: description,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "Speed",
+				Help:  "playback speed",
+				Value: 1.0,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Idle",
+				Help:  "max idle time in seconds, 0 is not limited",
+				Value: 0.0,
+			},
+		},
+		ParseFunc:    parseSessionPlayCommandFlags,
+		IsPositioned: true,
+	}
+
+	sault.Commands[sessionPlayFlagsTemplate.ID] = &sessionPlayCommand{}
+}
+
+func parseSessionPlayCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	if _, err = sault.GetRecordingPath("", subArgs[0]); err != nil {
+		return
+	}
+
+	if f.Values["Speed"].(float64) <= 0 {
+		err = fmt.Errorf("'-speed' must be greater than 0")
+		return
+	}
+
+	if f.Values["Idle"].(float64) < 0 {
+		err = fmt.Errorf("'-idle' must not be negative")
+		return
+	}
+
+	f.Values["RecordingID"] = subArgs[0]
+
+	return nil
+}
+
+type sessionPlayRequestData struct {
+	RecordingID string
+	Speed       float64
+	Idle        float64
+}
+
+type sessionPlayCommand struct{}
+
+func (c *sessionPlayCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var info sault.RecordingInfo
+	_, err = runStreamCommand(
+		allFlags[0],
+		sessionPlayFlagsTemplate.ID,
+		sessionPlayRequestData{
+			RecordingID: thisFlags.Values["RecordingID"].(string),
+			Speed:       thisFlags.Values["Speed"].(float64),
+			Idle:        thisFlags.Values["Idle"].(float64),
+		},
+		&info,
+		commandOutput(allFlags[0]),
+	)
+	if err != nil {
+		return
+	}
+
+	t, _ := saultcommon.SimpleTemplating(
+		`
+{{ line "=" }}
+end of recording, {{ .ID | yellow }}, {{ .Header.Account }}+{{ .Header.Host | colorHostID }} by {{ .Header.User | colorUserID }}
+{{ line "=" }}
+`,
+		info,
+	)
+	fmt.Fprintf(commandOutput(allFlags[0]), t)
+
+	return nil
+}
+
+func (c *sessionPlayCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionPlayRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	if data.Speed <= 0 {
+		data.Speed = 1
+	}
+
+	directory := config.Recording.GetDirectory()
+
+	var info sault.RecordingInfo
+	if info, err = sault.GetRecording(directory, data.RecordingID); err != nil {
+		return
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		info,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	if _, err = channel.Write(append(response, '\n')); err != nil {
+		return
+	}
+
+	// NOTE the response message was already sent, so the error while playing
+	// can not be delivered to the client.
+	var last float64
+	err = sault.ReadRecording(directory, data.RecordingID, func(event sault.RecordingEvent) error {
+		if event.Code != "o" {
+			return nil
+		}
+
+		wait := event.Time - last
+		if data.Idle > 0 && wait > data.Idle {
+			wait = data.Idle
+		}
+		last = event.Time
+
+		time.Sleep(time.Duration(wait / data.Speed * float64(time.Second)))
+
+		_, err := channel.Write([]byte(event.Data))
+		return err
+	})
+	if err != nil {
+		log.Errorf("failed to play recording, '%s': %v", data.RecordingID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package saultcommands
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionRecordingsFlagsTemplate *saultflags.FlagsTemplate
+
+type flagSessionTime struct {
+	IsSet bool
+	Value time.Time
+}
+
+func (f *flagSessionTime) String() string {
+	if !f.IsSet {
+		return ""
+	}
+
+	return f.Value.Format(time.RFC3339)
+}
+
+// Set accepts the duration from now, like '2h' or the time, like
+// '2017-07-01', '2017-07-01T15:04:05+09:00'
+func (f *flagSessionTime) Set(v string) (err error) {
+	v = strings.TrimSpace(v)
+
+	var t time.Time
+	if d, e := time.ParseDuration(v); e == nil {
+		t = time.Now().Add(-d)
+	} else if t, err = time.Parse(time.RFC3339, v); err != nil {
+		if t, err = time.ParseInLocation("2006-01-02", v, time.Local); err != nil {
+			return fmt.Errorf("invalid time, '%s'", v)
+		}
+	}
+
+	*f = flagSessionTime{IsSet: true, Value: t.UTC()}
+
+	return nil
+}
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session recordings" | yellow }} lists the recorded pty sessions in sault server.
+
+The belowed flags help to filter the recordings, by default, it shows all the recordings.
+{{ "-user <user id>" | yellow }}: recordings of user
+{{ "-host <host id>" | yellow }}: recordings of host
+{{ "-since <time>" | yellow }}, {{ "-until <time>" | yellow }}: recordings started in the time range, {{ "<time>" | yellow }} can be the duration from now, like {{ "2h" | yellow }}, {{ "30m" | yellow }} or the time, like {{ "2017-07-01" | yellow }}, {{ "2017-07-01T15:04:05+09:00" | yellow }}.
+
+For example,
+  * {{ "$ sault session recordings -user spikeekips -since 24h" | magenta }}
+		`,
+		nil,
+	)
+
+	sessionRecordingsFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:          "session recordings",
+		Name:        "recordings",
+		Help:        "list the recorded sessions",
+		Usage:       "[flags]",
+		Description: description,
+		Flags:       sessionFilterFlags(),
+		ParseFunc:   parseSessionFilterFlags,
+	}
+
+	sault.Commands[sessionRecordingsFlagsTemplate.ID] = &sessionRecordingsCommand{}
+}
+
+func sessionFilterFlags() []saultflags.FlagTemplate {
+	return []saultflags.FlagTemplate{
+		saultflags.FlagTemplate{
+			Name:  "User",
+			Help:  "user id",
+			Value: "",
+		},
+		saultflags.FlagTemplate{
+			Name:  "Host",
+			Help:  "host id",
+			Value: "",
+		},
+		saultflags.FlagTemplate{
+			Name:  "Since",
+			Help:  "started after, duration from now or time",
+			Value: new(flagSessionTime),
+		},
+		saultflags.FlagTemplate{
+			Name:  "Until",
+			Help:  "started before, duration from now or time",
+			Value: new(flagSessionTime),
+		},
+	}
+}
+
+func parseSessionFilterFlags(f *saultflags.Flags, args []string) (err error) {
+	if userID := f.Values["User"].(string); len(userID) > 0 && !saultcommon.CheckUserID(userID) {
+		err = &saultcommon.InvalidUserIDError{ID: userID}
+		return
+	}
+
+	if hostID := f.Values["Host"].(string); len(hostID) > 0 && !saultcommon.CheckHostID(hostID) {
+		err = &saultcommon.InvalidHostIDError{ID: hostID}
+		return
+	}
+
+	since := f.Values["Since"].(flagSessionTime)
+	until := f.Values["Until"].(flagSessionTime)
+	if since.IsSet && until.IsSet && since.Value.After(until.Value) {
+		err = fmt.Errorf("'-since' must be before '-until'")
+		return
+	}
+
+	return nil
+}
+
+type sessionFilterData struct {
+	UserID string
+	HostID string
+	Since  time.Time
+	Until  time.Time
+}
+
+func newSessionFilterData(f *saultflags.Flags) sessionFilterData {
+	return sessionFilterData{
+		UserID: f.Values["User"].(string),
+		HostID: f.Values["Host"].(string),
+		Since:  f.Values["Since"].(flagSessionTime).Value,
+		Until:  f.Values["Until"].(flagSessionTime).Value,
+	}
+}
+
+func (f sessionFilterData) match(info sault.RecordingInfo) bool {
+	if len(f.UserID) > 0 && info.Header.User != f.UserID {
+		return false
+	}
+	if len(f.HostID) > 0 && info.Header.Host != f.HostID {
+		return false
+	}
+	if !f.Since.IsZero() && info.TimeStarted().Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && info.TimeStarted().After(f.Until) {
+		return false
+	}
+
+	return true
+}
+
+func getFilteredRecordings(config *sault.Config, filter sessionFilterData) (recordings []sault.RecordingInfo, err error) {
+	var all []sault.RecordingInfo
+	if all, err = sault.GetRecordings(config.Recording.GetDirectory()); err != nil {
+		return
+	}
+
+	recordings = []sault.RecordingInfo{}
+	for _, info := range all {
+		if filter.match(info) {
+			recordings = append(recordings, info)
+		}
+	}
+
+	return
+}
+
+type sessionRecordingsCommand struct{}
+
+func (c *sessionRecordingsCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var recordings []sault.RecordingInfo
+	_, err = runCommand(
+		allFlags[0],
+		sessionRecordingsFlagsTemplate.ID,
+		newSessionFilterData(thisFlags),
+		&recordings,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printRecordingsData("recording-list", recordings, nil))
+
+	return nil
+}
+
+func (c *sessionRecordingsCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionFilterData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var recordings []sault.RecordingInfo
+	if recordings, err = getFilteredRecordings(config, data); err != nil {
+		return
+	}
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		recordings,
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
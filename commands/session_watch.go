@@ -0,0 +1,166 @@
+package saultcommands
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var sessionWatchFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "session watch" | yellow }} attaches to the live pty session and shows the output of it. The watcher can not send any input to the session, and the watched user will be notified.
+
+{{ "<id>" | yellow }} is the session id or the channel id, which can be found by {{ "session list" | yellow }}. With the session id, the first pty session will be watched.
+
+It also can be run with ssh, press {{ "ctrl-c" | yellow }} to stop watching.
+  * {{ "$ ssh -t sault@<sault server> session watch <id>" | magenta }}
+		`,
+		nil,
+	)
+
+	sessionWatchFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "session watch",
+		Name:         "watch",
+		Help:         "watch the live pty session",
+		Usage:        "<id>",
+		Description:  description,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseSessionWatchCommandFlags,
+		IsPositioned: true,
+	}
+
+	sault.Commands[sessionWatchFlagsTemplate.ID] = &sessionWatchCommand{}
+}
+
+func parseSessionWatchCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	f.Values["ID"] = subArgs[0]
+
+	return nil
+}
+
+type sessionWatchRequestData struct {
+	ID string
+}
+
+type sessionWatchCommand struct{}
+
+func (c *sessionWatchCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var session sault.SessionInfo
+	_, err = runStreamCommand(
+		allFlags[0],
+		sessionWatchFlagsTemplate.ID,
+		sessionWatchRequestData{ID: thisFlags.Values["ID"].(string)},
+		&session,
+		commandOutput(allFlags[0]),
+	)
+	if err != nil {
+		return
+	}
+
+	t, _ := saultcommon.SimpleTemplating(
+		`
+{{ line "=" }}
+session, {{ .ID | yellow }} of {{ .UserID | colorUserID }} was closed
+{{ line "=" }}
+`,
+		session,
+	)
+	fmt.Fprintf(commandOutput(allFlags[0]), t)
+
+	return nil
+}
+
+// keys to stop watching in ssh; ctrl-c and ctrl-d
+var sessionWatchStopKeys = []byte{0x03, 0x04}
+
+func (c *sessionWatchCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data sessionWatchRequestData
+	if msg.IsSaultClient {
+		if err = msg.GetData(&data); err != nil {
+			return
+		}
+	} else {
+		var args []string
+		if err = msg.GetData(&args); err != nil {
+			return
+		}
+		if len(args) != 1 {
+			err = fmt.Errorf("usage: session watch <id>")
+			return
+		}
+		data.ID = args[0]
+	}
+
+	var watcher *sault.SessionWatcher
+	if watcher, err = server.Sessions().Watch(data.ID, user.ID); err != nil {
+		return
+	}
+
+	if msg.IsSaultClient {
+		var response []byte
+		response, err = saultcommon.NewResponseMsg(
+			watcher.Session,
+			saultcommon.CommandErrorNone,
+			nil,
+		).ToJSON()
+		if err != nil {
+			return
+		}
+		channel.Write(append(response, '\n'))
+	} else {
+		t, _ := saultcommon.SimpleTemplating(
+			"{{ \"* sault\" | blue }} watching session, {{ .Channel.ID | yellow }} of {{ .Session.UserID | colorUserID }}, press ctrl-c to stop\r\n",
+			watcher,
+		)
+		channel.Write([]byte(t))
+	}
+
+	watcher.Start(channel)
+	defer watcher.Stop()
+
+	// the input of watcher is not delivered to the watched session
+	stopped := make(chan struct{})
+	go func() {
+		b := make([]byte, 256)
+		for {
+			n, err := channel.Read(b)
+			if err == io.EOF {
+				// sault client closes the input at first
+				return
+			} else if err != nil {
+				close(stopped)
+				return
+			}
+			if bytes.IndexAny(b[:n], string(sessionWatchStopKeys)) >= 0 {
+				close(stopped)
+				return
+			}
+		}
+	}()
+
+	select {
+	case <-watcher.Done():
+	case <-watcher.Stopped():
+	case <-stopped:
+	}
+
+	if !msg.IsSaultClient {
+		t, _ := saultcommon.SimpleTemplating("\r\n{{ \"* sault\" | blue }} stopped watching\r\n", nil)
+		channel.Write([]byte(t))
+	}
+
+	return nil
+}
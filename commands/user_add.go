@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/core"
@@ -102,7 +101,7 @@ func (c *userAddCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultf
 		return
 	}
 
-	fmt.Fprintf(os.Stdout, printUserData(
+	fmt.Fprintf(commandOutput(allFlags[0]), printUserData(
 		"one-user",
 		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 		userListResponseUserData{User: user},
@@ -112,7 +111,7 @@ func (c *userAddCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultf
 	return nil
 }
 
-func (c *userAddCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userAddCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data userAddRequestData
 	err = msg.GetData(&data)
 	if err != nil {
@@ -2,8 +2,9 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/core"
@@ -14,6 +15,74 @@ import (
 
 var userLinkFlagsTemplate *saultflags.FlagsTemplate
 
+type flagUserLinkRecording struct {
+	IsSet bool
+	Value bool
+}
+
+func (f *flagUserLinkRecording) String() string { return "true" }
+
+func (f *flagUserLinkRecording) Set(v string) error {
+	p, err := saultcommon.ParseBooleanString(v)
+	if err != nil {
+		return err
+	}
+
+	f.Value = p
+	f.IsSet = true
+	return nil
+}
+
+// flagUserLinkAllow is the boolean option of link, which is not allowed by
+// default
+type flagUserLinkAllow struct {
+	IsSet bool
+	Value bool
+}
+
+func (f *flagUserLinkAllow) String() string { return strconv.FormatBool(f.Value) }
+
+// IsBoolFlag allows the flag without value, like '-agentforwarding'
+func (f *flagUserLinkAllow) IsBoolFlag() bool { return true }
+
+func (f *flagUserLinkAllow) Set(v string) error {
+	p, err := saultcommon.ParseBooleanString(v)
+	if err != nil {
+		return err
+	}
+
+	f.Value = p
+	f.IsSet = true
+	return nil
+}
+
+// flagUserLinkExpires is the duration until the link expires, like '4h';
+// empty value removes the expiry.
+type flagUserLinkExpires struct {
+	IsSet bool
+	Value time.Duration
+}
+
+func (f *flagUserLinkExpires) String() string { return f.Value.String() }
+
+func (f *flagUserLinkExpires) Set(v string) error {
+	v = strings.TrimSpace(v)
+	if len(v) < 1 {
+		f.Value = 0
+		f.IsSet = true
+		return nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return fmt.Errorf("invalid expires, '%s'", v)
+	}
+
+	f.Value = d
+	f.IsSet = true
+	return nil
+}
+
 func init() {
 	description, _ := saultcommon.SimpleTemplating(`{{ "user link" | yellow }} will link the sault user to the host. For examples,
 
@@ -29,10 +98,37 @@ Such like this case, if the '<account>'s is not specified, the user 'spikeekips'
 {{ "$ sault user link spikeekips prometeus-" | magenta }}:
 Such like appending '-' at the end of account name, this will disallow the user 'spikeekips' to access to the host, 'prometeus'.
 
+{{ "$ sault user link spikeekips prometeus -recording=false" | magenta }}:
+With the options like {{ "-recording" | yellow }} and without '<account>'s, only the options of the existing link will be updated. {{ "-recording=false" | yellow }} will stop to record the sessions of the user, 'spikeekips' to the 'prometeus' host.
+
+{{ "$ sault user link spikeekips prometeus -agentforwarding" | magenta }}:
+{{ "-agentforwarding" | yellow }} allows the user, 'spikeekips' to forward the ssh agent to the 'prometeus' host, like {{ "ssh -A" | magenta }}. The agent forwarding is not allowed by default and every signing request thru the forwarded agent is audited.
+
+{{ "$ sault user link spikeekips prometeus -remoteforwarding" | magenta }}:
+{{ "-remoteforwarding" | yellow }} allows the user, 'spikeekips' to forward the ports of the 'prometeus' host to the client, like {{ "ssh -R" | magenta }}. The remote port forwarding is not allowed by default.
+
+{{ "$ sault user link spikeekips prometeus -upload=false -download=true" | magenta }}:
+{{ "-upload" | yellow }} and {{ "-download" | yellow }} allow or block the file transfer by sftp and scp to and from the 'prometeus' host. The file transfers are allowed by default and always audited. If one of them is blocked, only scp, sftp and the shell with pty are allowed; the other commands, like 'ssh prometeus cat /etc/hosts' and the other subsystems are refused, but the interactive shell is not restricted.
+
+{{ "$ sault user link spikeekips prometeus -idletimeout 10m -maxduration 0" | magenta }}:
+{{ "-idletimeout" | yellow }} and {{ "-maxduration" | yellow }} override the session timeouts of the host and sault server for this link. '0' disables the timeout and '' follows the host.
+
+{{ "$ sault user link spikeekips prometeus -expires 4h" | magenta }}:
+{{ "-expires" | yellow }} makes the link expire after the given duration. '' removes the expiry.
+
 		`,
 		nil,
 	)
 
+	var userLinkRecordingFlag flagUserLinkRecording
+	var userLinkAgentForwardingFlag flagUserLinkAllow
+	var userLinkRemoteForwardingFlag flagUserLinkAllow
+	var userLinkUploadFlag flagUserLinkRecording
+	var userLinkDownloadFlag flagUserLinkRecording
+	var userLinkIdleTimeoutFlag flagSessionTimeout
+	var userLinkMaxDurationFlag flagSessionTimeout
+	var userLinkExpiresFlag flagUserLinkExpires
+
 	userLinkFlagsTemplate = &saultflags.FlagsTemplate{
 		ID:           "user link",
 		Name:         "link",
@@ -40,8 +136,49 @@ Such like appending '-' at the end of account name, this will disallow the user
 		Usage:        "<user id> <host id> [<account>...] [flags]",
 		Description:  description,
 		IsPositioned: true,
-		Flags:        []saultflags.FlagTemplate{},
-		ParseFunc:    parseUserLinkCommandFlags,
+		Flags: []saultflags.FlagTemplate{
+			saultflags.FlagTemplate{
+				Name:  "Recording",
+				Help:  "record the sessions of link [true false]",
+				Value: &userLinkRecordingFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "AgentForwarding",
+				Help:  "allow the ssh agent forwarding [true false]",
+				Value: &userLinkAgentForwardingFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "RemoteForwarding",
+				Help:  "allow the remote port forwarding [true false]",
+				Value: &userLinkRemoteForwardingFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Upload",
+				Help:  "allow the file upload by sftp and scp [true false]",
+				Value: &userLinkUploadFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Download",
+				Help:  "allow the file download by sftp and scp [true false]",
+				Value: &userLinkDownloadFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "IdleTimeout",
+				Help:  "idle timeout of sessions, like '30m'",
+				Value: &userLinkIdleTimeoutFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "MaxDuration",
+				Help:  "maximum session duration, like '12h'",
+				Value: &userLinkMaxDurationFlag,
+			},
+			saultflags.FlagTemplate{
+				Name:  "Expires",
+				Help:  "expire the link after the duration, like '4h'",
+				Value: &userLinkExpiresFlag,
+			},
+		},
+		ParseFunc: parseUserLinkCommandFlags,
 	}
 
 	sault.Commands[userLinkFlagsTemplate.ID] = &userLinkCommand{}
@@ -62,6 +199,40 @@ func parseUserLinkCommandFlags(f *saultflags.Flags, args []string) (err error) {
 
 	data := userLinkRequestData{UserID: userID}
 
+	if recording := f.Values["Recording"].(flagUserLinkRecording); recording.IsSet {
+		data.UpdateOptions = true
+		data.UpdateRecording = true
+		data.DisableRecording = !recording.Value
+	}
+	if agentForwarding := f.Values["AgentForwarding"].(flagUserLinkAllow); agentForwarding.IsSet {
+		data.UpdateOptions = true
+		data.AgentForwarding = agentForwarding
+	}
+	if remoteForwarding := f.Values["RemoteForwarding"].(flagUserLinkAllow); remoteForwarding.IsSet {
+		data.UpdateOptions = true
+		data.RemoteForwarding = remoteForwarding
+	}
+	if upload := f.Values["Upload"].(flagUserLinkRecording); upload.IsSet {
+		data.UpdateOptions = true
+		data.Upload = upload
+	}
+	if download := f.Values["Download"].(flagUserLinkRecording); download.IsSet {
+		data.UpdateOptions = true
+		data.Download = download
+	}
+	if idleTimeout := f.Values["IdleTimeout"].(flagSessionTimeout); idleTimeout.IsSet {
+		data.UpdateOptions = true
+		data.IdleTimeout = idleTimeout
+	}
+	if maxDuration := f.Values["MaxDuration"].(flagSessionTimeout); maxDuration.IsSet {
+		data.UpdateOptions = true
+		data.MaxDuration = maxDuration
+	}
+	if expires := f.Values["Expires"].(flagUserLinkExpires); expires.IsSet {
+		data.UpdateOptions = true
+		data.Expires = expires
+	}
+
 	hostID, minus := saultcommon.ParseMinusName(subArgs[1])
 	if !saultcommon.CheckHostID(hostID) {
 		err = &saultcommon.InvalidHostIDError{ID: hostID}
@@ -77,6 +248,11 @@ func parseUserLinkCommandFlags(f *saultflags.Flags, args []string) (err error) {
 
 	accounts := subArgs[2:]
 	if len(accounts) < 1 {
+		if data.UpdateOptions {
+			f.Values["Link"] = data
+			return
+		}
+
 		data.LinkAll = true
 		f.Values["Link"] = data
 		return
@@ -113,6 +289,17 @@ type userLinkRequestData struct {
 	AccountsRemove []string
 	UnlinkAll      bool
 	LinkAll        bool
+
+	UpdateOptions    bool
+	UpdateRecording  bool
+	DisableRecording bool
+	AgentForwarding  flagUserLinkAllow
+	RemoteForwarding flagUserLinkAllow
+	Upload           flagUserLinkRecording
+	Download         flagUserLinkRecording
+	IdleTimeout      flagSessionTimeout
+	MaxDuration      flagSessionTimeout
+	Expires          flagUserLinkExpires
 }
 
 type userLinkCommand struct{}
@@ -130,7 +317,7 @@ func (c *userLinkCommand) Request(allFlags []*saultflags.Flags, thisFlags *sault
 		return
 	}
 
-	fmt.Fprintf(os.Stdout, printUserData(
+	fmt.Fprintf(commandOutput(allFlags[0]), printUserData(
 		"one-user-updated",
 		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 		result,
@@ -140,7 +327,7 @@ func (c *userLinkCommand) Request(allFlags []*saultflags.Flags, thisFlags *sault
 	return nil
 }
 
-func (c *userLinkCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userLinkCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data userLinkRequestData
 	err = msg.GetData(&data)
 	if err != nil {
@@ -201,7 +388,7 @@ func (c *userLinkCommand) Response(u saultregistry.UserRegistry, channel saultss
 		if err = registry.UnlinkAll(user.ID, host.ID); err != nil {
 			return
 		}
-	} else {
+	} else if len(data.AccountsAdd) > 0 || len(data.AccountsRemove) > 0 {
 		if len(data.AccountsAdd) > 0 {
 			if err = registry.Link(user.ID, host.ID, data.AccountsAdd...); err != nil {
 				return
@@ -214,29 +401,47 @@ func (c *userLinkCommand) Response(u saultregistry.UserRegistry, channel saultss
 		}
 	}
 
-	var links []userLinkAccountData
-	for hostID, link := range registry.GetLinksOfUser(user.ID) {
-		_, err := registry.GetHost(hostID, saultregistry.HostFilterNone)
-		if err != nil {
-			log.Errorf("UserListCommand.Response: %v", err)
-			continue
+	if data.UpdateOptions && !data.UnlinkAll {
+		var link saultregistry.LinkAccountRegistry
+		if link, err = registry.GetLink(user.ID, host.ID); err != nil {
+			return
+		}
+
+		if data.UpdateRecording {
+			link.DisableRecording = data.DisableRecording
+		}
+		if data.AgentForwarding.IsSet {
+			link.AgentForwarding = data.AgentForwarding.Value
+		}
+		if data.RemoteForwarding.IsSet {
+			link.RemoteForwarding = data.RemoteForwarding.Value
+		}
+		if data.Upload.IsSet {
+			link.DisableUpload = !data.Upload.Value
+		}
+		if data.Download.IsSet {
+			link.DisableDownload = !data.Download.Value
+		}
+		if data.IdleTimeout.IsSet {
+			link.IdleTimeout = data.IdleTimeout.Value
+		}
+		if data.MaxDuration.IsSet {
+			link.MaxDuration = data.MaxDuration.Value
+		}
+		if data.Expires.IsSet {
+			link.DateExpires = time.Time{}
+			if data.Expires.Value > 0 {
+				link.DateExpires = time.Now().Add(data.Expires.Value)
+			}
+		}
+		if err = registry.UpdateLink(user.ID, host.ID, link); err != nil {
+			return
 		}
-		links = append(
-			links,
-			userLinkAccountData{
-				Accounts: link.Accounts,
-				All:      link.All,
-				HostID:   hostID,
-			},
-		)
 	}
 
 	registry.Save()
 
-	result := userListResponseUserData{
-		User:  user,
-		Links: links,
-	}
+	result := newUserResponseData(registry, user)
 
 	var response []byte
 	response, err = saultcommon.NewResponseMsg(
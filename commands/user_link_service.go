@@ -0,0 +1,142 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var userLinkServiceFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "user link-service" | yellow }} links the user to the services, the linked user can connect to the service by the ssh tunnel of sault server.
+With appending '{{ "-" | yellow }}' to the service name, the link is removed.
+  * {{ "$ sault user link-service spikeekips postgres grafana-" | magenta }}: link to 'postgres' and unlink from 'grafana'
+		`,
+		nil,
+	)
+
+	userLinkServiceFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "user link-service",
+		Name:         "link-service",
+		Help:         "link the user to the services",
+		Usage:        "<user id> <service name>[-] [<service name>[-]...] [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseUserLinkServiceCommandFlags,
+	}
+
+	sault.Commands[userLinkServiceFlagsTemplate.ID] = &userLinkServiceCommand{}
+}
+
+func parseUserLinkServiceCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) < 2 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	userID := subArgs[0]
+	if !saultcommon.CheckUserID(userID) {
+		err = &saultcommon.InvalidUserIDError{ID: userID}
+		return
+	}
+
+	data := userLinkServiceRequestData{UserID: userID}
+	for _, a := range subArgs[1:] {
+		name, minus := saultcommon.ParseMinusName(a)
+		if !saultcommon.CheckServiceName(name) {
+			err = &saultcommon.InvalidServiceNameError{Name: name}
+			return
+		}
+		if minus {
+			data.ServicesRemove = append(data.ServicesRemove, name)
+		} else {
+			data.ServicesAdd = append(data.ServicesAdd, name)
+		}
+	}
+
+	f.Values["Link"] = data
+	return nil
+}
+
+type userLinkServiceRequestData struct {
+	UserID         string
+	ServicesAdd    []string
+	ServicesRemove []string
+}
+
+type userLinkServiceCommand struct{}
+
+func (c *userLinkServiceCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var result userListResponseUserData
+	_, err = runCommand(
+		allFlags[0],
+		userLinkServiceFlagsTemplate.ID,
+		thisFlags.Values["Link"].(userLinkServiceRequestData),
+		&result,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printUserData(
+		"one-user-updated",
+		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
+		result,
+		nil,
+	))
+
+	return nil
+}
+
+func (c *userLinkServiceCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var data userLinkServiceRequestData
+	err = msg.GetData(&data)
+	if err != nil {
+		return err
+	}
+
+	var user saultregistry.UserRegistry
+	if user, err = registry.GetUser(data.UserID, nil, saultregistry.UserFilterNone); err != nil {
+		return
+	}
+
+	for _, s := range append(data.ServicesAdd, data.ServicesRemove...) {
+		if _, err = registry.GetService(s); err != nil {
+			return
+		}
+	}
+
+	for _, s := range data.ServicesAdd {
+		if err = registry.LinkService(user.ID, s); err != nil {
+			return
+		}
+	}
+	for _, s := range data.ServicesRemove {
+		if err = registry.UnlinkService(user.ID, s); err != nil {
+			return
+		}
+	}
+
+	registry.Save()
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		newUserResponseData(registry, user),
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
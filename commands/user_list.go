@@ -6,6 +6,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/core"
@@ -187,14 +188,63 @@ type userListRequestData struct {
 }
 
 type userLinkAccountData struct {
-	HostID   string
-	Accounts []string
-	All      bool
+	HostID           string
+	Accounts         []string
+	All              bool
+	DisableRecording bool
+	AgentForwarding  bool
+	RemoteForwarding bool
+	DisableUpload    bool
+	DisableDownload  bool
+	IdleTimeout      string
+	MaxDuration      string
+	DateExpires      time.Time
+	AccountExpires   map[string]time.Time
 }
 
 type userListResponseUserData struct {
-	User  saultregistry.UserRegistry
-	Links []userLinkAccountData
+	User     saultregistry.UserRegistry
+	Links    []userLinkAccountData
+	Services []string
+
+	// TOTP is set when the user enrolled the TOTP
+	TOTP bool
+}
+
+// newUserResponseData collects the links and services of user
+func newUserResponseData(registry *saultregistry.Registry, user saultregistry.UserRegistry) userListResponseUserData {
+	var links []userLinkAccountData
+	for hostID, link := range registry.GetLinksOfUser(user.ID) {
+		_, err := registry.GetHost(hostID, saultregistry.HostFilterNone)
+		if err != nil {
+			log.Errorf("UserListCommand.Response: %v", err)
+			continue
+		}
+		links = append(
+			links,
+			userLinkAccountData{
+				Accounts:         link.Accounts,
+				All:              link.All,
+				HostID:           hostID,
+				DisableRecording: link.DisableRecording,
+				AgentForwarding:  link.AgentForwarding,
+				RemoteForwarding: link.RemoteForwarding,
+				DisableUpload:    link.DisableUpload,
+				DisableDownload:  link.DisableDownload,
+				IdleTimeout:      link.IdleTimeout,
+				MaxDuration:      link.MaxDuration,
+				DateExpires:      link.DateExpires,
+				AccountExpires:   link.AccountExpires,
+			},
+		)
+	}
+
+	return userListResponseUserData{
+		User:     user,
+		Links:    links,
+		Services: registry.GetServicesOfUser(user.ID),
+		TOTP:     user.HasTOTP(),
+	}
 }
 
 type userListResponseData []userListResponseUserData
@@ -239,7 +289,7 @@ func (c *userListCommand) Request(allFlags []*saultflags.Flags, thisFlags *sault
 		sort.Sort(users)
 	}
 
-	fmt.Fprintf(os.Stdout, printUsersData(
+	fmt.Fprintf(commandOutput(allFlags[0]), printUsersData(
 		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 		users,
 	))
@@ -247,7 +297,7 @@ func (c *userListCommand) Request(allFlags []*saultflags.Flags, thisFlags *sault
 	return nil
 }
 
-func (c *userListCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userListCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data userListRequestData
 	err = msg.GetData(&data)
 	if err != nil {
@@ -268,29 +318,9 @@ func (c *userListCommand) Response(u saultregistry.UserRegistry, channel saultss
 			continue
 		}
 
-		var links []userLinkAccountData
-		for hostID, link := range registry.GetLinksOfUser(u.ID) {
-			_, err := registry.GetHost(hostID, saultregistry.HostFilterNone)
-			if err != nil {
-				log.Errorf("UserListCommand.Response: %v", err)
-				continue
-			}
-			links = append(
-				links,
-				userLinkAccountData{
-					Accounts: link.Accounts,
-					All:      link.All,
-					HostID:   hostID,
-				},
-			)
-		}
-
 		result = append(
 			result,
-			userListResponseUserData{
-				User:  u,
-				Links: links,
-			},
+			newUserResponseData(registry, u),
 		)
 	}
 
@@ -21,7 +21,7 @@ func (c *userPublicKeyCommand) Request(allFlags []*saultflags.Flags, thisFlags *
 	return nil
 }
 
-func (c *userPublicKeyCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userPublicKeyCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 
 	var args []string
 	err = msg.GetData(&args)
@@ -30,7 +30,7 @@ func (c *userPublicKeyCommand) Response(user saultregistry.UserRegistry, channel
 	}
 
 	if len(args) < 1 {
-		return sault.Commands["whoami"].Response(user, channel, msg, registry, config)
+		return sault.Commands["whoami"].Response(user, channel, msg, registry, config, server)
 	}
 
 	var publicKey saultssh.PublicKey
@@ -65,30 +65,10 @@ Usage: publickey <user id> <public key string>`, err)
 
 	registry.Save()
 
-	var links []userLinkAccountData
-	for hostID, link := range registry.GetLinksOfUser(newUser.ID) {
-		_, err := registry.GetHost(hostID, saultregistry.HostFilterNone)
-		if err != nil {
-			log.Errorf("UserListCommand.Response: %v", err)
-			continue
-		}
-		links = append(
-			links,
-			userLinkAccountData{
-				Accounts: link.Accounts,
-				All:      link.All,
-				HostID:   hostID,
-			},
-		)
-	}
-
 	printed := printUserData(
 		"one-user-updated",
 		"<sault server>",
-		userListResponseUserData{
-			User:  newUser,
-			Links: links,
-		},
+		newUserResponseData(registry, newUser),
 		nil,
 	)
 
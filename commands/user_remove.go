@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spikeekips/sault/common"
@@ -73,12 +72,12 @@ func (c *userRemoveCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 	} else {
 		m = fmt.Sprintf("users, %s were successfully removed", strings.Join(ids, ", "))
 	}
-	fmt.Fprintf(os.Stdout, m+"\n")
+	fmt.Fprintf(commandOutput(allFlags[0]), m+"\n")
 
 	return nil
 }
 
-func (c *userRemoveCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userRemoveCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data []string
 	err = msg.GetData(&data)
 	if err != nil {
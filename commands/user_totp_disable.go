@@ -0,0 +1,91 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var userTOTPDisableFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "user totp disable" | yellow }} removes the TOTP secret and the recovery codes of the user.
+{{ "NOTE" | note }} If the TOTP is required by {{ "server.totp" | yellow }}, the user can not be authenticated until enrolled again.
+  * {{ "$ sault user totp disable spikeekips" | magenta }}
+		`,
+		nil,
+	)
+
+	userTOTPDisableFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "user totp disable",
+		Name:         "disable",
+		Help:         "disable the TOTP of user",
+		Usage:        "<user id> [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseUserTOTPCommandFlags,
+	}
+
+	sault.Commands[userTOTPDisableFlagsTemplate.ID] = &userTOTPDisableCommand{}
+}
+
+type userTOTPDisableCommand struct{}
+
+func (c *userTOTPDisableCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var result userListResponseUserData
+	_, err = runCommand(
+		allFlags[0],
+		userTOTPDisableFlagsTemplate.ID,
+		thisFlags.Values["UserID"].(string),
+		&result,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printUserData(
+		"one-user-updated",
+		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
+		result,
+		nil,
+	))
+
+	return nil
+}
+
+func (c *userTOTPDisableCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var userID string
+	err = msg.GetData(&userID)
+	if err != nil {
+		return err
+	}
+
+	if err = registry.DisableTOTP(userID); err != nil {
+		return
+	}
+
+	registry.Save()
+
+	user, _ := registry.GetUser(userID, nil, saultregistry.UserFilterNone)
+
+	log.Debugf("TOTP of '%s' disabled by '%s'", user.ID, u.ID)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		newUserResponseData(registry, user),
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
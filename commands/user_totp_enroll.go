@@ -0,0 +1,120 @@
+package saultcommands
+
+import (
+	"fmt"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
+	"github.com/spikeekips/sault/flags"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+var userTOTPEnrollFlagsTemplate *saultflags.FlagsTemplate
+
+func init() {
+	description, _ := saultcommon.SimpleTemplating(`{{ "user totp enroll" | yellow }} generates the new TOTP secret and the recovery codes of the user. The otpauth URI can be added to the authenticator apps and each recovery code can be used once instead of the verification code.
+If the user already enrolled, the previous secret and recovery codes are replaced.
+The user, who is not admin, can enroll the TOTP of oneself, only if it is not enrolled yet. If the TOTP is required, but not enrolled, the user can enter sault only to run this command.
+  * {{ "$ sault user totp enroll spikeekips" | magenta }}
+		`,
+		nil,
+	)
+
+	userTOTPEnrollFlagsTemplate = &saultflags.FlagsTemplate{
+		ID:           "user totp enroll",
+		Name:         "enroll",
+		Help:         "enroll the TOTP of user",
+		Usage:        "<user id> [flags]",
+		Description:  description,
+		IsPositioned: true,
+		Flags:        []saultflags.FlagTemplate{},
+		ParseFunc:    parseUserTOTPCommandFlags,
+	}
+
+	sault.Commands[userTOTPEnrollFlagsTemplate.ID] = &userTOTPEnrollCommand{}
+}
+
+func parseUserTOTPCommandFlags(f *saultflags.Flags, args []string) (err error) {
+	subArgs := f.Args()
+	if len(subArgs) != 1 {
+		err = fmt.Errorf("wrong usage")
+		return
+	}
+
+	if !saultcommon.CheckUserID(subArgs[0]) {
+		err = &saultcommon.InvalidUserIDError{ID: subArgs[0]}
+		return
+	}
+
+	f.Values["UserID"] = subArgs[0]
+
+	return nil
+}
+
+type userTOTPEnrollResponseData struct {
+	User          userListResponseUserData
+	URI           string
+	RecoveryCodes []string
+}
+
+type userTOTPEnrollCommand struct{}
+
+func (c *userTOTPEnrollCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) (err error) {
+	var result userTOTPEnrollResponseData
+	_, err = runCommand(
+		allFlags[0],
+		userTOTPEnrollFlagsTemplate.ID,
+		thisFlags.Values["UserID"].(string),
+		&result,
+	)
+	if err != nil {
+		return
+	}
+
+	fmt.Fprintf(commandOutput(allFlags[0]), printTOTPEnrollData(
+		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
+		result,
+		nil,
+	))
+
+	return nil
+}
+
+func (c *userTOTPEnrollCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
+	var userID string
+	err = msg.GetData(&userID)
+	if err != nil {
+		return err
+	}
+
+	var secret string
+	var codes []string
+	if secret, codes, err = registry.EnrollTOTP(userID); err != nil {
+		return
+	}
+
+	registry.Save()
+
+	user, _ := registry.GetUser(userID, nil, saultregistry.UserFilterNone)
+
+	log.Debugf("TOTP of '%s' enrolled by '%s'", user.ID, u.ID)
+
+	var response []byte
+	response, err = saultcommon.NewResponseMsg(
+		userTOTPEnrollResponseData{
+			User:          newUserResponseData(registry, user),
+			URI:           saultcommon.GetTOTPURI(config.Server.SaultServerName, user.ID, secret),
+			RecoveryCodes: codes,
+		},
+		saultcommon.CommandErrorNone,
+		nil,
+	).ToJSON()
+	if err != nil {
+		return
+	}
+
+	channel.Write(response)
+
+	return nil
+}
@@ -2,7 +2,6 @@ package saultcommands
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/core"
@@ -217,7 +216,7 @@ func (c *userUpdateCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 		resultErr = fmt.Errorf(result.Err)
 	}
 
-	fmt.Fprintf(os.Stdout, printUserData(
+	fmt.Fprintf(commandOutput(allFlags[0]), printUserData(
 		"one-user-updated",
 		allFlags[0].Values["Sault"].(saultcommon.FlagSaultServer).Address,
 		userListResponseUserData{User: result.User},
@@ -227,7 +226,7 @@ func (c *userUpdateCommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 	return nil
 }
 
-func (c *userUpdateCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
+func (c *userUpdateCommand) Response(u saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	var data userUpdateRequestData
 	err = msg.GetData(&data)
 	if err != nil {
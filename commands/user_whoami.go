@@ -18,31 +18,11 @@ func (c *userWhoAmICommand) Request(allFlags []*saultflags.Flags, thisFlags *sau
 	return nil
 }
 
-func (c *userWhoAmICommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) (err error) {
-	var links []userLinkAccountData
-	for hostID, link := range registry.GetLinksOfUser(user.ID) {
-		_, err := registry.GetHost(hostID, saultregistry.HostFilterNone)
-		if err != nil {
-			log.Errorf("UserListCommand.Response: %v", err)
-			continue
-		}
-		links = append(
-			links,
-			userLinkAccountData{
-				Accounts: link.Accounts,
-				All:      link.All,
-				HostID:   hostID,
-			},
-		)
-	}
-
+func (c *userWhoAmICommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) (err error) {
 	printed := printUserData(
 		"whoami",
 		"<sault server>",
-		userListResponseUserData{
-			User:  user,
-			Links: links,
-		},
+		newUserResponseData(registry, user),
 		nil,
 	)
 
@@ -7,11 +7,13 @@ import (
 	"io"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/core"
 	"github.com/spikeekips/sault/registry"
 	"github.com/spikeekips/sault/saultssh"
 )
@@ -21,15 +23,17 @@ var maxConnectionString = 3
 var printUsersDataTemplate = `{{ define "block-user" }}{{ $maxConnectionString := .maxConnectionString }}{{ $saultServerAddress := splitHostPort .saultServerAddress 22 }}{{ $lenlinks := len .user.Links }}            User ID: {{ .user.User.ID | colorUserID }}
               Admin: {{ if .user.User.IsAdmin }}{{ print .user.User.IsAdmin | green }}{{ else }}{{ print .user.User.IsAdmin | dim }}{{ end }}
              Active: {{ if .user.User.IsActive }}{{ print .user.User.IsActive | green }}{{ else }}{{ print .user.User.IsActive | dim }}{{ end }}
+               TOTP: {{ if .user.TOTP }}{{ "enrolled" | green }}{{ else }}{{ "not enrolled" | dim }}{{ end }}
          Public Key: {{ .user.User.PublicKey |stringify | bold }}
  Fingerprint(sha256): {{ publicKeyFingerprintSha256 .user.User.GetPublicKey | dim }}
  Fingerprint(md5)   : {{ publicKeyFingerprintMd5 .user.User.GetPublicKey | dim }}
      Registered Time: {{ .user.User.DateAdded | timeToLocal | sprintf "%v" | dim }}
    Last Updated Time: {{ .user.User.DateUpdated | timeToLocal | sprintf "%v" | dim }}
         Linked Hosts: {{ if eq $lenlinks 0 }}{{ "not yet linked" | yellow }}{{ else }}{{ range .user.Links }}
-{{ .HostID | sprintf "%14s" | colorHostID }}: {{ if .All }}{{ "open to all acocunts" | yellow }}{{ else }}{{ join .Accounts " " }}{{ end }}
+{{ .HostID | sprintf "%14s" | colorHostID }}: {{ if .All }}{{ "open to all acocunts" | yellow }}{{ else }}{{ join .Accounts " " }}{{ end }}{{ if .DisableRecording }} {{ "(not recorded)" | dim }}{{ end }}{{ if .AgentForwarding }} {{ "(agent forwarding)" | dim }}{{ end }}{{ if .RemoteForwarding }} {{ "(remote forwarding)" | dim }}{{ end }}{{ if .DisableUpload }} {{ "(upload blocked)" | dim }}{{ end }}{{ if .DisableDownload }} {{ "(download blocked)" | dim }}{{ end }}{{ if .IdleTimeout }} {{ print "(idle timeout: " .IdleTimeout ")" | dim }}{{ end }}{{ if .MaxDuration }} {{ print "(max duration: " .MaxDuration ")" | dim }}{{ end }}{{ if not .DateExpires.IsZero }} {{ .DateExpires | timeToLocal | sprintf "(expires at %v)" | dim }}{{ end }}{{ range $account, $expires := .AccountExpires }} {{ printf "(%s expires at %v)" $account (timeToLocal $expires) | dim }}{{ end }}
 {{ $lenaccounts := len .Accounts }}{{ $hostID := .HostID }}{{ $saultPort := index $saultServerAddress "Port" }}{{ $saultHostName := index $saultServerAddress "HostName" }}{{ range $i, $_ := .Accounts }}{{ if lt $i $maxConnectionString }}{{ sprintf "%15s" "" }}{{ print "$ ssh -p " $saultPort " " . "+" $hostID "@" $saultHostName | magenta }}
-{{ end }}{{ end }}{{ sprintf "%20s" "" }}{{ if gt $lenaccounts $maxConnectionString }}... {{ minus $lenaccounts $maxConnectionString }} more{{ end }}{{ end }}{{ end }}{{ end }}
+{{ end }}{{ end }}{{ sprintf "%20s" "" }}{{ if gt $lenaccounts $maxConnectionString }}... {{ minus $lenaccounts $maxConnectionString }} more{{ end }}{{ end }}{{ end }}{{ if .user.Services }}
+     Linked Services: {{ join .user.Services " " }}{{ end }}{{ end }}
 
 
 {{ define "block-users" }}{{ $maxConnectionString := .maxConnectionString }}{{ $saultServerAddress := .saultServerAddress }}{{ $len := len .users }}{{ range $_, $user := .users }}
@@ -104,11 +108,49 @@ func printUserData(templateName, saultServerAddress string, userdata userListRes
 	return strings.TrimSpace(t) + "\n"
 }
 
+var printTOTPEnrollDataTemplate = `
+{{ define "totp-enrolled" }}
+{{ line "=" }}{{ template "block-user" dict "user" .data.User "saultServerAddress" .saultServerAddress "maxConnectionString" .maxConnectionString }}
+{{ line "- " }}
+TOTP was enrolled; add this URI to the authenticator app,
+{{ .data.URI | bold }}
+
+Recovery codes, each code can be used once instead of the verification code:
+{{ range .data.RecoveryCodes }}  {{ . | yellow }}
+{{ end }}
+{{ "NOTE" | note }} the secret and the recovery codes will not be shown again.
+{{ line "=" }}{{ end }}
+`
+
+func printTOTPEnrollData(saultServerAddress string, data userTOTPEnrollResponseData, err error) string {
+	t, err := saultcommon.Templating(
+		printUsersDataTemplate+printTOTPEnrollDataTemplate,
+		"totp-enrolled",
+		map[string]interface{}{
+			"maxConnectionString": maxConnectionString,
+			"saultServerAddress":  saultServerAddress,
+			"data":                data,
+			"error":               err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintTOTPEnrollData', '%s': %v", data.User.User.ID, err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
 var printHostDataTemplate = `
 {{ define "block-host" }}{{ $maxConnectionString := .maxConnectionString }}{{ $saultServerAddress := splitHostPort .saultServerAddress 22 }}{{ $lenaccounts := len .host.Accounts }}{{ $hostID := .host.ID }}{{ $saultPort := index $saultServerAddress "Port" }}{{ $saultHostName := index $saultServerAddress "HostName" }}           host ID: {{ .host.ID | blue }}
-            Active: {{ if .host.IsActive }}{{ print .host.IsActive | green }}{{ else }}{{ print .host.IsActive | dim }}{{ end }}
-           Address: {{ .host.HostName }}{{ .host.Port }}
-          Accounts: {{ join .host.Accounts " " }}
+            Active: {{ if .host.IsActive }}{{ print .host.IsActive | green }}{{ else }}{{ print .host.IsActive | dim }}{{ end }}{{ if .host.Sensitive }}
+         Sensitive: {{ print .host.Sensitive | yellow }}{{ end }}
+         Addresses: {{ $health := .health }}{{ range $i, $address := .host.GetAddresses }}{{ if $i }}
+                    {{ end }}{{ $address }}{{ if $health }}{{ with index $health $address }}{{ if eq .Status "reachable" }} {{ .Status | green }}{{ else if eq .Status "unreachable" }} {{ .Status | red }}{{ if .Error }} {{ .Error | dim }}{{ end }}{{ else }} {{ .Status | dim }}{{ end }}{{ if not .LastChecked.IsZero }} {{ .LastChecked | timeToLocal | sprintf "at %v" | dim }}{{ end }}{{ end }}{{ end }}{{ end }}
+          Accounts: {{ join .host.Accounts " " }}{{ if .host.IdleTimeout }}
+      Idle Timeout: {{ .host.IdleTimeout }}{{ end }}{{ if .host.MaxDuration }}
+      Max Duration: {{ .host.MaxDuration }}{{ end }}{{ if .host.Banner }}
+            Banner: {{ .host.Banner | printf "%q" }}{{ end }}
    Registered Time: {{ .host.DateAdded | timeToLocal | sprintf "%v" | dim }}
  Last Updated Time: {{ .host.DateUpdated | timeToLocal | sprintf "%v" | dim }}
 {{ range $i, $_ := .host.Accounts }}{{ if lt $i $maxConnectionString }}{{ sprintf "%9s" "" }} {{ print "$ ssh -p " $saultPort " " . "+" $hostID "@" $saultHostName | magenta }}
@@ -134,8 +176,8 @@ host was successfully added
 {{ line "=" }}{{ end }}
 
 
-{{ define "host-list" }}{{ $maxConnectionString := .maxConnectionString }}{{ $saultServerAddress := .saultServerAddress }}{{ $len := len .hosts }}{{ line "=" }}
-{{ range $_, $host := .hosts }}{{ template "block-host" dict "host" $host "saultServerAddress" $saultServerAddress "maxConnectionString" $maxConnectionString }}
+{{ define "host-list" }}{{ $maxConnectionString := .maxConnectionString }}{{ $health := .health }}{{ $saultServerAddress := .saultServerAddress }}{{ $len := len .hosts }}{{ line "=" }}
+{{ range $_, $host := .hosts }}{{ template "block-host" dict "host" $host "saultServerAddress" $saultServerAddress "maxConnectionString" $maxConnectionString "health" $health }}
 {{ line "- " }}
 {{end}}{{ if eq $len 1 }}1 host found{{ end }}{{ if gt $len 1 }}{{ $len }} hosts found{{ end }}
 {{ line "=" }}{{ end }}
@@ -161,7 +203,7 @@ func printHostData(templateName, saultServerAddress string, host saultregistry.H
 	return strings.TrimSpace(t) + "\n"
 }
 
-func printHostsData(templateName, saultServerAddress string, hosts []saultregistry.HostRegistry, err error) string {
+func printHostsData(templateName, saultServerAddress string, hosts []saultregistry.HostRegistry, health map[string]sault.HostAddressHealth, err error) string {
 	if len(hosts) < 1 {
 		return "no hosts found\n"
 	}
@@ -173,6 +215,7 @@ func printHostsData(templateName, saultServerAddress string, hosts []saultregist
 			"maxConnectionString": maxConnectionString,
 			"saultServerAddress":  saultServerAddress,
 			"hosts":               hosts,
+			"health":              health,
 			"error":               err,
 		},
 	)
@@ -184,6 +227,270 @@ func printHostsData(templateName, saultServerAddress string, hosts []saultregist
 	return strings.TrimSpace(t) + "\n"
 }
 
+var printServiceDataTemplate = `
+{{ define "block-service" }}{{ $saultServerAddress := splitHostPort .saultServerAddress 22 }}{{ $saultPort := index $saultServerAddress "Port" }}{{ $saultHostName := index $saultServerAddress "HostName" }}           Service: {{ .service.Name | blue }}
+           Address: {{ .service.GetAddress }}
+            Labels: {{ range $k, $v := .service.Labels }}{{ $k }}={{ $v }} {{ else }}{{ "no labels" | dim }}{{ end }}
+   Registered Time: {{ .service.DateAdded | timeToLocal | sprintf "%v" | dim }}
+ Last Updated Time: {{ .service.DateUpdated | timeToLocal | sprintf "%v" | dim }}
+{{ sprintf "%9s" "" }} {{ print "$ ssh -p " $saultPort " -N -L " .service.Port ":" .service.Name ":" .service.Port " sault@" $saultHostName | magenta }}{{ end }}
+
+
+{{ define "service-added" }}
+{{ line "=" }}{{ template "block-service" dict "service" .service "saultServerAddress" .saultServerAddress }}
+{{ line "- " }}
+service was successfully added
+{{ line "=" }}{{ end }}
+
+
+{{ define "service-list" }}{{ $saultServerAddress := .saultServerAddress }}{{ $len := len .services }}{{ line "=" }}
+{{ range $_, $service := .services }}{{ template "block-service" dict "service" $service "saultServerAddress" $saultServerAddress }}
+{{ line "- " }}
+{{end}}{{ if eq $len 1 }}1 service found{{ end }}{{ if gt $len 1 }}{{ $len }} services found{{ end }}
+{{ line "=" }}{{ end }}
+
+`
+
+func printServiceData(templateName, saultServerAddress string, service saultregistry.ServiceRegistry, err error) string {
+	t, err := saultcommon.Templating(
+		printServiceDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"saultServerAddress": saultServerAddress,
+			"service":            service,
+			"error":              err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintServiceData', '%s': %v", saultcommon.SprintInstance(service), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
+func printServicesData(templateName, saultServerAddress string, services []saultregistry.ServiceRegistry, err error) string {
+	if len(services) < 1 {
+		return "no services found\n"
+	}
+
+	t, err := saultcommon.Templating(
+		printServiceDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"saultServerAddress": saultServerAddress,
+			"services":           services,
+			"error":              err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintServicesData', '%s': %v", saultcommon.SprintInstance(services), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
+var printAccessRequestsDataTemplate = `
+{{ define "block-access-request" }}        Request ID: {{ .request.ID | blue }}
+            Status: {{ if eq .request.Status "pending" }}{{ print .request.Status | yellow }}{{ else if eq .request.Status "approved" }}{{ print .request.Status | green }}{{ else }}{{ print .request.Status | dim }}{{ end }}
+              User: {{ .request.UserID | colorUserID }}
+              Host: {{ .request.Account }}@{{ .request.HostID }}
+          Duration: {{ .request.Duration }}
+            Reason: {{ .request.Reason }}
+    Requested Time: {{ .request.DateRequested | timeToLocal | sprintf "%v" | dim }}{{ if .request.DecidedBy }}
+        Decided By: {{ .request.DecidedBy | colorUserID }} {{ .request.DateDecided | timeToLocal | sprintf "at %v" | dim }}{{ end }}{{ if not .request.DateExpires.IsZero }}
+      Expires Time: {{ .request.DateExpires | timeToLocal | sprintf "%v" | yellow }}{{ end }}{{ end }}
+
+
+{{ define "access-requested" }}
+{{ line "=" }}{{ range $_, $request := .requests }}{{ template "block-access-request" dict "request" $request }}{{ end }}
+{{ line "- " }}
+access was successfully requested; wait for the approval of admin
+{{ line "=" }}{{ end }}
+
+
+{{ define "access-approved" }}
+{{ line "=" }}{{ range $_, $request := .requests }}{{ template "block-access-request" dict "request" $request }}{{ end }}
+{{ line "- " }}
+access request was successfully approved
+{{ line "=" }}{{ end }}
+
+
+{{ define "access-denied" }}
+{{ line "=" }}{{ range $_, $request := .requests }}{{ template "block-access-request" dict "request" $request }}{{ end }}
+{{ line "- " }}
+access request was denied
+{{ line "=" }}{{ end }}
+
+
+{{ define "access-request-list" }}{{ $len := len .requests }}{{ line "=" }}
+{{ range $_, $request := .requests }}{{ template "block-access-request" dict "request" $request }}
+{{ line "- " }}
+{{end}}{{ if eq $len 1 }}1 request found{{ end }}{{ if gt $len 1 }}{{ $len }} requests found{{ end }}
+{{ line "=" }}{{ end }}
+`
+
+func printAccessRequestsData(templateName string, requests []saultregistry.AccessRequestRegistry, err error) string {
+	if len(requests) < 1 {
+		return "no access requests found\n"
+	}
+
+	t, err := saultcommon.Templating(
+		printAccessRequestsDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"requests": requests,
+			"error":    err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintAccessRequestsData', '%s': %v", saultcommon.SprintInstance(requests), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
+var printRecordingsDataTemplate = `
+{{ define "block-recording" }}      Recording ID: {{ .recording.ID | yellow }}
+              User: {{ .recording.Header.User | colorUserID }}
+              Host: {{ .recording.Header.Account }}+{{ .recording.Header.Host | colorHostID }}
+    Remote Address: {{ .recording.Header.RemoteAddr }}{{ if .recording.Header.BreakGlass }}
+       Break-Glass: {{ "true" | red }}{{ end }}{{ if .recording.Header.Reason }}
+            Reason: {{ .recording.Header.Reason }}{{ end }}
+      Started Time: {{ .recording.TimeStarted | timeToLocal | sprintf "%v" | dim }}
+          Duration: {{ .recording.Duration | sprintf "%v" }}
+              Size: {{ .recording.Size | sprintf "%d bytes" | dim }}{{ end }}
+
+
+{{ define "recording-list" }}{{ $len := len .recordings }}{{ line "=" }}
+{{ range $_, $recording := .recordings }}{{ template "block-recording" dict "recording" $recording }}
+{{ line "- " }}
+{{ end }}{{ if eq $len 1 }}1 recording found{{ end }}{{ if gt $len 1 }}{{ $len }} recordings found{{ end }}
+{{ line "=" }}{{ end }}
+
+
+{{ define "recording-grep" }}{{ $len := len .recordings }}{{ line "=" }}
+{{ range $_, $match := .recordings }}{{ $match.RecordingID | yellow }} {{ $match.Account }}+{{ $match.HostID | colorHostID }} by {{ $match.UserID | colorUserID }} at {{ $match.Offset | sprintf "%v" | magenta }}
+  {{ $match.Line }}
+{{ end }}{{ line "- " }}
+{{ if eq $len 1 }}1 line found{{ end }}{{ if gt $len 1 }}{{ $len }} lines found{{ end }}
+{{ line "=" }}{{ end }}
+`
+
+func printRecordingsData(templateName string, recordings interface{}, err error) string {
+	if reflect.ValueOf(recordings).Len() < 1 {
+		return "nothing found\n"
+	}
+
+	t, err := saultcommon.Templating(
+		printRecordingsDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"recordings": recordings,
+			"error":      err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintRecordingsData', '%s': %v", saultcommon.SprintInstance(recordings), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
+var printSessionsDataTemplate = `
+{{ define "block-session" }}        Session ID: {{ .session.ID | yellow }}
+              User: {{ .session.UserID | colorUserID }}
+              Host: {{ if .session.InsideSault }}{{ "inside sault" | dim }}{{ else }}{{ .session.Account }}+{{ .session.HostID | colorHostID }}{{ end }}
+    Remote Address: {{ .session.RemoteAddr }}{{ if .session.BreakGlass }}
+       Break-Glass: {{ "true" | red }}{{ end }}{{ if .session.Reason }}
+            Reason: {{ .session.Reason }}{{ end }}
+      Started Time: {{ .session.TimeStarted | timeToLocal | sprintf "%v" | dim }}
+             Bytes: {{ .session.BytesIn | sprintf "in %d" }}, {{ .session.BytesOut | sprintf "out %d" }}
+          Channels: {{ range .session.Channels }}
+{{ sprintf "%20s" "" }}{{ .ID }} {{ .Type | yellow }} {{ .BytesIn | sprintf "in %d" | dim }}, {{ .BytesOut | sprintf "out %d" | dim }}{{ end }}{{ end }}
+
+
+{{ define "session-list" }}{{ $len := len .sessions }}{{ line "=" }}
+{{ range $_, $session := .sessions }}{{ template "block-session" dict "session" $session }}
+{{ line "- " }}
+{{ end }}{{ if eq $len 1 }}1 session found{{ end }}{{ if gt $len 1 }}{{ $len }} sessions found{{ end }}
+{{ line "=" }}{{ end }}
+
+
+{{ define "session-killed" }}{{ $len := len .sessions }}{{ line "=" }}
+{{ range $_, $session := .sessions }}{{ template "block-session" dict "session" $session }}
+{{ line "- " }}
+{{ end }}{{ if eq $len 1 }}1 session was killed{{ end }}{{ if gt $len 1 }}{{ $len }} sessions were killed{{ end }}
+{{ line "=" }}{{ end }}
+`
+
+func printSessionsData(templateName string, sessions []sault.SessionInfo, err error) string {
+	if len(sessions) < 1 {
+		return "no sessions found\n"
+	}
+
+	t, err := saultcommon.Templating(
+		printSessionsDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"sessions": sessions,
+			"error":    err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintSessionsData', '%s': %v", saultcommon.SprintInstance(sessions), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
+var printBansDataTemplate = `
+{{ define "block-ban" }}{{ .ban.Kind | sprintf "%11s" | yellow }}: {{ .ban.Key }}
+   Failures: {{ .ban.Failures }}, banned {{ .ban.Bans }} times
+Last Failed: {{ .ban.LastFailure | timeToLocal | sprintf "%v" | dim }}{{ if .ban.IsBanned .now }}
+  Banned To: {{ .ban.BannedUntil | timeToLocal | sprintf "%v" | red }}{{ end }}{{ end }}
+
+
+{{ define "ban-list" }}{{ $len := len .bans }}{{ $now := .now }}{{ line "=" }}
+{{ range $_, $ban := .bans }}{{ template "block-ban" dict "ban" $ban "now" $now }}
+{{ line "- " }}
+{{ end }}{{ if eq $len 1 }}1 found{{ end }}{{ if gt $len 1 }}{{ $len }} found{{ end }}
+{{ line "=" }}{{ end }}
+
+
+{{ define "ban-cleared" }}{{ $len := len .bans }}{{ $now := .now }}{{ line "=" }}
+{{ range $_, $ban := .bans }}{{ template "block-ban" dict "ban" $ban "now" $now }}
+{{ line "- " }}
+{{ end }}{{ if eq $len 1 }}1 was cleared{{ end }}{{ if gt $len 1 }}{{ $len }} were cleared{{ end }}
+{{ line "=" }}{{ end }}
+`
+
+func printBansData(templateName string, bans []sault.BanInfo, err error) string {
+	if len(bans) < 1 {
+		return "no bans found\n"
+	}
+
+	t, err := saultcommon.Templating(
+		printBansDataTemplate,
+		templateName,
+		map[string]interface{}{
+			"bans":  bans,
+			"now":   time.Now(),
+			"error": err,
+		},
+	)
+
+	if err != nil {
+		log.Errorf("failed to render, 'PrintBansData', '%s': %v", saultcommon.SprintInstance(bans), err)
+	}
+
+	return strings.TrimSpace(t) + "\n"
+}
+
 var printServerKindTemplate = `
 {{ define "default" }}
 {{ $key := print "- " .key " " }}{{ line $key "-" | yellow }}
@@ -208,6 +515,47 @@ func printServerKind(templateName, key string, value string) string {
 	return strings.TrimSpace(t) + "\n"
 }
 
+// injectUserCAScript installs the user CA public key and sets
+// 'TrustedUserCAKeys' at the top of sshd config, so it is not in the 'Match'
+// blocks; if the other 'TrustedUserCAKeys' is already set, it fails. It needs
+// root or sudo without password.
+var injectUserCAScript = `set -e
+SUDO=""
+[ "$(id -u)" = "0" ] || SUDO="sudo -n"
+echo "%[2]s" | $SUDO tee %[1]s >/dev/null
+$SUDO chmod 644 %[1]s
+if ! $SUDO grep -q "^TrustedUserCAKeys %[1]s$" %[3]s; then
+	if $SUDO grep -qi "^[[:space:]]*TrustedUserCAKeys" %[3]s; then
+		echo "the other TrustedUserCAKeys is already set in %[3]s" >&2
+		exit 1
+	fi
+	$SUDO sed -i "1i TrustedUserCAKeys %[1]s" %[3]s
+fi
+$SUDO sshd -t -f %[3]s
+$SUDO systemctl reload sshd 2>/dev/null || $SUDO systemctl reload ssh 2>/dev/null || $SUDO service ssh reload 2>/dev/null || $SUDO kill -HUP "$(cat /var/run/sshd.pid)"`
+
+// injectUserCAToHost installs the user CA public key to the host, then sshd
+// of host trusts the user certificates, which are signed by sault server.
+func injectUserCAToHost(sc *saultcommon.SSHClient, publicKey saultssh.PublicKey) (err error) {
+	log.Debugf("trying to install user CA to host")
+
+	script := fmt.Sprintf(
+		injectUserCAScript,
+		trustedUserCAKeysFile,
+		saultcommon.GetAuthorizedKey(publicKey),
+		sshdConfigFile,
+	)
+
+	if _, err = sc.Run(fmt.Sprintf("sh -c '%s'", script)); err != nil {
+		log.Errorf("failed to install user CA, '%s': %v", trustedUserCAKeysFile, err)
+		return
+	}
+
+	log.Debugf("installed user CA, '%s'", trustedUserCAKeysFile)
+
+	return nil
+}
+
 func injectClientKeyToHost(sc *saultcommon.SSHClient, publicKey saultssh.PublicKey) (err error) {
 	log.Debugf("trying to inject client public key to host")
 
@@ -3,7 +3,6 @@ package saultcommands
 import (
 	"encoding/base64"
 	"fmt"
-	"os"
 	"strings"
 
 	"github.com/spikeekips/sault/common"
@@ -27,11 +26,11 @@ type versionCommand struct {
 }
 
 func (c *versionCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) error {
-	fmt.Fprintf(os.Stdout, getSaultVersion())
+	fmt.Fprintf(commandOutput(allFlags[0]), getSaultVersion())
 	return nil
 }
 
-func (c *versionCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config) error {
+func (c *versionCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *sault.Config, server *sault.Server) error {
 	return nil
 }
 
@@ -8,10 +8,28 @@ var (
 	ServerFlagsTemplate,
 	UserFlagsTemplate,
 	VersionFlagsTemplate,
-	HostFlagsTemplate *saultflags.FlagsTemplate
+	HostFlagsTemplate,
+	ServiceFlagsTemplate,
+	SessionFlagsTemplate,
+	AccessFlagsTemplate *saultflags.FlagsTemplate
 )
 
+var serverBansFlagsTemplate *saultflags.FlagsTemplate
+var userTOTPFlagsTemplate *saultflags.FlagsTemplate
+
 func init() {
+	serverBansFlagsTemplate = &saultflags.FlagsTemplate{
+		Name: "bans",
+		Help: "manage the banned addresses and public keys",
+		Description: `
+Manage the source addresses and public keys, which are banned by the repeated authentication failures.
+		`,
+		Subcommands: []*saultflags.FlagsTemplate{
+			serverBansListFlagsTemplate,
+			serverBansClearFlagsTemplate,
+		},
+	}
+
 	ServerFlagsTemplate = &saultflags.FlagsTemplate{
 		Name: "server",
 		Help: "sault server",
@@ -22,6 +40,19 @@ Run {{ "sault" | yellow }} server.
 			serverRunFlagsTemplate,
 			serverPrintFlagsTemplate,
 			serverInitFlagsTemplate,
+			serverBansFlagsTemplate,
+		},
+	}
+
+	userTOTPFlagsTemplate = &saultflags.FlagsTemplate{
+		Name: "totp",
+		Help: "manage the TOTP of users",
+		Description: `
+Manage the TOTP second factor of users, which is required after the public key authentication by {{ "server.totp" | yellow }}.
+		`,
+		Subcommands: []*saultflags.FlagsTemplate{
+			userTOTPEnrollFlagsTemplate,
+			userTOTPDisableFlagsTemplate,
 		},
 	}
 
@@ -33,9 +64,11 @@ Run {{ "sault" | yellow }} server.
 		Subcommands: []*saultflags.FlagsTemplate{
 			userListFlagsTemplate,
 			userLinkFlagsTemplate,
+			userLinkServiceFlagsTemplate,
 			userUpdateFlagsTemplate,
 			userRemoveFlagsTemplate,
 			userAddFlagsTemplate,
+			userTOTPFlagsTemplate,
 		},
 	}
 	HostFlagsTemplate = &saultflags.FlagsTemplate{
@@ -52,4 +85,44 @@ Mange hosts of sault server.
 			hostInjectFlagsTemplate,
 		},
 	}
+	ServiceFlagsTemplate = &saultflags.FlagsTemplate{
+		Name: "service",
+		Help: "manage services",
+		Description: `
+Manage the services, which the linked users can connect to thru the ssh tunnel of sault server.
+		`,
+		Subcommands: []*saultflags.FlagsTemplate{
+			serviceListFlagsTemplate,
+			serviceRemoveFlagsTemplate,
+			serviceAddFlagsTemplate,
+		},
+	}
+	SessionFlagsTemplate = &saultflags.FlagsTemplate{
+		Name: "session",
+		Help: "manage sessions",
+		Description: `
+Manage the sessions of sault server.
+		`,
+		Subcommands: []*saultflags.FlagsTemplate{
+			sessionListFlagsTemplate,
+			sessionKillFlagsTemplate,
+			sessionWatchFlagsTemplate,
+			sessionRecordingsFlagsTemplate,
+			sessionPlayFlagsTemplate,
+			sessionGrepFlagsTemplate,
+		},
+	}
+	AccessFlagsTemplate = &saultflags.FlagsTemplate{
+		Name: "access",
+		Help: "request and approve the temporary links",
+		Description: `
+Request the temporary link to the host, and approve or deny the requests by admin.
+		`,
+		Subcommands: []*saultflags.FlagsTemplate{
+			accessRequestFlagsTemplate,
+			accessListFlagsTemplate,
+			accessApproveFlagsTemplate,
+			accessDenyFlagsTemplate,
+		},
+	}
 }
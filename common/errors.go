@@ -143,3 +143,49 @@ type HostAndUserNotLinked struct {
 func (e *HostAndUserNotLinked) Error() string {
 	return fmt.Sprintf("user, '%s' and host, '%s' was not linked", e.UserID, e.HostID)
 }
+
+// InvalidServiceNameError means wrong service name
+type InvalidServiceNameError struct {
+	Name string
+}
+
+func (e *InvalidServiceNameError) Error() string {
+	return fmt.Sprintf("invalid service name, '%s'", e.Name)
+}
+
+// ServiceDoesNotExistError means service does not exist
+type ServiceDoesNotExistError struct {
+	Name string
+}
+
+func (e *ServiceDoesNotExistError) Error() string {
+	return fmt.Sprintf("service, '%s' does not exist", e.Name)
+}
+
+// ServiceExistError means service exist
+type ServiceExistError struct {
+	Name string
+}
+
+func (e *ServiceExistError) Error() string {
+	return fmt.Sprintf("service, '%s' already exists", e.Name)
+}
+
+// ServiceAndUserNotLinked means service and user is not linked
+type ServiceAndUserNotLinked struct {
+	UserID  string
+	Service string
+}
+
+func (e *ServiceAndUserNotLinked) Error() string {
+	return fmt.Sprintf("user, '%s' and service, '%s' was not linked", e.UserID, e.Service)
+}
+
+// AccessRequestDoesNotExistError means access request does not exist
+type AccessRequestDoesNotExistError struct {
+	ID string
+}
+
+func (e *AccessRequestDoesNotExistError) Error() string {
+	return fmt.Sprintf("access request, '%s' does not exist", e.ID)
+}
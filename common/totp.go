@@ -0,0 +1,147 @@
+package saultcommon
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP follows RFC 6238 with the default parameters of the authenticator
+// apps; HMAC-SHA1, 6 digits and 30 seconds period.
+const (
+	TOTPDigits = 6
+	TOTPPeriod = 30
+
+	// TOTPSkew is the allowed steps before and after the current step
+	TOTPSkew = 1
+)
+
+// GenerateTOTPSecret generates the new base32 encoded secret
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(base32.StdEncoding.EncodeToString(b), "="), nil
+}
+
+func decodeTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.TrimRight(strings.TrimSpace(secret), "="))
+	if m := len(secret) % 8; m != 0 {
+		secret += strings.Repeat("=", 8-m)
+	}
+
+	return base32.StdEncoding.DecodeString(secret)
+}
+
+func totpCode(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+
+	var mod uint32 = 1
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// TOTPCounter returns the time step of the given time
+func TOTPCounter(t time.Time) uint64 {
+	return uint64(t.Unix()) / TOTPPeriod
+}
+
+// GetTOTPCode returns the code of secret at the given time
+func GetTOTPCode(secret string, t time.Time) (string, error) {
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return totpCode(key, TOTPCounter(t)), nil
+}
+
+// VerifyTOTPCode checks the code around the given time and returns the
+// matched time step, which can be used to prevent the replay.
+func VerifyTOTPCode(secret, code string, t time.Time) (counter uint64, ok bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return
+	}
+
+	key, err := decodeTOTPSecret(secret)
+	if err != nil {
+		return
+	}
+
+	current := TOTPCounter(t)
+	for i := -TOTPSkew; i <= TOTPSkew; i++ {
+		c := uint64(int64(current) + int64(i))
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, c)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+
+	return
+}
+
+// GetTOTPURI returns the 'otpauth' URI for the authenticator apps
+func GetTOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	v.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+
+	return u.String()
+}
+
+// GenerateRecoveryCodes generates the one-time recovery codes, like
+// '1a2b3-c4d5e'
+func GenerateRecoveryCodes(n int) (codes []string, err error) {
+	for i := 0; i < n; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return
+		}
+
+		h := hex.EncodeToString(b)
+		codes = append(codes, h[:5]+"-"+h[5:])
+	}
+
+	return
+}
+
+// HashRecoveryCode returns the hash of recovery code to store it; the code
+// is compared without '-' and case.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.Replace(strings.TrimSpace(code), "-", "", -1))
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,74 @@
+package saultcommon
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 test vectors of SHA1, last 6 digits
+	secret := base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+	cases := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for ts, expected := range cases {
+		code, err := GetTOTPCode(secret, time.Unix(ts, 0))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, code)
+	}
+}
+
+func TestVerifyTOTPCode(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.Nil(t, err)
+	assert.Equal(t, 32, len(secret))
+
+	now := time.Now()
+	code, _ := GetTOTPCode(secret, now)
+
+	counter, ok := VerifyTOTPCode(secret, code, now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPCounter(now), counter)
+
+	// skew
+	_, ok = VerifyTOTPCode(secret, code, now.Add(time.Second*TOTPPeriod))
+	assert.True(t, ok)
+	_, ok = VerifyTOTPCode(secret, code, now.Add(time.Second*TOTPPeriod*3))
+	assert.False(t, ok)
+
+	_, ok = VerifyTOTPCode(secret, "12345", now)
+	assert.False(t, ok)
+	_, ok = VerifyTOTPCode("invalid secret", code, now)
+	assert.False(t, ok)
+}
+
+func TestGetTOTPURI(t *testing.T) {
+	u, err := url.Parse(GetTOTPURI("sault", "spikeekips", "JBSWY3DPEHPK3PXP"))
+	assert.Nil(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/sault:spikeekips", u.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", u.Query().Get("secret"))
+	assert.Equal(t, "sault", u.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(codes))
+	assert.Regexp(t, "^[0-9a-f]{5}-[0-9a-f]{5}$", codes[0])
+	assert.NotEqual(t, codes[0], codes[1])
+
+	assert.Equal(t, HashRecoveryCode("1a2b3-c4d5e"), HashRecoveryCode(" 1A2B3C4D5E "))
+	assert.NotEqual(t, HashRecoveryCode("1a2b3-c4d5e"), HashRecoveryCode("1a2b3-c4d5f"))
+}
@@ -448,6 +448,12 @@ func CheckHostID(s string) bool {
 	return regexp.MustCompile(reHostID).MatchString(s)
 }
 
+// CheckServiceName checks whether ServiceRegistry.Name is valid or not; it
+// follows the rule of host id.
+func CheckServiceName(s string) bool {
+	return CheckHostID(s)
+}
+
 // ParseSaultAccountName splits the `+` connected account and host name
 func ParseSaultAccountName(s string) (account, hostID string, err error) {
 	account, hostID, err = parseSaultAccountName(s)
@@ -758,6 +764,38 @@ func LoadPrivateKeySignerWithPasspharaseTrial(privateKeyFile string) (signer sau
 	return
 }
 
+// ReadKeyboardInteractive answers the keyboard-interactive questions from
+// terminal, like the TOTP verification code; the answer is not echoed if echo
+// is false.
+func ReadKeyboardInteractive(user, instruction string, questions []string, echos []bool) (answers []string, err error) {
+	if len(instruction) > 0 {
+		fmt.Fprintln(os.Stdout, instruction)
+	}
+
+	for i, q := range questions {
+		fmt.Fprint(os.Stdout, q)
+
+		var b []byte
+		if i < len(echos) && echos[i] {
+			var s string
+			if _, err = fmt.Fscanln(os.Stdin, &s); err != nil {
+				return
+			}
+			b = []byte(s)
+		} else {
+			b, err = terminal.ReadPassword(terminalStateFD)
+			fmt.Fprintln(os.Stdout, "")
+			if err != nil {
+				return
+			}
+		}
+
+		answers = append(answers, strings.TrimSpace(string(b)))
+	}
+
+	return
+}
+
 // ReadPassword read password from terminal
 func ReadPassword(maxTries int) (password string, err error) {
 	if maxTries < 1 {
@@ -872,6 +910,21 @@ func ParseMinusName(s string) (name string, minus bool) {
 	return string(r[:len(r)-1]), true
 }
 
+var reTerminalEscapes = regexp.MustCompile(
+	"\x1b\\[[0-?]*[ -/]*[@-~]" + // CSI
+		"|\x1b\\][^\x07\x1b]*(?:\x07|\x1b\\\\)" + // OSC
+		"|\x1b[P^_][^\x1b]*\x1b\\\\" + // DCS, PM, APC
+		"|\x1b[()][0-9A-Za-z]" + // charset
+		"|\x1b[ -/]*[0-~]" + // other escapes
+		"|[\x00-\x08\x0b-\x1f\x7f]", // control characters except tab and newline
+)
+
+// StripTerminalEscapes removes the terminal escape sequences and the control
+// characters except tab and newline from the terminal output.
+func StripTerminalEscapes(s string) string {
+	return reTerminalEscapes.ReplaceAllString(s, "")
+}
+
 // DefaultLogrusFormatter is the default logrus formatter
 type DefaultLogrusFormatter struct {
 	logrus.Formatter
@@ -192,3 +192,22 @@ func TestCheckAccountName(t *testing.T) {
 		assert.False(t, CheckAccountName(s))
 	}
 }
+
+func TestStripTerminalEscapes(t *testing.T) {
+	{
+		s := "\x1b[1;32mgreen\x1b[0m normal"
+		assert.Equal(t, "green normal", StripTerminalEscapes(s))
+	}
+	{
+		s := "\x1b]0;user@host: ~\x07$ ls\r\n"
+		assert.Equal(t, "$ ls\n", StripTerminalEscapes(s))
+	}
+	{
+		s := "\x1b[?2004h\x1b(Bshow\tme\x1b=\n"
+		assert.Equal(t, "show\tme\n", StripTerminalEscapes(s))
+	}
+	{
+		s := "우리나라\x1b[K"
+		assert.Equal(t, "우리나라", StripTerminalEscapes(s))
+	}
+}
@@ -0,0 +1,138 @@
+package sault
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// AdminShell, if set, runs the interactive shell for the admin, who connects
+// to the sault server with pty; it is set by the commands.
+var AdminShell func(shell *AdminShellSession) error
+
+// TerminalSize is the size of pty
+type TerminalSize struct {
+	Columns int
+	Rows    int
+}
+
+// AdminShellSession is the pty session of the admin shell
+type AdminShellSession struct {
+	sync.RWMutex
+
+	User     saultregistry.UserRegistry
+	Channel  saultssh.Channel
+	Registry *saultregistry.Registry
+	Config   *Config
+
+	size    TerminalSize
+	resized chan TerminalSize
+	conn    *connection
+}
+
+func newAdminShellSession(c *connection, channel saultssh.Channel, pty ptyRequestMsg) *AdminShellSession {
+	return &AdminShellSession{
+		User:     c.user,
+		Channel:  channel,
+		Registry: c.server.registry,
+		Config:   c.server.config,
+		size:     TerminalSize{Columns: int(pty.Columns), Rows: int(pty.Rows)},
+		resized:  make(chan TerminalSize, 1),
+		conn:     c,
+	}
+}
+
+// Size returns the current size of pty
+func (s *AdminShellSession) Size() TerminalSize {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.size
+}
+
+// Resized returns the channel, which receives the new size of pty; only the
+// last size is kept.
+func (s *AdminShellSession) Resized() <-chan TerminalSize {
+	return s.resized
+}
+
+func (s *AdminShellSession) resize(size TerminalSize) {
+	s.Lock()
+	s.size = size
+	s.Unlock()
+
+	select {
+	case <-s.resized:
+	default:
+	}
+	s.resized <- size
+}
+
+// Run runs the command like the command from sault client; the response is
+// written to the channel.
+func (s *AdminShellSession) Run(channel saultssh.Channel, msg saultcommon.CommandMsg) (err error) {
+	msg.IsSaultClient = true
+
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		response, _ := saultcommon.NewResponseMsg(nil, saultcommon.CommandErrorCommon, err).ToJSON()
+		channel.Write(response)
+	}()
+
+	command, ok := Commands[msg.Name]
+	if !ok {
+		err = fmt.Errorf("unknown command name, '%s'", msg.Name)
+		s.conn.auditCommand(msg, err)
+		return
+	}
+
+	return s.conn.responseCommand(command, channel, msg)
+}
+
+// runAdminShell runs the admin shell until the shell exits; the window-change
+// requests are delivered to the shell.
+func (c *connection) runAdminShell(
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	pty ptyRequestMsg,
+) error {
+	shell := newAdminShellSession(c, channel, pty)
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- AdminShell(shell)
+	}()
+
+	for {
+		select {
+		case request := <-requests:
+			if request == nil {
+				return nil
+			}
+
+			if request.Type != "window-change" {
+				request.Reply(false, nil)
+				continue
+			}
+
+			var msg windowChangeRequestMsg
+			if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+				shell.resize(TerminalSize{Columns: int(msg.Columns), Rows: int(msg.Rows)})
+			}
+			request.Reply(true, nil)
+		case err := <-exited:
+			if err != nil {
+				c.log.Error(err)
+			}
+			sendExitStatusThruChannel(channel, 0)
+
+			return err
+		}
+	}
+}
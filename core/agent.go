@@ -0,0 +1,93 @@
+package sault
+
+import (
+	"errors"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/spikeekips/sault/saultssh/agent"
+)
+
+const (
+	agentRequestType = "auth-agent-req@openssh.com"
+	agentChannelType = "auth-agent@openssh.com"
+)
+
+var errAgentNotAllowed = errors.New("agent: not allowed thru sault")
+
+// forwardedAgent is the agent for the inner host; the requests are routed to
+// the agent of client by the new agent channel of the client connection.
+// Only listing and signing the keys are allowed and every signing request is
+// audited.
+type forwardedAgent struct {
+	conn *connection
+}
+
+func newForwardedAgent(c *connection) *forwardedAgent {
+	return &forwardedAgent{conn: c}
+}
+
+func (a *forwardedAgent) open() (saultsshAgent.Agent, func(), error) {
+	channel, requests, err := a.conn.sshConn.OpenChannel(agentChannelType, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	go saultssh.DiscardRequests(requests)
+
+	return saultsshAgent.NewClient(channel), func() { channel.Close() }, nil
+}
+
+func (a *forwardedAgent) List() ([]*saultsshAgent.Key, error) {
+	agent, closeFunc, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	return agent.List()
+}
+
+func (a *forwardedAgent) Sign(key saultssh.PublicKey, data []byte) (signature *saultssh.Signature, err error) {
+	defer func() {
+		event := AuditEvent{
+			Type:        AuditEventAgentSign,
+			Fingerprint: saultcommon.FingerprintSHA256PublicKey(key),
+			Data:        map[string]interface{}{"key_type": key.Type()},
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		a.conn.audit(event)
+	}()
+
+	agent, closeFunc, err := a.open()
+	if err != nil {
+		return nil, err
+	}
+	defer closeFunc()
+
+	return agent.Sign(key, data)
+}
+
+func (a *forwardedAgent) Add(key saultsshAgent.AddedKey) error { return errAgentNotAllowed }
+
+func (a *forwardedAgent) Remove(key saultssh.PublicKey) error { return errAgentNotAllowed }
+
+func (a *forwardedAgent) RemoveAll() error { return errAgentNotAllowed }
+
+func (a *forwardedAgent) Lock(passphrase []byte) error { return errAgentNotAllowed }
+
+func (a *forwardedAgent) Unlock(passphrase []byte) error { return errAgentNotAllowed }
+
+func (a *forwardedAgent) Signers() ([]saultssh.Signer, error) { return nil, errAgentNotAllowed }
+
+// isAgentForwardingAllowed checks whether the link of host allows the agent
+// forwarding
+func (c *connection) isAgentForwardingAllowed() bool {
+	link, err := c.server.registry.GetLink(c.user.ID, c.host.ID)
+	if err != nil {
+		return false
+	}
+
+	return link.AgentForwarding
+}
@@ -0,0 +1,105 @@
+package sault
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"sync"
+	"testing"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/spikeekips/sault/saultssh/agent"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAuditSink struct {
+	sync.Mutex
+	events []AuditEvent
+}
+
+func (s *testAuditSink) GetType() string { return "test" }
+
+func (s *testAuditSink) Validate() error { return nil }
+
+func (s *testAuditSink) Write(b []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var event AuditEvent
+	json.Unmarshal(b, &event)
+	s.events = append(s.events, event)
+
+	return nil
+}
+
+// newTestAgentConnection returns the connection, whose client forwards the
+// keyring as agent.
+func newTestAgentConnection(t *testing.T, keyring saultsshAgent.Agent) (*connection, *testAuditSink) {
+	c, saultConn, clientConn, sink := newTestProxiedConnection(t, nil)
+	go saultssh.DiscardRequests(saultConn.requests)
+	go func() {
+		for channel := range saultConn.channels {
+			channel.Reject(saultssh.Prohibited, "")
+		}
+	}()
+
+	client := saultssh.NewClient(clientConn.conn, clientConn.channels, clientConn.requests)
+	if keyring != nil {
+		assert.Nil(t, saultsshAgent.ForwardToAgent(client, keyring))
+	}
+
+	return c, sink
+}
+
+func TestForwardedAgent(t *testing.T) {
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	signer, _ := saultssh.NewSignerFromKey(privateKey)
+
+	keyring := saultsshAgent.NewKeyring()
+	keyring.Add(saultsshAgent.AddedKey{PrivateKey: privateKey})
+
+	c, sink := newTestAgentConnection(t, keyring)
+	agent := newForwardedAgent(c)
+
+	keys, err := agent.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(keys))
+	assert.Equal(t, signer.PublicKey().Marshal(), keys[0].Marshal())
+
+	data := make([]byte, 32)
+	rand.Read(data)
+
+	signature, err := agent.Sign(signer.PublicKey(), data)
+	assert.Nil(t, err)
+	assert.Nil(t, signer.PublicKey().Verify(data, signature))
+
+	// the agent of client can not be modified by host
+	assert.Equal(t, errAgentNotAllowed, agent.RemoveAll())
+	assert.Equal(t, errAgentNotAllowed, agent.Add(saultsshAgent.AddedKey{PrivateKey: privateKey}))
+	keys, _ = keyring.List()
+	assert.Equal(t, 1, len(keys))
+
+	c.server.auditor.close()
+
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, AuditEventAgentSign, sink.events[0].Type)
+	assert.Equal(t, "session-0", sink.events[0].SessionID)
+	assert.Equal(t, saultcommon.FingerprintSHA256PublicKey(signer.PublicKey()), sink.events[0].Fingerprint)
+	assert.Empty(t, sink.events[0].Error)
+}
+
+func TestForwardedAgentNotForwarded(t *testing.T) {
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	signer, _ := saultssh.NewSignerFromKey(privateKey)
+
+	// the client does not forward the agent
+	c, sink := newTestAgentConnection(t, nil)
+
+	_, err := newForwardedAgent(c).Sign(signer.PublicKey(), []byte("sault"))
+	assert.NotNil(t, err)
+
+	c.server.auditor.close()
+
+	assert.Equal(t, 1, len(sink.events))
+	assert.NotEmpty(t, sink.events[0].Error)
+}
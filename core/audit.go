@@ -0,0 +1,327 @@
+package sault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/naoina/toml"
+	"github.com/spikeekips/sault/common"
+)
+
+// AuditEventType is the type of audit event
+type AuditEventType string
+
+const (
+	// AuditEventAuthSuccess is for the successful authentication
+	AuditEventAuthSuccess AuditEventType = "auth.success"
+	// AuditEventAuthFailure is for the failed authentication
+	AuditEventAuthFailure AuditEventType = "auth.failure"
+	// AuditEventAuthBan is for the source address or public key, which is
+	// banned by the repeated authentication failures
+	AuditEventAuthBan AuditEventType = "auth.ban"
+	// AuditEventChannelOpen is for the opened channel
+	AuditEventChannelOpen AuditEventType = "channel.open"
+	// AuditEventChannelRequest is for the request, which is forwarded thru
+	// the channel, like 'exec', 'subsystem'
+	AuditEventChannelRequest AuditEventType = "channel.request"
+	// AuditEventChannelExitStatus is for the exit status of the channel
+	AuditEventChannelExitStatus AuditEventType = "channel.exit-status"
+	// AuditEventChannelClose is for the closed channel with the transferred
+	// bytes and duration
+	AuditEventChannelClose AuditEventType = "channel.close"
+	// AuditEventSessionKill is for the session, which is killed by admin
+	AuditEventSessionKill AuditEventType = "session.kill"
+	// AuditEventSessionTimeout is for the session, which is closed by the
+	// idle timeout or the maximum session duration
+	AuditEventSessionTimeout AuditEventType = "session.timeout"
+	// AuditEventSessionWatch is for starting and stopping to watch the
+	// session
+	AuditEventSessionWatch AuditEventType = "session.watch"
+	// AuditEventAgentSign is for the signing request of the inner host thru
+	// the forwarded agent of client
+	AuditEventAgentSign AuditEventType = "agent.sign"
+	// AuditEventTCPIPForward is for the remote port forwarding request of
+	// client, like 'tcpip-forward' and 'cancel-tcpip-forward'
+	AuditEventTCPIPForward AuditEventType = "tcpip.forward"
+	// AuditEventFileTransfer is for the file operations of sftp and scp, like
+	// open, read, write, rename and remove
+	AuditEventFileTransfer AuditEventType = "file.transfer"
+	// AuditEventSessionReason is for the reason of session, which is
+	// required for the sensitive hosts
+	AuditEventSessionReason AuditEventType = "session.reason"
+	// AuditEventBreakGlass is the alert for the break-glass session, which
+	// connects to the host without link
+	AuditEventBreakGlass AuditEventType = "alert.break-glass"
+	// AuditEventLinkExpired is for the expired link, which is removed from
+	// the registry
+	AuditEventLinkExpired AuditEventType = "link.expired"
+	// AuditEventCommand is for the sault command
+	AuditEventCommand AuditEventType = "command"
+)
+
+// AuditEvent is the structured audit event
+type AuditEvent struct {
+	Time        time.Time              `json:"time"`
+	Type        AuditEventType         `json:"type"`
+	SessionID   string                 `json:"session_id,omitempty"`
+	ChannelID   string                 `json:"channel_id,omitempty"`
+	RemoteAddr  string                 `json:"remote_addr,omitempty"`
+	User        string                 `json:"user,omitempty"`
+	Host        string                 `json:"host,omitempty"`
+	Account     string                 `json:"account,omitempty"`
+	Fingerprint string                 `json:"fingerprint,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+	Data        map[string]interface{} `json:"data,omitempty"`
+}
+
+// AuditSink receives the audit events
+type AuditSink interface {
+	GetType() string
+	Write(event []byte) error
+	Validate() error
+}
+
+// LoadAuditSinkFromConfig load audit sink from config
+func LoadAuditSinkFromConfig(data map[string]interface{}, config map[string]interface{}) (sink AuditSink, err error) {
+	var sinkType string
+	var ok bool
+	if sinkType, ok = data["type"].(string); !ok {
+		err = fmt.Errorf("'type' is missing in data, map[string]interface{}")
+		return
+	}
+
+	var b bytes.Buffer
+	toml.NewEncoder(&b).Encode(data)
+
+	switch sinkType {
+	case "file":
+		s := &auditFileSink{}
+		if err = saultcommon.DefaultTOML.NewDecoder(&b).Decode(s); err != nil {
+			return
+		}
+		s.Path = saultcommon.BaseJoin(config["BaseDirectory"].(string), s.Path)
+		sink = s
+	case "syslog":
+		s := &auditSyslogSink{}
+		if err = saultcommon.DefaultTOML.NewDecoder(&b).Decode(s); err != nil {
+			return
+		}
+		sink = s
+	case "webhook":
+		s := &auditWebhookSink{}
+		if err = saultcommon.DefaultTOML.NewDecoder(&b).Decode(s); err != nil {
+			return
+		}
+		sink = s
+	default:
+		err = fmt.Errorf("unknown audit sink type, '%s'", sinkType)
+	}
+
+	return
+}
+
+var auditFileMode os.FileMode = 0600
+
+// auditFileSink appends the events to the file, one event per line
+type auditFileSink struct {
+	sync.Mutex
+
+	Type string // must be 'file'
+	Path string
+}
+
+func (s *auditFileSink) GetType() string {
+	return "file"
+}
+
+func (s *auditFileSink) Validate() error {
+	if len(strings.TrimSpace(s.Path)) < 1 {
+		return fmt.Errorf("path is empty")
+	}
+
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, auditFileMode)
+	if err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+func (s *auditFileSink) Write(event []byte) error {
+	s.Lock()
+	defer s.Unlock()
+
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, auditFileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(event, '\n'))
+	return err
+}
+
+// auditSyslogSink sends the events to syslog; if Network is empty, the local
+// syslog server will be used.
+type auditSyslogSink struct {
+	sync.Mutex
+
+	Type    string // must be 'syslog'
+	Network string
+	Address string
+	Tag     string
+
+	writer *syslog.Writer
+}
+
+func (s *auditSyslogSink) GetType() string {
+	return "syslog"
+}
+
+func (s *auditSyslogSink) Validate() error {
+	if len(s.Tag) < 1 {
+		s.Tag = "sault"
+	}
+
+	if len(s.Network) > 0 && len(s.Address) < 1 {
+		return fmt.Errorf("address is empty")
+	}
+
+	return nil
+}
+
+func (s *auditSyslogSink) Write(event []byte) (err error) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.writer == nil {
+		s.writer, err = syslog.Dial(s.Network, s.Address, syslog.LOG_INFO|syslog.LOG_AUTH, s.Tag)
+		if err != nil {
+			return
+		}
+	}
+
+	if err = s.writer.Info(string(event)); err != nil {
+		// reconnect at next time
+		s.writer.Close()
+		s.writer = nil
+	}
+
+	return
+}
+
+var defaultAuditWebhookTimeout = time.Second * 5
+
+// auditWebhookSink posts the event to the url as JSON
+type auditWebhookSink struct {
+	Type    string // must be 'webhook'
+	URL     string
+	Timeout string
+
+	client *http.Client
+}
+
+func (s *auditWebhookSink) GetType() string {
+	return "webhook"
+}
+
+func (s *auditWebhookSink) Validate() (err error) {
+	if !strings.HasPrefix(s.URL, "http://") && !strings.HasPrefix(s.URL, "https://") {
+		return fmt.Errorf("invalid url, '%s'", s.URL)
+	}
+
+	timeout := defaultAuditWebhookTimeout
+	if len(s.Timeout) > 0 {
+		if timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout, '%s': %v", s.Timeout, err)
+		}
+	}
+
+	s.client = &http.Client{Timeout: timeout}
+
+	return nil
+}
+
+func (s *auditWebhookSink) Write(event []byte) error {
+	response, err := s.client.Post(s.URL, "application/json", bytes.NewBuffer(event))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("webhook, '%s' responded %s", s.URL, response.Status)
+	}
+
+	return nil
+}
+
+var auditEventsBufferSize = 1024
+
+// auditor delivers the audit events to the sinks in background, so the slow
+// sinks like webhook do not block the connections.
+type auditor struct {
+	sinks  []AuditSink
+	events chan AuditEvent
+	done   chan struct{}
+}
+
+func newAuditor(sinks []AuditSink) *auditor {
+	a := &auditor{
+		sinks:  sinks,
+		events: make(chan AuditEvent, auditEventsBufferSize),
+		done:   make(chan struct{}),
+	}
+
+	go a.run()
+
+	return a
+}
+
+func (a *auditor) run() {
+	defer close(a.done)
+
+	for event := range a.events {
+		jsoned, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("failed to marshal audit event, %v: %v", event, err)
+			continue
+		}
+
+		for _, sink := range a.sinks {
+			if err := sink.Write(jsoned); err != nil {
+				log.Errorf("failed to write audit event to '%s' sink: %v", sink.GetType(), err)
+			}
+		}
+	}
+}
+
+// emit queues the event; if the queue is full, the event is dropped.
+func (a *auditor) emit(event AuditEvent) {
+	if a == nil || len(a.sinks) < 1 {
+		return
+	}
+
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	select {
+	case a.events <- event:
+	default:
+		log.Errorf("audit event queue is full; event dropped: %v", event)
+	}
+}
+
+// close stops the auditor after the queued events are delivered.
+func (a *auditor) close() {
+	close(a.events)
+	<-a.done
+}
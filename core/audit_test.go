@@ -0,0 +1,120 @@
+package sault
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditorFileSink(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	sink := &auditFileSink{Path: filepath.Join(directory, "audit.log")}
+	assert.Nil(t, sink.Validate())
+
+	a := newAuditor([]AuditSink{sink})
+	a.emit(AuditEvent{Type: AuditEventAuthSuccess, User: "spikeekips", RemoteAddr: "127.0.0.1:22"})
+	a.emit(AuditEvent{
+		Type: AuditEventCommand,
+		User: "spikeekips",
+		Data: map[string]interface{}{"command": "user list"},
+	})
+	a.close()
+
+	f, _ := os.Open(sink.Path)
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var event AuditEvent
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, AuditEventAuthSuccess, events[0].Type)
+	assert.Equal(t, "127.0.0.1:22", events[0].RemoteAddr)
+	assert.False(t, events[0].Time.IsZero())
+	assert.Equal(t, AuditEventCommand, events[1].Type)
+	assert.Equal(t, "user list", events[1].Data["command"])
+}
+
+func TestAuditorWebhookSink(t *testing.T) {
+	var lock sync.Mutex
+	var received []AuditEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		var event AuditEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+
+	sink := &auditWebhookSink{URL: server.URL, Timeout: "1s"}
+	assert.Nil(t, sink.Validate())
+
+	a := newAuditor([]AuditSink{sink})
+	a.emit(AuditEvent{Type: AuditEventAuthFailure, Error: "unknown user"})
+	a.close()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	assert.Equal(t, 1, len(received))
+	assert.Equal(t, AuditEventAuthFailure, received[0].Type)
+	assert.Equal(t, "unknown user", received[0].Error)
+}
+
+func TestServerRemoveExpiredLinks(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+
+	request, _ := registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour, "deploy")
+	registry.ApproveAccessRequest(request.ID, "admin")
+
+	sink := &auditFileSink{Path: filepath.Join(directory, "audit.log")}
+	server := &Server{registry: registry, auditor: newAuditor([]AuditSink{sink})}
+
+	server.removeExpiredLinksAt(time.Now())
+	assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+
+	server.removeExpiredLinksAt(time.Now().Add(time.Hour * 2))
+	_, err := registry.GetLink(user.ID, host.ID)
+	assert.NotNil(t, err)
+
+	server.auditor.close()
+
+	b, _ := ioutil.ReadFile(sink.Path)
+	var event AuditEvent
+	assert.Nil(t, json.Unmarshal(b, &event))
+	assert.Equal(t, AuditEventLinkExpired, event.Type)
+	assert.Equal(t, user.ID, event.User)
+	assert.Equal(t, host.ID, event.Host)
+}
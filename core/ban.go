@@ -0,0 +1,260 @@
+package sault
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// BanKindAddress is the ban by the source address
+	BanKindAddress = "address"
+	// BanKindFingerprint is the ban by the public key fingerprint
+	BanKindFingerprint = "fingerprint"
+)
+
+// BanInfo is the information of the tracked address or fingerprint
+type BanInfo struct {
+	Kind        string
+	Key         string
+	Failures    int
+	Bans        int
+	LastFailure time.Time
+	BannedUntil time.Time
+}
+
+// IsBanned checks whether it is banned at the given time
+func (b BanInfo) IsBanned(t time.Time) bool {
+	return b.BannedUntil.After(t)
+}
+
+type banEntry struct {
+	kind        string
+	key         string
+	failures    []time.Time
+	bans        int
+	lastFailure time.Time
+	bannedUntil time.Time
+}
+
+// BanRegistry counts the authentication failures and bans the source
+// addresses or the public key fingerprints after the failures exceed
+// MaxFailures in Window. The ban time is doubled for the repeated bans up to
+// MaxBanTime.
+type BanRegistry struct {
+	sync.Mutex
+
+	config  configBan
+	entries map[string]*banEntry
+
+	now func() time.Time
+}
+
+// NewBanRegistry makes BanRegistry, which is disabled until setup with config
+func NewBanRegistry() *BanRegistry {
+	return &BanRegistry{
+		entries: map[string]*banEntry{},
+		now:     time.Now,
+	}
+}
+
+func (r *BanRegistry) setup(config configBan) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.config = config
+}
+
+func banKey(kind, key string) string {
+	return kind + " " + key
+}
+
+func (r *BanRegistry) isAllowed(kind, key string) bool {
+	if kind != BanKindAddress {
+		return false
+	}
+
+	// the clients thru the unix socket have no IP address; they are the
+	// local clients and not banned.
+	ip := net.ParseIP(key)
+	if ip == nil {
+		return true
+	}
+
+	for _, n := range r.config.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *BanRegistry) isEnabled(kind string) bool {
+	if !r.config.Enabled {
+		return false
+	}
+
+	return kind == BanKindAddress || r.config.BanPublicKey
+}
+
+// isBanned checks whether the address or fingerprint is banned now
+func (r *BanRegistry) isBanned(kind, key string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.isEnabled(kind) || r.isAllowed(kind, key) {
+		return false
+	}
+
+	entry, ok := r.entries[banKey(kind, key)]
+	if !ok {
+		return false
+	}
+
+	return entry.bannedUntil.After(r.now())
+}
+
+// failed counts the failure; if it is banned by this failure, returns the
+// ban duration.
+func (r *BanRegistry) failed(kind, key string) (banned time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.isEnabled(kind) || r.isAllowed(kind, key) {
+		return
+	}
+
+	now := r.now()
+	r.expire(now)
+
+	k := banKey(kind, key)
+	entry, ok := r.entries[k]
+	if !ok {
+		entry = &banEntry{kind: kind, key: key}
+		r.entries[k] = entry
+	}
+
+	entry.lastFailure = now
+	entry.failures = append(entry.failures, now)
+
+	// drop the failures out of window
+	var failures []time.Time
+	for _, t := range entry.failures {
+		if now.Sub(t) < r.config.window {
+			failures = append(failures, t)
+		}
+	}
+	entry.failures = failures
+
+	if len(entry.failures) < r.config.MaxFailures || entry.bannedUntil.After(now) {
+		return
+	}
+
+	banned = r.config.banTime << uint(entry.bans)
+	if banned > r.config.maxBanTime || banned <= 0 {
+		banned = r.config.maxBanTime
+	}
+
+	entry.bans++
+	entry.failures = nil
+	entry.bannedUntil = now.Add(banned)
+
+	return
+}
+
+// succeeded resets the failures, but the ban history is kept for the
+// backoff.
+func (r *BanRegistry) succeeded(kind, key string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if entry, ok := r.entries[banKey(kind, key)]; ok {
+		entry.failures = nil
+	}
+}
+
+// expire forgets the entries, which are not failed during MaxBanTime after
+// the ban expired.
+func (r *BanRegistry) expire(now time.Time) {
+	for k, entry := range r.entries {
+		last := entry.lastFailure
+		if entry.bannedUntil.After(last) {
+			last = entry.bannedUntil
+		}
+
+		if now.Sub(last) > r.config.maxBanTime {
+			delete(r.entries, k)
+		}
+	}
+}
+
+// GetBans returns the tracked addresses and fingerprints
+func (r *BanRegistry) GetBans() (bans []BanInfo) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.expire(r.now())
+
+	bans = []BanInfo{}
+	for _, entry := range r.entries {
+		bans = append(bans, BanInfo{
+			Kind:        entry.kind,
+			Key:         entry.key,
+			Failures:    len(entry.failures),
+			Bans:        entry.bans,
+			LastFailure: entry.lastFailure,
+			BannedUntil: entry.bannedUntil,
+		})
+	}
+
+	sort.SliceStable(bans, func(i, j int) bool {
+		return bans[i].LastFailure.Before(bans[j].LastFailure)
+	})
+
+	return
+}
+
+// Clear removes the bans and failures of the given addresses or
+// fingerprints; if keys is empty, all will be removed.
+func (r *BanRegistry) Clear(keys ...string) (cleared []BanInfo) {
+	bans := r.GetBans()
+
+	r.Lock()
+	defer r.Unlock()
+
+	cleared = []BanInfo{}
+	for _, b := range bans {
+		if len(keys) > 0 {
+			var found bool
+			for _, key := range keys {
+				if b.Key == key {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		delete(r.entries, banKey(b.Kind, b.Key))
+		cleared = append(cleared, b)
+	}
+
+	return
+}
+
+func getAddressFromRemoteAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+
+	return host
+}
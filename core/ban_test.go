@@ -0,0 +1,150 @@
+package sault
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBanRegistry(t *testing.T, setup func(*Config)) (*BanRegistry, *time.Time) {
+	config := NewConfig()
+	if setup != nil {
+		setup(config)
+	}
+	assert.Nil(t, config.validateBan())
+
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewBanRegistry()
+	r.setup(config.Ban)
+	r.now = func() time.Time {
+		return now
+	}
+
+	return r, &now
+}
+
+func TestBanRegistry(t *testing.T) {
+	r, now := newTestBanRegistry(t, func(c *Config) {
+		c.Ban.MaxFailures = 3
+		c.Ban.Window = "1m"
+		c.Ban.BanTime = "10m"
+		c.Ban.MaxBanTime = "30m"
+	})
+
+	address := "192.168.99.1"
+
+	// failures out of window are not counted
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, address))
+		*now = now.Add(time.Second * 40)
+	}
+	assert.False(t, r.isBanned(BanKindAddress, address))
+
+	assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, address))
+	assert.Equal(t, time.Minute*10, r.failed(BanKindAddress, address))
+	assert.True(t, r.isBanned(BanKindAddress, address))
+
+	*now = now.Add(time.Minute * 11)
+	assert.False(t, r.isBanned(BanKindAddress, address))
+
+	// ban time is doubled, but not longer than MaxBanTime
+	for _, expected := range []time.Duration{time.Minute * 20, time.Minute * 30} {
+		r.failed(BanKindAddress, address)
+		r.failed(BanKindAddress, address)
+		assert.Equal(t, expected, r.failed(BanKindAddress, address))
+		*now = now.Add(expected + time.Second)
+	}
+
+	bans := r.GetBans()
+	assert.Equal(t, 1, len(bans))
+	assert.Equal(t, address, bans[0].Key)
+	assert.Equal(t, 3, bans[0].Bans)
+
+	// succeeded resets the failures
+	r.failed(BanKindAddress, address)
+	r.failed(BanKindAddress, address)
+	r.succeeded(BanKindAddress, address)
+	assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, address))
+
+	// forgotten after MaxBanTime
+	*now = now.Add(time.Minute * 31)
+	assert.Equal(t, 0, len(r.GetBans()))
+}
+
+func TestBanRegistryAllow(t *testing.T) {
+	r, _ := newTestBanRegistry(t, func(c *Config) {
+		c.Ban.MaxFailures = 1
+		c.Ban.Allow = []string{"10.0.0.0/8"}
+	})
+
+	assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, "10.1.1.1"))
+	assert.False(t, r.isBanned(BanKindAddress, "10.1.1.1"))
+
+	assert.NotEqual(t, time.Duration(0), r.failed(BanKindAddress, "11.1.1.1"))
+	assert.True(t, r.isBanned(BanKindAddress, "11.1.1.1"))
+
+	// the unix socket is not banned
+	assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, "/run/sault.sock"))
+	assert.False(t, r.isBanned(BanKindAddress, "/run/sault.sock"))
+}
+
+func TestBanRegistryFingerprint(t *testing.T) {
+	fingerprint := "SHA256:2qdRWsCxQ9y4y2vwzuLkdEw3+oD7I3ZcYNzCDJvlwyI"
+
+	{
+		r, _ := newTestBanRegistry(t, func(c *Config) {
+			c.Ban.MaxFailures = 1
+		})
+
+		assert.Equal(t, time.Duration(0), r.failed(BanKindFingerprint, fingerprint))
+		assert.False(t, r.isBanned(BanKindFingerprint, fingerprint))
+	}
+
+	{
+		r, _ := newTestBanRegistry(t, func(c *Config) {
+			c.Ban.MaxFailures = 1
+			c.Ban.BanPublicKey = true
+		})
+
+		assert.NotEqual(t, time.Duration(0), r.failed(BanKindFingerprint, fingerprint))
+		assert.True(t, r.isBanned(BanKindFingerprint, fingerprint))
+	}
+}
+
+func TestBanRegistryDisabled(t *testing.T) {
+	r, _ := newTestBanRegistry(t, func(c *Config) {
+		c.Ban.Enabled = false
+		c.Ban.MaxFailures = 1
+	})
+
+	assert.Equal(t, time.Duration(0), r.failed(BanKindAddress, "11.1.1.1"))
+	assert.False(t, r.isBanned(BanKindAddress, "11.1.1.1"))
+}
+
+func TestBanRegistryClear(t *testing.T) {
+	r, _ := newTestBanRegistry(t, func(c *Config) {
+		c.Ban.MaxFailures = 1
+	})
+
+	for _, address := range []string{"11.1.1.1", "11.1.1.2", "11.1.1.3"} {
+		r.failed(BanKindAddress, address)
+	}
+
+	cleared := r.Clear("11.1.1.2", "11.1.1.9")
+	assert.Equal(t, 1, len(cleared))
+	assert.Equal(t, "11.1.1.2", cleared[0].Key)
+	assert.False(t, r.isBanned(BanKindAddress, "11.1.1.2"))
+	assert.True(t, r.isBanned(BanKindAddress, "11.1.1.1"))
+
+	assert.Equal(t, 2, len(r.Clear()))
+	assert.Equal(t, 0, len(r.GetBans()))
+}
+
+func TestGetAddressFromRemoteAddr(t *testing.T) {
+	assert.Equal(t, "192.168.99.1", getAddressFromRemoteAddr(&net.TCPAddr{IP: net.ParseIP("192.168.99.1"), Port: 22}))
+	assert.Equal(t, "::1", getAddressFromRemoteAddr(&net.TCPAddr{IP: net.ParseIP("::1"), Port: 22}))
+	assert.Equal(t, "", getAddressFromRemoteAddr(nil))
+}
@@ -0,0 +1,112 @@
+package sault
+
+import (
+	"strings"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// bannerData is the template variables of banner, for example,
+//
+//	Welcome {{ .User | green }}, you are connecting to '{{ .Account }}@{{ .Host }}'.
+//
+// Before authentication, only `Account` and `Host`, which the client requested
+// are available.
+type bannerData struct {
+	User      string
+	Host      string
+	Account   string
+	SessionID string
+
+	// LinkExpires is the expiry time of the link of user; it is empty if
+	// the link does not expire.
+	LinkExpires string
+}
+
+func renderBanner(banner string, data bannerData) (string, error) {
+	if len(strings.TrimSpace(banner)) < 1 {
+		return "", nil
+	}
+
+	return saultcommon.SimpleTemplating(banner, data)
+}
+
+func (c *connection) getBannerData() bannerData {
+	data := bannerData{
+		User:      c.user.ID,
+		Host:      c.host.ID,
+		Account:   c.account,
+		SessionID: c.id,
+	}
+
+	if c.server == nil || c.server.registry == nil {
+		return data
+	}
+
+	if link, err := c.server.registry.GetLink(c.user.ID, c.host.ID); err == nil {
+		if expires := link.GetDateExpires(c.account); !expires.IsZero() {
+			data.LinkExpires = expires.Format(time.RFC3339)
+		}
+	}
+
+	return data
+}
+
+// bannerCallback returns the banner of sault server for authentication; the
+// banner of host is not shown, because the client is not yet authenticated.
+// The colors are removed, because the clients, like OpenSSH filter the
+// control characters of the authentication banner.
+func (c *connection) bannerCallback(conn saultssh.ConnMetadata) string {
+	if c.server == nil || c.server.config == nil || len(c.server.config.Server.Banner) < 1 {
+		return ""
+	}
+
+	data := bannerData{SessionID: c.id}
+	if account, hostID, err := saultcommon.ParseSaultAccountName(conn.User()); err == nil {
+		data.Account = account
+		data.Host = hostID
+	}
+
+	banner, err := renderBanner(c.server.config.Server.Banner, data)
+	if err != nil {
+		c.log.Errorf("failed to render banner: %v", err)
+		return ""
+	}
+
+	return saultcommon.StripTerminalEscapes(banner)
+}
+
+// getPtyBanner returns the banners of sault server and host for the pty
+// session; the newlines are converted for terminal.
+func (c *connection) getPtyBanner() string {
+	var banners []string
+	if c.server != nil && c.server.config != nil {
+		banners = append(banners, c.server.config.Server.Banner)
+	}
+	banners = append(banners, c.host.Banner)
+
+	data := c.getBannerData()
+
+	var rendered []string
+	for _, b := range banners {
+		banner, err := renderBanner(b, data)
+		if err != nil {
+			c.log.Errorf("failed to render banner: %v", err)
+			continue
+		}
+		if len(strings.TrimSpace(banner)) < 1 {
+			continue
+		}
+
+		rendered = append(rendered, strings.TrimRight(banner, "\n")+"\n")
+	}
+
+	if len(rendered) < 1 {
+		return ""
+	}
+
+	banner := strings.Replace(strings.Join(rendered, ""), "\r\n", "\n", -1)
+	return strings.Replace(banner, "\n", "\r\n", -1)
+}
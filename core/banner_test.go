@@ -0,0 +1,127 @@
+package sault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderBanner(t *testing.T) {
+	{
+		banner, err := renderBanner("", bannerData{User: "spikeekips"})
+		assert.Nil(t, err)
+		assert.Empty(t, banner)
+	}
+
+	{
+		banner, err := renderBanner(
+			"hello {{ .User }}, {{ .Account }}@{{ .Host }} ({{ .SessionID }})",
+			bannerData{User: "spikeekips", Host: "prometeus", Account: "ubuntu", SessionID: "findme"},
+		)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello spikeekips, ubuntu@prometeus (findme)", banner)
+	}
+
+	{
+		// colors
+		banner, err := renderBanner(`{{ .User | green }}`, bannerData{User: "spikeekips"})
+		assert.Nil(t, err)
+		assert.Contains(t, banner, "spikeekips")
+	}
+
+	{
+		_, err := renderBanner("{{ .User ", bannerData{})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestConfigServerBanner(t *testing.T) {
+	{
+		config := NewConfig()
+		config.Server.Banner = "hello {{ .User }}"
+		assert.Nil(t, config.validateServerBanner())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.Banner = "hello {{ .User "
+		assert.NotNil(t, config.validateServerBanner())
+	}
+}
+
+func TestBannerCallback(t *testing.T) {
+	config := NewConfig()
+	config.Server.Banner = "\x1b[1mhello\x1b[0m {{ .Account | green }}@{{ .Host | yellow }}\r\n"
+
+	c := &connection{
+		id:     "findme",
+		server: &Server{config: config},
+		log:    log.WithFields(logrus.Fields{}),
+	}
+
+	// the colors are not allowed in the authentication banner
+	banner := c.bannerCallback(&testSSHConn{user: "ubuntu+prometeus"})
+	assert.Equal(t, "hello ubuntu@prometeus\n", banner)
+}
+
+func TestPtyBanner(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus", uint64(22), []string{"ubuntu"})
+	assert.Nil(t, registry.Link(user.ID, host.ID, "ubuntu"))
+
+	config := NewConfig()
+	server, _ := NewServer(registry, config, nil, nil, DefaultSaultServerName)
+	newConn := func() *connection {
+		host, _ := registry.GetHost("prometeus", saultregistry.HostFilterNone)
+		return &connection{
+			server:  server,
+			id:      "findme",
+			user:    user,
+			host:    host,
+			account: "ubuntu",
+			log:     log.WithFields(logrus.Fields{}),
+		}
+	}
+
+	{
+		// no banner
+		assert.Empty(t, newConn().getPtyBanner())
+	}
+
+	{
+		config.Server.Banner = "welcome {{ .User }}\n"
+		host.Banner = "{{ .Account }}@{{ .Host }}\nsession: {{ .SessionID }}"
+		_, err := registry.UpdateHost(host.ID, host)
+		assert.Nil(t, err)
+
+		assert.Equal(
+			t,
+			"welcome spikeekips\r\nubuntu@prometeus\r\nsession: findme\r\n",
+			newConn().getPtyBanner(),
+		)
+	}
+
+	{
+		// link expiry
+		expires := time.Now().Add(time.Hour)
+
+		link, _ := registry.GetLink(user.ID, host.ID)
+		link.DateExpires = expires
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, link))
+
+		host.Banner = "expires at {{ .LinkExpires }}"
+		registry.UpdateHost(host.ID, host)
+
+		assert.Contains(t, newConn().getPtyBanner(), expires.Format(time.RFC3339))
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"bytes"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/naoina/toml"
 	"github.com/spikeekips/sault/common"
@@ -16,8 +19,13 @@ import (
 
 // Config contains configurations
 type Config struct {
-	Server   configServer
-	Registry configRegistry
+	Server    configServer
+	Registry  configRegistry
+	Recording configRecording
+	Audit     configAudit
+	Ban       configBan
+	Session   configSession
+	InnerPool configInnerPool
 
 	baseDirectory string
 }
@@ -31,6 +39,24 @@ func NewConfig() *Config {
 	c.Server.SaultServerName = DefaultSaultServerName
 	c.Server.HostKey = DefaultHostKey
 	c.Server.ClientKey = DefaultClientKey
+	c.Server.TOTP = TOTPModeNone
+	c.Server.UserCertValidity = DefaultUserCertValidity
+
+	c.Recording.Enabled = true
+	c.Recording.Directory = DefaultRecordingDirectory
+
+	c.Ban.Enabled = true
+	c.Ban.MaxFailures = DefaultBanMaxFailures
+	c.Ban.Window = DefaultBanWindow
+	c.Ban.BanTime = DefaultBanTime
+	c.Ban.MaxBanTime = DefaultBanMaxBanTime
+
+	c.Session.Warning = DefaultSessionWarning
+
+	c.InnerPool.Enabled = true
+	c.InnerPool.MaxShared = DefaultInnerPoolMaxShared
+	c.InnerPool.IdleTimeout = DefaultInnerPoolIdleTimeout
+	c.InnerPool.KeepaliveInterval = DefaultInnerPoolKeepaliveInterval
 
 	registryFile := fmt.Sprintf("./sault%s", saultregistry.RegistryFileExt)
 	c.Registry.Source = []interface{}{
@@ -44,6 +70,12 @@ type configServer struct {
 	// Bind, sault server bind address, '<hostname or ip>:<port>'
 	Bind string
 
+	// Listener is the list of listeners, like the public interface, the
+	// internal interface and the unix socket; if empty, only Bind is
+	// listened.
+	Listener  []configListener
+	listeners []configListener
+
 	SaultServerName string
 
 	// HostKey is the ssh host key path
@@ -54,6 +86,102 @@ type configServer struct {
 	ClientKey       string
 	clientKey       []byte
 	clientKeySigner saultssh.Signer
+
+	// UserCA is the private key path of the user CA; if set, sault server
+	// signs the short-lived user certificate for each inner connection
+	// instead of using the client key. The hosts trust it by
+	// 'TrustedUserCAKeys', see 'host inject -ca'.
+	UserCA       string
+	userCASigner saultssh.Signer
+
+	// UserCertValidity is the validity of the user certificates, like '5m'
+	UserCertValidity string
+	userCertValidity time.Duration
+
+	// UserCAClientKeyFallback, if true, the client key is also offered after
+	// the user certificate for the hosts, which do not trust the user CA yet;
+	// by default, only the user certificate is offered with UserCA.
+	UserCAClientKeyFallback bool
+
+	// TrustedUserCAKeys is the public keys of the CAs, which sign the user
+	// certificates of sault users, like 'ssh-ed25519 AAAA...'; one of the
+	// principals of certificate is the user id.
+	TrustedUserCAKeys []string
+	trustedUserCAKeys []saultssh.PublicKey
+
+	// RevokedUserCerts is the revoked user certificates by the serial number
+	// or the sha256 fingerprint of the certified key, like 'SHA256:...'
+	RevokedUserCerts []string
+	revokedUserCerts map[string]bool
+
+	// MetricsBind, if set, the prometheus metrics is served at
+	// 'http://<MetricsBind>/metrics'
+	MetricsBind string
+
+	// InnerDialTimeout is the timeout to connect to each address of hosts,
+	// like '3s'
+	InnerDialTimeout string
+	innerDialTimeout time.Duration
+
+	// Banner is shown to the clients before authentication and in the pty
+	// sessions; it can have the template variables and colors, see
+	// bannerData. The colors are removed before authentication.
+	Banner string
+
+	// AdminDirectTCPIP, if true, the admin can open the direct-tcpip channel
+	// to any address; the other users can connect only to the linked hosts.
+	AdminDirectTCPIP bool
+
+	// TOTP is when the TOTP second factor is required after the public key
+	// authentication; 'none', 'all' or 'sensitive', which requires it for
+	// the admins and the sensitive hosts. The user, who does not enroll it
+	// yet, can enter sault only to run 'user totp enroll'.
+	TOTP string
+
+	// BreakGlassUsers is the users, who can connect to the hosts, which they
+	// are not linked to, in the emergency; the reason is required and the
+	// alert is raised by the audit event, 'alert.break-glass'.
+	BreakGlassUsers []string
+
+	// ProxyProtocol is the list of CIDRs of the trusted upstreams, like the
+	// load balancers; the connections from them must send the PROXY protocol
+	// v1 or v2 header and the client address in the header is used instead
+	// of the address of upstream.
+	ProxyProtocol []string
+	proxyProtocol []*net.IPNet
+}
+
+type configListener struct {
+	// Bind is '<hostname or ip>:<port>' or 'unix:<socket path>'
+	Bind string
+
+	// Policy is one of 'all', 'admin' and 'proxy'; see ListenerPolicyAll
+	Policy string
+
+	network string
+	address string
+}
+
+// GetNetwork returns the network of listener, 'tcp' or 'unix'
+func (c configListener) GetNetwork() string {
+	return c.network
+}
+
+// GetAddress returns the address of listener; for the unix socket, it is
+// the absolute path of socket.
+func (c configListener) GetAddress() string {
+	return c.address
+}
+
+// GetListeners returns the validated listeners
+func (c configServer) GetListeners() []configListener {
+	return c.listeners
+}
+
+// GetInnerDialTimeout returns the timeout to connect to each address of
+// hosts
+func (c configServer) GetInnerDialTimeout() time.Duration {
+	return c.innerDialTimeout
 }
 
 type configRegistry struct {
@@ -65,6 +193,130 @@ func (c configRegistry) GetSources() []saultregistry.RegistrySource {
 	return c.source
 }
 
+type configRecording struct {
+	// Enabled, if true, the pty sessions thru sault will be recorded
+	Enabled bool
+
+	// Directory is the directory path for the recording files
+	Directory string
+	directory string
+
+	// MaxAge is the retention period of recording files, like '720h'; the
+	// older recording files will be removed. If empty, the recording files
+	// will be kept forever.
+	MaxAge string
+	maxAge time.Duration
+}
+
+// GetDirectory returns the absolute path of recording directory
+func (c configRecording) GetDirectory() string {
+	return c.directory
+}
+
+// GetMaxAge returns the retention period of recording files
+func (c configRecording) GetMaxAge() time.Duration {
+	return c.maxAge
+}
+
+type configAudit struct {
+	// Sink is the list of audit sinks, each sink must have 'type', one of
+	// 'file', 'syslog' and 'webhook'. If empty, the audit events are not
+	// emitted.
+	Sink []interface{}
+	sink []AuditSink
+}
+
+// GetSinks returns the validated audit sinks
+func (c configAudit) GetSinks() []AuditSink {
+	return c.sink
+}
+
+type configSession struct {
+	// IdleTimeout, if set, the proxied sessions, which have no input from
+	// the client during IdleTimeout, like '30m', will be closed.
+	IdleTimeout string
+	idleTimeout time.Duration
+
+	// MaxDuration, if set, the proxied sessions will be closed after
+	// MaxDuration, like '12h'.
+	MaxDuration string
+	maxDuration time.Duration
+
+	// Warning is the duration to warn the client before closing the session
+	Warning string
+	warning time.Duration
+}
+
+// GetIdleTimeout returns the idle timeout; if 0, the idle sessions are not
+// closed.
+func (c configSession) GetIdleTimeout() time.Duration {
+	return c.idleTimeout
+}
+
+// GetMaxDuration returns the maximum session duration; if 0, the sessions
+// are not limited.
+func (c configSession) GetMaxDuration() time.Duration {
+	return c.maxDuration
+}
+
+// GetWarning returns the duration to warn before closing the session
+func (c configSession) GetWarning() time.Duration {
+	return c.warning
+}
+
+type configInnerPool struct {
+	// Enabled, if true, the inner connections to the same host and account
+	// are shared by the proxied connections
+	Enabled bool
+
+	// MaxShared is the maximum number of the proxied connections, which
+	// share one inner connection; it should be less than 'MaxSessions' of
+	// the sshd of hosts.
+	MaxShared int
+
+	// IdleTimeout is the duration to keep the unused inner connections
+	IdleTimeout string
+	idleTimeout time.Duration
+
+	// KeepaliveInterval is the interval to check the inner connections
+	KeepaliveInterval string
+	keepaliveInterval time.Duration
+}
+
+// GetIdleTimeout returns the duration to keep the unused inner connections
+func (c configInnerPool) GetIdleTimeout() time.Duration {
+	return c.idleTimeout
+}
+
+// GetKeepaliveInterval returns the interval to check the inner connections
+func (c configInnerPool) GetKeepaliveInterval() time.Duration {
+	return c.keepaliveInterval
+}
+
+type configBan struct {
+	// Enabled, if true, the source addresses are banned after the
+	// authentication failures exceed MaxFailures in Window
+	Enabled     bool
+	MaxFailures int
+
+	Window string
+	window time.Duration
+
+	// BanTime is the first ban time; it is doubled for the repeated bans up
+	// to MaxBanTime
+	BanTime    string
+	banTime    time.Duration
+	MaxBanTime string
+	maxBanTime time.Duration
+
+	// BanPublicKey, if true, the public key fingerprints are also banned
+	BanPublicKey bool
+
+	// Allow is the list of CIDRs, which are never banned
+	Allow []string
+	allow []*net.IPNet
+}
+
 // LoadConfigs loads configs
 func LoadConfigs(envDirs []string) (config *Config, err error) {
 	if len(envDirs) < 1 {
@@ -163,6 +415,77 @@ func (c configServer) GetHostKeySigner() saultssh.Signer {
 	return c.hostKeySigner
 }
 
+// GetUserCASigner returns signer of the user CA; if the user CA is not set,
+// nil is returned.
+func (c configServer) GetUserCASigner() saultssh.Signer {
+	return c.userCASigner
+}
+
+// GetUserCertValidity returns the validity of the user certificates
+func (c configServer) GetUserCertValidity() time.Duration {
+	return c.userCertValidity
+}
+
+// IsTrustedUserCA checks whether the public key is one of the trusted user CAs
+func (c configServer) IsTrustedUserCA(publicKey saultssh.PublicKey) bool {
+	b := publicKey.Marshal()
+	for _, k := range c.trustedUserCAKeys {
+		if bytes.Equal(k.Marshal(), b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HasTrustedUserCA checks whether the trusted user CAs are set
+func (c configServer) HasTrustedUserCA() bool {
+	return len(c.trustedUserCAKeys) > 0
+}
+
+// IsBreakGlassUser checks whether the user can connect to the hosts without
+// the link in the emergency
+func (c configServer) IsBreakGlassUser(id string) bool {
+	for _, u := range c.BreakGlassUsers {
+		if u == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// IsProxyProtocolUpstream checks whether the address is the trusted upstream,
+// which sends the PROXY protocol header
+func (c configServer) IsProxyProtocolUpstream(addr net.Addr) bool {
+	ip := net.ParseIP(getAddressFromRemoteAddr(addr))
+	if ip == nil {
+		return false
+	}
+
+	for _, n := range c.proxyProtocol {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func revokedUserCertSerial(serial uint64) string {
+	return fmt.Sprintf("serial:%d", serial)
+}
+
+// IsRevokedUserCert checks whether the user certificate is revoked by the
+// serial number or the fingerprint of the certified key
+func (c configServer) IsRevokedUserCert(cert *saultssh.Certificate) bool {
+	if c.revokedUserCerts[revokedUserCertSerial(cert.Serial)] {
+		return true
+	}
+
+	return c.revokedUserCerts[saultcommon.FingerprintSHA256PublicKey(cert.Key)]
+}
+
 // GetClientKeySigner returns signer of internal client key
 func (c configServer) GetClientKeySigner() saultssh.Signer {
 	return c.clientKeySigner
@@ -177,10 +500,24 @@ func (c configServer) GetClientKey() []byte {
 func (c *Config) Validate() (err error) {
 	funcs := [](func() error){
 		c.validateServerBind,
+		c.validateServerListener,
+		c.validateServerMetricsBind,
+		c.validateServerInnerDialTimeout,
+		c.validateServerBanner,
+		c.validateServerTOTP,
 		c.validateServerSaultServerName,
 		c.validateServerHostKey,
 		c.validateServerClientKey,
+		c.validateServerUserCA,
+		c.validateServerTrustedUserCAKeys,
+		c.validateServerBreakGlassUsers,
+		c.validateServerProxyProtocol,
 		c.validateRegistry,
+		c.validateRecording,
+		c.validateAudit,
+		c.validateBan,
+		c.validateSession,
+		c.validateInnerPool,
 	}
 
 	for _, f := range funcs {
@@ -206,6 +543,111 @@ func (c *Config) validateServerBind() (err error) {
 	return nil
 }
 
+func (c *Config) validateServerMetricsBind() (err error) {
+	c.Server.MetricsBind = strings.TrimSpace(c.Server.MetricsBind)
+	if len(c.Server.MetricsBind) < 1 {
+		return
+	}
+
+	if _, _, err = net.SplitHostPort(c.Server.MetricsBind); err != nil {
+		err = fmt.Errorf("invalid server.metrics_bind, '%s': %v", c.Server.MetricsBind, err)
+		return
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerInnerDialTimeout() (err error) {
+	c.Server.InnerDialTimeout = strings.TrimSpace(c.Server.InnerDialTimeout)
+	if len(c.Server.InnerDialTimeout) < 1 {
+		c.Server.innerDialTimeout = defaultTimeoutProxyClient
+		return
+	}
+
+	if c.Server.innerDialTimeout, err = time.ParseDuration(c.Server.InnerDialTimeout); err != nil || c.Server.innerDialTimeout <= 0 {
+		err = fmt.Errorf("invalid server.inner_dial_timeout, '%s'", c.Server.InnerDialTimeout)
+		return
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerBanner() (err error) {
+	if len(strings.TrimSpace(c.Server.Banner)) < 1 {
+		c.Server.Banner = ""
+		return
+	}
+
+	if _, err = renderBanner(c.Server.Banner, bannerData{}); err != nil {
+		err = fmt.Errorf("invalid server.banner: %v", err)
+		return
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerListener() (err error) {
+	listeners := c.Server.Listener
+	if len(listeners) < 1 {
+		listeners = []configListener{configListener{Bind: c.Server.Bind}}
+	}
+
+	c.Server.listeners = []configListener{}
+	binds := map[string]bool{}
+	for _, l := range listeners {
+		l.Bind = strings.TrimSpace(l.Bind)
+
+		if strings.HasPrefix(l.Bind, "unix:") {
+			path := strings.TrimSpace(strings.TrimPrefix(l.Bind, "unix:"))
+			if len(path) < 1 {
+				return fmt.Errorf("invalid server.listener.bind, '%s'; empty socket path", l.Bind)
+			}
+			l.network = "unix"
+			l.address = saultcommon.BaseJoin(c.GetBaseDirectory(), path)
+		} else {
+			var hostName string
+			var port uint64
+			if hostName, port, err = saultcommon.SplitHostPort(l.Bind, DefaultServerPort); err != nil {
+				return fmt.Errorf("invalid server.listener.bind, '%s': %v", l.Bind, err)
+			}
+			l.network = "tcp"
+			l.address = net.JoinHostPort(hostName, fmt.Sprintf("%d", port))
+		}
+
+		if binds[l.network+" "+l.address] {
+			return fmt.Errorf("server.listener.bind, '%s' is duplicated", l.Bind)
+		}
+		binds[l.network+" "+l.address] = true
+
+		l.Policy = strings.ToLower(strings.TrimSpace(l.Policy))
+		switch l.Policy {
+		case "":
+			l.Policy = ListenerPolicyAll
+		case ListenerPolicyAll, ListenerPolicyAdmin, ListenerPolicyProxy:
+		default:
+			return fmt.Errorf("invalid server.listener.policy, '%s'", l.Policy)
+		}
+
+		c.Server.listeners = append(c.Server.listeners, l)
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerTOTP() (err error) {
+	c.Server.TOTP = strings.ToLower(strings.TrimSpace(c.Server.TOTP))
+	switch c.Server.TOTP {
+	case "":
+		c.Server.TOTP = TOTPModeNone
+	case TOTPModeNone, TOTPModeAll, TOTPModeSensitive:
+	default:
+		err = fmt.Errorf("invalid server.totp, '%s'", c.Server.TOTP)
+		return
+	}
+
+	return nil
+}
+
 func (c *Config) validateServerSaultServerName() (err error) {
 	c.Server.SaultServerName = strings.TrimSpace(c.Server.SaultServerName)
 
@@ -257,6 +699,88 @@ func (c *Config) validateServerClientKey() (err error) {
 	return
 }
 
+func (c *Config) validateServerUserCA() (err error) {
+	c.Server.UserCertValidity = strings.TrimSpace(c.Server.UserCertValidity)
+	if len(c.Server.UserCertValidity) < 1 {
+		c.Server.UserCertValidity = DefaultUserCertValidity
+	}
+
+	if c.Server.userCertValidity, err = time.ParseDuration(c.Server.UserCertValidity); err != nil || c.Server.userCertValidity <= 0 {
+		err = fmt.Errorf("invalid server.user_cert_validity, '%s'", c.Server.UserCertValidity)
+		return
+	}
+
+	c.Server.UserCA = strings.TrimSpace(c.Server.UserCA)
+	if len(c.Server.UserCA) < 1 {
+		return nil
+	}
+
+	s, err := ioutil.ReadFile(saultcommon.BaseJoin(c.baseDirectory, c.Server.UserCA))
+	if err != nil {
+		return fmt.Errorf("user_ca, '%s' does not exist: %v", c.Server.UserCA, err)
+	}
+
+	c.Server.userCASigner, err = saultcommon.GetSignerFromPrivateKey(s)
+	if err != nil {
+		err = fmt.Errorf("invalid user_ca, '%s': %v", c.Server.UserCA, err)
+		return
+	}
+
+	if c.Server.UserCAClientKeyFallback {
+		log.Warnf("user_ca_client_key_fallback is set; the client key is offered to the hosts, which do not accept the user certificate")
+	}
+
+	return
+}
+
+func (c *Config) validateServerTrustedUserCAKeys() (err error) {
+	c.Server.trustedUserCAKeys = nil
+	for _, k := range c.Server.TrustedUserCAKeys {
+		var publicKey saultssh.PublicKey
+		if publicKey, err = saultcommon.ParsePublicKey([]byte(k)); err != nil {
+			err = fmt.Errorf("invalid server.trusted_user_ca_keys, '%s': %v", k, err)
+			return
+		}
+		c.Server.trustedUserCAKeys = append(c.Server.trustedUserCAKeys, publicKey)
+	}
+
+	c.Server.revokedUserCerts = map[string]bool{}
+	for _, r := range c.Server.RevokedUserCerts {
+		r = strings.TrimSpace(r)
+		if strings.HasPrefix(r, "SHA256:") {
+			c.Server.revokedUserCerts[strings.TrimPrefix(r, "SHA256:")] = true
+			continue
+		}
+
+		var serial uint64
+		if serial, err = strconv.ParseUint(r, 10, 64); err != nil {
+			err = fmt.Errorf("invalid server.revoked_user_certs, '%s'; serial number or 'SHA256:' fingerprint", r)
+			return
+		}
+		c.Server.revokedUserCerts[revokedUserCertSerial(serial)] = true
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerBreakGlassUsers() (err error) {
+	for _, id := range c.Server.BreakGlassUsers {
+		if !saultcommon.CheckUserID(id) {
+			return fmt.Errorf("invalid server.break_glass_users, '%s'", id)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateServerProxyProtocol() (err error) {
+	if c.Server.proxyProtocol, err = parseCIDRs("server.proxy_protocol", c.Server.ProxyProtocol); err != nil {
+		return
+	}
+
+	return nil
+}
+
 func (c *Config) validateRegistry() (err error) {
 	if len(c.Registry.Source) < 1 {
 		return fmt.Errorf("empty registry")
@@ -283,3 +807,178 @@ func (c *Config) validateRegistry() (err error) {
 
 	return
 }
+
+func (c *Config) validateRecording() (err error) {
+	c.Recording.maxAge = 0
+	if len(strings.TrimSpace(c.Recording.MaxAge)) > 0 {
+		if c.Recording.maxAge, err = time.ParseDuration(c.Recording.MaxAge); err != nil {
+			err = fmt.Errorf("invalid recording.max_age, '%s': %v", c.Recording.MaxAge, err)
+			return
+		}
+	}
+
+	if !c.Recording.Enabled {
+		return
+	}
+
+	if len(c.Recording.Directory) < 1 {
+		c.Recording.Directory = DefaultRecordingDirectory
+	}
+
+	c.Recording.directory = saultcommon.BaseJoin(c.baseDirectory, c.Recording.Directory)
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(c.Recording.directory); os.IsNotExist(err) {
+		if err = os.MkdirAll(c.Recording.directory, 0700); err != nil {
+			err = fmt.Errorf("failed to create recording.directory, '%s': %v", c.Recording.Directory, err)
+			return
+		}
+
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	if !fi.IsDir() {
+		err = fmt.Errorf("recording.directory, '%s' is not directory", c.Recording.Directory)
+		return
+	}
+
+	return nil
+}
+
+func (c *Config) validateAudit() (err error) {
+	c.Audit.sink = []AuditSink{}
+
+	for _, s := range c.Audit.Sink {
+		data, ok := s.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid audit.sink, '%v'", s)
+		}
+
+		var sink AuditSink
+		sink, err = LoadAuditSinkFromConfig(
+			data,
+			map[string]interface{}{
+				"BaseDirectory": c.GetBaseDirectory(),
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("invalid audit.sink: %v", err)
+		}
+		if err = sink.Validate(); err != nil {
+			return fmt.Errorf("invalid audit.sink, '%s': %v", sink.GetType(), err)
+		}
+		c.Audit.sink = append(c.Audit.sink, sink)
+	}
+
+	return
+}
+
+func (c *Config) validateBan() (err error) {
+	if c.Ban.MaxFailures < 1 {
+		c.Ban.MaxFailures = DefaultBanMaxFailures
+	}
+
+	durations := []struct {
+		name  string
+		value *string
+		d     *time.Duration
+		def   string
+	}{
+		{"window", &c.Ban.Window, &c.Ban.window, DefaultBanWindow},
+		{"ban_time", &c.Ban.BanTime, &c.Ban.banTime, DefaultBanTime},
+		{"max_ban_time", &c.Ban.MaxBanTime, &c.Ban.maxBanTime, DefaultBanMaxBanTime},
+	}
+	for _, d := range durations {
+		if len(strings.TrimSpace(*d.value)) < 1 {
+			*d.value = d.def
+		}
+		if *d.d, err = time.ParseDuration(*d.value); err != nil || *d.d <= 0 {
+			return fmt.Errorf("invalid ban.%s, '%s'", d.name, *d.value)
+		}
+	}
+
+	if c.Ban.maxBanTime < c.Ban.banTime {
+		return fmt.Errorf("ban.max_ban_time, '%s' must be longer than ban.ban_time, '%s'", c.Ban.MaxBanTime, c.Ban.BanTime)
+	}
+
+	if c.Ban.allow, err = parseCIDRs("ban.allow", c.Ban.Allow); err != nil {
+		return
+	}
+
+	return nil
+}
+
+// parseCIDRs parses the list of CIDRs; the single IP address is also allowed.
+func parseCIDRs(name string, l []string) (ns []*net.IPNet, err error) {
+	ns = []*net.IPNet{}
+	for _, a := range l {
+		a = strings.TrimSpace(a)
+		if !strings.Contains(a, "/") {
+			if ip := net.ParseIP(a); ip != nil && ip.To4() != nil {
+				a += "/32"
+			} else {
+				a += "/128"
+			}
+		}
+
+		var n *net.IPNet
+		if _, n, err = net.ParseCIDR(a); err != nil {
+			err = fmt.Errorf("invalid %s, '%s': %v", name, a, err)
+			return
+		}
+		ns = append(ns, n)
+	}
+
+	return
+}
+
+func (c *Config) validateSession() (err error) {
+	durations := []struct {
+		name  string
+		value *string
+		d     *time.Duration
+	}{
+		{"idle_timeout", &c.Session.IdleTimeout, &c.Session.idleTimeout},
+		{"max_duration", &c.Session.MaxDuration, &c.Session.maxDuration},
+		{"warning", &c.Session.Warning, &c.Session.warning},
+	}
+	for _, d := range durations {
+		if len(strings.TrimSpace(*d.value)) < 1 {
+			*d.d = 0
+			continue
+		}
+		if *d.d, err = time.ParseDuration(strings.TrimSpace(*d.value)); err != nil || *d.d < 0 {
+			return fmt.Errorf("invalid session.%s, '%s'", d.name, *d.value)
+		}
+	}
+
+	return nil
+}
+
+func (c *Config) validateInnerPool() (err error) {
+	if c.InnerPool.MaxShared < 1 {
+		c.InnerPool.MaxShared = DefaultInnerPoolMaxShared
+	}
+
+	durations := []struct {
+		name  string
+		value *string
+		d     *time.Duration
+		def   string
+	}{
+		{"idle_timeout", &c.InnerPool.IdleTimeout, &c.InnerPool.idleTimeout, DefaultInnerPoolIdleTimeout},
+		{"keepalive_interval", &c.InnerPool.KeepaliveInterval, &c.InnerPool.keepaliveInterval, DefaultInnerPoolKeepaliveInterval},
+	}
+	for _, d := range durations {
+		if len(strings.TrimSpace(*d.value)) < 1 {
+			*d.value = d.def
+		}
+		if *d.d, err = time.ParseDuration(*d.value); err != nil || *d.d <= 0 {
+			return fmt.Errorf("invalid inner_pool.%s, '%s'", d.name, *d.value)
+		}
+	}
+
+	return nil
+}
@@ -3,9 +3,11 @@ package sault
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/registry"
@@ -521,3 +523,392 @@ type = "toml"
 		assert.Nil(t, err)
 	}
 }
+
+func TestConfigRecording(t *testing.T) {
+	env, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(env)
+
+	{
+		// default
+		config := NewConfig()
+		config.SetBaseDirectory(env)
+
+		assert.Nil(t, config.validateRecording())
+		assert.True(t, config.Recording.Enabled)
+		assert.Equal(t, saultcommon.BaseJoin(env, DefaultRecordingDirectory), config.Recording.GetDirectory())
+		assert.Equal(t, time.Duration(0), config.Recording.GetMaxAge())
+
+		fi, err := os.Stat(config.Recording.GetDirectory())
+		assert.Nil(t, err)
+		assert.True(t, fi.IsDir())
+	}
+
+	{
+		// with max_age
+		configBody := `
+[recording]
+directory = "./casts"
+max_age = "720h"
+	`
+		ioutil.WriteFile(saultcommon.BaseJoin(env, "sault.conf"), []byte(configBody), 0600)
+
+		config, _ := LoadConfigs([]string{env})
+		assert.Nil(t, config.validateRecording())
+		assert.Equal(t, saultcommon.BaseJoin(env, "casts"), config.Recording.GetDirectory())
+		assert.Equal(t, time.Hour*720, config.Recording.GetMaxAge())
+	}
+
+	{
+		// with invalid max_age
+		configBody := `
+[recording]
+max_age = "30 days"
+	`
+		ioutil.WriteFile(saultcommon.BaseJoin(env, "sault.conf"), []byte(configBody), 0600)
+
+		config, _ := LoadConfigs([]string{env})
+		assert.NotNil(t, config.validateRecording())
+	}
+}
+
+func TestConfigAudit(t *testing.T) {
+	env, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(env)
+
+	{
+		// default
+		config := NewConfig()
+		config.SetBaseDirectory(env)
+
+		assert.Nil(t, config.validateAudit())
+		assert.Equal(t, 0, len(config.Audit.GetSinks()))
+	}
+
+	{
+		configBody := `
+[[audit.sink]]
+type = "file"
+path = "./audit.log"
+
+[[audit.sink]]
+type = "webhook"
+url = "http://127.0.0.1:8080/audit"
+timeout = "1s"
+	`
+		ioutil.WriteFile(saultcommon.BaseJoin(env, "sault.conf"), []byte(configBody), 0600)
+
+		config, err := LoadConfigs([]string{env})
+		assert.Nil(t, err)
+		assert.Nil(t, config.validateAudit())
+
+		sinks := config.Audit.GetSinks()
+		assert.Equal(t, 2, len(sinks))
+		assert.Equal(t, "file", sinks[0].GetType())
+		assert.Equal(t, saultcommon.BaseJoin(env, "audit.log"), sinks[0].(*auditFileSink).Path)
+		assert.Equal(t, "webhook", sinks[1].GetType())
+	}
+
+	{
+		// unknown type
+		configBody := `
+[audit]
+sink = [ { type = "findme" } ]
+	`
+		ioutil.WriteFile(saultcommon.BaseJoin(env, "sault.conf"), []byte(configBody), 0600)
+
+		config, _ := LoadConfigs([]string{env})
+		assert.NotNil(t, config.validateAudit())
+	}
+
+	{
+		// invalid webhook url
+		configBody := `
+[audit]
+sink = [ { type = "webhook", url = "127.0.0.1:8080" } ]
+	`
+		ioutil.WriteFile(saultcommon.BaseJoin(env, "sault.conf"), []byte(configBody), 0600)
+
+		config, _ := LoadConfigs([]string{env})
+		assert.NotNil(t, config.validateAudit())
+	}
+}
+
+func TestConfigValidateMetricsBind(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateServerMetricsBind())
+		assert.Equal(t, "", config.Server.MetricsBind)
+	}
+
+	{
+		config := NewConfig()
+		config.Server.MetricsBind = "127.0.0.1:9100"
+		assert.Nil(t, config.validateServerMetricsBind())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.MetricsBind = "127.0.0.1"
+		assert.NotNil(t, config.validateServerMetricsBind())
+	}
+}
+
+func TestConfigBan(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateBan())
+		assert.True(t, config.Ban.Enabled)
+		assert.Equal(t, DefaultBanMaxFailures, config.Ban.MaxFailures)
+		assert.Equal(t, time.Minute*10, config.Ban.window)
+		assert.Equal(t, time.Hour*24, config.Ban.maxBanTime)
+	}
+
+	{
+		config := NewConfig()
+		config.Ban.Allow = []string{"192.168.0.0/16", "10.0.0.1", "::1"}
+		assert.Nil(t, config.validateBan())
+		assert.Equal(t, 3, len(config.Ban.allow))
+		assert.Equal(t, "10.0.0.1/32", config.Ban.allow[1].String())
+		assert.Equal(t, "::1/128", config.Ban.allow[2].String())
+	}
+
+	{
+		config := NewConfig()
+		config.Ban.Allow = []string{"192.168.0.0/33"}
+		assert.NotNil(t, config.validateBan())
+	}
+
+	{
+		config := NewConfig()
+		config.Ban.BanTime = "10x"
+		assert.NotNil(t, config.validateBan())
+	}
+
+	{
+		config := NewConfig()
+		config.Ban.BanTime = "2h"
+		config.Ban.MaxBanTime = "1h"
+		assert.NotNil(t, config.validateBan())
+	}
+}
+
+func TestConfigSession(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateSession())
+		assert.Equal(t, time.Duration(0), config.Session.GetIdleTimeout())
+		assert.Equal(t, time.Duration(0), config.Session.GetMaxDuration())
+		assert.Equal(t, time.Minute, config.Session.GetWarning())
+	}
+
+	{
+		config := NewConfig()
+		config.Session.IdleTimeout = "30m"
+		config.Session.MaxDuration = "12h"
+		assert.Nil(t, config.validateSession())
+		assert.Equal(t, time.Minute*30, config.Session.GetIdleTimeout())
+		assert.Equal(t, time.Hour*12, config.Session.GetMaxDuration())
+	}
+
+	{
+		config := NewConfig()
+		config.Session.IdleTimeout = "30x"
+		assert.NotNil(t, config.validateSession())
+	}
+}
+
+func TestConfigInnerPool(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateInnerPool())
+		assert.True(t, config.InnerPool.Enabled)
+		assert.Equal(t, DefaultInnerPoolMaxShared, config.InnerPool.MaxShared)
+		assert.Equal(t, time.Minute*5, config.InnerPool.GetIdleTimeout())
+		assert.Equal(t, time.Second*30, config.InnerPool.GetKeepaliveInterval())
+	}
+
+	{
+		config := NewConfig()
+		config.InnerPool.KeepaliveInterval = "0s"
+		assert.NotNil(t, config.validateInnerPool())
+	}
+}
+
+func TestConfigValidateInnerDialTimeout(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateServerInnerDialTimeout())
+		assert.Equal(t, defaultTimeoutProxyClient, config.Server.GetInnerDialTimeout())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.InnerDialTimeout = "5s"
+		assert.Nil(t, config.validateServerInnerDialTimeout())
+		assert.Equal(t, time.Second*5, config.Server.GetInnerDialTimeout())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.InnerDialTimeout = "0"
+		assert.NotNil(t, config.validateServerInnerDialTimeout())
+	}
+}
+
+func TestConfigValidateTOTP(t *testing.T) {
+	{
+		config := NewConfig()
+		config.Server.TOTP = ""
+		assert.Nil(t, config.validateServerTOTP())
+		assert.Equal(t, TOTPModeNone, config.Server.TOTP)
+	}
+
+	{
+		config := NewConfig()
+		config.Server.TOTP = " Sensitive "
+		assert.Nil(t, config.validateServerTOTP())
+		assert.Equal(t, TOTPModeSensitive, config.Server.TOTP)
+	}
+
+	{
+		config := NewConfig()
+		config.Server.TOTP = "admin"
+		assert.NotNil(t, config.validateServerTOTP())
+	}
+}
+
+func TestConfigValidateUserCA(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateServerUserCA())
+		assert.Nil(t, config.Server.GetUserCASigner())
+		assert.Equal(t, time.Minute*5, config.Server.GetUserCertValidity())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.UserCertValidity = "-1m"
+		assert.NotNil(t, config.validateServerUserCA())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.UserCA = "/not-exists"
+		assert.NotNil(t, config.validateServerUserCA())
+	}
+
+	{
+		privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+		b, _ := saultcommon.EncodePrivateKey(privateKey)
+		f, _ := ioutil.TempFile("", "sault-user-ca")
+		f.Write(b)
+		f.Close()
+		defer os.Remove(f.Name())
+
+		config := NewConfig()
+		config.Server.UserCA = f.Name()
+		assert.Nil(t, config.validateServerUserCA())
+		assert.NotNil(t, config.Server.GetUserCASigner())
+	}
+}
+
+func TestConfigValidateTrustedUserCAKeys(t *testing.T) {
+	{
+		config := NewConfig()
+		assert.Nil(t, config.validateServerTrustedUserCAKeys())
+		assert.False(t, config.Server.HasTrustedUserCA())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.TrustedUserCAKeys = []string{"ssh-rsa findkeys"}
+		assert.NotNil(t, config.validateServerTrustedUserCAKeys())
+	}
+
+	{
+		config := NewConfig()
+		config.Server.RevokedUserCerts = []string{"10", "SHA256:2qdRWsCxQ9y4y2vwzuLkdEw3+oD7I3ZcYNzCDJvlwyI"}
+		assert.Nil(t, config.validateServerTrustedUserCAKeys())
+
+		config.Server.RevokedUserCerts = []string{"showmethemoney"}
+		assert.NotNil(t, config.validateServerTrustedUserCAKeys())
+	}
+}
+
+func TestConfigValidateBreakGlassUsers(t *testing.T) {
+	config := NewConfig()
+	assert.Nil(t, config.validateServerBreakGlassUsers())
+	assert.False(t, config.Server.IsBreakGlassUser("spikeekips"))
+
+	config.Server.BreakGlassUsers = []string{"spikeekips"}
+	assert.Nil(t, config.validateServerBreakGlassUsers())
+	assert.True(t, config.Server.IsBreakGlassUser("spikeekips"))
+	assert.False(t, config.Server.IsBreakGlassUser("findkeys"))
+
+	config.Server.BreakGlassUsers = []string{"spike ekips"}
+	assert.NotNil(t, config.validateServerBreakGlassUsers())
+}
+
+func TestConfigValidateProxyProtocol(t *testing.T) {
+	config := NewConfig()
+	assert.Nil(t, config.validateServerProxyProtocol())
+	assert.False(t, config.Server.IsProxyProtocolUpstream(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 10000}))
+
+	config.Server.ProxyProtocol = []string{"10.0.0.0/8", "192.168.0.1"}
+	assert.Nil(t, config.validateServerProxyProtocol())
+	assert.True(t, config.Server.IsProxyProtocolUpstream(&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 10000}))
+	assert.True(t, config.Server.IsProxyProtocolUpstream(&net.TCPAddr{IP: net.ParseIP("192.168.0.1"), Port: 10000}))
+	assert.False(t, config.Server.IsProxyProtocolUpstream(&net.TCPAddr{IP: net.ParseIP("192.168.0.2"), Port: 10000}))
+
+	config.Server.ProxyProtocol = []string{"10.0.0.0/33"}
+	assert.NotNil(t, config.validateServerProxyProtocol())
+}
+
+func TestConfigValidateListener(t *testing.T) {
+	{
+		// without listener, bind is listened
+		config := NewConfig()
+		config.Server.Bind = "127.0.0.1:2223"
+		assert.Nil(t, config.validateServerListener())
+
+		listeners := config.Server.GetListeners()
+		assert.Equal(t, 1, len(listeners))
+		assert.Equal(t, "tcp", listeners[0].GetNetwork())
+		assert.Equal(t, "127.0.0.1:2223", listeners[0].GetAddress())
+		assert.Equal(t, ListenerPolicyAll, listeners[0].Policy)
+	}
+
+	{
+		config := NewConfig()
+		config.SetBaseDirectory("/tmp/sault")
+		config.Server.Listener = []configListener{
+			configListener{Bind: "0.0.0.0:22", Policy: "Proxy"},
+			configListener{Bind: "10.0.0.1", Policy: "admin"},
+			configListener{Bind: "unix:sault.sock"},
+			configListener{Bind: "unix:/run/sault.sock"},
+		}
+		assert.Nil(t, config.validateServerListener())
+
+		listeners := config.Server.GetListeners()
+		assert.Equal(t, 4, len(listeners))
+		assert.Equal(t, "0.0.0.0:22", listeners[0].GetAddress())
+		assert.Equal(t, ListenerPolicyProxy, listeners[0].Policy)
+		assert.Equal(t, "10.0.0.1:2222", listeners[1].GetAddress())
+		assert.Equal(t, ListenerPolicyAdmin, listeners[1].Policy)
+		assert.Equal(t, "unix", listeners[2].GetNetwork())
+		assert.Equal(t, "/tmp/sault/sault.sock", listeners[2].GetAddress())
+		assert.Equal(t, ListenerPolicyAll, listeners[2].Policy)
+		assert.Equal(t, "/run/sault.sock", listeners[3].GetAddress())
+	}
+
+	cases := [][]configListener{
+		{configListener{Bind: "unix:"}},
+		{configListener{Bind: "127.0.0.1:22", Policy: "readonly"}},
+		{configListener{Bind: "127.0.0.1:22"}, configListener{Bind: "127.0.0.1:22", Policy: "admin"}},
+	}
+	for _, c := range cases {
+		config := NewConfig()
+		config.Server.Listener = c
+		assert.NotNil(t, config.validateServerListener(), "%v", c)
+	}
+}
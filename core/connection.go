@@ -3,6 +3,8 @@ package sault
 import (
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/spikeekips/sault/common"
@@ -10,8 +12,23 @@ import (
 	"github.com/spikeekips/sault/saultssh"
 )
 
+const (
+	authFailedReasonInvalidAccountName = "invalid-account-name"
+	authFailedReasonUnknownUser        = "unknown-user"
+	authFailedReasonUnknownHost        = "unknown-host"
+	authFailedReasonUnknownAccount     = "unknown-account"
+	authFailedReasonNotLinked          = "not-linked"
+	authFailedReasonHandshake          = "handshake"
+	authFailedReasonBanned             = "banned"
+	authFailedReasonTOTPNotEnrolled    = "totp-not-enrolled"
+	authFailedReasonTOTP               = "totp"
+	authFailedReasonInvalidCertificate = "invalid-certificate"
+	authFailedReasonListenerPolicy     = "listener-policy"
+)
+
 type authenticationFailedError struct {
-	Err error
+	Err    error
+	Reason string
 }
 
 func (e *authenticationFailedError) Error() string {
@@ -22,22 +39,64 @@ type connection struct {
 	net.Conn
 	server *Server
 
-	log *logrus.Entry
+	// policy is the policy of listener, which accepted this connection; see
+	// ListenerPolicyAll
+	policy string
+
+	id      string
+	log     *logrus.Entry
+	sshConn saultssh.Conn
+	started time.Time
 
 	account      string
+	publicKey    saultssh.PublicKey
 	user         saultregistry.UserRegistry
 	host         saultregistry.HostRegistry
 	insideSault  bool
 	openChannels []func()
+	channelSeq   uint32
+
+	// agentForwarding is set when the agent of client is forwarded to the
+	// inner connection
+	agentForwarding bool
+
+	// secondFactor is the authenticated second factor, like TOTP
+	secondFactor string
+
+	// totpEnrollOnly is set when the TOTP is required, but the user does not
+	// enroll it yet; the user can only enroll the TOTP inside sault.
+	totpEnrollOnly bool
+
+	// breakGlass is set when the break-glass user connects to the host
+	// without link
+	breakGlass bool
+
+	// reason is the reason of session, which is given by the user; see
+	// isReasonRequired()
+	reason string
+
+	channelsLock sync.RWMutex
+	channels     map[string]*channelState
+	bytesIn      int64
+	bytesOut     int64
+
+	// lastActivity is the last time of the input from the client in unix
+	// nano
+	lastActivity int64
 }
 
-func newConnection(server *Server, conn net.Conn) (*connection, error) {
+func newConnection(server *Server, conn net.Conn, policy string) (*connection, error) {
+	id := saultcommon.MakeRandomString()
 	pconn := &connection{
-		Conn:   conn,
-		server: server,
+		Conn:    conn,
+		server:  server,
+		policy:  policy,
+		id:      id,
+		started: time.Now().UTC(),
 		log: log.WithFields(logrus.Fields{
-			"id":         fmt.Sprintf("%v", &conn),
+			"id":         id,
 			"remoteAddr": conn.RemoteAddr(),
+			"localAddr":  conn.LocalAddr(),
 		}),
 	}
 
@@ -57,17 +116,34 @@ func newConnection(server *Server, conn net.Conn) (*connection, error) {
 func (c *connection) getServerConfig() *saultssh.ServerConfig {
 	serverConfig := &saultssh.ServerConfig{
 		PublicKeyCallback: c.publicKeyCallback,
+		BannerCallback:    c.bannerCallback,
 	}
 
 	serverConfig.AddHostKey(c.server.hostKeySigner)
 	return serverConfig
 }
 
+// setProxiedHost sets the host and account, which are selected after
+// authentication, like by the host picker; the connection is not inside
+// sault any more.
+func (c *connection) setProxiedHost(host saultregistry.HostRegistry, account string) {
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+
+	c.host = host
+	c.account = account
+	c.insideSault = false
+}
+
 func (c *connection) addOpenChannel(f func()) {
 	c.openChannels = append(c.openChannels, f)
 }
 
 func (c *connection) close() {
+	if c.server != nil && c.server.sessions != nil {
+		c.server.sessions.remove(c)
+	}
+
 	for _, closeFunc := range c.openChannels {
 		closeFunc()
 	}
@@ -75,21 +151,136 @@ func (c *connection) close() {
 	c.log.Debugf("cilent connection closed")
 }
 
+// audit emits the audit event with the connection information
+func (c *connection) audit(event AuditEvent) {
+	if c.server == nil {
+		return
+	}
+
+	event.SessionID = c.id
+	if c.Conn != nil {
+		event.RemoteAddr = c.RemoteAddr().String()
+	}
+	if len(event.User) < 1 {
+		event.User = c.user.ID
+	}
+	if len(event.Host) < 1 && !c.insideSault {
+		event.Host = c.host.ID
+	}
+	if len(event.Account) < 1 {
+		event.Account = c.account
+	}
+	if reason := c.getReason(); len(reason) > 0 || c.breakGlass {
+		if event.Data == nil {
+			event.Data = map[string]interface{}{}
+		}
+		if len(reason) > 0 {
+			event.Data["reason"] = reason
+		}
+		if c.breakGlass {
+			event.Data["break_glass"] = true
+		}
+	}
+
+	c.server.auditor.emit(event)
+}
+
+// banFailed counts the authentication failure and bans it if the failures
+// exceed the limit.
+func (c *connection) banFailed(kind, key string) {
+	banned := c.server.bans.failed(kind, key)
+	if banned <= 0 {
+		return
+	}
+
+	serverMetrics.bans.inc(kind)
+
+	c.log.Errorf("too many authentication failures, %s, '%s' banned for %s", kind, key, banned)
+
+	event := AuditEvent{
+		Type: AuditEventAuthBan,
+		Data: map[string]interface{}{
+			"kind":     kind,
+			"key":      key,
+			"duration": banned.String(),
+		},
+	}
+	if kind == BanKindFingerprint {
+		event.Fingerprint = key
+	}
+	c.audit(event)
+}
+
 func (c *connection) publicKeyCallback(
 	conn saultssh.ConnMetadata,
 	publicKey saultssh.PublicKey,
 ) (perm *saultssh.Permissions, err error) {
+	var user saultregistry.UserRegistry
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		// the further authentication is required
+		if _, ok := err.(*saultssh.PartialSuccessError); ok {
+			return
+		}
+
+		reason := authFailedReasonUnknownUser
+		if e, ok := err.(*authenticationFailedError); ok {
+			reason = e.Reason
+		}
+		serverMetrics.authTotal.inc("failure", reason)
+
+		fingerprint := saultcommon.FingerprintSHA256PublicKey(publicKey)
+		c.audit(AuditEvent{
+			Type:        AuditEventAuthFailure,
+			User:        user.ID,
+			Fingerprint: fingerprint,
+			Error:       err.Error(),
+			Data:        map[string]interface{}{"login": conn.User()},
+		})
+
+		if reason != authFailedReasonBanned {
+			c.banFailed(BanKindFingerprint, fingerprint)
+		}
+	}()
+
+	if fingerprint := saultcommon.FingerprintSHA256PublicKey(publicKey); c.server.bans.isBanned(BanKindFingerprint, fingerprint) {
+		serverMetrics.bannedConnections.inc(BanKindFingerprint)
+		err = &authenticationFailedError{
+			Err:    fmt.Errorf("public key, '%s' is banned", fingerprint),
+			Reason: authFailedReasonBanned,
+		}
+		c.log.Error(err)
+		return
+	}
+
 	account, hostID, err := saultcommon.ParseSaultAccountName(conn.User())
 	if err != nil {
-		err = &authenticationFailedError{Err: err}
+		err = &authenticationFailedError{Err: err, Reason: authFailedReasonInvalidAccountName}
 		c.log.Error(err)
 		return
 	}
 
-	var user saultregistry.UserRegistry
-	user, err = c.server.registry.GetUser("", publicKey, saultregistry.UserFilterIsActive)
-	if err != nil {
-		err = &authenticationFailedError{Err: err}
+	if cert, ok := publicKey.(*saultssh.Certificate); ok && c.server.hasTrustedUserCA() {
+		user, err = c.getUserFromCertificate(cert)
+		if err != nil {
+			err = &authenticationFailedError{Err: err, Reason: authFailedReasonInvalidCertificate}
+			c.log.Error(err)
+			return
+		}
+		c.log.Debugf("user certificate accepted, serial=%d key_id=%s; %s", cert.Serial, cert.KeyId, user)
+	} else {
+		user, err = c.server.registry.GetUser("", publicKey, saultregistry.UserFilterIsActive)
+		if err != nil {
+			err = &authenticationFailedError{Err: err, Reason: authFailedReasonUnknownUser}
+			c.log.Error(err)
+			return
+		}
+	}
+
+	if err = c.checkListenerPolicy(user, hostID == c.server.saultServerName); err != nil {
 		c.log.Error(err)
 		return
 	}
@@ -101,17 +292,20 @@ func (c *connection) publicKeyCallback(
 	var host saultregistry.HostRegistry
 	host, err = c.server.registry.GetHost(hostID, saultregistry.HostFilterIsActive)
 	if err != nil {
-		err = &authenticationFailedError{Err: err}
+		err = &authenticationFailedError{Err: err, Reason: authFailedReasonUnknownHost}
 		c.log.Error(err)
 		return
 	}
 	if !host.HasAccount(account) {
-		err = &authenticationFailedError{Err: fmt.Errorf("unknown account, '%s'", account)}
+		err = &authenticationFailedError{
+			Err:    fmt.Errorf("unknown account, '%s'", account),
+			Reason: authFailedReasonUnknownAccount,
+		}
 		c.log.Error(err)
 		return
 	}
 
-	if !c.server.registry.IsLinked(user.ID, host.ID, account) {
+	if !c.server.registry.IsLinked(user.ID, host.ID, account) && !c.isBreakGlassUser(user) {
 		err = &authenticationFailedError{
 			Err: fmt.Errorf(
 				"user, '%s' host, '%s' and it's account, '%s' is not linked",
@@ -119,18 +313,26 @@ func (c *connection) publicKeyCallback(
 				host.ID,
 				account,
 			),
+			Reason: authFailedReasonNotLinked,
 		}
 		c.log.Error(err)
 		return
 	}
 
+	if !c.server.registry.IsLinked(user.ID, host.ID, account) {
+		// the break-glass user connects without link
+		c.breakGlass = true
+		c.log.Warnf("user, '%s' is not linked to '%s+%s', but break-glass is allowed", user.ID, account, host.ID)
+	}
+
 	c.account = account
+	c.publicKey = publicKey
 	c.user = user
 	c.host = host
 
 	c.log.Debugf("authenticated; %s, %s", user, host)
 
-	return nil, nil
+	return c.checkTOTP(user)
 }
 
 func (c *connection) publicKeyCallbackInsideSault(
@@ -143,7 +345,8 @@ func (c *connection) publicKeyCallbackInsideSault(
 
 	if len(account) > 0 {
 		err = &authenticationFailedError{
-			Err: &saultcommon.InvalidAccountNameError{Name: account},
+			Err:    &saultcommon.InvalidAccountNameError{Name: account},
+			Reason: authFailedReasonInvalidAccountName,
 		}
 		c.log.Errorf("in 'inSaultServer', account name is prohibited")
 		return
@@ -159,23 +362,51 @@ func (c *connection) publicKeyCallbackInsideSault(
 		}
 	*/
 
+	c.publicKey = publicKey
 	c.user = user
 
 	c.log.Debugf("authenticated; %s, inside sault", user)
 
-	return nil, nil
+	return c.checkTOTP(user)
 }
 
 func (c *connection) openConnection() error {
 	conn, channels, requests, err := saultssh.NewServerConn(c, c.getServerConfig())
 	if err != nil {
+		serverMetrics.authTotal.inc("failure", authFailedReasonHandshake)
+		c.audit(AuditEvent{Type: AuditEventAuthFailure, Error: err.Error()})
 		c.log.Error(err)
+
+		c.banFailed(BanKindAddress, getAddressFromRemoteAddr(c.RemoteAddr()))
 		return err
 	}
 
 	defer conn.Close()
 
-	go saultssh.DiscardRequests(requests)
+	c.sshConn = conn
+	c.server.sessions.add(c)
+
+	serverMetrics.authTotal.inc("success", "")
+
+	c.server.bans.succeeded(BanKindAddress, getAddressFromRemoteAddr(c.RemoteAddr()))
+	c.server.bans.succeeded(BanKindFingerprint, saultcommon.FingerprintSHA256PublicKey(c.publicKey))
+
+	successEvent := AuditEvent{
+		Type:        AuditEventAuthSuccess,
+		Fingerprint: saultcommon.FingerprintSHA256PublicKey(c.publicKey),
+		Data: map[string]interface{}{
+			"login":          conn.User(),
+			"client_version": string(conn.ClientVersion()),
+		},
+	}
+	if len(c.secondFactor) > 0 {
+		successEvent.Data["second_factor"] = c.secondFactor
+	}
+	if c.totpEnrollOnly {
+		successEvent.Data["totp_enroll_only"] = true
+	}
+	c.audit(successEvent)
+
 	/*
 		go func(in <-chan *saultssh.Request) {
 			for request := range in {
@@ -194,9 +425,10 @@ func (c *connection) openConnection() error {
 	{
 		var err error
 		if c.insideSault {
+			go saultssh.DiscardRequests(requests)
 			err = c.openInsideSaultConnection(channels)
 		} else {
-			err = c.openProxyConnection(channels)
+			err = c.openProxyConnection(channels, requests)
 		}
 		if err != nil {
 			c.log.Error(err)
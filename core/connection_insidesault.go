@@ -1,12 +1,15 @@
 package sault
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/spikeekips/sault/common"
@@ -64,46 +67,105 @@ type channelOpenDirectMsg struct {
 func (c *connection) openInsideSaultDirectTCPIPChannel(channel saultssh.NewChannel) (err error) {
 	var msg channelOpenDirectMsg
 	if err = saultssh.Unmarshal(channel.ExtraData(), &msg); err != nil {
+		channel.Reject(saultssh.ConnectionFailed, "invalid direct-tcpip payload")
 		return
 	}
 
-	var newChannel saultssh.Channel
-	newChannel, _, err = channel.Accept()
+	remoteAddress := fmt.Sprintf("%s:%d", msg.Raddr, msg.Rport)
+
+	event := AuditEvent{
+		Type: AuditEventChannelOpen,
+		Data: map[string]interface{}{
+			"channel_type": channel.ChannelType(),
+			"address":      remoteAddress,
+		},
+	}
+
+	target, err := c.authorizeDirectTCPIP(msg)
+	event.Host = target.Host
+	if len(target.Service) > 0 {
+		event.Data["service"] = target.Service
+	}
 	if err != nil {
-		c.log.Error(err)
+		event.Error = err.Error()
+		c.audit(event)
+
+		channel.Reject(saultssh.Prohibited, err.Error())
 		return
 	}
-	defer newChannel.Close()
-	newChannel.SetProxy(false)
-
-	remoteAddress := fmt.Sprintf("%s:%d", msg.Raddr, msg.Rport)
 
 	var remoetListener net.Conn
-	remoetListener, err = net.Dial("tcp", remoteAddress)
+	remoetListener, err = c.dialDirectTCPIP(target.Addresses)
 	if err != nil {
-		c.log.Error(err)
-		return err
+		event.Error = err.Error()
+		c.audit(event)
+
+		channel.Reject(saultssh.ConnectionFailed, err.Error())
+		return
 	}
 	defer remoetListener.Close()
 
+	event.Data["remote_address"] = remoetListener.RemoteAddr().String()
+	c.audit(event)
+
+	var newChannel saultssh.Channel
+	newChannel, _, err = channel.Accept()
+	if err != nil {
+		c.log.Error(err)
+		return
+	}
+	defer newChannel.Close()
+	newChannel.SetProxy(false)
+
 	c.addOpenChannel(func() {
 		remoetListener.Close()
 		newChannel.Close()
 	})
 	c.log.Debugf("open host listener: %s", remoteAddress)
 
+	channelID := fmt.Sprintf("%s-%d", c.id, atomic.AddUint32(&c.channelSeq, 1))
+	state := c.addChannel(channelID, channel.ChannelType(), nil, nil)
+	defer func() {
+		c.removeChannel(channelID)
+
+		info := state.info()
+		c.log.Debugf(
+			"tunnel to '%s' closed; bytes in=%d out=%d",
+			remoteAddress,
+			info.BytesIn,
+			info.BytesOut,
+		)
+
+		closeEvent := AuditEvent{
+			Type:      AuditEventChannelClose,
+			ChannelID: channelID,
+			Host:      target.Host,
+			Data: map[string]interface{}{
+				"channel_type": channel.ChannelType(),
+				"address":      remoteAddress,
+				"bytes_in":     info.BytesIn,
+				"bytes_out":    info.BytesOut,
+				"duration":     time.Since(info.TimeStarted).Seconds(),
+			},
+		}
+		if len(target.Service) > 0 {
+			closeEvent.Data["service"] = target.Service
+		}
+		c.audit(closeEvent)
+	}()
+
 	wg := &sync.WaitGroup{}
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		if _, err := io.Copy(newChannel, remoetListener); err != nil {
+		if _, err := io.Copy(c.countingWriter(newChannel, state, false), remoetListener); err != nil {
 			c.log.Error(err)
 		}
 	}()
 
 	go func() {
 		defer wg.Done()
-		if _, err := io.Copy(remoetListener, newChannel); err != nil {
+		if _, err := io.Copy(c.countingWriter(remoetListener, state, true), newChannel); err != nil {
 			c.log.Error(err)
 		}
 	}()
@@ -121,6 +183,19 @@ func (c *connection) openInsideSaultSessionChannel(channel saultssh.NewChannel)
 	defer newChannel.Close()
 	newChannel.SetProxy(false)
 
+	channelID := fmt.Sprintf("%s-%d", c.id, atomic.AddUint32(&c.channelSeq, 1))
+	c.addChannel(channelID, channel.ChannelType(), newChannel.Stderr(), nil)
+	defer c.removeChannel(channelID)
+
+	c.audit(AuditEvent{
+		Type:      AuditEventChannelOpen,
+		ChannelID: channelID,
+		Data:      map[string]interface{}{"channel_type": channel.ChannelType()},
+	})
+
+	var pty *ptyRequestMsg
+	var replays []*saultssh.Request
+
 L:
 	for request := range requests {
 		rlog := c.log.WithFields(logrus.Fields{
@@ -139,16 +214,61 @@ L:
 			}
 
 			break L
-		default:
-			rlog.Debugf("request.Type: %v, but not allowed", t)
+		case "pty-req", "window-change":
+			// the pty is allowed for the interactive commands, like
+			// 'session watch'
+			if request.WantReply {
+				request.Reply(true, nil)
+			}
 
-			rendered, _ := saultcommon.SimpleTemplating(
-				`{{ "* sault" | blue }} {{ "error" | red }} This kind of access is not allowed. hejdå vän~`,
-				nil,
-			)
-			newChannel.Write([]byte(rendered + "\r\n"))
+			if t == "pty-req" {
+				var msg ptyRequestMsg
+				if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+					pty = &msg
+					replays = append(replays, &saultssh.Request{Type: t, Payload: request.Payload})
+				}
+			} else if pty != nil {
+				var msg windowChangeRequestMsg
+				if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+					pty.Columns, pty.Rows = msg.Columns, msg.Rows
+					pty.Width, pty.Height = msg.Width, msg.Height
+				}
+			}
+		case "shell":
+			if c.totpEnrollOnly {
+				c.notAllowed(newChannel, rlog, t)
+				break L
+			}
+
+			if pty != nil && c.isAdminAllowed() && AdminShell != nil {
+				request.Reply(true, nil)
+
+				return c.runAdminShell(newChannel, requests, *pty)
+			}
+
+			if pty == nil || c.user.IsAdmin || len(c.host.ID) > 0 {
+				c.notAllowed(newChannel, rlog, t)
+				break L
+			}
 
-			sendExitStatusThruChannel(newChannel, exitStatusNotAllowed)
+			// the non-admin user can select the linked host
+			request.Reply(true, nil)
+			c.removeChannel(channelID)
+
+			replays = append(replays, &saultssh.Request{Type: t, Payload: request.Payload})
+			return c.pickHost(newChannel, requests, *pty, replays)
+		case "env":
+			// the reason is kept for the sensitive host, which is selected
+			// by the host picker
+			reason, ok := parseReasonEnv(request)
+			if ok {
+				c.setReason(reason)
+			}
+			if request.WantReply {
+				request.Reply(ok, nil)
+			}
+		default:
+			c.notAllowed(newChannel, rlog, t)
 
 			break L
 		}
@@ -157,6 +277,18 @@ L:
 	return nil
 }
 
+func (c *connection) notAllowed(channel saultssh.Channel, rlog *logrus.Entry, requestType string) {
+	rlog.Debugf("request.Type: %v, but not allowed", requestType)
+
+	rendered, _ := saultcommon.SimpleTemplating(
+		`{{ "* sault" | blue }} {{ "error" | red }} This kind of access is not allowed. hejdå vän~`,
+		nil,
+	)
+	channel.Write([]byte(rendered + "\r\n"))
+
+	sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+}
+
 func parseSaultCommandMsg(payload []byte) (saultcommon.CommandMsg, error) {
 	{
 		var msg saultcommon.CommandMsg
@@ -167,9 +299,82 @@ func parseSaultCommandMsg(payload []byte) (saultcommon.CommandMsg, error) {
 	}
 
 	args := strings.Fields(string(payload))
-	msg, err := saultcommon.NewCommandMsg(args[0], args[1:])
+	if len(args) < 1 {
+		return saultcommon.CommandMsg{}, fmt.Errorf("empty command")
+	}
+
+	// the command name can have multiple words, like 'session watch'
+	nameLength := 1
+	for i := len(args); i > 1; i-- {
+		if _, ok := Commands[strings.Join(args[:i], " ")]; ok {
+			nameLength = i
+			break
+		}
+	}
+
+	msg, err := saultcommon.NewCommandMsg(strings.Join(args[:nameLength], " "), args[nameLength:])
+	if err != nil {
+		return saultcommon.CommandMsg{}, err
+	}
+
+	return *msg, nil
+}
+
+func (c *connection) auditCommand(msg saultcommon.CommandMsg, err error) {
+	event := AuditEvent{
+		Type: AuditEventCommand,
+		Data: map[string]interface{}{
+			"command":      msg.Name,
+			"sault_client": msg.IsSaultClient,
+		},
+	}
+	if json.Valid(msg.Data) {
+		event.Data["args"] = json.RawMessage(msg.Data)
+	} else {
+		event.Data["args"] = string(msg.Data)
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	c.audit(event)
+}
+
+// responseCommand runs the command in sault server
+func (c *connection) responseCommand(command Command, channel saultssh.Channel, msg saultcommon.CommandMsg) (err error) {
+	started := time.Now()
+	err = command.Response(c.user, channel, msg, c.server.registry, c.server.config, c.server)
+	serverMetrics.commandDuration.observeSince(started, msg.Name)
+	if err != nil {
+		serverMetrics.commandsTotal.inc(msg.Name, "error")
+	} else {
+		serverMetrics.commandsTotal.inc(msg.Name, "success")
+	}
+	c.auditCommand(msg, err)
+
+	return
+}
+
+// isCommandAllowed checks whether the user can run the command; the users,
+// who are not admin, can run only some of the commands.
+func (c *connection) isCommandAllowed(msg saultcommon.CommandMsg) bool {
+	if c.totpEnrollOnly {
+		// until the TOTP is enrolled, the user can enroll it only
+		return msg.Name == "user totp enroll" && c.canEnrollOwnTOTP(msg)
+	}
+
+	switch msg.Name {
+	case "whoami", "publickey":
+		return true
+	case "access request", "access list":
+		// the users, who are not admin, can request the temporary link
+		return true
+	case "user totp enroll":
+		// the users, who are not admin, can enroll their own TOTP once
+		return c.isAdminAllowed() || c.canEnrollOwnTOTP(msg)
+	}
 
-	return *msg, err
+	return c.isAdminAllowed()
 }
 
 func (c *connection) handleCommandMsg(channel saultssh.Channel, request *saultssh.Request, rlog *logrus.Entry) (err error) {
@@ -188,6 +393,7 @@ func (c *connection) handleCommandMsg(channel saultssh.Channel, request *saultss
 		var ok bool
 		if command, ok = Commands[msg.Name]; !ok {
 			err = fmt.Errorf("unknown command name, '%s'", msg.Name)
+			c.auditCommand(msg, err)
 			if !msg.IsSaultClient {
 				t, _ := saultcommon.SimpleTemplating("{{ \"error\" | red }} {{ . }}\r\n", err)
 				channel.Write([]byte(t))
@@ -203,35 +409,35 @@ func (c *connection) handleCommandMsg(channel saultssh.Channel, request *saultss
 			return
 		}
 
-		switch msg.Name {
-		case "whoami":
-			//
-		case "publickey":
-			//
-		default:
-			if !c.user.IsAdmin {
-				if !msg.IsSaultClient {
-					t, _ := saultcommon.SimpleTemplating("{{ \"error\" | red }} Prohibited\r\n", nil)
-					fmt.Println(t)
-					channel.Write([]byte(t))
-					err = errors.New("")
-				} else {
-					response, _ := saultcommon.NewResponseMsg(
-						nil,
-						saultcommon.CommandErrorCommon,
-						fmt.Errorf("Prohibited"),
-					).ToJSON()
-					channel.Write(response)
-
-					sendExitStatusThruChannel(channel, 0)
-					err = nil
-				}
-				return
+		if !c.isCommandAllowed(msg) {
+			prohibited := errors.New("Prohibited")
+			if c.totpEnrollOnly {
+				prohibited = errTOTPEnrollOnly
 			}
+
+			serverMetrics.commandsTotal.inc(msg.Name, "prohibited")
+			c.auditCommand(msg, errors.New("prohibited"))
+			if !msg.IsSaultClient {
+				t, _ := saultcommon.SimpleTemplating("{{ \"error\" | red }} {{ . }}\r\n", prohibited)
+				fmt.Println(t)
+				channel.Write([]byte(t))
+				err = errors.New("")
+			} else {
+				response, _ := saultcommon.NewResponseMsg(
+					nil,
+					saultcommon.CommandErrorCommon,
+					prohibited,
+				).ToJSON()
+				channel.Write(response)
+
+				sendExitStatusThruChannel(channel, 0)
+				err = nil
+			}
+			return
 		}
 	}
 
-	err = command.Response(c.user, channel, msg, c.server.registry, c.server.config)
+	err = c.responseCommand(command, channel, msg)
 	if err != nil {
 		if !msg.IsSaultClient {
 			t, _ := saultcommon.SimpleTemplating("{{ \"error\" | red }} {{ . }}\r\n", err)
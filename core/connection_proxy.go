@@ -1,29 +1,215 @@
 package sault
 
 import (
+	"fmt"
 	"io"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/spikeekips/sault/common"
 	"github.com/spikeekips/sault/saultssh"
+	"github.com/spikeekips/sault/saultssh/agent"
 )
 
+// RFC 4254 Section 6.2.
+type ptyRequestMsg struct {
+	Term     string
+	Columns  uint32
+	Rows     uint32
+	Width    uint32
+	Height   uint32
+	Modelist string
+}
+
+// RFC 4254 Section 6.7.
+type windowChangeRequestMsg struct {
+	Columns uint32
+	Rows    uint32
+	Width   uint32
+	Height  uint32
+}
+
+// RFC 4254 Section 6.5.
+type execRequestMsg struct {
+	Command string
+}
+
+// RFC 4254 Section 6.5.
+type subsystemRequestMsg struct {
+	Subsystem string
+}
+
+// RFC 4254 Section 6.4.
+type envRequestMsg struct {
+	Name  string
+	Value string
+}
+
+// auditRequestData extracts the data from the request payload for audit
+func auditRequestData(request *saultssh.Request, ok bool) map[string]interface{} {
+	data := map[string]interface{}{
+		"request_type": request.Type,
+		"ok":           ok,
+	}
+
+	switch request.Type {
+	case "exec":
+		var msg execRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+			data["command"] = msg.Command
+		}
+	case "subsystem":
+		var msg subsystemRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+			data["subsystem"] = msg.Subsystem
+		}
+	case "env":
+		var msg envRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+			data["name"] = msg.Name
+		}
+	case "pty-req":
+		var msg ptyRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+			data["term"] = msg.Term
+		}
+	}
+
+	return data
+}
+
+// outputTee writes the output of host to the client and also to the taps,
+// like the session recorder. The failure of taps does not affect the client.
+type outputTee struct {
+	sync.RWMutex
+	out  io.Writer
+	taps map[string]io.Writer
+}
+
+func newOutputTee(out io.Writer) *outputTee {
+	return &outputTee{out: out, taps: map[string]io.Writer{}}
+}
+
+func (t *outputTee) Write(p []byte) (n int, err error) {
+	n, err = t.out.Write(p)
+
+	t.RLock()
+	defer t.RUnlock()
+
+	for _, w := range t.taps {
+		w.Write(p[:n])
+	}
+
+	return
+}
+
+func (t *outputTee) addTap(name string, w io.Writer) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.taps[name] = w
+}
+
+func (t *outputTee) removeTap(name string) {
+	t.Lock()
+	defer t.Unlock()
+
+	delete(t.taps, name)
+}
+
+// getInnerClient gets the inner connection of the proxied host from the pool;
+// if the agent forwarding is allowed, the inner connection is not shared and
+// the agent requests of host are routed to the client. The remote port
+// forwarding also needs the not shared connection, the forwards of host are
+// closed with the session. With the user CA, every session has it's own inner
+// connection, which is authenticated by the user certificate.
+func (c *connection) getInnerClient() (*pooledInnerClient, error) {
+	key := newInnerClientKey(c.host, c.account)
+
+	agentForwarding := c.isAgentForwardingAllowed()
+	if agentForwarding || c.isRemoteForwardingAllowed() {
+		key.SessionID = c.id
+	}
+	if c.server.getUserCASigner() != nil {
+		key.SessionID = c.id
+		key.User = c.user.ID
+		key.BreakGlass = c.breakGlass
+	}
+
+	innerclient, err := c.server.innerClients.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if agentForwarding {
+		if err := saultsshAgent.ForwardToAgent(innerclient.Client, newForwardedAgent(c)); err != nil {
+			c.log.Errorf("failed to forward agent: %v", err)
+		} else {
+			c.channelsLock.Lock()
+			c.agentForwarding = true
+			c.channelsLock.Unlock()
+		}
+	}
+
+	return innerclient, nil
+}
+
+func (c *connection) isAgentForwarding() bool {
+	c.channelsLock.RLock()
+	defer c.channelsLock.RUnlock()
+
+	return c.agentForwarding
+}
+
 func (c *connection) openProxyConnection(
 	channels <-chan saultssh.NewChannel,
+	requests <-chan *saultssh.Request,
 ) error {
-	innerclient := saultcommon.NewSSHClient(c.account, c.host.GetAddress())
-	innerclient.AddAuthMethod(saultssh.PublicKeys(c.server.clientKeySigner))
-	innerclient.SetTimeout(defaultTimeoutProxyClient)
+	// the first session channel is held until the reason is given
+	var reasonChannel saultssh.Channel
+	var reasonRequests <-chan *saultssh.Request
+	var replays []*saultssh.Request
+	if c.isReasonRequired() {
+		var err error
+		if reasonChannel, reasonRequests, replays, err = c.waitReason(channels); err != nil {
+			go saultssh.DiscardRequests(requests)
+			return err
+		}
+	}
 
-	if err := innerclient.Connect(); err != nil {
+	innerclient, err := c.getInnerClient()
+	if err != nil {
+		go saultssh.DiscardRequests(requests)
 		c.log.Error(err)
+		if reasonChannel != nil {
+			reasonChannel.Stderr().Write([]byte(fmt.Sprintf("sault: failed to connect to %s+%s\n", c.account, c.host.ID)))
+			sendExitStatusThruChannel(reasonChannel, exitStatusNotAllowed)
+			reasonChannel.Close()
+		}
 		return err
 	}
-	defer innerclient.Close()
+	defer c.server.innerClients.release(innerclient)
+
+	go c.proxyGlobalRequests(innerclient.SSHClient, requests)
+
+	if reasonChannel != nil {
+		reasonChannel.SetProxy(true)
+		go func() {
+			if err := c.proxyChannel(innerclient.SSHClient, "session", nil, reasonChannel, reasonRequests, replays); err != nil {
+				c.log.Error(err)
+			}
+		}()
+	}
+
+	stopTimer := make(chan struct{})
+	defer close(stopTimer)
+	go c.runSessionTimer(stopTimer)
 
 	for channel := range channels {
 		go func() {
-			if err := c.openProxyChannel(innerclient, channel); err != nil {
+			if err := c.openProxyChannel(innerclient.SSHClient, channel); err != nil {
 				c.log.Error(err)
 				return
 			}
@@ -33,28 +219,179 @@ func (c *connection) openProxyConnection(
 	return nil
 }
 
+func (c *connection) isRecordingEnabled() bool {
+	if c.server.config == nil || !c.server.config.Recording.Enabled {
+		return false
+	}
+
+	link, err := c.server.registry.GetLink(c.user.ID, c.host.ID)
+	if err != nil {
+		return true
+	}
+
+	return !link.DisableRecording
+}
+
+func (c *connection) newSessionRecorder(channelID string, msg ptyRequestMsg) (*sessionRecorder, error) {
+	return newSessionRecorder(
+		c.server.config.Recording.GetDirectory(),
+		channelID,
+		RecordingHeader{
+			Width:      msg.Columns,
+			Height:     msg.Rows,
+			Title:      fmt.Sprintf("%s+%s", c.account, c.host.ID),
+			Env:        map[string]string{"TERM": msg.Term},
+			SessionID:  c.id,
+			User:       c.user.ID,
+			Host:       c.host.ID,
+			Account:    c.account,
+			RemoteAddr: c.RemoteAddr().String(),
+			Reason:     c.getReason(),
+			BreakGlass: c.breakGlass,
+		},
+	)
+}
+
 func (c *connection) openProxyChannel(innerclient *saultcommon.SSHClient, channel saultssh.NewChannel) error {
 	proxyChannel, proxyRequests, err := channel.Accept()
 	if err != nil {
 		c.log.Error(err)
 		return err
 	}
+
+	return c.proxyChannel(innerclient, channel.ChannelType(), channel.ExtraData(), proxyChannel, proxyRequests, nil)
+}
+
+// replayRequests sends the replays before the requests; the replays are the
+// requests, which were received before the host was connected.
+func replayRequests(replays []*saultssh.Request, requests <-chan *saultssh.Request, done <-chan struct{}) <-chan *saultssh.Request {
+	replayed := make(chan *saultssh.Request)
+	go func() {
+		defer close(replayed)
+
+		send := func(request *saultssh.Request) bool {
+			select {
+			case replayed <- request:
+				return true
+			case <-done:
+				return false
+			}
+		}
+
+		for _, request := range replays {
+			if !send(request) {
+				return
+			}
+		}
+		for request := range requests {
+			if !send(request) {
+				return
+			}
+		}
+	}()
+
+	return replayed
+}
+
+// proxyChannel proxies the accepted client channel to the new channel of
+// host.
+func (c *connection) proxyChannel(
+	innerclient *saultcommon.SSHClient,
+	channelType string,
+	extraData []byte,
+	proxyChannel saultssh.Channel,
+	proxyRequests <-chan *saultssh.Request,
+	replays []*saultssh.Request,
+) error {
+	channelID := fmt.Sprintf("%s-%d", c.id, atomic.AddUint32(&c.channelSeq, 1))
+
+	// the replays are sent to the host with want-reply, so the answer of
+	// host is known, even if it was already replied to the client
+	replayed := map[*saultssh.Request]bool{}
+	if len(replays) > 0 {
+		done := make(chan struct{})
+		defer close(done)
+
+		for _, r := range replays {
+			replayed[r] = true
+		}
+		proxyRequests = replayRequests(replays, proxyRequests, done)
+	}
+
+	output := newOutputTee(proxyChannel)
+	state := c.addChannel(channelID, channelType, proxyChannel.Stderr(), output)
+	copies := &sync.WaitGroup{}
+
+	var transfer fileTransfer
+	defer func() {
+		copies.Wait()
+		c.removeChannel(channelID)
+
+		if transfer != nil {
+			transfer.close()
+		}
+
+		info := state.info()
+		c.audit(AuditEvent{
+			Type:      AuditEventChannelClose,
+			ChannelID: channelID,
+			Data: map[string]interface{}{
+				"channel_type": channelType,
+				"bytes_in":     info.BytesIn,
+				"bytes_out":    info.BytesOut,
+				"duration":     time.Since(info.TimeStarted).Seconds(),
+			},
+		})
+	}()
 	defer proxyChannel.Close()
 	proxyChannel.SetProxy(true)
 
 	innerChannel, innerRequests, err := innerclient.Client.OpenChannel(
-		channel.ChannelType(),
-		channel.ExtraData(),
+		channelType,
+		extraData,
 	)
 	if err != nil {
+		c.audit(AuditEvent{
+			Type:      AuditEventChannelOpen,
+			ChannelID: channelID,
+			Error:     err.Error(),
+			Data:      map[string]interface{}{"channel_type": channelType},
+		})
 		log.Error(err)
 		return err
 	}
 	defer innerChannel.Close()
 	innerChannel.SetProxy(true)
 
-	go io.Copy(proxyChannel, innerChannel)
-	go io.Copy(innerChannel, proxyChannel)
+	c.audit(AuditEvent{
+		Type:      AuditEventChannelOpen,
+		ChannelID: channelID,
+		Data:      map[string]interface{}{"channel_type": channelType},
+	})
+
+	// the input and output are switched to the filters of file transfer
+	input := newSwitchWriter(innerChannel)
+	hostOutput := newSwitchWriter(output)
+
+	copies.Add(2)
+	go func() {
+		defer copies.Done()
+		io.Copy(c.countingWriter(hostOutput, state, false), innerChannel)
+	}()
+	go func() {
+		defer copies.Done()
+		io.Copy(c.countingWriter(input, state, true), proxyChannel)
+	}()
+
+	var recorder *sessionRecorder
+	defer func() {
+		if recorder == nil {
+			return
+		}
+
+		output.removeTap("recorder")
+		recorder.Close()
+	}()
 
 	var requestOrigin string
 	for {
@@ -83,23 +420,96 @@ func (c *connection) openProxyChannel(innerclient *saultcommon.SSHClient, channe
 
 		rlog.Debug("got request")
 
+		if requestOrigin == "client" {
+			c.touch()
+		}
+
 		if request.Type == "EOF" {
 			toChannel.CloseWrite()
 			continue
 		}
 
-		ok, err := toChannel.SendRequest(request.Type, request.WantReply, request.Payload)
-		if err != nil {
-			rlog.Error(err)
+		var denied error
+		if requestOrigin == "client" && transfer == nil {
+			transfer, denied = c.startFileTransfer(channelID, request, state.isPty(), input, hostOutput)
+		}
+
+		var ok bool
+		if requestOrigin == "client" && request.Type == agentRequestType && !c.isAgentForwarding() {
+			rlog.Debug("agent forwarding is not allowed")
+		} else if denied != nil {
+			rlog.Debug(denied)
+			proxyChannel.Stderr().Write([]byte(fmt.Sprintf("sault: %v\n", denied)))
+		} else {
+			ok, err = toChannel.SendRequest(request.Type, request.WantReply || replayed[request], request.Payload)
+			if err != nil {
+				rlog.Error(err)
+			} else if !ok && replayed[request] {
+				rlog.Debug("host refused the replayed request")
+			}
 		}
 
 		request.Reply(ok, nil)
 
+		if requestOrigin == "client" && request.Type != "window-change" {
+			c.audit(AuditEvent{
+				Type:      AuditEventChannelRequest,
+				ChannelID: channelID,
+				Data:      auditRequestData(request, ok),
+			})
+		}
+
 		switch request.Type {
 		case "exit-status":
-			break
+			var msg exitStatusMsg
+			if err := saultssh.Unmarshal(request.Payload, &msg); err != nil {
+				rlog.Errorf("invalid exit-status payload: %v", err)
+				break
+			}
+
+			c.audit(AuditEvent{
+				Type:      AuditEventChannelExitStatus,
+				ChannelID: channelID,
+				Data:      map[string]interface{}{"status": msg.Status},
+			})
 		case "pty-req":
-			// TODO: print welcome message
+			if ok {
+				state.setPty()
+
+				if banner := c.getPtyBanner(); len(banner) > 0 {
+					proxyChannel.Write([]byte(banner))
+				}
+			}
+
+			if !ok || recorder != nil || !c.isRecordingEnabled() {
+				break
+			}
+
+			var msg ptyRequestMsg
+			if err := saultssh.Unmarshal(request.Payload, &msg); err != nil {
+				rlog.Errorf("invalid pty-req payload: %v", err)
+				break
+			}
+
+			if recorder, err = c.newSessionRecorder(channelID, msg); err != nil {
+				rlog.Errorf("failed to start recording: %v", err)
+				recorder = nil
+				break
+			}
+			output.addTap("recorder", recorder)
+
+			rlog.Debugf("started to record the session to '%s'", recorder.Path)
+		case "window-change":
+			if recorder == nil {
+				break
+			}
+
+			var msg windowChangeRequestMsg
+			if err := saultssh.Unmarshal(request.Payload, &msg); err != nil {
+				rlog.Errorf("invalid window-change payload: %v", err)
+				break
+			}
+			recorder.Resize(msg.Columns, msg.Rows)
 		default:
 			//
 		}
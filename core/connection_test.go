@@ -7,6 +7,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/flags"
 	"github.com/spikeekips/sault/registry"
 	"github.com/spikeekips/sault/saultssh"
 	"github.com/stretchr/testify/assert"
@@ -192,3 +193,40 @@ func TestPublicKeyCallbackInSaultServer(t *testing.T) {
 		assert.Nil(t, err)
 	}
 }
+
+type testCommand struct{}
+
+func (c *testCommand) Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) error {
+	return nil
+}
+
+func (c *testCommand) Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *Config, server *Server) error {
+	return nil
+}
+
+func TestParseSaultCommandMsg(t *testing.T) {
+	Commands["session watch"] = &testCommand{}
+	defer delete(Commands, "session watch")
+
+	{
+		msg, err := parseSaultCommandMsg([]byte("whoami"))
+		assert.Nil(t, err)
+		assert.Equal(t, "whoami", msg.Name)
+		assert.False(t, msg.IsSaultClient)
+	}
+
+	{
+		msg, err := parseSaultCommandMsg([]byte("session watch findme"))
+		assert.Nil(t, err)
+		assert.Equal(t, "session watch", msg.Name)
+
+		var args []string
+		msg.GetData(&args)
+		assert.Equal(t, []string{"findme"}, args)
+	}
+
+	{
+		_, err := parseSaultCommandMsg([]byte("  "))
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,166 @@
+package sault
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+)
+
+// findDirectTCPIPHost finds the active host of the direct-tcpip target; the
+// target is the host id with the port of host, like 'ssh -J sault@sault
+// ubuntu@prometeus' or one of the addresses of host. The addresses to
+// connect are returned with the host.
+func findDirectTCPIPHost(registry *saultregistry.Registry, raddr string, rport uint32) (
+	host saultregistry.HostRegistry,
+	addresses []string,
+	found bool,
+) {
+	target := fmt.Sprintf("%s:%d", raddr, rport)
+	for _, h := range registry.GetHosts(saultregistry.HostFilterIsActive) {
+		if h.ID == raddr && h.Port == uint64(rport) {
+			return h, h.GetAddresses(), true
+		}
+
+		for _, a := range h.GetAddresses() {
+			if strings.EqualFold(a, target) {
+				return h, []string{a}, true
+			}
+		}
+	}
+
+	return
+}
+
+// findDirectTCPIPService finds the service of the direct-tcpip target; the
+// target is the service name with the port of service or the address of
+// service.
+func findDirectTCPIPService(registry *saultregistry.Registry, raddr string, rport uint32) (
+	service saultregistry.ServiceRegistry,
+	found bool,
+) {
+	target := fmt.Sprintf("%s:%d", raddr, rport)
+	for _, s := range registry.GetServices() {
+		if s.Port != uint64(rport) {
+			continue
+		}
+
+		if s.Name == raddr || strings.EqualFold(s.GetAddress(), target) {
+			return s, true
+		}
+	}
+
+	return
+}
+
+// isLinkedToHost checks whether the user is linked to the host with any
+// account
+func isLinkedToHost(registry *saultregistry.Registry, userID, hostID string) bool {
+	link, err := registry.GetLink(userID, hostID)
+	if err != nil || link.IsExpired(time.Now()) {
+		return false
+	}
+
+	if link.All {
+		return true
+	}
+
+	for _, a := range link.Accounts {
+		if !link.IsAccountExpired(a, time.Now()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// directTCPIPTarget is the authorized target of direct-tcpip
+type directTCPIPTarget struct {
+	Host      string
+	Service   string
+	Addresses []string
+}
+
+// authorizeDirectTCPIP checks the direct-tcpip target; only the registered
+// hosts and services, which the user is linked to, are allowed. If
+// 'server.admin_direct_tcpip' is enabled, the admin can connect to any
+// address.
+func (c *connection) authorizeDirectTCPIP(msg channelOpenDirectMsg) (target directTCPIPTarget, err error) {
+	if c.totpEnrollOnly {
+		err = errTOTPEnrollOnly
+		return
+	}
+
+	registry := c.server.registry
+
+	host, addresses, hostFound := findDirectTCPIPHost(registry, msg.Raddr, msg.Rport)
+	if hostFound {
+		target = directTCPIPTarget{Host: host.ID, Addresses: addresses}
+		if isLinkedToHost(registry, c.user.ID, host.ID) {
+			// the session thru direct-tcpip can not be asked the reason
+			if host.Sensitive && len(c.getReason()) < 1 {
+				err = fmt.Errorf(
+					"host, '%s' is sensitive; the reason is required, set the '%s' env",
+					host.ID,
+					reasonEnvName,
+				)
+			}
+			return
+		}
+	}
+
+	service, serviceFound := findDirectTCPIPService(registry, msg.Raddr, msg.Rport)
+	if serviceFound && (!hostFound || registry.IsServiceLinked(c.user.ID, service.Name)) {
+		target = directTCPIPTarget{Service: service.Name, Addresses: []string{service.GetAddress()}}
+	}
+	if serviceFound && registry.IsServiceLinked(c.user.ID, service.Name) {
+		return
+	}
+
+	if c.isAdminAllowed() && c.server.config != nil && c.server.config.Server.AdminDirectTCPIP {
+		if !hostFound && !serviceFound {
+			target = directTCPIPTarget{Addresses: []string{fmt.Sprintf("%s:%d", msg.Raddr, msg.Rport)}}
+		}
+		return
+	}
+
+	switch {
+	case hostFound:
+		err = &saultcommon.HostAndUserNotLinked{UserID: c.user.ID, HostID: host.ID}
+	case serviceFound:
+		err = &saultcommon.ServiceAndUserNotLinked{UserID: c.user.ID, Service: service.Name}
+	default:
+		err = fmt.Errorf("'%s:%d' is not the registered host or service", msg.Raddr, msg.Rport)
+	}
+
+	return
+}
+
+// dialDirectTCPIP connects to the addresses in turn; the recently failed
+// addresses are tried at last.
+func (c *connection) dialDirectTCPIP(addresses []string) (conn net.Conn, err error) {
+	timeout := defaultTimeoutProxyClient
+	if c.server.config != nil && c.server.config.Server.GetInnerDialTimeout() > 0 {
+		timeout = c.server.config.Server.GetInnerDialTimeout()
+	}
+
+	if len(addresses) > 1 {
+		addresses = c.server.hostHealth.order(addresses)
+	}
+
+	var errs []string
+	for _, address := range addresses {
+		if conn, err = net.DialTimeout("tcp", address, timeout); err == nil {
+			return
+		}
+
+		c.log.Errorf("failed to connect to '%s': %v", address, err)
+		errs = append(errs, fmt.Sprintf("%s: %v", address, err))
+	}
+
+	err = fmt.Errorf("failed to connect; %s", strings.Join(errs, ", "))
+	return
+}
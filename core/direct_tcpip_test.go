@@ -0,0 +1,162 @@
+package sault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDirectTCPIPHost(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	prometeus, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+	prometeus.Addresses = []string{"192.168.0.10:22"}
+	registry.UpdateHost(prometeus.ID, prometeus)
+
+	hera, _ := registry.AddHost("hera", "hera.local", uint64(22), []string{"ubuntu"})
+	hera.IsActive = false
+	registry.UpdateHost(hera.ID, hera)
+
+	{
+		// by host id
+		host, addresses, found := findDirectTCPIPHost(registry, "prometeus", 22)
+		assert.True(t, found)
+		assert.Equal(t, "prometeus", host.ID)
+		assert.Equal(t, []string{"prometeus.local:22", "192.168.0.10:22"}, addresses)
+	}
+
+	{
+		// by address
+		host, addresses, found := findDirectTCPIPHost(registry, "192.168.0.10", 22)
+		assert.True(t, found)
+		assert.Equal(t, "prometeus", host.ID)
+		assert.Equal(t, []string{"192.168.0.10:22"}, addresses)
+
+		_, _, found = findDirectTCPIPHost(registry, "Prometeus.Local", 22)
+		assert.True(t, found)
+	}
+
+	{
+		// wrong port
+		_, _, found := findDirectTCPIPHost(registry, "prometeus", 80)
+		assert.False(t, found)
+		_, _, found = findDirectTCPIPHost(registry, "prometeus.local", 80)
+		assert.False(t, found)
+	}
+
+	{
+		// inactive host
+		_, _, found := findDirectTCPIPHost(registry, "hera", 22)
+		assert.False(t, found)
+	}
+}
+
+func TestAuthorizeDirectTCPIP(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	prometeus, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+	zeus, _ := registry.AddHost("zeus", "zeus.local", uint64(22), []string{"ubuntu"})
+	athena, _ := registry.AddHost("athena", "athena.local", uint64(22), []string{"ubuntu"})
+
+	registry.Link(user.ID, prometeus.ID, "ubuntu")
+	registry.Link(user.ID, athena.ID, "ubuntu")
+
+	link, _ := registry.GetLink(user.ID, athena.ID)
+	link.DateExpires = time.Now().Add(-time.Second)
+	registry.UpdateLink(user.ID, athena.ID, link)
+
+	registry.AddService("postgres", "db1", uint64(5432), nil)
+	registry.AddService("grafana", "monitor", uint64(3000), nil)
+	registry.LinkService(user.ID, "postgres")
+
+	config := NewConfig()
+	c := &connection{
+		server: &Server{registry: registry, config: config},
+		user:   user,
+	}
+
+	{
+		target, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "prometeus", Rport: 22})
+		assert.Nil(t, err)
+		assert.Equal(t, directTCPIPTarget{Host: prometeus.ID, Addresses: []string{"prometeus.local:22"}}, target)
+	}
+
+	{
+		// linked services by name and address
+		target, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "postgres", Rport: 5432})
+		assert.Nil(t, err)
+		assert.Equal(t, directTCPIPTarget{Service: "postgres", Addresses: []string{"db1:5432"}}, target)
+
+		target, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "db1", Rport: 5432})
+		assert.Nil(t, err)
+		assert.Equal(t, "postgres", target.Service)
+
+		// wrong port
+		_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "db1", Rport: 5433})
+		assert.NotNil(t, err)
+	}
+
+	{
+		// not linked
+		_, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "zeus.local", Rport: 22})
+		assert.IsType(t, &saultcommon.HostAndUserNotLinked{}, err)
+
+		_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "grafana", Rport: 3000})
+		assert.IsType(t, &saultcommon.ServiceAndUserNotLinked{}, err)
+
+		// expired link
+		_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "athena", Rport: 22})
+		assert.IsType(t, &saultcommon.HostAndUserNotLinked{}, err)
+
+		// unknown address
+		_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "8.8.8.8", Rport: 53})
+		assert.NotNil(t, err)
+	}
+
+	{
+		// sensitive host requires the reason
+		prometeus.Sensitive = true
+		registry.UpdateHost(prometeus.ID, prometeus)
+
+		_, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "prometeus", Rport: 22})
+		assert.NotNil(t, err)
+
+		c.reason = "JIRA-1234"
+		_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "prometeus", Rport: 22})
+		assert.Nil(t, err)
+
+		c.reason = ""
+		prometeus.Sensitive = false
+		registry.UpdateHost(prometeus.ID, prometeus)
+	}
+
+	{
+		// admin without AdminDirectTCPIP
+		c.user.IsAdmin = true
+		_, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "8.8.8.8", Rport: 53})
+		assert.NotNil(t, err)
+
+		config.Server.AdminDirectTCPIP = true
+
+		target, err := c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "8.8.8.8", Rport: 53})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"8.8.8.8:53"}, target.Addresses)
+
+		target, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "zeus", Rport: 22})
+		assert.Nil(t, err)
+		assert.Equal(t, directTCPIPTarget{Host: zeus.ID, Addresses: []string{"zeus.local:22"}}, target)
+
+		target, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "grafana", Rport: 3000})
+		assert.Nil(t, err)
+		assert.Equal(t, directTCPIPTarget{Service: "grafana", Addresses: []string{"monitor:3000"}}, target)
+	}
+}
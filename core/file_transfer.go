@@ -0,0 +1,784 @@
+package sault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// The directions of file transfer
+const (
+	fileTransferUpload   = "upload"
+	fileTransferDownload = "download"
+)
+
+// RFC draft-ietf-secsh-filexfer-02, the packet types and flags of SFTP v3
+const (
+	sftpPacketInit     = 1
+	sftpPacketOpen     = 3
+	sftpPacketClose    = 4
+	sftpPacketRead     = 5
+	sftpPacketWrite    = 6
+	sftpPacketRemove   = 13
+	sftpPacketRename   = 18
+	sftpPacketStatus   = 101
+	sftpPacketHandle   = 102
+	sftpPacketData     = 103
+	sftpPacketExtended = 200
+
+	sftpFlagRead   = 0x00000001
+	sftpFlagWrite  = 0x00000002
+	sftpFlagAppend = 0x00000004
+	sftpFlagCreat  = 0x00000008
+	sftpFlagTrunc  = 0x00000010
+
+	sftpStatusOK               = 0
+	sftpStatusPermissionDenied = 3
+
+	// sftpMaxPacketLength is the maximum length of the packet, which is
+	// parsed; OpenSSH limits it to 256KB.
+	sftpMaxPacketLength = 1 << 20
+)
+
+var errInvalidSFTPPacket = errors.New("invalid sftp packet")
+
+// writerFunc is the adapter to use the function as io.Writer
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// switchWriter writes to the writer, which can be switched while copying,
+// like to the filter of file transfer.
+type switchWriter struct {
+	sync.RWMutex
+	w io.Writer
+}
+
+func newSwitchWriter(w io.Writer) *switchWriter {
+	return &switchWriter{w: w}
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.RLock()
+	w := s.w
+	s.RUnlock()
+
+	return w.Write(p)
+}
+
+func (s *switchWriter) get() io.Writer {
+	s.RLock()
+	defer s.RUnlock()
+
+	return s.w
+}
+
+func (s *switchWriter) set(w io.Writer) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.w = w
+}
+
+// fileTransferPolicy is the file transfer policy of link
+type fileTransferPolicy struct {
+	DisableUpload   bool
+	DisableDownload bool
+}
+
+func (p fileTransferPolicy) isRestricted() bool {
+	return p.DisableUpload || p.DisableDownload
+}
+
+func (p fileTransferPolicy) check(direction string) error {
+	if (direction == fileTransferUpload && p.DisableUpload) || (direction == fileTransferDownload && p.DisableDownload) {
+		return fmt.Errorf("%s is not allowed", direction)
+	}
+
+	return nil
+}
+
+func (c *connection) getFileTransferPolicy() fileTransferPolicy {
+	link, err := c.server.registry.GetLink(c.user.ID, c.host.ID)
+	if err != nil {
+		return fileTransferPolicy{}
+	}
+
+	return fileTransferPolicy{
+		DisableUpload:   link.DisableUpload,
+		DisableDownload: link.DisableDownload,
+	}
+}
+
+// fileTransfer audits the file transfer of channel; close audits the
+// transfers, which are not finished.
+type fileTransfer interface {
+	close()
+}
+
+type fileTransferAuditFunc func(data map[string]interface{}, err error)
+
+// startFileTransfer starts to audit the file transfer, if the request of
+// client starts the sftp subsystem or scp; the traffic is parsed passively
+// and only the not allowed scp command and the sftp open requests are
+// blocked by the link policy.
+//
+// If the file transfer of link is restricted, the other commands, which can
+// transfer the files, like 'cat' and 'tar' are not allowed; only the plain
+// scp and sftp-server commands can be executed, the subsystem other than sftp
+// is refused and the shell needs the pty. The interactive shell on the pty is
+// not restricted.
+func (c *connection) startFileTransfer(
+	channelID string,
+	request *saultssh.Request,
+	pty bool,
+	input,
+	output *switchWriter,
+) (fileTransfer, error) {
+	audit := func(data map[string]interface{}, err error) {
+		event := AuditEvent{
+			Type:      AuditEventFileTransfer,
+			ChannelID: channelID,
+			Host:      c.host.ID,
+			Data:      data,
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		c.audit(event)
+	}
+
+	startSFTP := func() (fileTransfer, error) {
+		f := newSFTPFilter(input.get(), output.get(), c.getFileTransferPolicy(), audit)
+		input.set(writerFunc(f.writeInput))
+		output.set(writerFunc(f.writeOutput))
+
+		return f, nil
+	}
+
+	deny := func(command string) error {
+		err := fmt.Errorf("file transfer is restricted; '%s' is not allowed", request.Type)
+		data := map[string]interface{}{"operation": "start", "request": request.Type}
+		if len(command) > 0 {
+			data["command"] = command
+		}
+		audit(data, err)
+
+		return err
+	}
+
+	switch request.Type {
+	case "subsystem":
+		var msg subsystemRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err != nil || msg.Subsystem != "sftp" {
+			// the other subsystems can transfer the files without sftp
+			if c.getFileTransferPolicy().isRestricted() {
+				return nil, deny(msg.Subsystem)
+			}
+			return nil, nil
+		}
+
+		return startSFTP()
+	case "shell":
+		if !pty && c.getFileTransferPolicy().isRestricted() {
+			return nil, deny("")
+		}
+	case "exec":
+		var msg execRequestMsg
+		if err := saultssh.Unmarshal(request.Payload, &msg); err != nil {
+			return nil, nil
+		}
+
+		restricted := c.getFileTransferPolicy().isRestricted()
+		if restricted && hasShellMetacharacters(msg.Command) {
+			return nil, deny(msg.Command)
+		}
+
+		if isSFTPServerCommand(msg.Command) {
+			return startSFTP()
+		}
+
+		direction, target, ok := parseSCPCommand(msg.Command)
+		if !ok {
+			if restricted {
+				return nil, deny(msg.Command)
+			}
+			return nil, nil
+		}
+
+		if err := c.getFileTransferPolicy().check(direction); err != nil {
+			audit(
+				map[string]interface{}{
+					"protocol":  "scp",
+					"operation": "start",
+					"path":      target,
+					"direction": direction,
+				},
+				err,
+			)
+			return nil, err
+		}
+
+		a := newSCPAuditor(direction, target, audit)
+
+		// scp audits the stream of the source side
+		w := input
+		if direction == fileTransferDownload {
+			w = output
+		}
+		tee := newOutputTee(w.get())
+		tee.addTap("scp", a)
+		w.set(tee)
+
+		return a, nil
+	}
+
+	return nil, nil
+}
+
+// shellMetacharacters can run the other commands with scp, like
+// 'scp -f a; cat b'
+const shellMetacharacters = ";&|<>$`\\(){}\n"
+
+func hasShellMetacharacters(command string) bool {
+	return strings.ContainsAny(command, shellMetacharacters)
+}
+
+// isSFTPServerCommand checks whether the command runs the sftp server, like
+// '/usr/lib/openssh/sftp-server'
+func isSFTPServerCommand(command string) bool {
+	fields := strings.Fields(command)
+	return len(fields) > 0 && path.Base(fields[0]) == "sftp-server"
+}
+
+// parseSCPCommand parses the scp command, like 'scp -t -- /tmp'; '-t' is to
+// upload to host and '-f' is to download from host.
+func parseSCPCommand(command string) (direction, target string, ok bool) {
+	fields := strings.Fields(command)
+	if len(fields) < 2 || path.Base(fields[0]) != "scp" {
+		return
+	}
+
+	i := 1
+	for ; i < len(fields); i++ {
+		f := fields[i]
+		if f == "--" {
+			i++
+			break
+		}
+		if !strings.HasPrefix(f, "-") || len(f) < 2 {
+			break
+		}
+
+		switch {
+		case strings.ContainsRune(f[1:], 't'):
+			direction = fileTransferUpload
+		case strings.ContainsRune(f[1:], 'f'):
+			direction = fileTransferDownload
+		}
+	}
+
+	if len(direction) < 1 {
+		return
+	}
+
+	return direction, strings.Join(fields[i:], " "), true
+}
+
+// sftpBuffer reads the values of sftp packet
+type sftpBuffer []byte
+
+func (b *sftpBuffer) byte() (byte, bool) {
+	if len(*b) < 1 {
+		return 0, false
+	}
+
+	v := (*b)[0]
+	*b = (*b)[1:]
+	return v, true
+}
+
+func (b *sftpBuffer) uint32() (uint32, bool) {
+	if len(*b) < 4 {
+		return 0, false
+	}
+
+	v := binary.BigEndian.Uint32(*b)
+	*b = (*b)[4:]
+	return v, true
+}
+
+func (b *sftpBuffer) uint64() (uint64, bool) {
+	if len(*b) < 8 {
+		return 0, false
+	}
+
+	v := binary.BigEndian.Uint64(*b)
+	*b = (*b)[8:]
+	return v, true
+}
+
+func (b *sftpBuffer) string() (string, bool) {
+	length, ok := b.uint32()
+	if !ok || uint32(len(*b)) < length {
+		return "", false
+	}
+
+	v := string((*b)[:length])
+	*b = (*b)[length:]
+	return v, true
+}
+
+// nextSFTPPacket returns the first packet in b; if the packet is not
+// completed, packet is nil.
+func nextSFTPPacket(b []byte) (packet, rest []byte, err error) {
+	if len(b) < 4 {
+		return nil, b, nil
+	}
+
+	length := binary.BigEndian.Uint32(b)
+	if length < 1 || length > sftpMaxPacketLength {
+		return nil, b, errInvalidSFTPPacket
+	}
+	if uint32(len(b)-4) < length {
+		return nil, b, nil
+	}
+
+	return b[:4+length], b[4+length:], nil
+}
+
+func newSFTPStatusPacket(id, code uint32, message string) []byte {
+	b := make([]byte, 4+1+4+4+4+len(message)+4)
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+	b[4] = sftpPacketStatus
+	binary.BigEndian.PutUint32(b[5:], id)
+	binary.BigEndian.PutUint32(b[9:], code)
+	binary.BigEndian.PutUint32(b[13:], uint32(len(message)))
+	copy(b[17:], message)
+
+	return b
+}
+
+type sftpRequest struct {
+	kind    byte
+	path    string
+	newPath string
+	flags   uint32
+	handle  string
+}
+
+type sftpFile struct {
+	path    string
+	read    int64
+	written int64
+}
+
+// sftpFilter parses the sftp packets of both directions and audits the file
+// operations; the packets are passed thru as they are, except the open
+// requests, which are not allowed by the policy, are answered with the
+// permission denied status.
+type sftpFilter struct {
+	sync.Mutex
+	toHost    io.Writer
+	toClient  io.Writer
+	writeLock sync.Mutex
+	policy    fileTransferPolicy
+	audit     fileTransferAuditFunc
+
+	input             []byte
+	output            []byte
+	passthroughInput  bool
+	passthroughOutput bool
+
+	pending map[uint32]sftpRequest
+	handles map[string]*sftpFile
+}
+
+func newSFTPFilter(toHost, toClient io.Writer, policy fileTransferPolicy, audit fileTransferAuditFunc) *sftpFilter {
+	return &sftpFilter{
+		toHost:   toHost,
+		toClient: toClient,
+		policy:   policy,
+		audit:    audit,
+		pending:  map[uint32]sftpRequest{},
+		handles:  map[string]*sftpFile{},
+	}
+}
+
+func (f *sftpFilter) writeToClient(p []byte) (int, error) {
+	f.writeLock.Lock()
+	defer f.writeLock.Unlock()
+
+	return f.toClient.Write(p)
+}
+
+// writeInput is for the packets from client to host
+func (f *sftpFilter) writeInput(p []byte) (int, error) {
+	if f.passthroughInput {
+		return f.toHost.Write(p)
+	}
+
+	f.input = append(f.input, p...)
+	for {
+		packet, rest, err := nextSFTPPacket(f.input)
+		if err != nil {
+			// without parsing, the policy can not be applied
+			if f.policy.isRestricted() {
+				return 0, err
+			}
+
+			log.Errorf("stop to audit sftp: %v", err)
+			f.passthroughInput = true
+			buffered := f.input
+			f.input = nil
+			if _, err := f.toHost.Write(buffered); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+		if packet == nil {
+			break
+		}
+		f.input = rest
+
+		if id, err := f.handleRequest(packet); err != nil {
+			if _, err := f.writeToClient(newSFTPStatusPacket(id, sftpStatusPermissionDenied, err.Error())); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if _, err := f.toHost.Write(packet); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// writeOutput is for the packets from host to client
+func (f *sftpFilter) writeOutput(p []byte) (int, error) {
+	if f.passthroughOutput {
+		return f.writeToClient(p)
+	}
+
+	f.output = append(f.output, p...)
+	for {
+		packet, rest, err := nextSFTPPacket(f.output)
+		if err != nil {
+			if f.policy.isRestricted() {
+				return 0, err
+			}
+
+			log.Errorf("stop to audit sftp: %v", err)
+			f.passthroughOutput = true
+			buffered := f.output
+			f.output = nil
+			if _, err := f.writeToClient(buffered); err != nil {
+				return 0, err
+			}
+			return len(p), nil
+		}
+		if packet == nil {
+			break
+		}
+		f.output = rest
+
+		f.handleResponse(packet)
+
+		if _, err := f.writeToClient(packet); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// handleRequest parses the request packet of client; if the request is not
+// allowed, the error is returned with the request id.
+func (f *sftpFilter) handleRequest(packet []byte) (uint32, error) {
+	b := sftpBuffer(packet[4:])
+	kind, _ := b.byte()
+	if kind == sftpPacketInit {
+		return 0, nil
+	}
+
+	id, ok := b.uint32()
+	if !ok {
+		return 0, nil
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	switch kind {
+	case sftpPacketOpen:
+		filename, _ := b.string()
+		flags, _ := b.uint32()
+
+		var err error
+		if flags&(sftpFlagWrite|sftpFlagAppend|sftpFlagCreat|sftpFlagTrunc) != 0 {
+			err = f.policy.check(fileTransferUpload)
+		}
+		if err == nil && flags&sftpFlagRead != 0 {
+			err = f.policy.check(fileTransferDownload)
+		}
+		if err != nil {
+			f.audit(f.openData(filename, flags), err)
+			return id, err
+		}
+
+		f.pending[id] = sftpRequest{kind: kind, path: filename, flags: flags}
+	case sftpPacketClose:
+		handle, _ := b.string()
+		if file, found := f.handles[handle]; found {
+			f.auditFile(file)
+			delete(f.handles, handle)
+		}
+	case sftpPacketRead:
+		handle, _ := b.string()
+		f.pending[id] = sftpRequest{kind: kind, handle: handle}
+	case sftpPacketWrite:
+		handle, _ := b.string()
+		b.uint64()
+		data, _ := b.string()
+		if file, found := f.handles[handle]; found {
+			file.written += int64(len(data))
+		}
+	case sftpPacketRemove:
+		filename, _ := b.string()
+		f.pending[id] = sftpRequest{kind: kind, path: filename}
+	case sftpPacketRename:
+		oldPath, _ := b.string()
+		newPath, _ := b.string()
+		f.pending[id] = sftpRequest{kind: kind, path: oldPath, newPath: newPath}
+	case sftpPacketExtended:
+		if name, _ := b.string(); name == "posix-rename@openssh.com" {
+			oldPath, _ := b.string()
+			newPath, _ := b.string()
+			f.pending[id] = sftpRequest{kind: sftpPacketRename, path: oldPath, newPath: newPath}
+		}
+	}
+
+	return id, nil
+}
+
+// handleResponse parses the response packet of host
+func (f *sftpFilter) handleResponse(packet []byte) {
+	b := sftpBuffer(packet[4:])
+	kind, _ := b.byte()
+	id, ok := b.uint32()
+	if !ok {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	request, found := f.pending[id]
+	if !found {
+		return
+	}
+	delete(f.pending, id)
+
+	switch kind {
+	case sftpPacketHandle:
+		handle, _ := b.string()
+		if request.kind == sftpPacketOpen {
+			f.handles[handle] = &sftpFile{path: request.path}
+			f.audit(f.openData(request.path, request.flags), nil)
+		}
+	case sftpPacketData:
+		data, _ := b.string()
+		if file, found := f.handles[request.handle]; found && request.kind == sftpPacketRead {
+			file.read += int64(len(data))
+		}
+	case sftpPacketStatus:
+		code, _ := b.uint32()
+
+		var err error
+		if code != sftpStatusOK {
+			message, _ := b.string()
+			err = fmt.Errorf("sftp status %d: %s", code, message)
+		}
+
+		switch request.kind {
+		case sftpPacketOpen:
+			f.audit(f.openData(request.path, request.flags), err)
+		case sftpPacketRemove:
+			f.audit(
+				map[string]interface{}{"protocol": "sftp", "operation": "remove", "path": request.path},
+				err,
+			)
+		case sftpPacketRename:
+			f.audit(
+				map[string]interface{}{
+					"protocol":  "sftp",
+					"operation": "rename",
+					"path":      request.path,
+					"new_path":  request.newPath,
+				},
+				err,
+			)
+		}
+	}
+}
+
+func (f *sftpFilter) openData(filename string, flags uint32) map[string]interface{} {
+	direction := fileTransferDownload
+	if flags&(sftpFlagWrite|sftpFlagAppend|sftpFlagCreat|sftpFlagTrunc) != 0 {
+		direction = fileTransferUpload
+	}
+
+	return map[string]interface{}{
+		"protocol":  "sftp",
+		"operation": "open",
+		"path":      filename,
+		"direction": direction,
+	}
+}
+
+// auditFile audits the read and written bytes of the file
+func (f *sftpFilter) auditFile(file *sftpFile) {
+	if file.read > 0 {
+		f.audit(
+			map[string]interface{}{
+				"protocol":  "sftp",
+				"operation": "read",
+				"path":      file.path,
+				"size":      file.read,
+				"direction": fileTransferDownload,
+			},
+			nil,
+		)
+	}
+	if file.written > 0 {
+		f.audit(
+			map[string]interface{}{
+				"protocol":  "sftp",
+				"operation": "write",
+				"path":      file.path,
+				"size":      file.written,
+				"direction": fileTransferUpload,
+			},
+			nil,
+		)
+	}
+}
+
+func (f *sftpFilter) close() {
+	f.Lock()
+	defer f.Unlock()
+
+	for handle, file := range f.handles {
+		f.auditFile(file)
+		delete(f.handles, handle)
+	}
+}
+
+// scpAuditor parses the stream of the scp source side, like 'C0644 <size>
+// <name>' and audits the transferred files.
+type scpAuditor struct {
+	sync.Mutex
+	direction string
+	target    string
+	audit     fileTransferAuditFunc
+
+	line []byte
+	dirs []string
+	skip int64
+}
+
+func newSCPAuditor(direction, target string, audit fileTransferAuditFunc) *scpAuditor {
+	return &scpAuditor{direction: direction, target: target, audit: audit}
+}
+
+// Write never fails, the traffic is not affected by the auditor
+func (a *scpAuditor) Write(p []byte) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		if a.skip > 0 {
+			s := a.skip
+			if int64(len(p)) < s {
+				s = int64(len(p))
+			}
+			a.skip -= s
+			p = p[s:]
+			continue
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			a.line = append(a.line, p...)
+			break
+		}
+
+		a.line = append(a.line, p[:i]...)
+		p = p[i+1:]
+
+		a.handleLine(strings.TrimLeft(string(a.line), "\x00"))
+		a.line = nil
+	}
+
+	return n, nil
+}
+
+func (a *scpAuditor) handleLine(line string) {
+	if len(line) < 1 {
+		return
+	}
+
+	switch line[0] {
+	case 'C':
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) != 3 {
+			return
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || size < 0 {
+			return
+		}
+
+		operation := "write"
+		if a.direction == fileTransferDownload {
+			operation = "read"
+		}
+
+		a.audit(
+			map[string]interface{}{
+				"protocol":  "scp",
+				"operation": operation,
+				"path":      path.Join(append(append([]string{}, a.dirs...), fields[2])...),
+				"target":    a.target,
+				"size":      size,
+				"direction": a.direction,
+			},
+			nil,
+		)
+
+		// the file data is followed by '\0'
+		a.skip = size + 1
+	case 'D':
+		fields := strings.SplitN(line[1:], " ", 3)
+		if len(fields) == 3 {
+			a.dirs = append(a.dirs, fields[2])
+		}
+	case 'E':
+		if len(a.dirs) > 0 {
+			a.dirs = a.dirs[:len(a.dirs)-1]
+		}
+	}
+}
+
+func (a *scpAuditor) close() {}
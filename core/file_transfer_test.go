@@ -0,0 +1,275 @@
+package sault
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+type testFileTransferAudit struct {
+	data []map[string]interface{}
+	errs []error
+}
+
+func (a *testFileTransferAudit) audit(data map[string]interface{}, err error) {
+	a.data = append(a.data, data)
+	a.errs = append(a.errs, err)
+}
+
+// newTestSFTPPacket makes the sftp packet; the values are uint32, uint64,
+// string and []byte.
+func newTestSFTPPacket(kind byte, values ...interface{}) []byte {
+	b := []byte{0, 0, 0, 0, kind}
+	for _, v := range values {
+		switch v := v.(type) {
+		case uint32:
+			p := make([]byte, 4)
+			binary.BigEndian.PutUint32(p, v)
+			b = append(b, p...)
+		case uint64:
+			p := make([]byte, 8)
+			binary.BigEndian.PutUint64(p, v)
+			b = append(b, p...)
+		case string:
+			p := make([]byte, 4)
+			binary.BigEndian.PutUint32(p, uint32(len(v)))
+			b = append(b, p...)
+			b = append(b, v...)
+		case []byte:
+			b = append(b, v...)
+		}
+	}
+	binary.BigEndian.PutUint32(b, uint32(len(b)-4))
+
+	return b
+}
+
+func TestParseSCPCommand(t *testing.T) {
+	cases := []struct {
+		command   string
+		direction string
+		target    string
+		ok        bool
+	}{
+		{"scp -t -- /tmp", fileTransferUpload, "/tmp", true},
+		{"scp -r -d -t /tmp/a b", fileTransferUpload, "/tmp/a b", true},
+		{"/usr/bin/scp -pf /etc/hosts", fileTransferDownload, "/etc/hosts", true},
+		{"scp -v -f -- -weird", fileTransferDownload, "-weird", true},
+		{"scp /tmp", "", "", false},
+		{"ls -t /tmp", "", "", false},
+		{"scp", "", "", false},
+	}
+
+	for _, i := range cases {
+		direction, target, ok := parseSCPCommand(i.command)
+		assert.Equal(t, i.ok, ok, i.command)
+		assert.Equal(t, i.direction, direction, i.command)
+		assert.Equal(t, i.target, target, i.command)
+	}
+}
+
+func TestSFTPFilter(t *testing.T) {
+	var toHost, toClient bytes.Buffer
+	audit := &testFileTransferAudit{}
+	f := newSFTPFilter(&toHost, &toClient, fileTransferPolicy{}, audit.audit)
+
+	requests := [][]byte{
+		newTestSFTPPacket(sftpPacketInit, uint32(3)),
+		newTestSFTPPacket(sftpPacketOpen, uint32(1), "/etc/hosts", uint32(sftpFlagRead), uint32(0)),
+		newTestSFTPPacket(sftpPacketRead, uint32(2), "h1", uint64(0), uint32(32768)),
+		newTestSFTPPacket(sftpPacketOpen, uint32(3), "/tmp/upload", uint32(sftpFlagWrite|sftpFlagCreat), uint32(0)),
+		newTestSFTPPacket(sftpPacketWrite, uint32(4), "h2", uint64(0), "showmethemoney"),
+		newTestSFTPPacket(sftpPacketClose, uint32(5), "h1"),
+		newTestSFTPPacket(sftpPacketClose, uint32(6), "h2"),
+		newTestSFTPPacket(sftpPacketRename, uint32(7), "/tmp/upload", "/tmp/uploaded"),
+		newTestSFTPPacket(sftpPacketRemove, uint32(8), "/tmp/uploaded"),
+	}
+	responses := map[int][]byte{
+		1: newTestSFTPPacket(sftpPacketHandle, uint32(1), "h1"),
+		2: newTestSFTPPacket(sftpPacketData, uint32(2), "127.0.0.1 localhost\n"),
+		3: newTestSFTPPacket(sftpPacketHandle, uint32(3), "h2"),
+		7: newTestSFTPPacket(sftpPacketStatus, uint32(7), uint32(sftpStatusOK), "", ""),
+		8: newTestSFTPPacket(sftpPacketStatus, uint32(8), uint32(sftpStatusPermissionDenied), "denied", ""),
+	}
+
+	var expectedToHost, expectedToClient []byte
+	for i, request := range requests {
+		// the packets are splitted
+		for _, b := range [][]byte{request[:3], request[3:]} {
+			_, err := f.writeInput(b)
+			assert.Nil(t, err)
+		}
+		expectedToHost = append(expectedToHost, request...)
+
+		if response, found := responses[i]; found {
+			_, err := f.writeOutput(response)
+			assert.Nil(t, err)
+			expectedToClient = append(expectedToClient, response...)
+		}
+	}
+
+	// traffic is not altered
+	assert.Equal(t, expectedToHost, toHost.Bytes())
+	assert.Equal(t, expectedToClient, toClient.Bytes())
+
+	var operations []string
+	for i, data := range audit.data {
+		operations = append(operations, fmt.Sprintf("%s %s %v", data["operation"], data["path"], audit.errs[i] != nil))
+	}
+	assert.Equal(
+		t,
+		[]string{
+			"open /etc/hosts false",
+			"open /tmp/upload false",
+			"read /etc/hosts false",
+			"write /tmp/upload false",
+			"rename /tmp/upload false",
+			"remove /tmp/uploaded true",
+		},
+		operations,
+	)
+	assert.Equal(t, fileTransferDownload, audit.data[0]["direction"])
+	assert.Equal(t, fileTransferUpload, audit.data[1]["direction"])
+	assert.Equal(t, int64(len("127.0.0.1 localhost\n")), audit.data[2]["size"])
+	assert.Equal(t, int64(len("showmethemoney")), audit.data[3]["size"])
+	assert.Equal(t, "/tmp/uploaded", audit.data[4]["new_path"])
+}
+
+func TestSFTPFilterDenied(t *testing.T) {
+	var toHost, toClient bytes.Buffer
+	audit := &testFileTransferAudit{}
+	f := newSFTPFilter(&toHost, &toClient, fileTransferPolicy{DisableUpload: true}, audit.audit)
+
+	download := newTestSFTPPacket(sftpPacketOpen, uint32(1), "/etc/hosts", uint32(sftpFlagRead), uint32(0))
+	upload := newTestSFTPPacket(sftpPacketOpen, uint32(2), "/tmp/upload", uint32(sftpFlagWrite|sftpFlagCreat), uint32(0))
+
+	f.writeInput(download)
+	f.writeInput(upload)
+
+	// the upload is not sent to host and answered with the permission denied
+	assert.Equal(t, download, toHost.Bytes())
+
+	b := sftpBuffer(toClient.Bytes()[4:])
+	kind, _ := b.byte()
+	id, _ := b.uint32()
+	code, _ := b.uint32()
+	assert.Equal(t, byte(sftpPacketStatus), kind)
+	assert.Equal(t, uint32(2), id)
+	assert.Equal(t, uint32(sftpStatusPermissionDenied), code)
+
+	assert.Equal(t, 1, len(audit.data))
+	assert.Equal(t, "/tmp/upload", audit.data[0]["path"])
+	assert.NotNil(t, audit.errs[0])
+
+	// with the restricted policy, the invalid packet closes the stream
+	_, err := f.writeInput([]byte{0xff, 0xff, 0xff, 0xff})
+	assert.Equal(t, errInvalidSFTPPacket, err)
+}
+
+func TestSFTPFilterPassthrough(t *testing.T) {
+	var toHost, toClient bytes.Buffer
+	audit := &testFileTransferAudit{}
+	f := newSFTPFilter(&toHost, &toClient, fileTransferPolicy{}, audit.audit)
+
+	// the invalid packet stops auditing, but the traffic is passed thru
+	invalid := []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3}
+	_, err := f.writeInput(invalid)
+	assert.Nil(t, err)
+	f.writeInput([]byte("findkeys"))
+
+	assert.Equal(t, append(invalid, []byte("findkeys")...), toHost.Bytes())
+}
+
+func TestSCPAuditor(t *testing.T) {
+	audit := &testFileTransferAudit{}
+	a := newSCPAuditor(fileTransferUpload, "/tmp", audit.audit)
+
+	stream := "D0755 0 dir\nC0644 5 a.txt\nhello\x00C0644 3 b\nfoo\x00E\nC0600 0 c\n\x00"
+	for i := 0; i < len(stream); i += 4 {
+		end := i + 4
+		if end > len(stream) {
+			end = len(stream)
+		}
+		n, err := a.Write([]byte(stream[i:end]))
+		assert.Nil(t, err)
+		assert.Equal(t, end-i, n)
+	}
+
+	var paths []string
+	for _, data := range audit.data {
+		paths = append(paths, fmt.Sprintf("%s %s %d", data["operation"], data["path"], data["size"]))
+		assert.Equal(t, "/tmp", data["target"])
+		assert.Equal(t, fileTransferUpload, data["direction"])
+	}
+	assert.Equal(t, []string{"write dir/a.txt 5", "write dir/b 3", "write c 0"}, paths)
+}
+
+func TestStartFileTransferRestricted(t *testing.T) {
+	c, _, client, _ := newTestProxiedConnection(t, func(link *saultregistry.LinkAccountRegistry) {
+		link.DisableUpload = true
+	})
+	defer client.conn.Close()
+
+	exec := func(command string) *saultssh.Request {
+		return &saultssh.Request{
+			Type:    "exec",
+			Payload: saultssh.Marshal(execRequestMsg{Command: command}),
+		}
+	}
+
+	subsystem := func(name string) *saultssh.Request {
+		return &saultssh.Request{
+			Type:    "subsystem",
+			Payload: saultssh.Marshal(subsystemRequestMsg{Subsystem: name}),
+		}
+	}
+
+	cases := []struct {
+		request *saultssh.Request
+		pty     bool
+		allowed bool
+		sftp    bool
+	}{
+		{exec("scp -f /etc/hosts"), false, true, false},
+		{exec("scp -t /tmp"), false, false, false},
+		{exec("cat /etc/hosts"), false, false, false},
+		{exec("tar cf - /etc"), true, false, false},
+		{exec("sh -c 'scp -f x'"), false, false, false},
+		{exec("scp -f x; cat y"), false, false, false},
+		{exec("scp -f $(cat y)"), false, false, false},
+		{exec("/usr/lib/openssh/sftp-server"), false, true, true},
+		{&saultssh.Request{Type: "shell"}, false, false, false},
+		{&saultssh.Request{Type: "shell"}, true, true, false},
+		{subsystem("sftp"), false, true, true},
+		{subsystem("rsync"), false, false, false},
+		{subsystem("netconf"), true, false, false},
+	}
+
+	for _, i := range cases {
+		var toHost, toClient bytes.Buffer
+		input, output := newSwitchWriter(&toHost), newSwitchWriter(&toClient)
+
+		transfer, err := c.startFileTransfer("channel-0", i.request, i.pty, input, output)
+		assert.Equal(t, i.allowed, err == nil, string(i.request.Payload))
+
+		_, isSFTP := transfer.(*sftpFilter)
+		assert.Equal(t, i.sftp, isSFTP, string(i.request.Payload))
+	}
+
+	// without restriction, the commands are not blocked
+	link, _ := c.server.registry.GetLink(c.user.ID, c.host.ID)
+	link.DisableUpload = false
+	c.server.registry.UpdateLink(c.user.ID, c.host.ID, link)
+
+	_, err := c.startFileTransfer("channel-0", exec("cat /etc/hosts"), false, newSwitchWriter(ioutil.Discard), newSwitchWriter(ioutil.Discard))
+	assert.Nil(t, err)
+
+	_, err = c.startFileTransfer("channel-0", subsystem("rsync"), false, newSwitchWriter(ioutil.Discard), newSwitchWriter(ioutil.Discard))
+	assert.Nil(t, err)
+}
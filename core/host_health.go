@@ -0,0 +1,135 @@
+package sault
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// HostAddressStatusUnknown is for the address, which is not yet tried
+	HostAddressStatusUnknown = "unknown"
+	// HostAddressStatusReachable is for the address, which was connected at
+	// last
+	HostAddressStatusReachable = "reachable"
+	// HostAddressStatusUnreachable is for the address, which failed to
+	// connect at last
+	HostAddressStatusUnreachable = "unreachable"
+)
+
+// intervalHostAddressRetry is the duration to remember the failure of
+// address; the recently failed addresses are tried at last.
+var intervalHostAddressRetry = time.Minute
+
+// HostAddressHealth is the last-known reachability of the host address
+type HostAddressHealth struct {
+	Address     string
+	LastSuccess time.Time
+	LastFailure time.Time
+	Failures    int
+	Error       string
+}
+
+// Status returns the status by the last connection
+func (h HostAddressHealth) Status() string {
+	if h.LastSuccess.IsZero() && h.LastFailure.IsZero() {
+		return HostAddressStatusUnknown
+	}
+	if h.LastFailure.After(h.LastSuccess) {
+		return HostAddressStatusUnreachable
+	}
+
+	return HostAddressStatusReachable
+}
+
+// LastChecked returns the time of the last connection
+func (h HostAddressHealth) LastChecked() time.Time {
+	if h.LastFailure.After(h.LastSuccess) {
+		return h.LastFailure
+	}
+
+	return h.LastSuccess
+}
+
+// HostHealthRegistry records the results of the connections to the host
+// addresses
+type HostHealthRegistry struct {
+	sync.RWMutex
+	addresses map[string]*HostAddressHealth
+	now       func() time.Time
+}
+
+// NewHostHealthRegistry makes HostHealthRegistry
+func NewHostHealthRegistry() *HostHealthRegistry {
+	return &HostHealthRegistry{
+		addresses: map[string]*HostAddressHealth{},
+		now:       time.Now,
+	}
+}
+
+func (r *HostHealthRegistry) get(address string) *HostAddressHealth {
+	h, ok := r.addresses[address]
+	if !ok {
+		h = &HostAddressHealth{Address: address}
+		r.addresses[address] = h
+	}
+
+	return h
+}
+
+func (r *HostHealthRegistry) succeeded(address string) {
+	r.Lock()
+	defer r.Unlock()
+
+	h := r.get(address)
+	h.LastSuccess = r.now()
+	h.Failures = 0
+	h.Error = ""
+}
+
+func (r *HostHealthRegistry) failed(address string, err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	h := r.get(address)
+	h.LastFailure = r.now()
+	h.Failures++
+	h.Error = err.Error()
+}
+
+// order returns the addresses to try; the addresses, which failed recently
+// are moved to the last, but the given order is kept.
+func (r *HostHealthRegistry) order(addresses []string) []string {
+	r.RLock()
+	defer r.RUnlock()
+
+	now := r.now()
+
+	var healthy, failed []string
+	for _, a := range addresses {
+		h, ok := r.addresses[a]
+		if ok && h.Status() == HostAddressStatusUnreachable && now.Sub(h.LastFailure) < intervalHostAddressRetry {
+			failed = append(failed, a)
+			continue
+		}
+		healthy = append(healthy, a)
+	}
+
+	return append(healthy, failed...)
+}
+
+// GetHealth returns the health of the given addresses
+func (r *HostHealthRegistry) GetHealth(addresses ...string) map[string]HostAddressHealth {
+	r.RLock()
+	defer r.RUnlock()
+
+	health := map[string]HostAddressHealth{}
+	for _, a := range addresses {
+		if h, ok := r.addresses[a]; ok {
+			health[a] = *h
+			continue
+		}
+		health[a] = HostAddressHealth{Address: a}
+	}
+
+	return health
+}
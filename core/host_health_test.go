@@ -0,0 +1,71 @@
+package sault
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostHealthRegistry(t *testing.T) {
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	r := NewHostHealthRegistry()
+	r.now = func() time.Time { return now }
+
+	addresses := []string{"10.0.0.2:22", "10.0.1.2:22", "10.0.2.2:22"}
+
+	health := r.GetHealth(addresses...)
+	assert.Equal(t, 3, len(health))
+	assert.Equal(t, HostAddressStatusUnknown, health[addresses[0]].Status())
+	assert.Equal(t, addresses, r.order(addresses))
+
+	// recently failed address is tried at last
+	r.failed(addresses[0], fmt.Errorf("connection refused"))
+	r.succeeded(addresses[1])
+	assert.Equal(t, []string{addresses[1], addresses[2], addresses[0]}, r.order(addresses))
+
+	health = r.GetHealth(addresses...)
+	assert.Equal(t, HostAddressStatusUnreachable, health[addresses[0]].Status())
+	assert.Equal(t, "connection refused", health[addresses[0]].Error)
+	assert.Equal(t, 1, health[addresses[0]].Failures)
+	assert.Equal(t, HostAddressStatusReachable, health[addresses[1]].Status())
+
+	// after intervalHostAddressRetry, it is tried again in order
+	now = now.Add(intervalHostAddressRetry)
+	assert.Equal(t, addresses, r.order(addresses))
+
+	r.succeeded(addresses[0])
+	health = r.GetHealth(addresses...)
+	assert.Equal(t, HostAddressStatusReachable, health[addresses[0]].Status())
+	assert.Equal(t, 0, health[addresses[0]].Failures)
+	assert.Empty(t, health[addresses[0]].Error)
+}
+
+func TestDialInnerClientFailover(t *testing.T) {
+	// the closed ports
+	var addresses []string
+	for i := 0; i < 2; i++ {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		addresses = append(addresses, listener.Addr().String())
+		listener.Close()
+	}
+
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+	server, _ := NewServer(registry, nil, nil, nil, DefaultSaultServerName)
+
+	host := saultregistry.HostRegistry{ID: "prometeus", HostName: "127.0.0.1", Port: 1, Addresses: addresses}
+	key := newInnerClientKey(host, "ubuntu")
+	assert.Equal(t, 3, len(key.getAddresses()))
+
+	_, err := server.dialInnerClient(key)
+	assert.NotNil(t, err)
+
+	// all the addresses are tried
+	for _, h := range server.hostHealth.GetHealth(key.getAddresses()...) {
+		assert.Equal(t, HostAddressStatusUnreachable, h.Status())
+	}
+}
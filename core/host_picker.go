@@ -0,0 +1,387 @@
+package sault
+
+import (
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// hostPickerHeight is the number of lines of the host picker except the
+// entries
+const hostPickerHeight = 4
+
+const (
+	hostPickerKeyNone = iota
+	hostPickerKeyRune
+	hostPickerKeyUp
+	hostPickerKeyDown
+	hostPickerKeyEnter
+	hostPickerKeyBackspace
+	hostPickerKeyClear
+	hostPickerKeyQuit
+)
+
+type hostPickerKey struct {
+	Kind int
+	Rune rune
+}
+
+// parseHostPickerKeys parses the terminal input to the keys of host picker
+func parseHostPickerKeys(b []byte) (keys []hostPickerKey) {
+	if len(b) == 1 && b[0] == 0x1b {
+		return []hostPickerKey{{Kind: hostPickerKeyQuit}}
+	}
+
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == 0x1b:
+			// escape sequences of arrow keys, 'ESC [ A' or 'ESC O A'
+			if i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') {
+				switch b[i+2] {
+				case 'A':
+					keys = append(keys, hostPickerKey{Kind: hostPickerKeyUp})
+				case 'B':
+					keys = append(keys, hostPickerKey{Kind: hostPickerKeyDown})
+				}
+				i += 2
+			}
+		case c == '\r' || c == '\n':
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyEnter})
+		case c == 0x7f || c == 0x08:
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyBackspace})
+		case c == 0x15: // ctrl-u
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyClear})
+		case c == 0x10: // ctrl-p
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyUp})
+		case c == 0x0e: // ctrl-n
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyDown})
+		case c == 0x03 || c == 0x04: // ctrl-c, ctrl-d
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyQuit})
+		case c >= 0x20 && c < 0x7f:
+			keys = append(keys, hostPickerKey{Kind: hostPickerKeyRune, Rune: rune(c)})
+		}
+	}
+
+	return
+}
+
+type hostPickerEntry struct {
+	HostID  string
+	Account string
+}
+
+func (e hostPickerEntry) String() string {
+	return e.Account + "+" + e.HostID
+}
+
+// getHostPickerEntries returns the hosts and accounts, which the user is
+// linked to
+func getHostPickerEntries(registry *saultregistry.Registry, userID string) (entries []hostPickerEntry) {
+	now := time.Now()
+	for hostID, link := range registry.GetLinksOfUser(userID) {
+		if link.IsExpired(now) {
+			continue
+		}
+
+		host, err := registry.GetHost(hostID, saultregistry.HostFilterIsActive)
+		if err != nil {
+			continue
+		}
+
+		accounts := link.Accounts
+		if link.All {
+			accounts = host.Accounts
+		}
+
+		for _, a := range accounts {
+			if !host.HasAccount(a) || link.IsAccountExpired(a, now) {
+				continue
+			}
+			entries = append(entries, hostPickerEntry{HostID: host.ID, Account: a})
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].HostID == entries[j].HostID {
+			return entries[i].Account < entries[j].Account
+		}
+		return entries[i].HostID < entries[j].HostID
+	})
+
+	return
+}
+
+var hostPickerTemplate = `{{ "* sault" | blue }} select the host, {{ "type to filter, ↑ ↓ to move, enter to connect, ctrl-c to quit" | dim }}
+{{ ">" | yellow }} {{ .filter }}
+{{ range $i, $e := .entries }}{{ if eq $i $.cursor }}{{ print " " $e " " | invert }}{{ else }} {{ print $e }} {{ end }}
+{{ else }}{{ "no hosts found" | dim }}
+{{ end }}{{ if .more }}{{ sprintf "... %d more" .more | dim }}
+{{ end }}`
+
+// hostPicker is the terminal menu to select the linked host and account
+type hostPicker struct {
+	sync.Mutex
+	out     io.Writer
+	entries []hostPickerEntry
+	filter  string
+	cursor  int
+	rows    int
+}
+
+func newHostPicker(out io.Writer, entries []hostPickerEntry, rows int) *hostPicker {
+	return &hostPicker{out: out, entries: entries, rows: rows}
+}
+
+// filtered returns the entries, which contain the all words of filter
+func (p *hostPicker) filtered() (entries []hostPickerEntry) {
+	words := strings.Fields(strings.ToLower(p.filter))
+	for _, e := range p.entries {
+		s := strings.ToLower(e.String())
+
+		matched := true
+		for _, w := range words {
+			if !strings.Contains(s, w) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			entries = append(entries, e)
+		}
+	}
+
+	return
+}
+
+// input handles the key; it returns the selected entry or quit.
+func (p *hostPicker) input(key hostPickerKey) (selected *hostPickerEntry, quit bool) {
+	p.Lock()
+	defer p.Unlock()
+
+	switch key.Kind {
+	case hostPickerKeyRune:
+		p.filter += string(key.Rune)
+		p.cursor = 0
+	case hostPickerKeyBackspace:
+		if len(p.filter) > 0 {
+			p.filter = p.filter[:len(p.filter)-1]
+		}
+		p.cursor = 0
+	case hostPickerKeyClear:
+		p.filter = ""
+		p.cursor = 0
+	case hostPickerKeyUp:
+		if p.cursor > 0 {
+			p.cursor--
+		}
+	case hostPickerKeyDown:
+		if p.cursor < len(p.filtered())-1 {
+			p.cursor++
+		}
+	case hostPickerKeyEnter:
+		entries := p.filtered()
+		if p.cursor < len(entries) {
+			return &entries[p.cursor], false
+		}
+	case hostPickerKeyQuit:
+		return nil, true
+	}
+
+	return nil, false
+}
+
+func (p *hostPicker) resize(rows int) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.rows = rows
+}
+
+// render returns the picker screen; the entries are scrolled to show the
+// cursor within the terminal rows.
+func (p *hostPicker) render() string {
+	entries := p.filtered()
+
+	height := len(entries)
+	if p.rows > hostPickerHeight && height > p.rows-hostPickerHeight {
+		height = p.rows - hostPickerHeight
+	}
+
+	var start int
+	if p.cursor >= height {
+		start = p.cursor - height + 1
+	}
+
+	rendered, _ := saultcommon.SimpleTemplating(
+		hostPickerTemplate,
+		map[string]interface{}{
+			"filter":  p.filter,
+			"entries": entries[start : start+height],
+			"cursor":  p.cursor - start,
+			"more":    len(entries) - start - height,
+		},
+	)
+
+	return "\x1b[H\x1b[2J" + strings.Replace(rendered, "\n", "\r\n", -1)
+}
+
+func (p *hostPicker) redraw() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.out.Write([]byte(p.render()))
+}
+
+// run reads the keys from the client until the entry is selected; nil is
+// returned when the client quits.
+func (p *hostPicker) run(in io.Reader) *hostPickerEntry {
+	p.redraw()
+
+	b := make([]byte, 256)
+	for {
+		n, err := in.Read(b)
+		if err != nil {
+			return nil
+		}
+
+		for _, key := range parseHostPickerKeys(b[:n]) {
+			selected, quit := p.input(key)
+			if quit {
+				p.out.Write([]byte("\x1b[H\x1b[2J"))
+				return nil
+			}
+			if selected != nil {
+				p.out.Write([]byte("\x1b[H\x1b[2J"))
+				return selected
+			}
+		}
+
+		p.redraw()
+	}
+}
+
+// pickHost shows the host picker to the user, who connects to the sault
+// server with pty and the selected host is proxied in the same session
+// channel.
+func (c *connection) pickHost(
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	pty ptyRequestMsg,
+	replays []*saultssh.Request,
+) error {
+	entries := getHostPickerEntries(c.server.registry, c.user.ID)
+	if len(entries) < 1 {
+		rendered, _ := saultcommon.SimpleTemplating(
+			`{{ "* sault" | blue }} {{ "error" | red }} You are not linked to any host.`,
+			nil,
+		)
+		channel.Write([]byte(rendered + "\r\n"))
+		sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+
+		return nil
+	}
+
+	picker := newHostPicker(channel, entries, int(pty.Rows))
+
+	picked := make(chan *hostPickerEntry, 1)
+	go func() {
+		picked <- picker.run(channel)
+	}()
+
+	for {
+		select {
+		case request := <-requests:
+			if request == nil {
+				return nil
+			}
+
+			if request.Type != "window-change" {
+				request.Reply(false, nil)
+				continue
+			}
+
+			var msg windowChangeRequestMsg
+			if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+				pty.Columns, pty.Rows = msg.Columns, msg.Rows
+				pty.Width, pty.Height = msg.Width, msg.Height
+
+				picker.resize(int(msg.Rows))
+				picker.redraw()
+			}
+			request.Reply(true, nil)
+		case entry := <-picked:
+			if entry == nil {
+				sendExitStatusThruChannel(channel, 0)
+				return nil
+			}
+
+			// the pty-req is replayed with the last window size
+			for _, r := range replays {
+				if r.Type == "pty-req" {
+					r.Payload = saultssh.Marshal(pty)
+				}
+			}
+
+			return c.openPickedProxyChannel(*entry, channel, requests, &pty, replays)
+		}
+	}
+}
+
+func (c *connection) openPickedProxyChannel(
+	entry hostPickerEntry,
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	pty *ptyRequestMsg,
+	replays []*saultssh.Request,
+) error {
+	host, err := c.server.registry.GetHost(entry.HostID, saultregistry.HostFilterIsActive)
+	if err == nil && !c.server.registry.IsLinked(c.user.ID, host.ID, entry.Account) {
+		err = &saultcommon.HostAndUserNotLinked{UserID: c.user.ID, HostID: host.ID}
+	}
+	if err != nil {
+		rendered, _ := saultcommon.SimpleTemplating(
+			`{{ "* sault" | blue }} {{ "error" | red }} {{ . }}`,
+			err,
+		)
+		channel.Write([]byte(rendered + "\r\n"))
+		sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+
+		return err
+	}
+
+	c.setProxiedHost(host, entry.Account)
+	c.log.Debugf("picked; %s", entry)
+
+	if c.isReasonRequired() {
+		if channel, err = c.requireReason(channel, requests, pty, replays); err != nil {
+			return err
+		}
+	}
+
+	innerclient, err := c.getInnerClient()
+	if err != nil {
+		rendered, _ := saultcommon.SimpleTemplating(
+			`{{ "* sault" | blue }} {{ "error" | red }} failed to connect to {{ . }}`,
+			entry.String(),
+		)
+		channel.Write([]byte(rendered + "\r\n"))
+		sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+
+		return err
+	}
+	defer c.server.innerClients.release(innerclient)
+
+	stopTimer := make(chan struct{})
+	defer close(stopTimer)
+	go c.runSessionTimer(stopTimer)
+
+	channel.SetProxy(true)
+
+	return c.proxyChannel(innerclient.SSHClient, "session", nil, channel, requests, replays)
+}
@@ -0,0 +1,211 @@
+package sault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHostPickerKeys(t *testing.T) {
+	{
+		keys := parseHostPickerKeys([]byte("ab\x7f\r"))
+		assert.Equal(
+			t,
+			[]hostPickerKey{
+				{Kind: hostPickerKeyRune, Rune: 'a'},
+				{Kind: hostPickerKeyRune, Rune: 'b'},
+				{Kind: hostPickerKeyBackspace},
+				{Kind: hostPickerKeyEnter},
+			},
+			keys,
+		)
+	}
+
+	{
+		keys := parseHostPickerKeys([]byte("\x1b[A\x1bOB\x10\x0e"))
+		assert.Equal(
+			t,
+			[]hostPickerKey{
+				{Kind: hostPickerKeyUp},
+				{Kind: hostPickerKeyDown},
+				{Kind: hostPickerKeyUp},
+				{Kind: hostPickerKeyDown},
+			},
+			keys,
+		)
+	}
+
+	{
+		assert.Equal(t, []hostPickerKey{{Kind: hostPickerKeyQuit}}, parseHostPickerKeys([]byte("\x1b")))
+		assert.Equal(t, []hostPickerKey{{Kind: hostPickerKeyQuit}}, parseHostPickerKeys([]byte("\x03")))
+	}
+}
+
+func TestHostPickerInput(t *testing.T) {
+	entries := []hostPickerEntry{
+		{HostID: "prometeus", Account: "root"},
+		{HostID: "prometeus", Account: "ubuntu"},
+		{HostID: "zeus", Account: "ubuntu"},
+	}
+	picker := newHostPicker(&bytes.Buffer{}, entries, 24)
+
+	{
+		// filter by words
+		for _, r := range "ubuntu zeus" {
+			picker.input(hostPickerKey{Kind: hostPickerKeyRune, Rune: r})
+		}
+		assert.Equal(t, []hostPickerEntry{entries[2]}, picker.filtered())
+
+		picker.input(hostPickerKey{Kind: hostPickerKeyClear})
+		assert.Equal(t, entries, picker.filtered())
+	}
+
+	{
+		// the cursor stays in the entries
+		picker.input(hostPickerKey{Kind: hostPickerKeyUp})
+		assert.Equal(t, 0, picker.cursor)
+
+		for i := 0; i < 5; i++ {
+			picker.input(hostPickerKey{Kind: hostPickerKeyDown})
+		}
+		assert.Equal(t, 2, picker.cursor)
+
+		// typing resets the cursor
+		picker.input(hostPickerKey{Kind: hostPickerKeyRune, Rune: 'r'})
+		assert.Equal(t, 0, picker.cursor)
+		picker.input(hostPickerKey{Kind: hostPickerKeyBackspace})
+	}
+
+	{
+		picker.input(hostPickerKey{Kind: hostPickerKeyDown})
+		selected, quit := picker.input(hostPickerKey{Kind: hostPickerKeyEnter})
+		assert.False(t, quit)
+		assert.Equal(t, entries[1], *selected)
+	}
+
+	{
+		// nothing is selected without matched entries
+		picker.input(hostPickerKey{Kind: hostPickerKeyRune, Rune: 'x'})
+		selected, quit := picker.input(hostPickerKey{Kind: hostPickerKeyEnter})
+		assert.Nil(t, selected)
+		assert.False(t, quit)
+	}
+
+	{
+		selected, quit := picker.input(hostPickerKey{Kind: hostPickerKeyQuit})
+		assert.Nil(t, selected)
+		assert.True(t, quit)
+	}
+}
+
+func TestHostPickerRender(t *testing.T) {
+	var entries []hostPickerEntry
+	for _, h := range []string{"a", "b", "c", "d", "e", "f"} {
+		entries = append(entries, hostPickerEntry{HostID: h, Account: "ubuntu"})
+	}
+
+	// only 3 entries are shown
+	picker := newHostPicker(&bytes.Buffer{}, entries, hostPickerHeight+3)
+
+	{
+		rendered := picker.render()
+		assert.Contains(t, rendered, "ubuntu+c")
+		assert.NotContains(t, rendered, "ubuntu+d")
+		assert.Contains(t, rendered, "3 more")
+		assert.NotContains(t, strings.Replace(rendered, "\r\n", "", -1), "\n")
+	}
+
+	{
+		// scrolled to the cursor
+		for i := 0; i < 4; i++ {
+			picker.input(hostPickerKey{Kind: hostPickerKeyDown})
+		}
+		rendered := picker.render()
+		assert.NotContains(t, rendered, "ubuntu+b")
+		assert.Contains(t, rendered, "ubuntu+e")
+		assert.Contains(t, rendered, "1 more")
+	}
+}
+
+func TestHostPickerRun(t *testing.T) {
+	entries := []hostPickerEntry{
+		{HostID: "prometeus", Account: "root"},
+		{HostID: "zeus", Account: "ubuntu"},
+	}
+
+	{
+		out := &bytes.Buffer{}
+		selected := newHostPicker(out, entries, 24).run(strings.NewReader("zeus\r"))
+		assert.Equal(t, entries[1], *selected)
+		assert.Contains(t, out.String(), "root+prometeus")
+	}
+
+	{
+		assert.Nil(t, newHostPicker(&bytes.Buffer{}, entries, 24).run(strings.NewReader("\x03")))
+		assert.Nil(t, newHostPicker(&bytes.Buffer{}, entries, 24).run(strings.NewReader("")))
+	}
+}
+
+func TestGetHostPickerEntries(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	zeus, _ := registry.AddHost("zeus", "zeus", uint64(22), []string{"ubuntu", "root"})
+	prometeus, _ := registry.AddHost("prometeus", "prometeus", uint64(22), []string{"ubuntu", "root"})
+	hera, _ := registry.AddHost("hera", "hera", uint64(22), []string{"ubuntu"})
+	athena, _ := registry.AddHost("athena", "athena", uint64(22), []string{"ubuntu"})
+
+	registry.LinkAll(user.ID, zeus.ID)
+	registry.Link(user.ID, prometeus.ID, "ubuntu")
+	registry.Link(user.ID, hera.ID, "ubuntu")
+	registry.Link(user.ID, athena.ID, "ubuntu")
+
+	// inactive host
+	hera.IsActive = false
+	registry.UpdateHost(hera.ID, hera)
+
+	// expired link
+	link, _ := registry.GetLink(user.ID, athena.ID)
+	link.DateExpires = time.Now().Add(-time.Second)
+	registry.UpdateLink(user.ID, athena.ID, link)
+
+	assert.Equal(
+		t,
+		[]hostPickerEntry{
+			{HostID: "prometeus", Account: "ubuntu"},
+			{HostID: "zeus", Account: "root"},
+			{HostID: "zeus", Account: "ubuntu"},
+		},
+		getHostPickerEntries(registry, user.ID),
+	)
+}
+
+func TestReplayRequests(t *testing.T) {
+	requests := make(chan *saultssh.Request, 1)
+	requests <- &saultssh.Request{Type: "window-change"}
+	close(requests)
+
+	done := make(chan struct{})
+	defer close(done)
+
+	var types []string
+	for request := range replayRequests(
+		[]*saultssh.Request{{Type: "pty-req"}, {Type: "shell"}},
+		requests,
+		done,
+	) {
+		types = append(types, request.Type)
+	}
+
+	assert.Equal(t, []string{"pty-req", "shell", "window-change"}, types)
+}
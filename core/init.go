@@ -48,6 +48,43 @@ var DefaultClientKey = "./sault-client.key"
 // DefaultSaultHostID is the default host name, which is running the sault server
 var DefaultSaultHostID = "sault-host"
 
+// DefaultRecordingDirectory is the default directory for the session recordings
+var DefaultRecordingDirectory = "./recordings"
+
+// DefaultBanMaxFailures is the default number of the authentication failures
+// before banned
+var DefaultBanMaxFailures = 10
+
+// DefaultBanWindow is the default duration to count the authentication failures
+var DefaultBanWindow = "10m"
+
+// DefaultBanTime is the default duration of the first ban
+var DefaultBanTime = "10m"
+
+// DefaultBanMaxBanTime is the default maximum duration of the ban
+var DefaultBanMaxBanTime = "24h"
+
+// DefaultSessionWarning is the default duration to warn the client before
+// closing the session by the idle timeout or the maximum session duration
+var DefaultSessionWarning = "1m"
+
+// DefaultInnerPoolMaxShared is the default maximum number of the proxied
+// connections, which share one inner connection; the default 'MaxSessions' of
+// OpenSSH is 10.
+var DefaultInnerPoolMaxShared = 8
+
+// DefaultUserCertValidity is the default validity of the user certificates,
+// which are signed by the user CA for the inner connections
+var DefaultUserCertValidity = "5m"
+
+// DefaultInnerPoolIdleTimeout is the default duration to keep the unused
+// inner connections
+var DefaultInnerPoolIdleTimeout = "5m"
+
+// DefaultInnerPoolKeepaliveInterval is the default interval to check the
+// inner connections
+var DefaultInnerPoolKeepaliveInterval = "30s"
+
 // DefaultServerPort is the default bind address of sault server
 var DefaultServerPort = uint64(2222)
 var defaultServerBind = fmt.Sprintf(":%d", DefaultServerPort)
@@ -55,7 +92,7 @@ var defaultServerBind = fmt.Sprintf(":%d", DefaultServerPort)
 // Command is the command interface for sault server
 type Command interface {
 	Request(allFlags []*saultflags.Flags, thisFlags *saultflags.Flags) error
-	Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *Config) error
+	Response(user saultregistry.UserRegistry, channel saultssh.Channel, msg saultcommon.CommandMsg, registry *saultregistry.Registry, config *Config, server *Server) error
 }
 
 // Commands is the collection of sault commands
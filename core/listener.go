@@ -0,0 +1,128 @@
+package sault
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spikeekips/sault/registry"
+)
+
+const (
+	// ListenerPolicyAll allows the all kind of connections
+	ListenerPolicyAll = "all"
+	// ListenerPolicyAdmin allows only the admin users inside sault, like
+	// the admin commands and the admin shell; the sessions to the hosts,
+	// like 'ubuntu+prometeus' are not allowed.
+	ListenerPolicyAdmin = "admin"
+	// ListenerPolicyProxy does not allow the admin commands and the admin
+	// shell; the connections to the hosts and the commands of users, like
+	// 'whoami' are allowed.
+	ListenerPolicyProxy = "proxy"
+)
+
+// unixConn is the connection thru the unix socket; the client of unix socket
+// has no address, so the socket path is used instead.
+type unixConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *unixConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+type listener struct {
+	net.Listener
+	config configListener
+}
+
+// listen starts to listen; the stale unix socket file, which was left by the
+// previous server, is removed.
+func listen(config configListener) (*listener, error) {
+	if config.GetNetwork() == "unix" {
+		if fi, err := os.Stat(config.GetAddress()); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			if conn, err := net.Dial("unix", config.GetAddress()); err == nil {
+				conn.Close()
+			} else {
+				os.Remove(config.GetAddress())
+			}
+		}
+	}
+
+	l, err := net.Listen(config.GetNetwork(), config.GetAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	return &listener{Listener: l, config: config}, nil
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	if l.config.GetNetwork() == "unix" {
+		conn = &unixConn{Conn: conn, remoteAddr: l.Addr()}
+	}
+
+	return conn, nil
+}
+
+// checkListenerPolicy checks whether the user can be authenticated by the
+// policy of listener
+func (c *connection) checkListenerPolicy(user saultregistry.UserRegistry, insideSault bool) error {
+	if c.policy != ListenerPolicyAdmin {
+		return nil
+	}
+
+	if !user.IsAdmin || !insideSault {
+		return &authenticationFailedError{
+			Err:    fmt.Errorf("listener policy, '%s' allows only the admin users inside sault", c.policy),
+			Reason: authFailedReasonListenerPolicy,
+		}
+	}
+
+	return nil
+}
+
+// isAdminAllowed checks whether the user can run the admin commands; the
+// 'proxy' listener does not allow them even for the admin users.
+func (c *connection) isAdminAllowed() bool {
+	return c.user.IsAdmin && c.policy != ListenerPolicyProxy
+}
+
+// serve accepts the client connections until the listener is closed; the
+// temporary errors, like too many open files, are retried.
+func (p *Server) serve(l *listener) error {
+	log.Infof("started to listen %s, %s; policy=%s", l.Addr().Network(), l.Addr().String(), l.config.Policy)
+
+	var delay time.Duration
+	for {
+		clientConn, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return nil
+			}
+
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				log.Errorf("failed to accept from %s: %v; retrying in %s", l.Addr(), err, delay)
+				time.Sleep(delay)
+				continue
+			}
+
+			return err
+		}
+		delay = 0
+
+		go p.acceptClientConn(clientConn, l.config)
+	}
+}
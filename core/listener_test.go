@@ -0,0 +1,146 @@
+package sault
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerRunListeners(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	// find the free port
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	tcpAddress := l.Addr().String()
+	l.Close()
+
+	socket := filepath.Join(directory, "sault.sock")
+
+	config := NewConfig()
+	config.SetBaseDirectory(directory)
+	config.Server.Listener = []configListener{
+		configListener{Bind: tcpAddress, Policy: ListenerPolicyProxy},
+		configListener{Bind: "unix:sault.sock", Policy: ListenerPolicyAdmin},
+	}
+	assert.Nil(t, config.validateServerListener())
+
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+	server, _ := NewServer(registry, config, nil, nil, DefaultSaultServerName)
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- server.Run()
+	}()
+
+	dial := func(network, address string) (conn net.Conn, err error) {
+		for i := 0; i < 50; i++ {
+			if conn, err = net.Dial(network, address); err == nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 20)
+		}
+		return
+	}
+
+	for _, a := range [][]string{{"tcp", tcpAddress}, {"unix", socket}} {
+		conn, err := dial(a[0], a[1])
+		assert.Nil(t, err, a[1])
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	server.Close()
+
+	select {
+	case err := <-stopped:
+		assert.Nil(t, err)
+	case <-time.After(time.Second * 3):
+		t.Error("listeners were not closed")
+	}
+
+	// the all listeners are closed
+	_, err := net.Dial("tcp", tcpAddress)
+	assert.NotNil(t, err)
+	_, err = os.Stat(socket)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestListenStaleUnixSocket(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	config := NewConfig()
+	config.SetBaseDirectory(directory)
+	config.Server.Listener = []configListener{configListener{Bind: "unix:sault.sock"}}
+	assert.Nil(t, config.validateServerListener())
+
+	// the socket file is left without the listener
+	stale, _ := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(directory, "sault.sock"), Net: "unix"})
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	l, err := listen(config.Server.GetListeners()[0])
+	assert.Nil(t, err)
+	if l == nil {
+		return
+	}
+
+	{
+		// the socket of the running listener is not removed
+		_, err := listen(config.Server.GetListeners()[0])
+		assert.NotNil(t, err)
+	}
+
+	go func() {
+		if conn, err := net.Dial("unix", filepath.Join(directory, "sault.sock")); err == nil {
+			conn.Close()
+		}
+	}()
+
+	conn, err := l.Accept()
+	assert.Nil(t, err)
+	assert.Equal(t, l.Addr(), conn.RemoteAddr())
+	conn.Close()
+	l.Close()
+}
+
+func TestListenerPolicy(t *testing.T) {
+	admin := saultregistry.UserRegistry{ID: "spikeekips", IsAdmin: true}
+	user := saultregistry.UserRegistry{ID: "findkeys"}
+
+	cases := []struct {
+		policy       string
+		user         saultregistry.UserRegistry
+		insideSault  bool
+		allowed      bool
+		adminAllowed bool
+	}{
+		{ListenerPolicyAll, admin, true, true, true},
+		{ListenerPolicyAll, user, false, true, false},
+		{ListenerPolicyAdmin, admin, true, true, true},
+		{ListenerPolicyAdmin, admin, false, false, true},
+		{ListenerPolicyAdmin, user, true, false, false},
+		{ListenerPolicyProxy, admin, true, true, false},
+		{ListenerPolicyProxy, user, false, true, false},
+	}
+
+	for _, i := range cases {
+		c := &connection{policy: i.policy, user: i.user, log: log.WithFields(logrus.Fields{})}
+		err := c.checkListenerPolicy(i.user, i.insideSault)
+		if i.allowed {
+			assert.Nil(t, err, "%v", i)
+		} else {
+			assert.IsType(t, &authenticationFailedError{}, err, "%v", i)
+		}
+		assert.Equal(t, i.adminAllowed, c.isAdminAllowed(), "%v", i)
+	}
+}
@@ -0,0 +1,335 @@
+package sault
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spikeekips/sault/registry"
+)
+
+// metricVec is the counter or gauge with labels, which is exported in the
+// prometheus text format.
+type metricVec struct {
+	sync.RWMutex
+	name   string
+	help   string
+	kind   string
+	labels []string
+	values map[string]*int64
+}
+
+func newMetricVec(kind, name, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: map[string]*int64{},
+	}
+}
+
+func newCounterVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("counter", name, help, labels...)
+}
+
+func newGaugeVec(name, help string, labels ...string) *metricVec {
+	return newMetricVec("gauge", name, help, labels...)
+}
+
+// with returns the value of labels; it can be updated by sync/atomic.
+func (m *metricVec) with(labelValues ...string) *int64 {
+	key := m.key(labelValues)
+
+	m.RLock()
+	v, ok := m.values[key]
+	m.RUnlock()
+	if ok {
+		return v
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if v, ok = m.values[key]; !ok {
+		v = new(int64)
+		m.values[key] = v
+	}
+
+	return v
+}
+
+func (m *metricVec) add(n int64, labelValues ...string) {
+	atomic.AddInt64(m.with(labelValues...), n)
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) key(labelValues []string) string {
+	if len(labelValues) != len(m.labels) {
+		panic(fmt.Errorf("metric, '%s' needs %d labels, but %d given", m.name, len(m.labels), len(labelValues)))
+	}
+
+	pairs := make([]string, len(labelValues))
+	for i, v := range labelValues {
+		pairs[i] = fmt.Sprintf("%s=\"%s\"", m.labels[i], escapeMetricLabelValue(v))
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.RLock()
+	defer m.RUnlock()
+
+	writeMetricHeader(w, m.name, m.help, m.kind)
+
+	for _, key := range sortedMetricKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %d\n", m.name, wrapMetricLabels(key), atomic.LoadInt64(m.values[key]))
+	}
+}
+
+type metricHistogram struct {
+	sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// metricHistogramVec is the histogram with labels
+type metricHistogramVec struct {
+	*metricVec
+	buckets    []float64
+	histograms map[string]*metricHistogram
+}
+
+var defaultMetricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricHistogramVec {
+	return &metricHistogramVec{
+		metricVec:  newMetricVec("histogram", name, help, labels...),
+		buckets:    buckets,
+		histograms: map[string]*metricHistogram{},
+	}
+}
+
+func (m *metricHistogramVec) observe(v float64, labelValues ...string) {
+	key := m.key(labelValues)
+
+	m.Lock()
+	h, ok := m.histograms[key]
+	if !ok {
+		h = &metricHistogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	m.Unlock()
+
+	h.Lock()
+	defer h.Unlock()
+
+	for i, b := range m.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (m *metricHistogramVec) observeSince(started time.Time, labelValues ...string) {
+	m.observe(time.Since(started).Seconds(), labelValues...)
+}
+
+func (m *metricHistogramVec) write(w io.Writer) {
+	m.RLock()
+	defer m.RUnlock()
+
+	writeMetricHeader(w, m.name, m.help, m.kind)
+
+	keys := make([]string, 0, len(m.histograms))
+	for key := range m.histograms {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		h := m.histograms[key]
+		h.Lock()
+
+		prefix := key
+		if len(prefix) > 0 {
+			prefix += ","
+		}
+		for i, b := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket{%sle=\"%s\"} %d\n", m.name, prefix, formatMetricFloat(b), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket{%sle=\"+Inf\"} %d\n", m.name, prefix, h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, wrapMetricLabels(key), formatMetricFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, wrapMetricLabels(key), h.count)
+
+		h.Unlock()
+	}
+}
+
+func writeMetricHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+func wrapMetricLabels(key string) string {
+	if len(key) < 1 {
+		return ""
+	}
+
+	return "{" + key + "}"
+}
+
+func escapeMetricLabelValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func formatMetricFloat(v float64) string {
+	if math.IsInf(v, +1) {
+		return "+Inf"
+	}
+
+	return fmt.Sprintf("%g", v)
+}
+
+func sortedMetricKeys(values map[string]*int64) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+type metrics struct {
+	authTotal         *metricVec
+	innerDialDuration *metricHistogramVec
+	innerDialErrors   *metricVec
+	proxiedBytes      *metricVec
+	commandsTotal     *metricVec
+	commandDuration   *metricHistogramVec
+	registryFailures  *metricVec
+	bannedConnections *metricVec
+	bans              *metricVec
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		authTotal: newCounterVec(
+			"sault_auth_total",
+			"authentication attempts by result and reason",
+			"result", "reason",
+		),
+		innerDialDuration: newHistogramVec(
+			"sault_inner_dial_duration_seconds",
+			"latency of connecting to the inner hosts",
+			defaultMetricBuckets,
+			"host",
+		),
+		innerDialErrors: newCounterVec(
+			"sault_inner_dial_errors_total",
+			"failures of connecting to the inner hosts",
+			"host",
+		),
+		proxiedBytes: newCounterVec(
+			"sault_proxied_bytes_total",
+			"bytes proxied between the clients and the inner hosts",
+			"host", "direction",
+		),
+		commandsTotal: newCounterVec(
+			"sault_commands_total",
+			"sault commands by result",
+			"command", "result",
+		),
+		commandDuration: newHistogramVec(
+			"sault_command_duration_seconds",
+			"duration of sault commands",
+			defaultMetricBuckets,
+			"command",
+		),
+		registryFailures: newCounterVec(
+			"sault_registry_failures_total",
+			"failures of loading and saving the registry sources",
+			"operation", "source",
+		),
+		bannedConnections: newCounterVec(
+			"sault_banned_connections_total",
+			"connections and authentications rejected by the bans",
+			"kind",
+		),
+		bans: newCounterVec(
+			"sault_bans_total",
+			"bans by the repeated authentication failures",
+			"kind",
+		),
+	}
+}
+
+// serverMetrics collects the metrics of sault server
+var serverMetrics = newMetrics()
+
+// CountRegistrySourceError counts the failures of registry source; it can be
+// used for saultregistry.Registry.OnSourceError.
+func CountRegistrySourceError(operation string, source saultregistry.RegistrySource, err error) {
+	serverMetrics.registryFailures.inc(operation, source.GetType())
+}
+
+func (m *metrics) write(w io.Writer, sessions *SessionRegistry, innerClients *innerClientPool) {
+	connections := newGaugeVec("sault_connections_active", "active connections by host", "host")
+	channels := newGaugeVec("sault_sessions_active", "active session channels by host", "host")
+	for _, s := range sessions.GetSessions() {
+		connections.inc(s.HostID)
+		for _, ch := range s.Channels {
+			if ch.Type == "session" {
+				channels.inc(s.HostID)
+			}
+		}
+	}
+
+	connections.write(w)
+	channels.write(w)
+
+	if innerClients != nil {
+		inners := newGaugeVec("sault_inner_connections_active", "inner connections to the hosts by host", "host")
+		for hostID, n := range innerClients.count() {
+			inners.add(int64(n), hostID)
+		}
+		inners.write(w)
+	}
+
+	m.authTotal.write(w)
+	m.innerDialDuration.write(w)
+	m.innerDialErrors.write(w)
+	m.proxiedBytes.write(w)
+	m.commandsTotal.write(w)
+	m.commandDuration.write(w)
+	m.registryFailures.write(w)
+	m.bannedConnections.write(w)
+	m.bans.write(w)
+}
+
+func (p *Server) runMetricsServer(bind string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		serverMetrics.write(w, p.sessions, p.innerClients)
+	})
+
+	log.Infof("started to listen metrics, http://%s/metrics", bind)
+	if err := http.ListenAndServe(bind, mux); err != nil {
+		log.Errorf("failed to run metrics server: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package sault
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricVec(t *testing.T) {
+	m := newCounterVec("sault_test_total", "test counter", "host", "result")
+	m.inc("prometeus", "success")
+	m.inc("prometeus", "success")
+	m.add(3, "athena", "fail\"ed")
+
+	var b bytes.Buffer
+	m.write(&b)
+
+	expected := `# HELP sault_test_total test counter
+# TYPE sault_test_total counter
+sault_test_total{host="athena",result="fail\"ed"} 3
+sault_test_total{host="prometeus",result="success"} 2
+`
+	assert.Equal(t, expected, b.String())
+}
+
+func TestMetricHistogramVec(t *testing.T) {
+	m := newHistogramVec("sault_test_seconds", "test histogram", []float64{0.1, 1}, "host")
+	m.observe(0.05, "prometeus")
+	m.observe(0.5, "prometeus")
+	m.observe(5, "prometeus")
+
+	var b bytes.Buffer
+	m.write(&b)
+
+	expected := `# HELP sault_test_seconds test histogram
+# TYPE sault_test_seconds histogram
+sault_test_seconds_bucket{host="prometeus",le="0.1"} 1
+sault_test_seconds_bucket{host="prometeus",le="1"} 2
+sault_test_seconds_bucket{host="prometeus",le="+Inf"} 3
+sault_test_seconds_sum{host="prometeus"} 5.55
+sault_test_seconds_count{host="prometeus"} 3
+`
+	assert.Equal(t, expected, b.String())
+}
+
+func TestMetricsActiveSessions(t *testing.T) {
+	sessions := NewSessionRegistry()
+
+	c := &connection{
+		id:   "a",
+		log:  log.WithFields(logrus.Fields{}),
+		user: saultregistry.UserRegistry{ID: "spikeekips"},
+		host: saultregistry.HostRegistry{ID: "prometeus"},
+	}
+	c.addChannel("a-1", "session", nil, nil)
+	c.addChannel("a-2", "direct-tcpip", nil, nil)
+	sessions.add(c)
+
+	var b bytes.Buffer
+	newMetrics().write(&b, sessions, nil)
+
+	lines := strings.Split(b.String(), "\n")
+	assert.Contains(t, lines, `sault_connections_active{host="prometeus"} 1`)
+	assert.Contains(t, lines, `sault_sessions_active{host="prometeus"} 1`)
+}
@@ -0,0 +1,365 @@
+package sault
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// innerClientKey is the key of the shared inner connections
+type innerClientKey struct {
+	HostID  string
+	Account string
+
+	// Addresses is the addresses of host, joined by ','
+	Addresses string
+
+	// SessionID, if set, the inner connection is not shared with the other
+	// connections, like for the agent forwarding.
+	SessionID string
+
+	// User, if set, the inner connection is authenticated by the user
+	// certificate of this user, which is signed by the user CA.
+	User string
+
+	// BreakGlass, if true, the user is not linked to the host; the user
+	// certificate has the default permissions of link.
+	BreakGlass bool
+}
+
+func newInnerClientKey(host saultregistry.HostRegistry, account string) innerClientKey {
+	return innerClientKey{
+		HostID:    host.ID,
+		Account:   account,
+		Addresses: strings.Join(host.GetAddresses(), ","),
+	}
+}
+
+func (k innerClientKey) getAddresses() []string {
+	return strings.Split(k.Addresses, ",")
+}
+
+func (k innerClientKey) String() string {
+	return fmt.Sprintf("%s+%s(%s)", k.Account, k.HostID, k.Addresses)
+}
+
+// pooledInnerClient is the inner connection, which is shared by the proxied
+// connections.
+type pooledInnerClient struct {
+	*saultcommon.SSHClient
+	key      innerClientKey
+	refs     int
+	lastUsed time.Time
+	broken   bool
+
+	// ready is closed after dialed; err is the result of dialing
+	ready chan struct{}
+	err   error
+}
+
+func (c *pooledInnerClient) close() {
+	if c.SSHClient == nil {
+		return
+	}
+
+	c.SSHClient.Close()
+}
+
+func (c *pooledInnerClient) isReady() bool {
+	select {
+	case <-c.ready:
+		return c.err == nil
+	default:
+		return false
+	}
+}
+
+// innerClientPool keeps the inner connections to the hosts by host and
+// account; the proxied channels of the multiple client connections are
+// multiplexed over the one inner connection. The idle connections are closed
+// after IdleTimeout and the broken connections are detected by the keepalive.
+type innerClientPool struct {
+	sync.Mutex
+	clients map[innerClientKey][]*pooledInnerClient
+
+	maxShared         int
+	idleTimeout       time.Duration
+	keepaliveInterval time.Duration
+
+	dial func(key innerClientKey) (*saultcommon.SSHClient, error)
+	ping func(client *saultcommon.SSHClient) error
+	wait func(client *saultcommon.SSHClient) error
+	now  func() time.Time
+}
+
+func newInnerClientPool(
+	config configInnerPool,
+	dial func(innerClientKey) (*saultcommon.SSHClient, error),
+) *innerClientPool {
+	p := &innerClientPool{
+		clients:           map[innerClientKey][]*pooledInnerClient{},
+		maxShared:         config.MaxShared,
+		idleTimeout:       config.GetIdleTimeout(),
+		keepaliveInterval: config.GetKeepaliveInterval(),
+		dial:              dial,
+		ping:              pingInnerClient,
+		wait:              waitInnerClient,
+		now:               time.Now,
+	}
+
+	if !config.Enabled || p.maxShared < 1 {
+		// every proxied connection has it's own inner connection
+		p.maxShared = 1
+		p.idleTimeout = 0
+	}
+
+	return p
+}
+
+func pingInnerClient(client *saultcommon.SSHClient) error {
+	_, _, err := client.Client.SendRequest("keepalive@openssh.com", true, nil)
+	return err
+}
+
+func waitInnerClient(client *saultcommon.SSHClient) error {
+	return client.Client.Wait()
+}
+
+// get returns the shared inner connection; if there is no available
+// connection, new connection will be made. The returned connection must be
+// released by release().
+func (p *innerClientPool) get(key innerClientKey) (*pooledInnerClient, error) {
+	p.Lock()
+
+	var client *pooledInnerClient
+	for _, c := range p.clients[key] {
+		if !c.broken && c.refs < p.maxShared {
+			client = c
+			break
+		}
+	}
+
+	created := client == nil
+	if created {
+		client = &pooledInnerClient{key: key, ready: make(chan struct{})}
+		p.clients[key] = append(p.clients[key], client)
+	}
+	client.refs++
+	p.Unlock()
+
+	if created {
+		sshClient, err := p.dial(key)
+
+		p.Lock()
+		client.SSHClient = sshClient
+		client.err = err
+		if err != nil {
+			client.broken = true
+			p.remove(client)
+		}
+		p.Unlock()
+
+		close(client.ready)
+
+		if err == nil && p.wait != nil {
+			go func() {
+				err := p.wait(sshClient)
+				log.Debugf("inner connection, %s closed: %v", key, err)
+				p.discard(client)
+			}()
+		}
+	} else {
+		<-client.ready
+	}
+
+	if client.err != nil {
+		p.release(client)
+		return nil, client.err
+	}
+
+	return client, nil
+}
+
+// release returns the connection to the pool
+func (p *innerClientPool) release(client *pooledInnerClient) {
+	p.Lock()
+
+	client.refs--
+	client.lastUsed = p.now()
+
+	var willClose bool
+	if client.refs < 1 && (client.broken || p.idleTimeout <= 0 || len(client.key.SessionID) > 0) {
+		p.remove(client)
+		willClose = true
+	}
+	p.Unlock()
+
+	if willClose {
+		client.close()
+	}
+}
+
+// discard removes the broken connection from the pool and closes it; the
+// proxied channels thru it will be closed.
+func (p *innerClientPool) discard(client *pooledInnerClient) {
+	p.Lock()
+	client.broken = true
+	p.remove(client)
+	p.Unlock()
+
+	client.close()
+}
+
+func (p *innerClientPool) remove(client *pooledInnerClient) {
+	clients := p.clients[client.key]
+	for i, c := range clients {
+		if c != client {
+			continue
+		}
+
+		clients = append(clients[:i], clients[i+1:]...)
+		break
+	}
+
+	if len(clients) < 1 {
+		delete(p.clients, client.key)
+		return
+	}
+
+	p.clients[client.key] = clients
+}
+
+// evict closes the connections, which are not used during IdleTimeout
+func (p *innerClientPool) evict() (evicted int) {
+	p.Lock()
+
+	now := p.now()
+	var idles []*pooledInnerClient
+	for _, clients := range p.clients {
+		for _, c := range clients {
+			if c.refs > 0 || !c.isReady() || now.Sub(c.lastUsed) < p.idleTimeout {
+				continue
+			}
+			idles = append(idles, c)
+		}
+	}
+	for _, c := range idles {
+		p.remove(c)
+	}
+	p.Unlock()
+
+	for _, c := range idles {
+		log.Debugf("idle inner connection, %s evicted", c.key)
+		c.close()
+	}
+
+	return len(idles)
+}
+
+// keepalive checks the connections and discards the broken
+func (p *innerClientPool) keepalive() (discarded int) {
+	var clients []*pooledInnerClient
+
+	p.Lock()
+	for _, cs := range p.clients {
+		for _, c := range cs {
+			if c.isReady() && !c.broken {
+				clients = append(clients, c)
+			}
+		}
+	}
+	p.Unlock()
+
+	for _, c := range clients {
+		if err := p.ping(c.SSHClient); err != nil {
+			log.Errorf("inner connection, %s is broken: %v", c.key, err)
+			p.discard(c)
+			discarded++
+		}
+	}
+
+	return
+}
+
+// count returns the number of connections by host
+func (p *innerClientPool) count() map[string]int {
+	p.Lock()
+	defer p.Unlock()
+
+	counts := map[string]int{}
+	for key, clients := range p.clients {
+		counts[key.HostID] += len(clients)
+	}
+
+	return counts
+}
+
+func (p *innerClientPool) run() {
+	if p.idleTimeout <= 0 || p.keepaliveInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.keepaliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		p.evict()
+		p.keepalive()
+	}
+}
+
+// dialInnerClient connects to the addresses of host in turn; the recently
+// failed addresses are tried at last.
+func (p *Server) dialInnerClient(key innerClientKey) (*saultcommon.SSHClient, error) {
+	timeout := defaultTimeoutProxyClient
+	if p.config != nil && p.config.Server.GetInnerDialTimeout() > 0 {
+		timeout = p.config.Server.GetInnerDialTimeout()
+	}
+
+	// with the user CA, only the user certificate is offered; the client key
+	// is tried after it only with UserCAClientKeyFallback.
+	signers := []saultssh.Signer{p.clientKeySigner}
+	if len(key.User) > 0 && p.getUserCASigner() != nil {
+		certSigner, err := p.newUserCertSigner(key)
+		if err != nil {
+			log.Errorf("failed to sign user certificate for %s: %v", key, err)
+			return nil, err
+		}
+
+		signers = []saultssh.Signer{certSigner}
+		if p.config.Server.UserCAClientKeyFallback {
+			log.Warnf("the client key is offered after the user certificate for %s", key)
+			signers = append(signers, p.clientKeySigner)
+		}
+	}
+
+	var errs []string
+	for _, address := range p.hostHealth.order(key.getAddresses()) {
+		client := saultcommon.NewSSHClient(key.Account, address)
+		client.AddAuthMethod(saultssh.PublicKeys(signers...))
+		client.SetTimeout(timeout)
+
+		started := time.Now()
+		if err := client.Connect(); err != nil {
+			serverMetrics.innerDialErrors.inc(key.HostID)
+			p.hostHealth.failed(address, err)
+
+			log.Errorf("failed to connect to %s, '%s': %v", key.HostID, address, err)
+			errs = append(errs, fmt.Sprintf("%s: %v", address, err))
+			continue
+		}
+		serverMetrics.innerDialDuration.observeSince(started, key.HostID)
+		p.hostHealth.succeeded(address)
+
+		log.Debugf("new inner connection, %s thru '%s'", key, address)
+
+		return client, nil
+	}
+
+	return nil, fmt.Errorf("failed to connect to %s; %s", key.HostID, strings.Join(errs, ", "))
+}
@@ -0,0 +1,183 @@
+package sault
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestInnerClientPool(t *testing.T, setup func(*Config)) (*innerClientPool, *int32, *time.Time) {
+	config := NewConfig()
+	if setup != nil {
+		setup(config)
+	}
+	assert.Nil(t, config.validateInnerPool())
+
+	var dialed int32
+	now := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	p := newInnerClientPool(config.InnerPool, func(key innerClientKey) (*saultcommon.SSHClient, error) {
+		atomic.AddInt32(&dialed, 1)
+		if key.Account == "failed" {
+			return nil, fmt.Errorf("failed to dial")
+		}
+
+		return &saultcommon.SSHClient{}, nil
+	})
+	p.ping = func(*saultcommon.SSHClient) error { return nil }
+	p.wait = nil
+	p.now = func() time.Time { return now }
+
+	return p, &dialed, &now
+}
+
+func TestInnerClientPoolShared(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, func(c *Config) {
+		c.InnerPool.MaxShared = 2
+	})
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+
+	a, err := p.get(key)
+	assert.Nil(t, err)
+	b, _ := p.get(key)
+	assert.Equal(t, a, b)
+	assert.Equal(t, int32(1), atomic.LoadInt32(dialed))
+
+	// exceeds MaxShared
+	c, _ := p.get(key)
+	assert.NotEqual(t, a, c)
+	assert.Equal(t, int32(2), atomic.LoadInt32(dialed))
+
+	// different account
+	d, _ := p.get(innerClientKey{HostID: "prometeus", Account: "root", Addresses: "prometeus:22"})
+	assert.NotEqual(t, a, d)
+	assert.Equal(t, map[string]int{"prometeus": 3}, p.count())
+
+	// released connection is reused
+	p.release(a)
+	e, _ := p.get(key)
+	assert.Equal(t, a, e)
+	assert.Equal(t, int32(3), atomic.LoadInt32(dialed))
+}
+
+func TestInnerClientPoolConcurrentDial(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, nil)
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < DefaultInnerPoolMaxShared; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.get(key)
+			assert.Nil(t, err)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(dialed))
+}
+
+func TestInnerClientPoolDialError(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, nil)
+
+	key := innerClientKey{HostID: "prometeus", Account: "failed", Addresses: "prometeus:22"}
+
+	_, err := p.get(key)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(p.count()))
+
+	// failed connection is not reused
+	_, err = p.get(key)
+	assert.NotNil(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(dialed))
+}
+
+func TestInnerClientPoolEvict(t *testing.T) {
+	p, _, now := newTestInnerClientPool(t, func(c *Config) {
+		c.InnerPool.IdleTimeout = "5m"
+	})
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+
+	a, _ := p.get(key)
+	b, _ := p.get(innerClientKey{HostID: "prometeus", Account: "root", Addresses: "prometeus:22"})
+	p.release(a)
+
+	*now = now.Add(time.Minute * 4)
+	assert.Equal(t, 0, p.evict())
+
+	// the connection in use is not evicted
+	*now = now.Add(time.Minute * 2)
+	assert.Equal(t, 1, p.evict())
+	assert.Equal(t, map[string]int{"prometeus": 1}, p.count())
+
+	p.release(b)
+	assert.Equal(t, map[string]int{"prometeus": 1}, p.count())
+}
+
+func TestInnerClientPoolKeepalive(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, nil)
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+
+	a, _ := p.get(key)
+	assert.Equal(t, 0, p.keepalive())
+
+	p.ping = func(*saultcommon.SSHClient) error { return fmt.Errorf("broken") }
+	assert.Equal(t, 1, p.keepalive())
+	assert.Equal(t, 0, len(p.count()))
+
+	// broken connection is not reused
+	b, _ := p.get(key)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, int32(2), atomic.LoadInt32(dialed))
+
+	p.release(a)
+	assert.Equal(t, map[string]int{"prometeus": 1}, p.count())
+}
+
+func TestInnerClientPoolDisabled(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, func(c *Config) {
+		c.InnerPool.Enabled = false
+	})
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+
+	a, _ := p.get(key)
+	b, _ := p.get(key)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, int32(2), atomic.LoadInt32(dialed))
+
+	// closed after released
+	p.release(a)
+	p.release(b)
+	assert.Equal(t, 0, len(p.count()))
+}
+
+func TestInnerClientPoolNotShared(t *testing.T) {
+	p, dialed, _ := newTestInnerClientPool(t, func(c *Config) {
+		c.InnerPool.MaxShared = 2
+	})
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", Addresses: "prometeus:22"}
+	a, _ := p.get(key)
+
+	// the inner connection with SessionID is not shared
+	key.SessionID = "session-0"
+	b, _ := p.get(key)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, int32(2), atomic.LoadInt32(dialed))
+
+	// closed after released, even if IdleTimeout is set
+	p.release(a)
+	p.release(b)
+	assert.Equal(t, map[string]int{"prometeus": 1}, p.count())
+}
@@ -1,7 +1,10 @@
 package sault
 
 import (
+	"fmt"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/spikeekips/sault/registry"
 	"github.com/spikeekips/sault/saultssh"
@@ -14,6 +17,16 @@ type Server struct {
 	config          *Config
 	hostKeySigner   saultssh.Signer
 	clientKeySigner saultssh.Signer
+	auditor         *auditor
+	sessions        *SessionRegistry
+	bans            *BanRegistry
+	innerClients    *innerClientPool
+	hostHealth      *HostHealthRegistry
+	totpCounters    *totpReplayGuard
+
+	listenersLock sync.Mutex
+	listeners     []*listener
+	closed        bool
 }
 
 // NewServer makes server
@@ -24,42 +37,216 @@ func NewServer(
 	clientKeySigner saultssh.Signer,
 	saultServerName string,
 ) (*Server, error) {
-	return &Server{
+	var auditSinks []AuditSink
+	bans := NewBanRegistry()
+	if config != nil {
+		auditSinks = config.Audit.GetSinks()
+		bans.setup(config.Ban)
+	}
+
+	innerPoolConfig := configInnerPool{}
+	if config != nil {
+		innerPoolConfig = config.InnerPool
+	}
+
+	server := &Server{
 		saultServerName: saultServerName,
 		registry:        registry,
 		config:          config,
 		hostKeySigner:   hostKeySigner,
 		clientKeySigner: clientKeySigner,
-	}, nil
+		auditor:         newAuditor(auditSinks),
+		sessions:        NewSessionRegistry(),
+		bans:            bans,
+		hostHealth:      NewHostHealthRegistry(),
+		totpCounters:    newTOTPReplayGuard(),
+	}
+	server.innerClients = newInnerClientPool(innerPoolConfig, server.dialInnerClient)
+
+	return server, nil
+}
+
+// Sessions returns the active connections of server
+func (p *Server) Sessions() *SessionRegistry {
+	return p.sessions
+}
+
+// Bans returns the authentication failures and bans of server
+func (p *Server) Bans() *BanRegistry {
+	return p.bans
+}
+
+// HostHealth returns the last-known reachability of the host addresses
+func (p *Server) HostHealth() *HostHealthRegistry {
+	return p.hostHealth
+}
+
+// Run runs sault server; it listens the all listeners of config and returns
+// after they are closed by Close(). If one of them fails, the others are
+// also closed.
+func (p *Server) Run() (err error) {
+	if p.config == nil || len(p.config.Server.GetListeners()) < 1 {
+		return fmt.Errorf("listeners are not configured")
+	}
+
+	var listeners []*listener
+	for _, c := range p.config.Server.GetListeners() {
+		var l *listener
+		if l, err = listen(c); err != nil {
+			log.Error(err)
+			for _, l := range listeners {
+				l.Close()
+			}
+			return
+		}
+		listeners = append(listeners, l)
+	}
+
+	p.listenersLock.Lock()
+	if p.closed {
+		p.listenersLock.Unlock()
+		for _, l := range listeners {
+			l.Close()
+		}
+		return nil
+	}
+	p.listeners = listeners
+	p.listenersLock.Unlock()
+
+	go p.removeOldRecordings()
+	go p.removeExpiredLinks()
+	go p.innerClients.run()
+
+	if len(p.config.Server.MetricsBind) > 0 {
+		go p.runMetricsServer(p.config.Server.MetricsBind)
+	}
+
+	errs := make(chan error, len(listeners))
+	var wg sync.WaitGroup
+	for _, l := range listeners {
+		wg.Add(1)
+		go func(l *listener) {
+			defer wg.Done()
+
+			if err := p.serve(l); err != nil {
+				log.Errorf("listener, %s stopped: %v", l.Addr(), err)
+				errs <- err
+				p.Close()
+			}
+		}(l)
+	}
+	wg.Wait()
+
+	log.Infof("all listeners closed")
+
+	select {
+	case err = <-errs:
+	default:
+	}
+
+	return
 }
 
-// Run runs sault server
-func (p *Server) Run(bind string) (err error) {
-	var listener net.Listener
-	listener, err = net.Listen("tcp", bind)
+// Close stops the all listeners of Run(); the connected clients are not
+// closed.
+func (p *Server) Close() error {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+
+	p.closed = true
+	for _, l := range p.listeners {
+		l.Close()
+	}
+
+	return nil
+}
+
+func (p *Server) isClosed() bool {
+	p.listenersLock.Lock()
+	defer p.listenersLock.Unlock()
+
+	return p.closed
+}
+
+// acceptClientConn reads the PROXY protocol header from the trusted
+// upstream and starts the connection; the real client address is used for
+// the ban and the connection.
+func (p *Server) acceptClientConn(conn net.Conn, listenerConfig configListener) {
+	clientConn, err := p.acceptProxyProtocol(conn)
 	if err != nil {
 		log.Error(err)
+		conn.Close()
+		return
+	}
+
+	address := getAddressFromRemoteAddr(clientConn.RemoteAddr())
+	if p.bans.isBanned(BanKindAddress, address) {
+		log.Debugf("connection from banned address, '%s' rejected", address)
+		serverMetrics.bannedConnections.inc(BanKindAddress)
+		clientConn.Close()
 		return
 	}
-	defer listener.Close()
 
-	log.Infof("started to listen %s", listener.Addr().String())
+	if _, err = newConnection(p, clientConn, listenerConfig.Policy); err != nil {
+		log.Error(err)
+		clientConn.Close()
+	}
+}
+
+var intervalRemoveOldRecordings = time.Hour
+
+var intervalRemoveExpiredLinks = time.Minute
 
+func (p *Server) removeExpiredLinks() {
 	for {
-		var clientConn net.Conn
-		clientConn, err = listener.Accept()
+		p.removeExpiredLinksAt(time.Now())
+
+		time.Sleep(intervalRemoveExpiredLinks)
+	}
+}
+
+// removeExpiredLinksAt removes the expired links, like the temporary links of
+// the approved access requests, from the registry and audits them.
+func (p *Server) removeExpiredLinksAt(t time.Time) {
+	expired := p.registry.RemoveExpiredLinks(t)
+	if len(expired) < 1 {
+		return
+	}
+
+	if err := p.registry.Save(); err != nil {
+		log.Errorf("failed to save registry after removing expired links: %v", err)
+	}
+
+	for _, l := range expired {
+		log.Debugf("expired link removed, user=%s host=%s account=%s", l.UserID, l.HostID, l.Account)
+		p.auditor.emit(AuditEvent{
+			Type:    AuditEventLinkExpired,
+			User:    l.UserID,
+			Host:    l.HostID,
+			Account: l.Account,
+			Data: map[string]interface{}{
+				"date_expires": l.DateExpires,
+			},
+		})
+	}
+}
+
+func (p *Server) removeOldRecordings() {
+	if p.config == nil || !p.config.Recording.Enabled || p.config.Recording.GetMaxAge() <= 0 {
+		return
+	}
+
+	for {
+		removed, err := removeOldRecordings(
+			p.config.Recording.GetDirectory(),
+			p.config.Recording.GetMaxAge(),
+		)
 		if err != nil {
-			log.Error(err)
-			clientConn.Close()
-			continue
+			log.Errorf("failed to remove old recordings: %v", err)
+		} else if removed > 0 {
+			log.Debugf("%d old recordings removed", removed)
 		}
 
-		if _, err = newConnection(p, clientConn); err != nil {
-			log.Error(err)
-			clientConn.Close()
-			continue
-		}
+		time.Sleep(intervalRemoveOldRecordings)
 	}
-
-	return nil
 }
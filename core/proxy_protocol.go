@@ -0,0 +1,191 @@
+package sault
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// proxyProtocolTimeout is the timeout to read the PROXY protocol header from
+// the upstream
+var proxyProtocolTimeout = time.Second * 5
+
+const (
+	// proxyProtocolV1MaxLength is the maximum length of the v1 header,
+	// including CRLF
+	proxyProtocolV1MaxLength = 107
+
+	// proxyProtocolV2MaxLength limits the length of the addresses and TLVs
+	// of the v2 header
+	proxyProtocolV2MaxLength = 4096
+)
+
+var proxyProtocolV1Signature = []byte("PROXY ")
+
+var proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyProtocolConn is the connection from the trusted upstream; RemoteAddr()
+// returns the client address in the PROXY protocol header.
+type proxyProtocolConn struct {
+	net.Conn
+	reader     *bufio.Reader
+	remoteAddr net.Addr
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// acceptProxyProtocol reads the PROXY protocol header, if the connection is
+// from the trusted upstream; the other connections are returned as they are.
+func (p *Server) acceptProxyProtocol(conn net.Conn) (net.Conn, error) {
+	if p.config == nil || !p.config.Server.IsProxyProtocolUpstream(conn.RemoteAddr()) {
+		return conn, nil
+	}
+
+	conn.SetReadDeadline(time.Now().Add(proxyProtocolTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	reader := bufio.NewReader(conn)
+	remoteAddr, err := readProxyProtocolHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY protocol header from %s: %v", conn.RemoteAddr(), err)
+	}
+
+	// the LOCAL or UNKNOWN header, like the health check of upstream, keeps
+	// the address of upstream
+	if remoteAddr == nil {
+		remoteAddr = conn.RemoteAddr()
+	}
+
+	log.Debugf("PROXY protocol header from %s: client address is %s", conn.RemoteAddr(), remoteAddr)
+
+	return &proxyProtocolConn{Conn: conn, reader: reader, remoteAddr: remoteAddr}, nil
+}
+
+// readProxyProtocolHeader reads the PROXY protocol v1 or v2 header; if the
+// header does not have the client address, nil is returned.
+func readProxyProtocolHeader(reader *bufio.Reader) (net.Addr, error) {
+	// both of the v1 and v2 headers are longer than the v2 signature
+	b, err := reader.Peek(len(proxyProtocolV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.Equal(b, proxyProtocolV2Signature):
+		return readProxyProtocolV2Header(reader)
+	case bytes.HasPrefix(b, proxyProtocolV1Signature):
+		return readProxyProtocolV1Header(reader)
+	}
+
+	return nil, fmt.Errorf("PROXY protocol signature not found")
+}
+
+// readProxyProtocolV1Header reads the human-readable v1 header, like
+// 'PROXY TCP4 192.168.0.1 192.168.0.11 56324 22\r\n'
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		c, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, c)
+		if c == '\n' {
+			break
+		}
+		if len(line) >= proxyProtocolV1MaxLength {
+			return nil, fmt.Errorf("v1 header is too long")
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1 header must end with CRLF")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) > 1 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 {
+		return nil, fmt.Errorf("invalid v1 header, '%s'", strings.TrimSpace(string(line)))
+	}
+
+	ip := net.ParseIP(fields[2])
+	switch {
+	case ip == nil:
+		return nil, fmt.Errorf("invalid source address, '%s'", fields[2])
+	case fields[1] == "TCP4" && ip.To4() == nil, fields[1] == "TCP6" && ip.To4() != nil:
+		return nil, fmt.Errorf("source address, '%s' does not match with '%s'", fields[2], fields[1])
+	case fields[1] != "TCP4" && fields[1] != "TCP6":
+		return nil, fmt.Errorf("unknown protocol, '%s'", fields[1])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source port, '%s'", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2Header reads the binary v2 header; the TLVs are ignored.
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyProtocolV2Signature)+4)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	verCmd, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:16])
+
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unknown v2 version, %d", verCmd>>4)
+	}
+	if length > proxyProtocolV2MaxLength {
+		return nil, fmt.Errorf("v2 header is too long, %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0x0f {
+	case 0x00: // LOCAL
+		return nil, nil
+	case 0x01: // PROXY
+	default:
+		return nil, fmt.Errorf("unknown v2 command, %d", verCmd&0x0f)
+	}
+
+	var ipLength int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		ipLength = net.IPv4len
+	case 0x2: // AF_INET6
+		ipLength = net.IPv6len
+	default: // AF_UNSPEC, AF_UNIX
+		return nil, nil
+	}
+
+	if len(payload) < ipLength*2+4 {
+		return nil, fmt.Errorf("v2 addresses are too short, %d", len(payload))
+	}
+
+	ip := net.IP(payload[:ipLength])
+	port := binary.BigEndian.Uint16(payload[ipLength*2:])
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -0,0 +1,125 @@
+package sault
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProxyProtocolV2Header(command, family byte, addresses []byte) []byte {
+	var b bytes.Buffer
+	b.Write(proxyProtocolV2Signature)
+	b.WriteByte(0x20 | command)
+	b.WriteByte(family)
+	binary.Write(&b, binary.BigEndian, uint16(len(addresses)))
+	b.Write(addresses)
+
+	return b.Bytes()
+}
+
+func TestReadProxyProtocolHeader(t *testing.T) {
+	v4Addresses := append(append(net.ParseIP("192.168.0.1").To4(), net.ParseIP("192.168.0.11").To4()...), 0xdc, 0x04, 0x00, 0x16)
+	v6Addresses := append(append([]byte(net.ParseIP("2001:db8::1")), net.ParseIP("2001:db8::11")...), 0xdc, 0x04, 0x00, 0x16)
+
+	cases := []struct {
+		name    string
+		header  []byte
+		addr    string
+		isError bool
+	}{
+		{"v1 tcp4", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 22\r\n"), "192.168.0.1:56324", false},
+		{"v1 tcp6", []byte("PROXY TCP6 2001:db8::1 2001:db8::11 56324 22\r\n"), "[2001:db8::1]:56324", false},
+		{"v1 unknown", []byte("PROXY UNKNOWN\r\n"), "", false},
+		{"v1 without CRLF", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 22\n"), "", true},
+		{"v1 wrong family", []byte("PROXY TCP6 192.168.0.1 192.168.0.11 56324 22\r\n"), "", true},
+		{"v1 wrong port", []byte("PROXY TCP4 192.168.0.1 192.168.0.11 65536 22\r\n"), "", true},
+		{"v1 too long", append([]byte("PROXY TCP4 "), bytes.Repeat([]byte("1"), 200)...), "", true},
+		{"v2 tcp4", newTestProxyProtocolV2Header(0x1, 0x11, v4Addresses), "192.168.0.1:56324", false},
+		{"v2 tcp6", newTestProxyProtocolV2Header(0x1, 0x21, v6Addresses), "[2001:db8::1]:56324", false},
+		{"v2 local", newTestProxyProtocolV2Header(0x0, 0x00, nil), "", false},
+		{"v2 unix", newTestProxyProtocolV2Header(0x1, 0x31, make([]byte, 216)), "", false},
+		{"v2 short addresses", newTestProxyProtocolV2Header(0x1, 0x11, v4Addresses[:8]), "", true},
+		{"v2 unknown command", newTestProxyProtocolV2Header(0x2, 0x11, v4Addresses), "", true},
+		{"ssh", []byte("SSH-2.0-OpenSSH_7.4\r\n"), "", true},
+	}
+
+	for _, i := range cases {
+		reader := bufio.NewReader(bytes.NewReader(append(i.header, []byte("SSH-2.0-OpenSSH_7.4\r\n")...)))
+		addr, err := readProxyProtocolHeader(reader)
+		if i.isError {
+			assert.NotNil(t, err, i.name)
+			continue
+		}
+
+		assert.Nil(t, err, i.name)
+		if len(i.addr) < 1 {
+			assert.Nil(t, addr, i.name)
+		} else {
+			assert.Equal(t, i.addr, addr.String(), i.name)
+		}
+
+		// the remains are not consumed
+		remains, _ := ioutil.ReadAll(reader)
+		assert.Equal(t, "SSH-2.0-OpenSSH_7.4\r\n", string(remains), i.name)
+	}
+}
+
+func TestServerAcceptProxyProtocol(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+
+	dial := func(b []byte) net.Conn {
+		go func() {
+			if c, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+				c.Write(b)
+				c.Close()
+			}
+		}()
+
+		conn, _ := listener.Accept()
+		return conn
+	}
+
+	config := NewConfig()
+	server := &Server{config: config}
+
+	{
+		// not trusted upstream
+		conn := dial([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 22\r\n"))
+		defer conn.Close()
+
+		accepted, err := server.acceptProxyProtocol(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, conn, accepted)
+	}
+
+	config.Server.ProxyProtocol = []string{"127.0.0.1"}
+	assert.Nil(t, config.validateServerProxyProtocol())
+
+	{
+		conn := dial([]byte("PROXY TCP4 192.168.0.1 192.168.0.11 56324 22\r\nSSH-2.0-OpenSSH_7.4\r\n"))
+		defer conn.Close()
+
+		accepted, err := server.acceptProxyProtocol(conn)
+		assert.Nil(t, err)
+		assert.Equal(t, "192.168.0.1:56324", accepted.RemoteAddr().String())
+		assert.Equal(t, "192.168.0.1", getAddressFromRemoteAddr(accepted.RemoteAddr()))
+
+		remains, _ := ioutil.ReadAll(accepted)
+		assert.Equal(t, "SSH-2.0-OpenSSH_7.4\r\n", string(remains))
+	}
+
+	{
+		// the trusted upstream must send the header
+		conn := dial([]byte("SSH-2.0-OpenSSH_7.4\r\n"))
+		defer conn.Close()
+
+		_, err := server.acceptProxyProtocol(conn)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,341 @@
+package sault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// reasonEnvName is the env of client for the reason of session, like
+// 'ssh -o SetEnv=SAULT_REASON="JIRA-1234"'
+const reasonEnvName = "SAULT_REASON"
+
+// maxReasonLength is the maximum length of the reason of session
+const maxReasonLength = 256
+
+var errReasonCanceled = errors.New("reason was canceled")
+
+// isBreakGlassUser checks whether the user can connect to the hosts without
+// link in the emergency
+func (c *connection) isBreakGlassUser(user saultregistry.UserRegistry) bool {
+	return c.server.config != nil && c.server.config.Server.IsBreakGlassUser(user.ID)
+}
+
+// isReasonRequired checks whether the reason is required before connecting
+// to the host; the sensitive hosts and the break-glass sessions need it.
+func (c *connection) isReasonRequired() bool {
+	return c.breakGlass || c.host.Sensitive
+}
+
+func (c *connection) getReason() string {
+	c.channelsLock.RLock()
+	defer c.channelsLock.RUnlock()
+
+	return c.reason
+}
+
+// setReason sets the reason of session; the reason is attached to the logs,
+// audit events and recordings of this session.
+func (c *connection) setReason(reason string) {
+	reason = strings.TrimSpace(reason)
+	if len(reason) > maxReasonLength {
+		reason = reason[:maxReasonLength]
+	}
+
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+
+	c.reason = reason
+	c.log = c.log.WithField("reason", reason)
+}
+
+// auditReason audits the given reason; the break-glass session raises the
+// alert.
+func (c *connection) auditReason() {
+	if c.breakGlass {
+		c.log.Warnf("break-glass session; %s connects to %s+%s without link", c.user, c.account, c.host.ID)
+		c.audit(AuditEvent{Type: AuditEventBreakGlass})
+		return
+	}
+
+	c.audit(AuditEvent{Type: AuditEventSessionReason})
+}
+
+var reasonPromptTemplate = `{{ if .breakGlass }}{{ "* sault" | blue }} {{ "BREAK-GLASS" | red }} you are not linked to {{ .target | yellow }}; this session will be alerted to the admins.
+{{ else }}{{ "* sault" | blue }} {{ .target | yellow }} is the sensitive host; give the reason or ticket ID of this session.
+{{ end }}{{ "reason" | yellow }}: `
+
+// readReasonLine reads the line from the pty and echoes it to w; backspace
+// removes the last character and ctrl-c or ctrl-d cancels it. The rest is the
+// input, which was typed ahead after the line.
+func readReasonLine(r io.Reader, w io.Writer) (line string, rest []byte, err error) {
+	var read []byte
+
+	b := make([]byte, 256)
+	for {
+		var n int
+		if n, err = r.Read(b); err != nil {
+			return
+		}
+
+		for i, c := range b[:n] {
+			switch {
+			case c == '\r' || c == '\n':
+				w.Write([]byte("\r\n"))
+				line = string(read)
+				rest = append(rest, b[i+1:n]...)
+				return
+			case c == 0x03 || c == 0x04: // ctrl-c, ctrl-d
+				w.Write([]byte("\r\n"))
+				err = errReasonCanceled
+				return
+			case c == 0x7f || c == 0x08:
+				if len(read) < 1 {
+					break
+				}
+				_, size := utf8.DecodeLastRune(read)
+				read = read[:len(read)-size]
+				w.Write([]byte("\b \b"))
+			case c >= 0x20 && c != 0x7f:
+				if len(read) >= maxReasonLength {
+					break
+				}
+				read = append(read, c)
+				w.Write([]byte{c})
+			}
+		}
+	}
+}
+
+// pendingInputChannel reads the pending input before the channel; the input,
+// which was typed ahead after the reason, is sent to the host.
+type pendingInputChannel struct {
+	saultssh.Channel
+	r io.Reader
+}
+
+func newPendingInputChannel(channel saultssh.Channel, pending []byte) saultssh.Channel {
+	if len(pending) < 1 {
+		return channel
+	}
+
+	return &pendingInputChannel{
+		Channel: channel,
+		r:       io.MultiReader(bytes.NewReader(pending), channel),
+	}
+}
+
+func (c *pendingInputChannel) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// promptReason asks the reason on the pty; the window-change requests are
+// handled while prompting. The input after the reason is returned as rest.
+func (c *connection) promptReason(
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	pty *ptyRequestMsg,
+) (reason string, rest []byte, err error) {
+	prompt, _ := saultcommon.SimpleTemplating(
+		reasonPromptTemplate,
+		map[string]interface{}{
+			"breakGlass": c.breakGlass,
+			"target":     fmt.Sprintf("%s+%s", c.account, c.host.ID),
+		},
+	)
+
+	type readResult struct {
+		reason string
+		rest   []byte
+		err    error
+	}
+
+	for {
+		channel.Write([]byte(strings.Replace(prompt, "\n", "\r\n", -1)))
+
+		read := make(chan readResult, 1)
+		go func(pending []byte) {
+			reason, rest, err := readReasonLine(io.MultiReader(bytes.NewReader(pending), channel), channel)
+			read <- readResult{reason: reason, rest: rest, err: err}
+		}(rest)
+
+	L:
+		for {
+			select {
+			case request := <-requests:
+				if request == nil {
+					return "", nil, io.EOF
+				}
+
+				if request.Type != "window-change" {
+					request.Reply(false, nil)
+					continue
+				}
+
+				var msg windowChangeRequestMsg
+				if err := saultssh.Unmarshal(request.Payload, &msg); err == nil && pty != nil {
+					pty.Columns, pty.Rows = msg.Columns, msg.Rows
+					pty.Width, pty.Height = msg.Width, msg.Height
+				}
+				request.Reply(true, nil)
+			case r := <-read:
+				if r.err != nil {
+					return "", nil, r.err
+				}
+				if len(strings.TrimSpace(r.reason)) > 0 {
+					return r.reason, r.rest, nil
+				}
+
+				rest = r.rest
+				break L
+			}
+		}
+	}
+}
+
+// denyWithoutReason notifies the client, which did not give the reason
+func (c *connection) denyWithoutReason(channel saultssh.Channel) error {
+	err := fmt.Errorf(
+		"reason is required for %s+%s; set the '%s' env, like 'ssh -o SetEnv=%s=\"...\"'",
+		c.account,
+		c.host.ID,
+		reasonEnvName,
+		reasonEnvName,
+	)
+	channel.Stderr().Write([]byte(fmt.Sprintf("sault: %v\n", err)))
+	sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+
+	c.audit(AuditEvent{Type: AuditEventSessionReason, Error: err.Error()})
+
+	return err
+}
+
+// requireReason asks the reason on the pty, if not given yet; the pty-req of
+// replays is replaced with the last window size. The returned channel should
+// be proxied instead, it keeps the input typed after the reason.
+func (c *connection) requireReason(
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	pty *ptyRequestMsg,
+	replays []*saultssh.Request,
+) (saultssh.Channel, error) {
+	if len(c.getReason()) < 1 {
+		if pty == nil {
+			return nil, c.denyWithoutReason(channel)
+		}
+
+		reason, rest, err := c.promptReason(channel, requests, pty)
+		if err != nil {
+			if err == errReasonCanceled {
+				sendExitStatusThruChannel(channel, exitStatusNotAllowed)
+			}
+			return nil, err
+		}
+		c.setReason(reason)
+		channel = newPendingInputChannel(channel, rest)
+	}
+
+	if pty != nil {
+		for _, r := range replays {
+			if r.Type == "pty-req" {
+				r.Payload = saultssh.Marshal(*pty)
+			}
+		}
+	}
+
+	c.auditReason()
+
+	return channel, nil
+}
+
+// parseReasonEnv returns the reason from the env request
+func parseReasonEnv(request *saultssh.Request) (string, bool) {
+	if request.Type != "env" {
+		return "", false
+	}
+
+	var msg envRequestMsg
+	if err := saultssh.Unmarshal(request.Payload, &msg); err != nil || msg.Name != reasonEnvName {
+		return "", false
+	}
+
+	return msg.Value, true
+}
+
+// waitReason accepts the first session channel and holds it's requests until
+// the reason is given by the 'SAULT_REASON' env or the prompt on the pty; the
+// held requests are replayed to the host. The last request, like 'shell' is
+// not replied here, the answer of host is replied by proxyChannel. The other
+// channels are rejected until then.
+func (c *connection) waitReason(channels <-chan saultssh.NewChannel) (
+	channel saultssh.Channel,
+	requests <-chan *saultssh.Request,
+	replays []*saultssh.Request,
+	err error,
+) {
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(saultssh.Prohibited, "reason is required before connecting to the host")
+			continue
+		}
+
+		if channel, requests, err = newChannel.Accept(); err != nil {
+			return
+		}
+
+		var pty *ptyRequestMsg
+		for request := range requests {
+			if reason, ok := parseReasonEnv(request); ok {
+				c.setReason(reason)
+				request.Reply(true, nil)
+				continue
+			}
+
+			switch request.Type {
+			case "pty-req":
+				var msg ptyRequestMsg
+				if err := saultssh.Unmarshal(request.Payload, &msg); err == nil {
+					pty = &msg
+				}
+			case "window-change":
+				var msg windowChangeRequestMsg
+				if err := saultssh.Unmarshal(request.Payload, &msg); err == nil && pty != nil {
+					pty.Columns, pty.Rows = msg.Columns, msg.Rows
+					pty.Width, pty.Height = msg.Width, msg.Height
+				}
+				request.Reply(true, nil)
+				continue
+			}
+
+			switch request.Type {
+			case "shell", "exec", "subsystem":
+				replays = append(replays, request)
+
+				var proxied saultssh.Channel
+				if proxied, err = c.requireReason(channel, requests, pty, replays); err != nil {
+					request.Reply(false, nil)
+					channel.Close()
+					return
+				}
+				channel = proxied
+				return
+			}
+
+			request.Reply(true, nil)
+			replays = append(replays, &saultssh.Request{Type: request.Type, Payload: request.Payload})
+		}
+
+		err = fmt.Errorf("session channel was closed before the reason")
+		return
+	}
+
+	err = fmt.Errorf("connection was closed before the reason")
+	return
+}
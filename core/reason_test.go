@@ -0,0 +1,244 @@
+package sault
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+type testReadWriter struct {
+	in  *bytes.Reader
+	out bytes.Buffer
+}
+
+func (rw *testReadWriter) Read(p []byte) (int, error) {
+	return rw.in.Read(p)
+}
+
+func (rw *testReadWriter) Write(p []byte) (int, error) {
+	return rw.out.Write(p)
+}
+
+func TestReadReasonLine(t *testing.T) {
+	{
+		rw := &testReadWriter{in: bytes.NewReader([]byte("JIRA-1x\x7f234\r"))}
+		reason, rest, err := readReasonLine(rw, rw)
+		assert.Nil(t, err)
+		assert.Equal(t, "JIRA-1234", reason)
+		assert.Empty(t, rest)
+		assert.Equal(t, "JIRA-1x\b \b234\r\n", rw.out.String())
+	}
+
+	{
+		rw := &testReadWriter{in: bytes.NewReader([]byte("JIRA\x03"))}
+		_, _, err := readReasonLine(rw, rw)
+		assert.Equal(t, errReasonCanceled, err)
+	}
+
+	{
+		// the input typed ahead is not echoed and returned
+		rw := &testReadWriter{in: bytes.NewReader([]byte("JIRA-1234\rls -al\r"))}
+		reason, rest, err := readReasonLine(rw, rw)
+		assert.Nil(t, err)
+		assert.Equal(t, "JIRA-1234", reason)
+		assert.Equal(t, "ls -al\r", string(rest))
+		assert.Equal(t, "JIRA-1234\r\n", rw.out.String())
+	}
+}
+
+func TestPendingInputChannel(t *testing.T) {
+	saultConn, client := newTestSSHConnPair(t)
+	go saultssh.DiscardRequests(client.requests)
+	go saultssh.DiscardRequests(saultConn.requests)
+
+	accepted := make(chan saultssh.Channel, 1)
+	go func() {
+		newChannel := <-saultConn.channels
+		channel, requests, _ := newChannel.Accept()
+		go saultssh.DiscardRequests(requests)
+		accepted <- channel
+	}()
+
+	channel, requests, err := client.conn.OpenChannel("session", nil)
+	assert.Nil(t, err)
+	go saultssh.DiscardRequests(requests)
+
+	channel.Write([]byte("-al\r"))
+	channel.CloseWrite()
+
+	b, err := ioutil.ReadAll(newPendingInputChannel(<-accepted, []byte("ls ")))
+	assert.Nil(t, err)
+	assert.Equal(t, "ls -al\r", string(b))
+
+	client.conn.Close()
+}
+
+// newTestReasonConnection returns the connection to the sensitive host
+func newTestReasonConnection(t *testing.T) (*connection, testSSHConnEnd, testSSHConnEnd, *testAuditSink) {
+	c, saultConn, client, sink := newTestProxiedConnection(t, nil)
+	go saultssh.DiscardRequests(client.requests)
+	go saultssh.DiscardRequests(saultConn.requests)
+
+	c.host.Sensitive = true
+	c.host, _ = c.server.registry.UpdateHost(c.host.ID, c.host)
+
+	return c, saultConn, client, sink
+}
+
+type testWaitReasonResult struct {
+	replays []*saultssh.Request
+	err     error
+}
+
+func TestWaitReasonEnv(t *testing.T) {
+	c, saultConn, client, sink := newTestReasonConnection(t)
+	assert.True(t, c.isReasonRequired())
+
+	result := make(chan testWaitReasonResult, 1)
+	go func() {
+		_, _, replays, err := c.waitReason(saultConn.channels)
+		result <- testWaitReasonResult{replays, err}
+	}()
+
+	{
+		// the other channels are rejected until the reason is given
+		_, _, err := client.conn.OpenChannel("direct-tcpip", nil)
+		assert.NotNil(t, err)
+	}
+
+	channel, requests, err := client.conn.OpenChannel("session", nil)
+	assert.Nil(t, err)
+	go saultssh.DiscardRequests(requests)
+
+	ok, err := channel.SendRequest("env", true, saultssh.Marshal(envRequestMsg{Name: "LANG", Value: "C"}))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	ok, err = channel.SendRequest("env", true, saultssh.Marshal(envRequestMsg{Name: reasonEnvName, Value: " JIRA-1234 "}))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	replied := make(chan bool, 1)
+	go func() {
+		ok, _ := channel.SendRequest("exec", true, saultssh.Marshal(execRequestMsg{Command: "id"}))
+		replied <- ok
+	}()
+
+	r := <-result
+	assert.Nil(t, r.err)
+	assert.Equal(t, "JIRA-1234", c.getReason())
+
+	// the reason is not replayed to the host
+	assert.Equal(t, 2, len(r.replays))
+	assert.Equal(t, "env", r.replays[0].Type)
+	assert.Equal(t, "exec", r.replays[1].Type)
+
+	// the exec is replied by the answer of host
+	assert.True(t, r.replays[1].WantReply)
+	r.replays[1].Reply(false, nil)
+	assert.False(t, <-replied)
+
+	client.conn.Close()
+	c.server.auditor.close()
+
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, AuditEventSessionReason, sink.events[0].Type)
+	assert.Equal(t, "JIRA-1234", sink.events[0].Data["reason"])
+}
+
+func TestWaitReasonWithoutReason(t *testing.T) {
+	c, saultConn, client, sink := newTestReasonConnection(t)
+
+	result := make(chan testWaitReasonResult, 1)
+	go func() {
+		_, _, replays, err := c.waitReason(saultConn.channels)
+		result <- testWaitReasonResult{replays, err}
+	}()
+
+	channel, requests, err := client.conn.OpenChannel("session", nil)
+	assert.Nil(t, err)
+
+	exitStatus := make(chan uint32, 1)
+	go func() {
+		for request := range requests {
+			request.Reply(false, nil)
+			if request.Type == "exit-status" {
+				var msg exitStatusMsg
+				saultssh.Unmarshal(request.Payload, &msg)
+				exitStatus <- msg.Status
+			}
+		}
+	}()
+
+	channel.SendRequest("exec", true, saultssh.Marshal(execRequestMsg{Command: "id"}))
+
+	r := <-result
+	assert.NotNil(t, r.err)
+	assert.Empty(t, c.getReason())
+
+	stderr, _ := ioutil.ReadAll(channel.Stderr())
+	assert.Contains(t, string(stderr), reasonEnvName)
+	assert.Equal(t, uint32(exitStatusNotAllowed), <-exitStatus)
+
+	client.conn.Close()
+	c.server.auditor.close()
+
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, AuditEventSessionReason, sink.events[0].Type)
+	assert.NotEmpty(t, sink.events[0].Error)
+}
+
+func TestPublicKeyCallbackBreakGlass(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+
+	config := NewConfig()
+	server := &Server{
+		saultServerName: DefaultSaultServerName,
+		registry:        registry,
+		config:          config,
+		auditor:         newAuditor(nil),
+		bans:            NewBanRegistry(),
+	}
+
+	connMeta := &testSSHConn{user: fmt.Sprintf("ubuntu+%s", host.ID)}
+
+	{
+		// not linked
+		c := &connection{server: server, log: log.WithFields(logrus.Fields{})}
+		_, err := c.publicKeyCallback(connMeta, publicKey)
+		assert.NotNil(t, err)
+	}
+
+	config.Server.BreakGlassUsers = []string{user.ID}
+
+	{
+		c := &connection{server: server, log: log.WithFields(logrus.Fields{})}
+		_, err := c.publicKeyCallback(connMeta, publicKey)
+		assert.Nil(t, err)
+		assert.True(t, c.breakGlass)
+		assert.True(t, c.isReasonRequired())
+	}
+
+	{
+		// linked user is not break-glass
+		registry.Link(user.ID, host.ID, "ubuntu")
+
+		c := &connection{server: server, log: log.WithFields(logrus.Fields{})}
+		_, err := c.publicKeyCallback(connMeta, publicKey)
+		assert.Nil(t, err)
+		assert.False(t, c.breakGlass)
+		assert.False(t, c.isReasonRequired())
+	}
+}
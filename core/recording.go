@@ -0,0 +1,440 @@
+package sault
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/spikeekips/sault/common"
+)
+
+// RecordingFileExt is the file extension of session recording file
+var RecordingFileExt = ".cast"
+
+var recordingFileMode os.FileMode = 0600
+
+// RecordingHeader is the header of asciicast v2 format, see
+// https://github.com/asciinema/asciinema/blob/develop/doc/asciicast-v2.md .
+// The sault specific fields are ignored by the asciicast players.
+type RecordingHeader struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+
+	SessionID  string `json:"session_id"`
+	User       string `json:"user"`
+	Host       string `json:"host"`
+	Account    string `json:"account"`
+	RemoteAddr string `json:"remote_addr"`
+
+	// Reason is the reason of session; see connection.isReasonRequired()
+	Reason     string `json:"reason,omitempty"`
+	BreakGlass bool   `json:"break_glass,omitempty"`
+}
+
+// sessionRecorder writes the output of pty session to the file in asciicast
+// v2 format.
+type sessionRecorder struct {
+	sync.Mutex
+
+	ID   string
+	Path string
+
+	file    *os.File
+	started time.Time
+	pending []byte
+	closed  bool
+}
+
+func newSessionRecorder(directory, id string, header RecordingHeader) (*sessionRecorder, error) {
+	p := filepath.Join(directory, id+RecordingFileExt)
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, recordingFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	header.Version = 2
+	header.Timestamp = now.Unix()
+
+	jsoned, err := json.Marshal(header)
+	if err != nil {
+		f.Close()
+		os.Remove(p)
+		return nil, err
+	}
+
+	if _, err = fmt.Fprintf(f, "%s\n", jsoned); err != nil {
+		f.Close()
+		os.Remove(p)
+		return nil, err
+	}
+
+	return &sessionRecorder{
+		ID:      id,
+		Path:    p,
+		file:    f,
+		started: now,
+	}, nil
+}
+
+func (r *sessionRecorder) writeEvent(code, data string) error {
+	jsoned, err := json.Marshal([]interface{}{
+		time.Since(r.started).Seconds(),
+		code,
+		data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(r.file, "%s\n", jsoned)
+	return err
+}
+
+// Write records the output stream; the incomplete utf-8 sequence at the end
+// of p will be kept until the next Write.
+func (r *sessionRecorder) Write(p []byte) (int, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+
+	b := append(r.pending, p...)
+
+	cut := len(b)
+	for i := len(b) - 1; i >= 0 && len(b)-i <= utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	r.pending = append([]byte{}, b[cut:]...)
+	if cut < 1 {
+		return len(p), nil
+	}
+
+	if err := r.writeEvent("o", string(b[:cut])); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// Resize records the terminal dimension change
+func (r *sessionRecorder) Resize(width, height uint32) error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return io.ErrClosedPipe
+	}
+
+	return r.writeEvent("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+// Close flushes the pending output and closes the recording file
+func (r *sessionRecorder) Close() error {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	if len(r.pending) > 0 {
+		r.writeEvent("o", string(r.pending))
+		r.pending = nil
+	}
+
+	return r.file.Close()
+}
+
+// removeOldRecordings removes the recording files, which are older than
+// maxAge.
+func removeOldRecordings(directory string, maxAge time.Duration) (removed int, err error) {
+	if maxAge <= 0 {
+		return
+	}
+
+	var files []string
+	files, err = filepath.Glob(filepath.Join(directory, "*"+RecordingFileExt))
+	if err != nil {
+		return
+	}
+
+	expired := time.Now().Add(-maxAge)
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		if fi.ModTime().After(expired) {
+			continue
+		}
+
+		if err := os.Remove(f); err != nil {
+			log.Errorf("failed to remove old recording file, '%s': %v", f, err)
+			continue
+		}
+		removed++
+	}
+
+	return
+}
+
+// RecordingEvent is the event of asciicast v2 format; Code is 'o' for output,
+// 'r' for resize.
+type RecordingEvent struct {
+	Time float64
+	Code string
+	Data string
+}
+
+// Offset returns the elapsed time from the start of recording
+func (e RecordingEvent) Offset() time.Duration {
+	return time.Duration(e.Time * float64(time.Second))
+}
+
+// UnmarshalJSON is for the event line, '[<time>, <code>, <data>]'
+func (e *RecordingEvent) UnmarshalJSON(b []byte) (err error) {
+	var event []interface{}
+	if err = json.Unmarshal(b, &event); err != nil {
+		return
+	}
+
+	if len(event) != 3 {
+		return fmt.Errorf("invalid recording event, '%s'", b)
+	}
+
+	var ok bool
+	if e.Time, ok = event[0].(float64); !ok {
+		return fmt.Errorf("invalid time of recording event, '%s'", b)
+	}
+	if e.Code, ok = event[1].(string); !ok {
+		return fmt.Errorf("invalid code of recording event, '%s'", b)
+	}
+	if e.Data, ok = event[2].(string); !ok {
+		return fmt.Errorf("invalid data of recording event, '%s'", b)
+	}
+
+	return nil
+}
+
+// RecordingInfo contains the information of recording file
+type RecordingInfo struct {
+	ID        string
+	Header    RecordingHeader
+	Size      int64
+	TimeEnded time.Time
+}
+
+// TimeStarted returns the started time of recording
+func (r RecordingInfo) TimeStarted() time.Time {
+	return time.Unix(r.Header.Timestamp, 0).UTC()
+}
+
+// Duration returns the duration of recording
+func (r RecordingInfo) Duration() time.Duration {
+	return r.TimeEnded.Sub(r.TimeStarted()) / time.Second * time.Second
+}
+
+var reRecordingID = regexp.MustCompile(`^[0-9a-zA-Z][0-9a-zA-Z\-]*$`)
+
+// GetRecordingPath returns the path of recording file by recording id
+func GetRecordingPath(directory, id string) (string, error) {
+	if !reRecordingID.MatchString(id) {
+		return "", fmt.Errorf("invalid recording id, '%s'", id)
+	}
+
+	return filepath.Join(directory, id+RecordingFileExt), nil
+}
+
+// GetRecording returns the information of recording
+func GetRecording(directory, id string) (info RecordingInfo, err error) {
+	var p string
+	if p, err = GetRecordingPath(directory, id); err != nil {
+		return
+	}
+
+	var fi os.FileInfo
+	if fi, err = os.Stat(p); err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("recording, '%s' does not exist", id)
+		}
+		return
+	}
+
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		return
+	}
+	defer f.Close()
+
+	var line string
+	if line, err = bufio.NewReader(f).ReadString('\n'); err != nil && err != io.EOF {
+		return
+	}
+
+	if err = json.Unmarshal([]byte(line), &info.Header); err != nil {
+		err = fmt.Errorf("invalid recording, '%s': %v", id, err)
+		return
+	}
+
+	info.ID = id
+	info.Size = fi.Size()
+	info.TimeEnded = fi.ModTime().UTC()
+
+	return
+}
+
+// GetRecordings returns the recordings in the directory, which are sorted by
+// the started time.
+func GetRecordings(directory string) (recordings []RecordingInfo, err error) {
+	var files []string
+	if files, err = filepath.Glob(filepath.Join(directory, "*"+RecordingFileExt)); err != nil {
+		return
+	}
+
+	for _, f := range files {
+		id := strings.TrimSuffix(filepath.Base(f), RecordingFileExt)
+
+		info, err := GetRecording(directory, id)
+		if err != nil {
+			log.Errorf("failed to load recording, '%s': %v", f, err)
+			continue
+		}
+		recordings = append(recordings, info)
+	}
+
+	sort.SliceStable(recordings, func(i, j int) bool {
+		return recordings[i].Header.Timestamp < recordings[j].Header.Timestamp
+	})
+
+	return
+}
+
+// ReadRecording reads the events of recording; if callback returns error, it
+// stops reading.
+func ReadRecording(directory, id string, callback func(RecordingEvent) error) (err error) {
+	var p string
+	if p, err = GetRecordingPath(directory, id); err != nil {
+		return
+	}
+
+	var f *os.File
+	if f, err = os.Open(p); err != nil {
+		if os.IsNotExist(err) {
+			err = fmt.Errorf("recording, '%s' does not exist", id)
+		}
+		return
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	// skip header
+	if _, err = r.ReadString('\n'); err != nil {
+		if err == io.EOF {
+			err = nil
+		}
+		return
+	}
+
+	for {
+		var line string
+		line, err = r.ReadString('\n')
+		if len(strings.TrimSpace(line)) > 0 {
+			var event RecordingEvent
+			if e := json.Unmarshal([]byte(line), &event); e != nil {
+				return e
+			}
+
+			if e := callback(event); e != nil {
+				return e
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// RecordingLine is the line of the transcript of recording
+type RecordingLine struct {
+	Offset time.Duration
+	Line   string
+}
+
+// ReadRecordingLines reads the output of recording line by line; the terminal
+// escape sequences are stripped and Offset is the time when the line started.
+func ReadRecordingLines(directory, id string, callback func(RecordingLine) error) (err error) {
+	var buf bytes.Buffer
+	var offset time.Duration
+
+	flush := func() error {
+		line := strings.TrimRight(saultcommon.StripTerminalEscapes(buf.String()), "\n")
+		buf.Reset()
+		if len(strings.TrimSpace(line)) < 1 {
+			return nil
+		}
+
+		return callback(RecordingLine{Offset: offset, Line: line})
+	}
+
+	err = ReadRecording(directory, id, func(event RecordingEvent) error {
+		if event.Code != "o" {
+			return nil
+		}
+
+		data := event.Data
+		for len(data) > 0 {
+			if buf.Len() < 1 {
+				offset = event.Offset()
+			}
+
+			i := strings.IndexByte(data, '\n')
+			if i < 0 {
+				buf.WriteString(data)
+				break
+			}
+
+			buf.WriteString(data[:i+1])
+			data = data[i+1:]
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	return flush()
+}
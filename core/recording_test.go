@@ -0,0 +1,151 @@
+package sault
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRecorder(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	recorder, err := newSessionRecorder(
+		directory,
+		"findme",
+		RecordingHeader{
+			Width:   80,
+			Height:  24,
+			User:    "spikeekips",
+			Host:    "prometeus",
+			Account: "ubuntu",
+		},
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, filepath.Join(directory, "findme"+RecordingFileExt), recorder.Path)
+
+	recorder.Write([]byte("hej"))
+	recorder.Write([]byte("d\xc3")) // incomplete utf-8 sequence of 'å'
+	recorder.Write([]byte("\xa5 världen"))
+	recorder.Resize(100, 30)
+	assert.Nil(t, recorder.Close())
+
+	f, _ := os.Open(recorder.Path)
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, 5, len(lines))
+
+	var header RecordingHeader
+	assert.Nil(t, json.Unmarshal([]byte(lines[0]), &header))
+	assert.Equal(t, 2, header.Version)
+	assert.Equal(t, uint32(80), header.Width)
+	assert.Equal(t, uint32(24), header.Height)
+	assert.Equal(t, "spikeekips", header.User)
+	assert.Equal(t, "prometeus", header.Host)
+	assert.Equal(t, "ubuntu", header.Account)
+
+	var outputs []string
+	for _, l := range lines[1:] {
+		var event []interface{}
+		assert.Nil(t, json.Unmarshal([]byte(l), &event))
+		assert.Equal(t, 3, len(event))
+		outputs = append(outputs, event[1].(string)+":"+event[2].(string))
+	}
+	assert.Equal(t, []string{"o:hej", "o:d", "o:å världen", "r:100x30"}, outputs)
+
+	{
+		// write after closed
+		_, err := recorder.Write([]byte("hej"))
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRemoveOldRecordings(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	oldFile := filepath.Join(directory, "old"+RecordingFileExt)
+	newFile := filepath.Join(directory, "new"+RecordingFileExt)
+	ioutil.WriteFile(oldFile, []byte{}, 0600)
+	ioutil.WriteFile(newFile, []byte{}, 0600)
+
+	old := time.Now().Add(-time.Hour * 2)
+	os.Chtimes(oldFile, old, old)
+
+	removed, err := removeOldRecordings(directory, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, removed)
+
+	_, err = os.Stat(oldFile)
+	assert.True(t, os.IsNotExist(err))
+	_, err = os.Stat(newFile)
+	assert.Nil(t, err)
+}
+
+func TestReadRecording(t *testing.T) {
+	directory, _ := ioutil.TempDir("/tmp/", "sault-test")
+	defer os.RemoveAll(directory)
+
+	recorder, _ := newSessionRecorder(
+		directory,
+		"findme-1",
+		RecordingHeader{User: "spikeekips", Host: "prometeus", Account: "ubuntu"},
+	)
+	recorder.Write([]byte("\x1b[1;32m$\x1b[0m ls\r\n"))
+	recorder.Resize(100, 30)
+	recorder.Write([]byte("a.txt b"))
+	recorder.Write([]byte(".txt\r\n\r\n$ exit\r\n"))
+	recorder.Close()
+
+	{
+		info, err := GetRecording(directory, "findme-1")
+		assert.Nil(t, err)
+		assert.Equal(t, "findme-1", info.ID)
+		assert.Equal(t, "spikeekips", info.Header.User)
+	}
+
+	{
+		_, err := GetRecording(directory, "../findme-1")
+		assert.NotNil(t, err)
+
+		_, err = GetRecording(directory, "showme")
+		assert.NotNil(t, err)
+	}
+
+	{
+		var codes []string
+		err := ReadRecording(directory, "findme-1", func(e RecordingEvent) error {
+			codes = append(codes, e.Code)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"o", "r", "o", "o"}, codes)
+	}
+
+	{
+		var lines []string
+		err := ReadRecordingLines(directory, "findme-1", func(l RecordingLine) error {
+			lines = append(lines, l.Line)
+			return nil
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"$ ls", "a.txt b.txt", "$ exit"}, lines)
+	}
+
+	{
+		recordings, err := GetRecordings(directory)
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(recordings))
+	}
+}
@@ -0,0 +1,191 @@
+package sault
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+const forwardedTCPIPChannelType = "forwarded-tcpip"
+
+var errRemoteForwardingNotAllowed = errors.New("remote port forwarding is not allowed")
+
+// RFC 4254 Section 7.1.
+type tcpipForwardMsg struct {
+	Addr string
+	Port uint32
+}
+
+// RFC 4254 Section 7.2.
+type forwardedTCPIPMsg struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// isRemoteForwardingAllowed checks whether the link of host allows the
+// remote port forwarding
+func (c *connection) isRemoteForwardingAllowed() bool {
+	link, err := c.server.registry.GetLink(c.user.ID, c.host.ID)
+	if err != nil {
+		return false
+	}
+
+	return link.RemoteForwarding
+}
+
+// proxyGlobalRequests handles the global requests of client; the remote port
+// forwarding requests are relayed to the inner host and the other requests
+// are rejected.
+func (c *connection) proxyGlobalRequests(innerclient *saultcommon.SSHClient, requests <-chan *saultssh.Request) {
+	for request := range requests {
+		switch request.Type {
+		case "tcpip-forward", "cancel-tcpip-forward":
+			c.relayTCPIPForward(innerclient, request)
+		default:
+			if request.WantReply {
+				request.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// relayTCPIPForward relays the remote port forwarding request to the inner
+// host; the forwarded-tcpip channels of the inner host are piped back to the
+// client.
+func (c *connection) relayTCPIPForward(innerclient *saultcommon.SSHClient, request *saultssh.Request) {
+	var msg tcpipForwardMsg
+	if err := saultssh.Unmarshal(request.Payload, &msg); err != nil {
+		c.log.Errorf("invalid %s payload: %v", request.Type, err)
+		request.Reply(false, nil)
+		return
+	}
+
+	event := AuditEvent{
+		Type: AuditEventTCPIPForward,
+		Host: c.host.ID,
+		Data: map[string]interface{}{
+			"request_type": request.Type,
+			"address":      msg.Addr,
+			"port":         msg.Port,
+		},
+	}
+
+	var reply []byte
+	err := func() error {
+		if !c.isRemoteForwardingAllowed() {
+			return errRemoteForwardingNotAllowed
+		}
+
+		if request.Type == "cancel-tcpip-forward" {
+			return innerclient.Client.CancelForwardTCPIP(msg.Addr, msg.Port)
+		}
+
+		port, channels, err := innerclient.Client.ForwardTCPIP(msg.Addr, msg.Port)
+		if err != nil {
+			return err
+		}
+		if msg.Port == 0 {
+			event.Data["port"] = port
+			reply = saultssh.Marshal(struct{ Port uint32 }{port})
+		}
+
+		go func() {
+			for channel := range channels {
+				go c.proxyForwardedTCPIPChannel(channel)
+			}
+		}()
+
+		return nil
+	}()
+	if err != nil {
+		c.log.Errorf("failed to %s, '%s:%d': %v", request.Type, msg.Addr, msg.Port, err)
+		event.Error = err.Error()
+	}
+	c.audit(event)
+
+	request.Reply(err == nil, reply)
+}
+
+// proxyForwardedTCPIPChannel pipes the forwarded-tcpip channel of the inner
+// host to the new channel of client.
+func (c *connection) proxyForwardedTCPIPChannel(channel saultssh.NewChannel) {
+	channelID := fmt.Sprintf("%s-%d", c.id, atomic.AddUint32(&c.channelSeq, 1))
+
+	event := AuditEvent{
+		Type:      AuditEventChannelOpen,
+		ChannelID: channelID,
+		Host:      c.host.ID,
+		Data:      map[string]interface{}{"channel_type": forwardedTCPIPChannelType},
+	}
+
+	var msg forwardedTCPIPMsg
+	if err := saultssh.Unmarshal(channel.ExtraData(), &msg); err == nil {
+		event.Data["address"] = fmt.Sprintf("%s:%d", msg.Addr, msg.Port)
+		event.Data["origin_address"] = fmt.Sprintf("%s:%d", msg.OriginAddr, msg.OriginPort)
+	}
+
+	clientChannel, clientRequests, err := c.sshConn.OpenChannel(forwardedTCPIPChannelType, channel.ExtraData())
+	if err != nil {
+		event.Error = err.Error()
+		c.audit(event)
+
+		channel.Reject(saultssh.ConnectionFailed, err.Error())
+		return
+	}
+	defer clientChannel.Close()
+	go saultssh.DiscardRequests(clientRequests)
+
+	innerChannel, innerRequests, err := channel.Accept()
+	if err != nil {
+		event.Error = err.Error()
+		c.audit(event)
+
+		c.log.Error(err)
+		return
+	}
+	defer innerChannel.Close()
+	go saultssh.DiscardRequests(innerRequests)
+
+	c.audit(event)
+
+	state := c.addChannel(channelID, forwardedTCPIPChannelType, nil, nil)
+	defer func() {
+		c.removeChannel(channelID)
+
+		info := state.info()
+		c.audit(AuditEvent{
+			Type:      AuditEventChannelClose,
+			ChannelID: channelID,
+			Host:      c.host.ID,
+			Data: map[string]interface{}{
+				"channel_type": forwardedTCPIPChannelType,
+				"address":      event.Data["address"],
+				"bytes_in":     info.BytesIn,
+				"bytes_out":    info.BytesOut,
+				"duration":     time.Since(info.TimeStarted).Seconds(),
+			},
+		})
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(c.countingWriter(clientChannel, state, false), innerChannel)
+		clientChannel.CloseWrite()
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(c.countingWriter(innerChannel, state, true), clientChannel)
+		innerChannel.CloseWrite()
+	}()
+	wg.Wait()
+}
@@ -0,0 +1,252 @@
+package sault
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+type testSSHConnEnd struct {
+	conn     saultssh.Conn
+	channels <-chan saultssh.NewChannel
+	requests <-chan *saultssh.Request
+}
+
+// newTestSSHConnPair returns the server and client connections over the tcp
+// loopback.
+func newTestSSHConnPair(t *testing.T) (server, client testSSHConnEnd) {
+	hostKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	hostKeySigner, _ := saultssh.NewSignerFromKey(hostKey)
+
+	serverConfig := &saultssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKeySigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	served := make(chan testSSHConnEnd, 1)
+	go func() {
+		a, err := listener.Accept()
+		if err != nil {
+			t.Error(err)
+			served <- testSSHConnEnd{}
+			return
+		}
+
+		conn, channels, requests, err := saultssh.NewServerConn(a, serverConfig)
+		if err != nil {
+			t.Error(err)
+		}
+		served <- testSSHConnEnd{conn, channels, requests}
+	}()
+
+	b, err := net.Dial("tcp", listener.Addr().String())
+	assert.Nil(t, err)
+
+	conn, channels, requests, err := saultssh.NewClientConn(
+		b,
+		"",
+		&saultssh.ClientConfig{User: "spikeekips", HostKeyCallback: saultssh.InsecureIgnoreHostKey()},
+	)
+	assert.Nil(t, err)
+
+	return <-served, testSSHConnEnd{conn, channels, requests}
+}
+
+// newTestProxiedConnection returns the connection of 'spikeekips' to
+// 'ubuntu+prometeus' with the both ends of the client connection; if
+// setupLink is nil, the user is not linked to the host. The requests of the
+// ends are not consumed.
+func newTestProxiedConnection(t *testing.T, setupLink func(*saultregistry.LinkAccountRegistry)) (*connection, testSSHConnEnd, testSSHConnEnd, *testAuditSink) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+
+	if setupLink != nil {
+		registry.Link(user.ID, host.ID, "ubuntu")
+
+		link, _ := registry.GetLink(user.ID, host.ID)
+		setupLink(&link)
+		registry.UpdateLink(user.ID, host.ID, link)
+	}
+
+	// client <-> sault
+	saultConn, client := newTestSSHConnPair(t)
+
+	sink := &testAuditSink{}
+	c := &connection{
+		id:      "session-0",
+		account: "ubuntu",
+		user:    user,
+		host:    host,
+		server:  &Server{registry: registry, auditor: newAuditor([]AuditSink{sink})},
+		sshConn: saultConn.conn,
+		log:     log.WithFields(logrus.Fields{}),
+	}
+
+	return c, saultConn, client, sink
+}
+
+func newTestRemoteForwardConnection(t *testing.T, allowed bool) (*connection, testSSHConnEnd, testSSHConnEnd, *testAuditSink) {
+	c, saultConn, client, sink := newTestProxiedConnection(t, func(link *saultregistry.LinkAccountRegistry) {
+		link.RemoteForwarding = allowed
+	})
+	go saultssh.DiscardRequests(client.requests)
+
+	// sault <-> inner host
+	inner, innerClient := newTestSSHConnPair(t)
+	innerclient := &saultcommon.SSHClient{
+		Client: saultssh.NewClient(innerClient.conn, innerClient.channels, innerClient.requests),
+	}
+
+	go c.proxyGlobalRequests(innerclient, saultConn.requests)
+
+	return c, client, inner, sink
+}
+
+func TestRemoteForward(t *testing.T) {
+	c, client, inner, sink := newTestRemoteForwardConnection(t, true)
+
+	go func() {
+		for request := range inner.requests {
+			var msg tcpipForwardMsg
+			saultssh.Unmarshal(request.Payload, &msg)
+
+			if request.Type == "tcpip-forward" && msg.Port == 0 {
+				request.Reply(true, saultssh.Marshal(struct{ Port uint32 }{9000}))
+				continue
+			}
+			request.Reply(true, nil)
+		}
+	}()
+
+	ok, reply, err := client.conn.SendRequest(
+		"tcpip-forward",
+		true,
+		saultssh.Marshal(tcpipForwardMsg{Addr: "localhost", Port: 0}),
+	)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	var bound struct{ Port uint32 }
+	assert.Nil(t, saultssh.Unmarshal(reply, &bound))
+	assert.Equal(t, uint32(9000), bound.Port)
+
+	// the inner host opens the forwarded-tcpip channel
+	done := make(chan []byte)
+	go func() {
+		channel, requests, err := inner.conn.OpenChannel(
+			forwardedTCPIPChannelType,
+			saultssh.Marshal(forwardedTCPIPMsg{Addr: "localhost", Port: 9000, OriginAddr: "10.0.0.1", OriginPort: 5555}),
+		)
+		if err != nil {
+			t.Error(err)
+			close(done)
+			return
+		}
+		go saultssh.DiscardRequests(requests)
+
+		channel.Write([]byte("showmethemoney"))
+		channel.CloseWrite()
+
+		b, _ := ioutil.ReadAll(channel)
+		channel.Close()
+		done <- b
+	}()
+
+	newChannel := <-client.channels
+	assert.Equal(t, forwardedTCPIPChannelType, newChannel.ChannelType())
+
+	var msg forwardedTCPIPMsg
+	assert.Nil(t, saultssh.Unmarshal(newChannel.ExtraData(), &msg))
+	assert.Equal(t, uint32(9000), msg.Port)
+	assert.Equal(t, "10.0.0.1", msg.OriginAddr)
+
+	channel, requests, err := newChannel.Accept()
+	assert.Nil(t, err)
+	go saultssh.DiscardRequests(requests)
+
+	b, _ := ioutil.ReadAll(channel)
+	assert.Equal(t, "showmethemoney", string(b))
+	channel.Write([]byte("findkeys"))
+	channel.CloseWrite()
+
+	assert.Equal(t, "findkeys", string(<-done))
+	channel.Close()
+
+	ok, _, err = client.conn.SendRequest(
+		"cancel-tcpip-forward",
+		true,
+		saultssh.Marshal(tcpipForwardMsg{Addr: "localhost", Port: 9000}),
+	)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	client.conn.Close()
+	inner.conn.Close()
+	c.sshConn.Close()
+	c.server.auditor.close()
+
+	events := map[AuditEventType][]AuditEvent{}
+	for _, event := range sink.events {
+		events[event.Type] = append(events[event.Type], event)
+		assert.Empty(t, event.Error)
+	}
+	assert.Equal(t, 4, len(sink.events))
+	assert.Equal(t, 2, len(events[AuditEventTCPIPForward]))
+	assert.Equal(t, float64(9000), sink.events[0].Data["port"])
+
+	assert.Equal(t, 1, len(events[AuditEventChannelOpen]))
+	assert.Equal(t, "10.0.0.1:5555", events[AuditEventChannelOpen][0].Data["origin_address"])
+
+	assert.Equal(t, 1, len(events[AuditEventChannelClose]))
+	closeEvent := events[AuditEventChannelClose][0]
+	assert.Equal(t, float64(len("findkeys")), closeEvent.Data["bytes_in"])
+	assert.Equal(t, float64(len("showmethemoney")), closeEvent.Data["bytes_out"])
+}
+
+func TestRemoteForwardNotAllowed(t *testing.T) {
+	c, client, inner, sink := newTestRemoteForwardConnection(t, false)
+
+	relayed := make(chan string, 1)
+	go func() {
+		for request := range inner.requests {
+			relayed <- request.Type
+			request.Reply(true, nil)
+		}
+	}()
+
+	ok, _, err := client.conn.SendRequest(
+		"tcpip-forward",
+		true,
+		saultssh.Marshal(tcpipForwardMsg{Addr: "localhost", Port: 9000}),
+	)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// the other global requests are rejected
+	ok, _, err = client.conn.SendRequest("keepalive@openssh.com", true, nil)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	client.conn.Close()
+	inner.conn.Close()
+	c.sshConn.Close()
+	c.server.auditor.close()
+
+	assert.Equal(t, 0, len(relayed))
+	assert.Equal(t, 1, len(sink.events))
+	assert.Equal(t, AuditEventTCPIPForward, sink.events[0].Type)
+	assert.Equal(t, errRemoteForwardingNotAllowed.Error(), sink.events[0].Error)
+}
@@ -0,0 +1,462 @@
+package sault
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+)
+
+// ChannelInfo is the information of the open channel
+type ChannelInfo struct {
+	ID          string
+	Type        string
+	TimeStarted time.Time
+	BytesIn     int64
+	BytesOut    int64
+}
+
+// SessionInfo is the information of the active connection
+type SessionInfo struct {
+	ID          string
+	UserID      string
+	HostID      string
+	Account     string
+	InsideSault bool
+	RemoteAddr  string
+	Reason      string
+	BreakGlass  bool
+	TimeStarted time.Time
+	BytesIn     int64
+	BytesOut    int64
+	Channels    []ChannelInfo
+}
+
+// countingWriter counts the written bytes to the counters
+type countingWriter struct {
+	io.Writer
+	counters []*int64
+
+	// activity, if set, the last written time is stored in unix nano
+	activity *int64
+}
+
+func (w *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = w.Writer.Write(p)
+	for _, c := range w.counters {
+		atomic.AddInt64(c, int64(n))
+	}
+	if w.activity != nil {
+		atomic.StoreInt64(w.activity, time.Now().UnixNano())
+	}
+
+	return
+}
+
+type channelState struct {
+	id       string
+	kind     string
+	started  time.Time
+	bytesIn  int64
+	bytesOut int64
+
+	// stderr is used to send message to the client; it can be nil.
+	stderr io.Writer
+	// output is the output stream of the channel to the client; it can be
+	// nil.
+	output *outputTee
+	pty    int32
+	done   chan struct{}
+}
+
+func (s *channelState) setPty() {
+	atomic.StoreInt32(&s.pty, 1)
+}
+
+func (s *channelState) isPty() bool {
+	return atomic.LoadInt32(&s.pty) == 1
+}
+
+func (s *channelState) info() ChannelInfo {
+	return ChannelInfo{
+		ID:          s.id,
+		Type:        s.kind,
+		TimeStarted: s.started,
+		BytesIn:     atomic.LoadInt64(&s.bytesIn),
+		BytesOut:    atomic.LoadInt64(&s.bytesOut),
+	}
+}
+
+// SessionRegistry keeps the active connections
+type SessionRegistry struct {
+	sync.RWMutex
+	connections map[string]*connection
+}
+
+// NewSessionRegistry makes SessionRegistry
+func NewSessionRegistry() *SessionRegistry {
+	return &SessionRegistry{connections: map[string]*connection{}}
+}
+
+func (r *SessionRegistry) add(c *connection) {
+	r.Lock()
+	defer r.Unlock()
+
+	r.connections[c.id] = c
+}
+
+func (r *SessionRegistry) remove(c *connection) {
+	r.Lock()
+	defer r.Unlock()
+
+	delete(r.connections, c.id)
+}
+
+func (r *SessionRegistry) get(id string) (*connection, bool) {
+	r.RLock()
+	defer r.RUnlock()
+
+	c, ok := r.connections[id]
+	return c, ok
+}
+
+func (r *SessionRegistry) find(f func(SessionInfo) bool) (found []*connection) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, c := range r.connections {
+		if f(c.info()) {
+			found = append(found, c)
+		}
+	}
+
+	return
+}
+
+// GetSessions returns the active sessions ordered by the started time
+func (r *SessionRegistry) GetSessions() (sessions []SessionInfo) {
+	sessions = []SessionInfo{}
+	for _, c := range r.find(func(SessionInfo) bool { return true }) {
+		sessions = append(sessions, c.info())
+	}
+
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].TimeStarted.Before(sessions[j].TimeStarted)
+	})
+
+	return
+}
+
+// Kill closes the matched sessions after notifying the reason to the user;
+// the sessions are matched by the session id, or the user id and host id of
+// the proxied sessions.
+func (r *SessionRegistry) Kill(sessionID, userID, hostID, reason string) (killed []SessionInfo, err error) {
+	var found []*connection
+	if len(sessionID) > 0 {
+		c, ok := r.get(sessionID)
+		if !ok {
+			err = fmt.Errorf("session, '%s' does not exist", sessionID)
+			return
+		}
+		found = append(found, c)
+	} else if len(userID) > 0 || len(hostID) > 0 {
+		found = r.find(func(info SessionInfo) bool {
+			if info.InsideSault {
+				return false
+			}
+			if len(userID) > 0 && info.UserID != userID {
+				return false
+			}
+			if len(hostID) > 0 && info.HostID != hostID {
+				return false
+			}
+
+			return true
+		})
+	} else {
+		err = fmt.Errorf("session id, user id or host id must be given")
+		return
+	}
+
+	killed = []SessionInfo{}
+	for _, c := range found {
+		killed = append(killed, c.info())
+		c.kill(reason)
+	}
+
+	return
+}
+
+func (c *connection) info() SessionInfo {
+	c.channelsLock.RLock()
+	defer c.channelsLock.RUnlock()
+
+	info := SessionInfo{
+		ID:          c.id,
+		UserID:      c.user.ID,
+		Account:     c.account,
+		InsideSault: c.insideSault,
+		Reason:      c.reason,
+		BreakGlass:  c.breakGlass,
+		TimeStarted: c.started,
+		BytesIn:     atomic.LoadInt64(&c.bytesIn),
+		BytesOut:    atomic.LoadInt64(&c.bytesOut),
+		Channels:    []ChannelInfo{},
+	}
+	if !c.insideSault {
+		info.HostID = c.host.ID
+	}
+	if c.Conn != nil {
+		info.RemoteAddr = c.RemoteAddr().String()
+	}
+
+	for _, s := range c.channels {
+		info.Channels = append(info.Channels, s.info())
+	}
+	sort.SliceStable(info.Channels, func(i, j int) bool {
+		return info.Channels[i].TimeStarted.Before(info.Channels[j].TimeStarted)
+	})
+
+	return info
+}
+
+func (c *connection) addChannel(id, kind string, stderr io.Writer, output *outputTee) *channelState {
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+
+	s := &channelState{
+		id:      id,
+		kind:    kind,
+		started: time.Now().UTC(),
+		stderr:  stderr,
+		output:  output,
+		done:    make(chan struct{}),
+	}
+	if c.channels == nil {
+		c.channels = map[string]*channelState{}
+	}
+	c.channels[id] = s
+
+	return s
+}
+
+func (c *connection) removeChannel(id string) {
+	c.channelsLock.Lock()
+	defer c.channelsLock.Unlock()
+
+	if s, ok := c.channels[id]; ok {
+		close(s.done)
+		delete(c.channels, id)
+	}
+}
+
+// countingWriter returns the writer, which counts the bytes to the channel
+// and connection; in is the direction from client to host.
+func (c *connection) countingWriter(w io.Writer, s *channelState, in bool) io.Writer {
+	direction := "out"
+	counters := []*int64{&s.bytesOut, &c.bytesOut}
+	if in {
+		direction = "in"
+		counters = []*int64{&s.bytesIn, &c.bytesIn}
+	}
+
+	if !c.insideSault {
+		counters = append(counters, serverMetrics.proxiedBytes.with(c.host.ID, direction))
+	}
+
+	writer := &countingWriter{Writer: w, counters: counters}
+	if in {
+		writer.activity = &c.lastActivity
+	}
+
+	return writer
+}
+
+// notify sends the message to the client thru the stderr of the channels
+func (c *connection) notify(message string) {
+	c.channelsLock.RLock()
+	defer c.channelsLock.RUnlock()
+
+	for _, s := range c.channels {
+		if s.stderr == nil {
+			continue
+		}
+		s.stderr.Write([]byte(message))
+	}
+}
+
+// kill closes the connection after notifying the reason to the user
+func (c *connection) kill(reason string) {
+	c.terminate(AuditEventSessionKill, reason)
+}
+
+// terminate closes the connection after notifying the reason to the user
+func (c *connection) terminate(eventType AuditEventType, reason string) {
+	c.log.Infof("session will be terminated: %s", reason)
+
+	message, _ := saultcommon.SimpleTemplating(
+		"\r\n{{ \"* sault\" | blue }} {{ \"session terminated\" | red }} {{ . }}\r\n",
+		reason,
+	)
+	c.notify(message)
+
+	c.audit(AuditEvent{
+		Type: eventType,
+		Data: map[string]interface{}{"reason": reason},
+	})
+
+	if c.sshConn != nil {
+		c.sshConn.Close()
+		return
+	}
+
+	c.close()
+}
+
+var watchBufferSize = 256
+
+// SessionWatcher gets the copy of the output of the pty session. The output
+// is buffered, so the slow watcher does not block the watched session; if the
+// buffer is full, the output is dropped.
+type SessionWatcher struct {
+	ID      string
+	Session SessionInfo
+	Channel ChannelInfo
+
+	watcherID  string
+	connection *connection
+	state      *channelState
+	buffer     chan []byte
+	stopOnce   sync.Once
+	stopped    chan struct{}
+}
+
+// Watch finds the pty session by the session id or the channel id for
+// watching; the watching is started by SessionWatcher.Start.
+func (r *SessionRegistry) Watch(id, watcherID string) (*SessionWatcher, error) {
+	r.RLock()
+	defer r.RUnlock()
+
+	for _, c := range r.connections {
+		state := c.findWatchableChannel(id)
+		if state == nil {
+			continue
+		}
+
+		return &SessionWatcher{
+			ID:         saultcommon.MakeRandomString(),
+			Session:    c.info(),
+			Channel:    state.info(),
+			watcherID:  watcherID,
+			connection: c,
+			state:      state,
+			buffer:     make(chan []byte, watchBufferSize),
+			stopped:    make(chan struct{}),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("pty session, '%s' does not exist", id)
+}
+
+func (c *connection) findWatchableChannel(id string) *channelState {
+	c.channelsLock.RLock()
+	defer c.channelsLock.RUnlock()
+
+	var found *channelState
+	for _, s := range c.channels {
+		if s.output == nil || !s.isPty() {
+			continue
+		}
+		if s.id == id {
+			return s
+		}
+		if c.id == id && (found == nil || s.started.Before(found.started)) {
+			found = s
+		}
+	}
+
+	return found
+}
+
+func (w *SessionWatcher) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	copy(b, p)
+
+	select {
+	case w.buffer <- b:
+	default:
+	}
+
+	return len(p), nil
+}
+
+// Start starts to copy the output of session to out; the watched user will
+// be notified.
+func (w *SessionWatcher) Start(out io.Writer) {
+	go func() {
+		for {
+			select {
+			case b := <-w.buffer:
+				if _, err := out.Write(b); err != nil {
+					w.Stop()
+					return
+				}
+			case <-w.stopped:
+				return
+			}
+		}
+	}()
+
+	w.state.output.addTap("watcher-"+w.ID, w)
+
+	w.notify(fmt.Sprintf("{{ \"your session is being watched by\" | yellow }} {{ \"%s\" | colorUserID }}", w.watcherID))
+	w.connection.audit(AuditEvent{
+		Type:      AuditEventSessionWatch,
+		ChannelID: w.state.id,
+		Data:      map[string]interface{}{"watcher": w.watcherID, "action": "start"},
+	})
+}
+
+// Stop stops watching
+func (w *SessionWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopped)
+		w.state.output.removeTap("watcher-" + w.ID)
+
+		w.notify(fmt.Sprintf("{{ \"%s\" | colorUserID }} {{ \"stopped watching your session\" | yellow }}", w.watcherID))
+		w.connection.audit(AuditEvent{
+			Type:      AuditEventSessionWatch,
+			ChannelID: w.state.id,
+			Data:      map[string]interface{}{"watcher": w.watcherID, "action": "stop"},
+		})
+	})
+}
+
+// Stopped is closed when the watching is stopped
+func (w *SessionWatcher) Stopped() <-chan struct{} {
+	return w.stopped
+}
+
+// Done is closed when the watched session is closed
+func (w *SessionWatcher) Done() <-chan struct{} {
+	return w.state.done
+}
+
+func (w *SessionWatcher) notify(message string) {
+	if w.state.stderr == nil {
+		return
+	}
+
+	select {
+	case <-w.state.done:
+		return
+	default:
+	}
+
+	t, _ := saultcommon.SimpleTemplating("\r\n{{ \"* sault\" | blue }} "+message+"\r\n", nil)
+	w.state.stderr.Write([]byte(t))
+}
@@ -0,0 +1,151 @@
+package sault
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	sessions := NewSessionRegistry()
+
+	newTestConnection := func(id, userID, hostID string) (*connection, *bytes.Buffer) {
+		server, client := net.Pipe()
+		defer client.Close()
+
+		c := &connection{
+			Conn:    server,
+			id:      id,
+			log:     log.WithFields(logrus.Fields{}),
+			user:    saultregistry.UserRegistry{ID: userID},
+			host:    saultregistry.HostRegistry{ID: hostID},
+			account: "ubuntu",
+		}
+
+		var stderr bytes.Buffer
+		state := c.addChannel(id+"-1", "session", &stderr, nil)
+		c.countingWriter(&bytes.Buffer{}, state, true).Write([]byte("findme"))
+
+		sessions.add(c)
+		return c, &stderr
+	}
+
+	_, stderr0 := newTestConnection("a", "spikeekips", "prometeus")
+	_, stderr1 := newTestConnection("b", "spikeekips", "athena")
+	newTestConnection("c", "ekips", "prometeus")
+
+	{
+		result := sessions.GetSessions()
+		assert.Equal(t, 3, len(result))
+		assert.Equal(t, int64(6), result[0].BytesIn)
+		assert.Equal(t, 1, len(result[0].Channels))
+		assert.Equal(t, int64(6), result[0].Channels[0].BytesIn)
+	}
+
+	{
+		_, err := sessions.Kill("", "", "", "")
+		assert.NotNil(t, err)
+
+		_, err = sessions.Kill("unknown", "", "", "")
+		assert.NotNil(t, err)
+	}
+
+	{
+		killed, err := sessions.Kill("", "spikeekips", "prometeus", "maintenance")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(killed))
+		assert.Equal(t, "a", killed[0].ID)
+		assert.Contains(t, stderr0.String(), "maintenance")
+		assert.Equal(t, 0, stderr1.Len())
+	}
+
+	{
+		// without server, the killed connection is not removed by itself
+		sessions.remove(&connection{id: "a"})
+		killed, err := sessions.Kill("", "spikeekips", "", "maintenance")
+		assert.Nil(t, err)
+		assert.Equal(t, 1, len(killed))
+		assert.Equal(t, "b", killed[0].ID)
+	}
+}
+
+type lockedBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.Buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+
+	return b.Buffer.String()
+}
+
+func TestSessionRegistryWatch(t *testing.T) {
+	sessions := NewSessionRegistry()
+
+	c := &connection{
+		id:   "a",
+		log:  log.WithFields(logrus.Fields{}),
+		user: saultregistry.UserRegistry{ID: "spikeekips"},
+		host: saultregistry.HostRegistry{ID: "prometeus"},
+	}
+	sessions.add(c)
+
+	var client, stderr lockedBuffer
+	output := newOutputTee(&client)
+	state := c.addChannel("a-1", "session", &stderr, output)
+
+	{
+		// not pty
+		_, err := sessions.Watch("a", "ekips")
+		assert.NotNil(t, err)
+	}
+
+	state.setPty()
+
+	{
+		_, err := sessions.Watch("unknown", "ekips")
+		assert.NotNil(t, err)
+	}
+
+	watcher, err := sessions.Watch("a", "ekips")
+	assert.Nil(t, err)
+	assert.Equal(t, "a-1", watcher.Channel.ID)
+
+	var watched lockedBuffer
+	watcher.Start(&watched)
+	assert.Contains(t, stderr.String(), "ekips")
+
+	output.Write([]byte("findme"))
+	for i := 0; i < 100 && len(watched.String()) < 1; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, "findme", watched.String())
+	assert.Equal(t, "findme", client.String())
+
+	watcher.Stop()
+	output.Write([]byte("showme"))
+	time.Sleep(time.Millisecond * 10)
+	assert.Equal(t, "findme", watched.String())
+
+	c.removeChannel("a-1")
+	select {
+	case <-watcher.Done():
+	default:
+		assert.Fail(t, "watcher.Done() must be closed")
+	}
+}
@@ -0,0 +1,167 @@
+package sault
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+)
+
+var intervalSessionTimer = time.Second
+
+// sessionTimer decides when the session should be warned and closed by the
+// idle timeout and the maximum session duration; 0 means no limit.
+type sessionTimer struct {
+	idle    time.Duration
+	max     time.Duration
+	warning time.Duration
+	started time.Time
+
+	idleWarned bool
+	maxWarned  bool
+}
+
+func (t *sessionTimer) isEnabled() bool {
+	return t.idle > 0 || t.max > 0
+}
+
+// check returns the warning message, if the session will be closed soon, and
+// the reason, if the session must be closed now.
+func (t *sessionTimer) check(now, lastActivity time.Time) (warning, expired string) {
+	if t.max > 0 {
+		remain := t.started.Add(t.max).Sub(now)
+		if remain <= 0 {
+			expired = fmt.Sprintf("maximum session duration, %s exceeded", t.max)
+			return
+		}
+		if remain <= t.warning && !t.maxWarned {
+			t.maxWarned = true
+			warning = fmt.Sprintf(
+				"session will be closed in %s by the maximum session duration, %s",
+				remain-remain%time.Second,
+				t.max,
+			)
+		}
+	}
+
+	if t.idle > 0 {
+		remain := lastActivity.Add(t.idle).Sub(now)
+		if remain <= 0 {
+			expired = fmt.Sprintf("idle for %s", t.idle)
+			return
+		}
+		if remain > t.warning {
+			// the client is back; warn again at next idle
+			t.idleWarned = false
+		} else if !t.idleWarned && len(warning) < 1 {
+			t.idleWarned = true
+			warning = fmt.Sprintf(
+				"session will be closed in %s by idle timeout, %s; press any key to keep it",
+				remain-remain%time.Second,
+				t.idle,
+			)
+		}
+	}
+
+	return
+}
+
+// getSessionTimeouts returns the idle timeout and the maximum session
+// duration; the timeouts of link override the host's, and the host's
+// override the sault server's. The session does not last after the link is
+// expired.
+func (c *connection) getSessionTimeouts() (idle, max time.Duration) {
+	if c.server.config != nil {
+		idle = c.server.config.Session.GetIdleTimeout()
+		max = c.server.config.Session.GetMaxDuration()
+	}
+
+	var expires time.Time
+	overrides := [][]string{{c.host.IdleTimeout, c.host.MaxDuration}}
+	if link, err := c.server.registry.GetLink(c.user.ID, c.host.ID); err == nil {
+		overrides = append(overrides, []string{link.IdleTimeout, link.MaxDuration})
+		expires = link.GetDateExpires(c.account)
+	}
+
+	for _, o := range overrides {
+		if d, err := saultregistry.ParseSessionTimeout(o[0]); err != nil {
+			c.log.Error(err)
+		} else if d >= 0 {
+			idle = d
+		}
+		if d, err := saultregistry.ParseSessionTimeout(o[1]); err != nil {
+			c.log.Error(err)
+		} else if d >= 0 {
+			max = d
+		}
+	}
+
+	if !expires.IsZero() {
+		d := expires.Sub(c.started)
+		if d <= 0 {
+			d = time.Nanosecond
+		}
+		if max == 0 || d < max {
+			max = d
+		}
+	}
+
+	return
+}
+
+// touch marks the activity of client
+func (c *connection) touch() {
+	atomic.StoreInt64(&c.lastActivity, time.Now().UnixNano())
+}
+
+func (c *connection) getLastActivity() time.Time {
+	last := atomic.LoadInt64(&c.lastActivity)
+	if last < 1 {
+		return c.started
+	}
+
+	return time.Unix(0, last)
+}
+
+// runSessionTimer closes the session by the idle timeout and the maximum
+// session duration until stop is closed.
+func (c *connection) runSessionTimer(stop <-chan struct{}) {
+	timer := &sessionTimer{started: c.started}
+	timer.idle, timer.max = c.getSessionTimeouts()
+	if c.server.config != nil {
+		timer.warning = c.server.config.Session.GetWarning()
+	}
+
+	if !timer.isEnabled() {
+		return
+	}
+
+	c.log.Debugf("session timeouts: idle=%s max=%s", timer.idle, timer.max)
+
+	ticker := time.NewTicker(intervalSessionTimer)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			warning, expired := timer.check(now, c.getLastActivity())
+			if len(warning) > 0 {
+				c.log.Debug(warning)
+
+				message, _ := saultcommon.SimpleTemplating(
+					"\r\n{{ \"* sault\" | blue }} {{ . | yellow }}\r\n",
+					warning,
+				)
+				c.notify(message)
+			}
+			if len(expired) > 0 {
+				c.terminate(AuditEventSessionTimeout, expired)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package sault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSessionTimerIdle(t *testing.T) {
+	started := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	timer := &sessionTimer{idle: time.Minute * 10, warning: time.Minute, started: started}
+	assert.True(t, timer.isEnabled())
+
+	{
+		warning, expired := timer.check(started.Add(time.Minute*8), started)
+		assert.Empty(t, warning)
+		assert.Empty(t, expired)
+	}
+
+	{
+		warning, expired := timer.check(started.Add(time.Minute*9+time.Second*30), started)
+		assert.Contains(t, warning, "idle timeout")
+		assert.Contains(t, warning, "30s")
+		assert.Empty(t, expired)
+
+		// warned only once
+		warning, _ = timer.check(started.Add(time.Minute*9+time.Second*40), started)
+		assert.Empty(t, warning)
+	}
+
+	{
+		// the activity resets the warning
+		last := started.Add(time.Minute * 9)
+		warning, expired := timer.check(started.Add(time.Minute*10), last)
+		assert.Empty(t, warning)
+		assert.Empty(t, expired)
+
+		warning, _ = timer.check(last.Add(time.Minute*9+time.Second), last)
+		assert.NotEmpty(t, warning)
+
+		_, expired = timer.check(last.Add(time.Minute*10), last)
+		assert.Contains(t, expired, "idle")
+	}
+}
+
+func TestSessionTimerMaxDuration(t *testing.T) {
+	started := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	timer := &sessionTimer{max: time.Hour, warning: time.Minute, started: started}
+
+	{
+		// activity does not extend the maximum session duration
+		warning, expired := timer.check(started.Add(time.Minute*59+time.Second), started.Add(time.Minute*59))
+		assert.Contains(t, warning, "maximum session duration")
+		assert.Empty(t, expired)
+	}
+
+	{
+		warning, expired := timer.check(started.Add(time.Hour), started.Add(time.Minute*59))
+		assert.Empty(t, warning)
+		assert.Contains(t, expired, "maximum session duration")
+	}
+
+	assert.False(t, (&sessionTimer{warning: time.Minute}).isEnabled())
+}
+
+func TestGetSessionTimeouts(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus", uint64(22), []string{"ubuntu"})
+	assert.Nil(t, registry.Link(user.ID, host.ID, "ubuntu"))
+
+	config := NewConfig()
+	config.Session.IdleTimeout = "30m"
+	config.Session.MaxDuration = "12h"
+	assert.Nil(t, config.validateSession())
+
+	server, _ := NewServer(registry, config, nil, nil, DefaultSaultServerName)
+	newConn := func() *connection {
+		host, _ := registry.GetHost("prometeus", saultregistry.HostFilterNone)
+		return &connection{server: server, user: user, host: host, log: log.WithFields(logrus.Fields{})}
+	}
+
+	{
+		idle, max := newConn().getSessionTimeouts()
+		assert.Equal(t, time.Minute*30, idle)
+		assert.Equal(t, time.Hour*12, max)
+	}
+
+	{
+		// host overrides sault server
+		host.IdleTimeout = "10m"
+		host.MaxDuration = "0"
+		_, err := registry.UpdateHost(host.ID, host)
+		assert.Nil(t, err)
+
+		idle, max := newConn().getSessionTimeouts()
+		assert.Equal(t, time.Minute*10, idle)
+		assert.Equal(t, time.Duration(0), max)
+	}
+
+	{
+		// link overrides host
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, saultregistry.LinkAccountRegistry{MaxDuration: "1h"}))
+
+		idle, max := newConn().getSessionTimeouts()
+		assert.Equal(t, time.Minute*10, idle)
+		assert.Equal(t, time.Hour, max)
+	}
+
+	{
+		// the session does not last after the link is expired
+		c := newConn()
+		c.account = "ubuntu"
+		c.started = time.Now()
+
+		link, _ := registry.GetLink(user.ID, host.ID)
+		link.DateExpires = c.started.Add(time.Minute * 20)
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, link))
+
+		_, max := c.getSessionTimeouts()
+		assert.Equal(t, time.Minute*20, max)
+
+		link.MaxDuration = "0"
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, link))
+
+		_, max = c.getSessionTimeouts()
+		assert.Equal(t, time.Minute*20, max)
+	}
+}
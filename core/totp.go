@@ -0,0 +1,211 @@
+package sault
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+const (
+	// TOTPModeNone does not require the TOTP
+	TOTPModeNone = "none"
+	// TOTPModeAll requires the TOTP for all the users
+	TOTPModeAll = "all"
+	// TOTPModeSensitive requires the TOTP for the admins and the sensitive
+	// hosts
+	TOTPModeSensitive = "sensitive"
+)
+
+const (
+	secondFactorTOTP     = "totp"
+	secondFactorRecovery = "recovery-code"
+)
+
+var errInvalidTOTPCode = errors.New("invalid verification code")
+var errTOTPEnrollOnly = errors.New("TOTP is not enrolled yet; only 'user totp enroll' is allowed")
+
+// totpReplayGuard keeps the last used time step of users to prevent the
+// replay of the same code
+type totpReplayGuard struct {
+	sync.Mutex
+	last map[string]uint64
+}
+
+func newTOTPReplayGuard() *totpReplayGuard {
+	return &totpReplayGuard{last: map[string]uint64{}}
+}
+
+// use marks the time step as used; it returns false if the same or the older
+// step was already used.
+func (g *totpReplayGuard) use(userID string, counter uint64) bool {
+	g.Lock()
+	defer g.Unlock()
+
+	if last, found := g.last[userID]; found && counter <= last {
+		return false
+	}
+	g.last[userID] = counter
+
+	return true
+}
+
+// canEnrollOwnTOTP checks whether the user enrolls the TOTP of oneself, which
+// is not enrolled yet; replacing the enrolled secret needs the admin.
+func (c *connection) canEnrollOwnTOTP(msg saultcommon.CommandMsg) bool {
+	var userID string
+	if err := msg.GetData(&userID); err != nil || userID != c.user.ID {
+		return false
+	}
+
+	user, err := c.server.registry.GetUser(userID, nil, saultregistry.UserFilterNone)
+	if err != nil {
+		return false
+	}
+
+	return !user.HasTOTP()
+}
+
+// isTOTPRequired checks whether the TOTP is required for the authenticated
+// user and host; inside sault, the user, who is linked to the sensitive host,
+// also requires it, because the host can be selected by the host picker.
+func (c *connection) isTOTPRequired(user saultregistry.UserRegistry) bool {
+	if c.server.config == nil {
+		return false
+	}
+
+	switch c.server.config.Server.TOTP {
+	case TOTPModeAll:
+		return true
+	case TOTPModeSensitive:
+		if user.IsAdmin {
+			return true
+		}
+
+		if !c.insideSault {
+			return c.host.Sensitive
+		}
+
+		for hostID := range c.server.registry.GetLinksOfUser(user.ID) {
+			host, err := c.server.registry.GetHost(hostID, saultregistry.HostFilterIsActive)
+			if err == nil && host.Sensitive {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// checkTOTP requires the keyboard-interactive authentication for the TOTP
+// after the public key authentication, if required. The user, who does not
+// enroll the TOTP yet, can enter sault only to enroll it; see
+// isCommandAllowed().
+func (c *connection) checkTOTP(user saultregistry.UserRegistry) (*saultssh.Permissions, error) {
+	if !c.isTOTPRequired(user) {
+		return nil, nil
+	}
+
+	if !user.HasTOTP() {
+		if c.insideSault {
+			c.totpEnrollOnly = true
+			c.log.Warnf("TOTP is required, but user, '%s' does not enroll it; only 'user totp enroll' is allowed", user.ID)
+			return nil, nil
+		}
+
+		err := &authenticationFailedError{
+			Err:    fmt.Errorf("TOTP is required, but user, '%s' does not enroll it; enroll it inside sault", user.ID),
+			Reason: authFailedReasonTOTPNotEnrolled,
+		}
+		c.log.Error(err)
+		return nil, err
+	}
+
+	c.log.Debugf("TOTP is required; %s", user)
+
+	return nil, &saultssh.PartialSuccessError{
+		Next: saultssh.ServerAuthCallbacks{
+			KeyboardInteractiveCallback: c.totpCallback,
+		},
+	}
+}
+
+// totpCallback asks the verification code; the code is the TOTP code or one
+// of the recovery codes.
+func (c *connection) totpCallback(
+	conn saultssh.ConnMetadata,
+	challenge saultssh.KeyboardInteractiveChallenge,
+) (perm *saultssh.Permissions, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+
+		serverMetrics.authTotal.inc("failure", authFailedReasonTOTP)
+
+		fingerprint := saultcommon.FingerprintSHA256PublicKey(c.publicKey)
+		c.audit(AuditEvent{
+			Type:        AuditEventAuthFailure,
+			Fingerprint: fingerprint,
+			Error:       err.Error(),
+			Data: map[string]interface{}{
+				"login":  conn.User(),
+				"method": "keyboard-interactive",
+			},
+		})
+
+		c.banFailed(BanKindFingerprint, fingerprint)
+	}()
+
+	answers, err := challenge("", "", []string{"Verification code: "}, []bool{false})
+	if err != nil {
+		return
+	}
+	if len(answers) != 1 {
+		err = &authenticationFailedError{Err: errInvalidTOTPCode, Reason: authFailedReasonTOTP}
+		return
+	}
+
+	// the user can be updated after the public key authentication
+	user, err := c.server.registry.GetUser(c.user.ID, nil, saultregistry.UserFilterIsActive)
+	if err != nil {
+		err = &authenticationFailedError{Err: err, Reason: authFailedReasonUnknownUser}
+		return
+	}
+
+	if counter, ok := saultcommon.VerifyTOTPCode(user.TOTPSecret, answers[0], time.Now()); ok {
+		if !c.server.totpCounters.use(user.ID, counter) {
+			err = &authenticationFailedError{
+				Err:    errors.New("verification code was already used"),
+				Reason: authFailedReasonTOTP,
+			}
+			c.log.Error(err)
+			return
+		}
+
+		c.secondFactor = secondFactorTOTP
+		c.log.Debugf("TOTP verified; %s", user)
+		return nil, nil
+	}
+
+	if c.server.registry.UseTOTPRecoveryCode(user.ID, answers[0]) {
+		if err = c.server.registry.Save(); err != nil {
+			c.log.Errorf("failed to save the used recovery code: %v", err)
+			err = &authenticationFailedError{Err: err, Reason: authFailedReasonTOTP}
+			return
+		}
+
+		c.secondFactor = secondFactorRecovery
+		c.log.Warnf("recovery code is used; %s", user)
+		return nil, nil
+	}
+
+	err = &authenticationFailedError{Err: errInvalidTOTPCode, Reason: authFailedReasonTOTP}
+	c.log.Error(err)
+
+	return
+}
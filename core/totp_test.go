@@ -0,0 +1,241 @@
+package sault
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTOTPHandshake runs the ssh handshake with the public key and the
+// keyboard-interactive, which answers the given code; it returns the
+// questions, which the server asked.
+func testTOTPHandshake(t *testing.T, server *Server, signer saultssh.Signer, login, code string) (questions []string, c *connection, err error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	served := make(chan error, 1)
+	go func() {
+		a, err := listener.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		defer a.Close()
+
+		c = &connection{Conn: a, server: server, log: log.WithFields(logrus.Fields{})}
+		conn, _, _, err := saultssh.NewServerConn(c, c.getServerConfig())
+		if err == nil {
+			conn.Close()
+		}
+		served <- err
+	}()
+
+	challenge := func(user, instruction string, q []string, echos []bool) ([]string, error) {
+		questions = append(questions, q...)
+		for _, e := range echos {
+			assert.False(t, e)
+		}
+		return []string{code}, nil
+	}
+
+	clientConn, err := saultssh.Dial("tcp", listener.Addr().String(), &saultssh.ClientConfig{
+		User: login,
+		Auth: []saultssh.AuthMethod{
+			saultssh.PublicKeys(signer),
+			saultssh.RetryableAuthMethod(saultssh.KeyboardInteractive(challenge), 1),
+		},
+		HostKeyCallback: saultssh.InsecureIgnoreHostKey(),
+	})
+	if err == nil {
+		clientConn.Close()
+	}
+
+	<-served
+	return
+}
+
+func TestTOTPAuthentication(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	signer, _ := saultssh.NewSignerFromKey(privateKey)
+	encoded, _ := saultcommon.EncodePublicKey(signer.PublicKey())
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	normal, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+	registry.Link(user.ID, normal.ID, "ubuntu")
+
+	sensitive, _ := registry.AddHost("zeus", "zeus.local", uint64(22), []string{"ubuntu"})
+	sensitive.Sensitive = true
+	registry.UpdateHost(sensitive.ID, sensitive)
+	registry.Link(user.ID, sensitive.ID, "ubuntu")
+
+	hostKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	hostKeySigner, _ := saultssh.NewSignerFromKey(hostKey)
+
+	config := NewConfig()
+	config.Server.TOTP = TOTPModeSensitive
+
+	sink := &testAuditSink{}
+	server := &Server{
+		saultServerName: DefaultSaultServerName,
+		registry:        registry,
+		config:          config,
+		hostKeySigner:   hostKeySigner,
+		auditor:         newAuditor([]AuditSink{sink}),
+		bans:            NewBanRegistry(),
+		totpCounters:    newTOTPReplayGuard(),
+	}
+
+	{
+		// the normal host does not require TOTP
+		questions, _, err := testTOTPHandshake(t, server, signer, "ubuntu+prometeus", "")
+		assert.Nil(t, err)
+		assert.Empty(t, questions)
+	}
+
+	{
+		// not enrolled
+		questions, _, err := testTOTPHandshake(t, server, signer, "ubuntu+zeus", "")
+		assert.NotNil(t, err)
+		assert.Empty(t, questions)
+	}
+
+	secret, codes, _ := registry.EnrollTOTP(user.ID)
+
+	{
+		questions, _, err := testTOTPHandshake(t, server, signer, "ubuntu+zeus", "000000")
+		assert.NotNil(t, err)
+		assert.Equal(t, []string{"Verification code: "}, questions)
+	}
+
+	code, _ := saultcommon.GetTOTPCode(secret, time.Now())
+	{
+		_, c, err := testTOTPHandshake(t, server, signer, "ubuntu+zeus", code)
+		assert.Nil(t, err)
+		assert.Equal(t, secondFactorTOTP, c.secondFactor)
+	}
+
+	{
+		// the same code can not be used again
+		_, _, err := testTOTPHandshake(t, server, signer, "ubuntu+zeus", code)
+		assert.NotNil(t, err)
+	}
+
+	{
+		// inside sault, the user linked to the sensitive host
+		_, c, err := testTOTPHandshake(t, server, signer, DefaultSaultServerName, codes[0])
+		assert.Nil(t, err)
+		assert.Equal(t, secondFactorRecovery, c.secondFactor)
+
+		_, _, err = testTOTPHandshake(t, server, signer, DefaultSaultServerName, codes[0])
+		assert.NotNil(t, err)
+	}
+
+	server.auditor.close()
+
+	var failures int
+	for _, event := range sink.events {
+		assert.Equal(t, AuditEventAuthFailure, event.Type)
+		failures++
+	}
+	// not enrolled, wrong code, replay and used recovery code
+	assert.Equal(t, 4, failures)
+}
+
+func TestCanEnrollOwnTOTP(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	otherPrivateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	otherPublicKey, _ := saultssh.NewPublicKey(otherPrivateKey.Public())
+	otherEncoded, _ := saultcommon.EncodePublicKey(otherPublicKey)
+	other, _ := registry.AddUser("dir", otherEncoded)
+
+	c := &connection{user: user, server: &Server{registry: registry}, log: log.WithFields(logrus.Fields{})}
+
+	own, _ := saultcommon.NewCommandMsg("user totp enroll", user.ID)
+	assert.True(t, c.canEnrollOwnTOTP(*own))
+
+	// the other user
+	others, _ := saultcommon.NewCommandMsg("user totp enroll", other.ID)
+	assert.False(t, c.canEnrollOwnTOTP(*others))
+
+	// already enrolled
+	registry.EnrollTOTP(user.ID)
+	assert.False(t, c.canEnrollOwnTOTP(*own))
+}
+
+func TestTOTPEnrollOnly(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	signer, _ := saultssh.NewSignerFromKey(privateKey)
+	encoded, _ := saultcommon.EncodePublicKey(signer.PublicKey())
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+	registry.Link(user.ID, host.ID, "ubuntu")
+
+	hostKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	hostKeySigner, _ := saultssh.NewSignerFromKey(hostKey)
+
+	config := NewConfig()
+	config.Server.TOTP = TOTPModeAll
+
+	server := &Server{
+		saultServerName: DefaultSaultServerName,
+		registry:        registry,
+		config:          config,
+		hostKeySigner:   hostKeySigner,
+		auditor:         newAuditor(nil),
+		bans:            NewBanRegistry(),
+		totpCounters:    newTOTPReplayGuard(),
+	}
+
+	{
+		// the host can not be connected without the TOTP
+		_, _, err := testTOTPHandshake(t, server, signer, "ubuntu+prometeus", "")
+		assert.NotNil(t, err)
+	}
+
+	// inside sault, the user can enroll the TOTP only
+	questions, c, err := testTOTPHandshake(t, server, signer, DefaultSaultServerName, "")
+	assert.Nil(t, err)
+	assert.Empty(t, questions)
+	assert.True(t, c.totpEnrollOnly)
+
+	enroll, _ := saultcommon.NewCommandMsg("user totp enroll", user.ID)
+	assert.True(t, c.isCommandAllowed(*enroll))
+
+	whoami, _ := saultcommon.NewCommandMsg("whoami", nil)
+	assert.False(t, c.isCommandAllowed(*whoami))
+
+	request, _ := saultcommon.NewCommandMsg("access request", nil)
+	assert.False(t, c.isCommandAllowed(*request))
+
+	_, err = c.authorizeDirectTCPIP(channelOpenDirectMsg{Raddr: "prometeus", Rport: 22})
+	assert.Equal(t, errTOTPEnrollOnly, err)
+
+	// enrolled by 'user totp enroll'
+	secret, _, _ := registry.EnrollTOTP(user.ID)
+	assert.False(t, c.isCommandAllowed(*enroll))
+
+	code, _ := saultcommon.GetTOTPCode(secret, time.Now())
+	questions, c, err = testTOTPHandshake(t, server, signer, DefaultSaultServerName, code)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Verification code: "}, questions)
+	assert.False(t, c.totpEnrollOnly)
+	assert.True(t, c.isCommandAllowed(*whoami))
+}
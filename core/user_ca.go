@@ -0,0 +1,124 @@
+package sault
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+// userCertClockSkew is subtracted from the beginning of the validity to allow
+// the small clock difference of hosts
+const userCertClockSkew = time.Minute
+
+func (p *Server) getUserCASigner() saultssh.Signer {
+	if p.config == nil {
+		return nil
+	}
+
+	return p.config.Server.GetUserCASigner()
+}
+
+// newUserCertPermissions mirrors the link policy to the user certificate; the
+// inner host also prohibits the forwardings, which are not allowed by the
+// link.
+func newUserCertPermissions(link saultregistry.LinkAccountRegistry) saultssh.Permissions {
+	extensions := map[string]string{
+		"permit-pty":     "",
+		"permit-user-rc": "",
+	}
+	if link.AgentForwarding {
+		extensions["permit-agent-forwarding"] = ""
+	}
+	if link.RemoteForwarding {
+		extensions["permit-port-forwarding"] = ""
+	}
+
+	return saultssh.Permissions{
+		CriticalOptions: map[string]string{},
+		Extensions:      extensions,
+	}
+}
+
+// newUserCert signs the public key into the user certificate of the inner
+// connection; the principal is the account and the KeyId is the sault user.
+// The certificate is not valid after the link is expired.
+func newUserCert(
+	publicKey saultssh.PublicKey,
+	authority saultssh.Signer,
+	key innerClientKey,
+	link saultregistry.LinkAccountRegistry,
+	now time.Time,
+	validity time.Duration,
+) (*saultssh.Certificate, error) {
+	validBefore := now.Add(validity)
+	if expires := link.GetDateExpires(key.Account); !expires.IsZero() && expires.Before(validBefore) {
+		validBefore = expires
+	}
+
+	serial := make([]byte, 8)
+	if _, err := rand.Read(serial); err != nil {
+		return nil, err
+	}
+
+	cert := &saultssh.Certificate{
+		Key:             publicKey,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        saultssh.UserCert,
+		KeyId:           key.User,
+		ValidPrincipals: []string{key.Account},
+		ValidAfter:      uint64(now.Add(-userCertClockSkew).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+		Permissions:     newUserCertPermissions(link),
+	}
+	if err := cert.SignCert(rand.Reader, authority); err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// newUserCertSigner makes the ephemeral key and signs it by the user CA for
+// the inner connection of the given key.
+func (p *Server) newUserCertSigner(key innerClientKey) (saultssh.Signer, error) {
+	link, err := p.registry.GetLink(key.User, key.HostID)
+	if err != nil {
+		if !key.BreakGlass {
+			return nil, err
+		}
+		link = saultregistry.LinkAccountRegistry{Accounts: []string{key.Account}}
+	}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := saultssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	validity := p.config.Server.GetUserCertValidity()
+	if validity <= 0 {
+		validity, _ = time.ParseDuration(DefaultUserCertValidity)
+	}
+
+	cert, err := newUserCert(signer.PublicKey(), p.getUserCASigner(), key, link, time.Now(), validity)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf(
+		"user certificate signed, serial=%d key_id=%s principal=%s valid_before=%s",
+		cert.Serial,
+		cert.KeyId,
+		key.Account,
+		time.Unix(int64(cert.ValidBefore), 0).UTC(),
+	)
+
+	return saultssh.NewCertSigner(cert, signer)
+}
@@ -0,0 +1,199 @@
+package sault
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUserCert(t *testing.T) {
+	caKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	authority, _ := saultssh.NewSignerFromKey(caKey)
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	signer, _ := saultssh.NewSignerFromKey(privateKey)
+
+	key := innerClientKey{HostID: "prometeus", Account: "ubuntu", User: "spikeekips"}
+	now := time.Now()
+
+	checker := &saultssh.CertChecker{
+		IsUserAuthority: func(auth saultssh.PublicKey) bool {
+			return string(auth.Marshal()) == string(authority.PublicKey().Marshal())
+		},
+	}
+
+	{
+		link := saultregistry.LinkAccountRegistry{Accounts: []string{"ubuntu"}, AgentForwarding: true}
+		cert, err := newUserCert(signer.PublicKey(), authority, key, link, now, time.Minute*5)
+		assert.Nil(t, err)
+
+		assert.Equal(t, "spikeekips", cert.KeyId)
+		assert.Equal(t, []string{"ubuntu"}, cert.ValidPrincipals)
+		assert.Equal(t, uint64(now.Add(time.Minute*5).Unix()), cert.ValidBefore)
+
+		_, found := cert.Permissions.Extensions["permit-agent-forwarding"]
+		assert.True(t, found)
+		_, found = cert.Permissions.Extensions["permit-port-forwarding"]
+		assert.False(t, found)
+
+		assert.Nil(t, checker.CheckCert("ubuntu", cert))
+		assert.NotNil(t, checker.CheckCert("root", cert))
+	}
+
+	{
+		// the certificate is not valid after the link is expired
+		link := saultregistry.LinkAccountRegistry{All: true, DateExpires: now.Add(time.Minute)}
+		cert, err := newUserCert(signer.PublicKey(), authority, key, link, now, time.Minute*5)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(link.DateExpires.Unix()), cert.ValidBefore)
+
+		checker.Clock = func() time.Time { return now.Add(time.Minute * 2) }
+		assert.NotNil(t, checker.CheckCert("ubuntu", cert))
+	}
+
+	{
+		// the expiry of the other account is not applied
+		link := saultregistry.LinkAccountRegistry{
+			Accounts:       []string{"root", "ubuntu"},
+			AccountExpires: map[string]time.Time{"root": now.Add(time.Minute)},
+		}
+		cert, err := newUserCert(signer.PublicKey(), authority, key, link, now, time.Minute*5)
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(now.Add(time.Minute*5).Unix()), cert.ValidBefore)
+	}
+}
+
+func TestNewUserCertSigner(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+
+	caKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	config := NewConfig()
+	config.Server.userCASigner, _ = saultssh.NewSignerFromKey(caKey)
+	config.Server.userCertValidity = time.Minute
+
+	server := &Server{registry: registry, config: config}
+
+	key := innerClientKey{HostID: host.ID, Account: "ubuntu", User: user.ID}
+	{
+		// not linked
+		_, err := server.newUserCertSigner(key)
+		assert.NotNil(t, err)
+	}
+
+	registry.Link(user.ID, host.ID, "ubuntu")
+
+	signer, err := server.newUserCertSigner(key)
+	assert.Nil(t, err)
+
+	cert, ok := signer.PublicKey().(*saultssh.Certificate)
+	assert.True(t, ok)
+	assert.Equal(t, user.ID, cert.KeyId)
+	assert.Equal(
+		t,
+		string(config.Server.userCASigner.PublicKey().Marshal()),
+		string(cert.SignatureKey.Marshal()),
+	)
+}
+
+func TestDialInnerClientUserCert(t *testing.T) {
+	// the inner host records the offered keys and accepts none of them
+	var lock sync.Mutex
+	var offered []string
+	innerConfig := &saultssh.ServerConfig{
+		PublicKeyCallback: func(conn saultssh.ConnMetadata, key saultssh.PublicKey) (*saultssh.Permissions, error) {
+			lock.Lock()
+			defer lock.Unlock()
+
+			offered = append(offered, key.Type())
+			return nil, errors.New("denied")
+		},
+	}
+	innerHostKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	innerHostKeySigner, _ := saultssh.NewSignerFromKey(innerHostKey)
+	innerConfig.AddHostKey(innerHostKeySigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		for {
+			a, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer a.Close()
+				saultssh.NewServerConn(a, innerConfig)
+			}()
+		}
+	}()
+
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	user, _ := registry.AddUser("spikeekips", encoded)
+
+	address := listener.Addr().(*net.TCPAddr)
+	host, _ := registry.AddHost("prometeus", address.IP.String(), uint64(address.Port), []string{"ubuntu"})
+	registry.Link(user.ID, host.ID, "ubuntu")
+
+	caKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	config := NewConfig()
+	config.Server.userCASigner, _ = saultssh.NewSignerFromKey(caKey)
+	config.Server.userCertValidity = time.Minute
+
+	clientKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	clientKeySigner, _ := saultssh.NewSignerFromKey(clientKey)
+
+	server, _ := NewServer(registry, config, nil, clientKeySigner, DefaultSaultServerName)
+
+	key := newInnerClientKey(host, "ubuntu")
+	key.User = user.ID
+
+	dial := func() []string {
+		lock.Lock()
+		offered = nil
+		lock.Unlock()
+
+		_, err := server.dialInnerClient(key)
+		assert.NotNil(t, err)
+
+		lock.Lock()
+		defer lock.Unlock()
+
+		return offered
+	}
+
+	{
+		// only the user certificate
+		assert.Equal(t, []string{saultssh.CertAlgoECDSA256v01}, dial())
+	}
+
+	{
+		// the client key after the user certificate
+		config.Server.UserCAClientKeyFallback = true
+		assert.Equal(t, []string{saultssh.CertAlgoECDSA256v01, saultssh.KeyAlgoRSA}, dial())
+	}
+
+	{
+		// the signing error is returned without connecting to the host
+		registry.UnlinkAll(user.ID, host.ID)
+		assert.Empty(t, dial())
+	}
+}
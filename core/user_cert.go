@@ -0,0 +1,73 @@
+package sault
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+)
+
+const sourceAddressCriticalOption = "source-address"
+
+var errUntrustedUserCert = errors.New("user certificate is not signed by the trusted CA")
+
+func (p *Server) hasTrustedUserCA() bool {
+	return p.config != nil && p.config.Server.HasTrustedUserCA()
+}
+
+// getUserFromCertificate checks the user certificate, which is signed by the
+// trusted user CA, and finds the user by the principals of certificate in
+// order; the KeyId is used only for logging. The validity, the revocation and
+// the 'source-address' critical option are checked; the other critical
+// options are not supported.
+func (c *connection) getUserFromCertificate(cert *saultssh.Certificate) (user saultregistry.UserRegistry, err error) {
+	serverConfig := c.server.config.Server
+	if cert.CertType != saultssh.UserCert || !serverConfig.IsTrustedUserCA(cert.SignatureKey) {
+		err = errUntrustedUserCert
+		return
+	}
+
+	if len(cert.ValidPrincipals) < 1 {
+		err = fmt.Errorf("user certificate, key id='%s' does not have the principals", cert.KeyId)
+		return
+	}
+
+	var principal string
+	for _, id := range cert.ValidPrincipals {
+		if len(id) < 1 {
+			continue
+		}
+		if user, err = c.server.registry.GetUser(id, nil, saultregistry.UserFilterIsActive); err != nil {
+			continue
+		}
+
+		principal = id
+		break
+	}
+	if len(principal) < 1 {
+		err = fmt.Errorf(
+			"user of certificate, key id='%s' principals=%v does not exist",
+			cert.KeyId,
+			cert.ValidPrincipals,
+		)
+		return
+	}
+
+	checker := &saultssh.CertChecker{
+		SupportedCriticalOptions: []string{sourceAddressCriticalOption},
+		IsUserAuthority:          serverConfig.IsTrustedUserCA,
+		IsRevoked:                serverConfig.IsRevokedUserCert,
+	}
+	if err = checker.CheckCert(principal, cert); err != nil {
+		return
+	}
+
+	if sourceAddress, ok := cert.CriticalOptions[sourceAddressCriticalOption]; ok && c.Conn != nil {
+		if err = saultssh.CheckSourceAddress(c.RemoteAddr(), sourceAddress); err != nil {
+			return
+		}
+	}
+
+	return
+}
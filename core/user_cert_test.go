@@ -0,0 +1,158 @@
+package sault
+
+import (
+	"crypto/rand"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/spikeekips/sault/common"
+	"github.com/spikeekips/sault/registry"
+	"github.com/spikeekips/sault/saultssh"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUserCert(t *testing.T, authority saultssh.Signer, keyID string, principals []string, validBefore time.Time) *saultssh.Certificate {
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+
+	cert := &saultssh.Certificate{
+		Key:             publicKey,
+		Serial:          uint64(time.Now().UnixNano()),
+		CertType:        saultssh.UserCert,
+		KeyId:           keyID,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(time.Now().Add(-time.Minute).Unix()),
+		ValidBefore:     uint64(validBefore.Unix()),
+	}
+	assert.Nil(t, cert.SignCert(rand.Reader, authority))
+
+	return cert
+}
+
+func TestPublicKeyCallbackUserCert(t *testing.T) {
+	registry, _ := saultregistry.NewTestRegistryFromBytes([]byte{})
+
+	privateKey, _ := saultcommon.CreateRSAPrivateKey(256)
+	publicKey, _ := saultssh.NewPublicKey(privateKey.Public())
+	encoded, _ := saultcommon.EncodePublicKey(publicKey)
+	registry.AddUser("spikeekips", encoded)
+
+	caKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	authority, _ := saultssh.NewSignerFromKey(caKey)
+	otherCAKey, _ := saultcommon.CreateRSAPrivateKey(1024)
+	otherAuthority, _ := saultssh.NewSignerFromKey(otherCAKey)
+
+	config := NewConfig()
+	server := &Server{
+		saultServerName: DefaultSaultServerName,
+		registry:        registry,
+		config:          config,
+		auditor:         newAuditor(nil),
+		bans:            NewBanRegistry(),
+	}
+
+	// the remote address of connection is 127.0.0.1
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	go func() {
+		if a, err := listener.Accept(); err == nil {
+			defer a.Close()
+		}
+	}()
+	clientConn, _ := net.Dial("tcp", listener.Addr().String())
+	defer clientConn.Close()
+
+	connMeta := &testSSHConn{user: DefaultSaultServerName}
+	newConn := func() *connection {
+		return &connection{Conn: clientConn, server: server, log: log.WithFields(logrus.Fields{})}
+	}
+
+	validBefore := time.Now().Add(time.Hour)
+
+	{
+		// without the trusted CA, the certificate is not accepted
+		cert := newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore)
+		_, err := newConn().publicKeyCallback(connMeta, cert)
+		assert.NotNil(t, err)
+	}
+
+	config.Server.TrustedUserCAKeys = []string{saultcommon.GetAuthorizedKey(authority.PublicKey())}
+	assert.Nil(t, config.validateServerTrustedUserCAKeys())
+
+	cases := []struct {
+		name      string
+		cert      *saultssh.Certificate
+		setup     func(*saultssh.Certificate)
+		succeeded bool
+	}{
+		{"principal", newTestUserCert(t, authority, "spike@example.com", []string{"ops", "spikeekips"}, validBefore), nil, true},
+		{"key id is not the user", newTestUserCert(t, authority, "spikeekips", []string{"ubuntu"}, validBefore), nil, false},
+		{"no principals", newTestUserCert(t, authority, "spikeekips", nil, validBefore), nil, false},
+		{"unknown user", newTestUserCert(t, authority, "findkeys", []string{"ops"}, validBefore), nil, false},
+		{"untrusted", newTestUserCert(t, otherAuthority, "spikeekips", []string{"spikeekips"}, validBefore), nil, false},
+		{"expired", newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, time.Now().Add(-time.Second)), nil, false},
+		{
+			"revoked serial",
+			newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore),
+			func(cert *saultssh.Certificate) {
+				config.Server.RevokedUserCerts = []string{fmt.Sprintf("%d", cert.Serial)}
+			},
+			false,
+		},
+		{
+			"revoked fingerprint",
+			newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore),
+			func(cert *saultssh.Certificate) {
+				config.Server.RevokedUserCerts = []string{"SHA256:" + saultcommon.FingerprintSHA256PublicKey(cert.Key)}
+			},
+			false,
+		},
+		{
+			"source address",
+			newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore),
+			func(cert *saultssh.Certificate) {
+				cert.CriticalOptions = map[string]string{sourceAddressCriticalOption: "127.0.0.1/32"}
+				cert.SignCert(rand.Reader, authority)
+			},
+			true,
+		},
+		{
+			"source address not allowed",
+			newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore),
+			func(cert *saultssh.Certificate) {
+				cert.CriticalOptions = map[string]string{sourceAddressCriticalOption: "10.0.0.0/8"}
+				cert.SignCert(rand.Reader, authority)
+			},
+			false,
+		},
+		{
+			"unsupported critical option",
+			newTestUserCert(t, authority, "spikeekips", []string{"spikeekips"}, validBefore),
+			func(cert *saultssh.Certificate) {
+				cert.CriticalOptions = map[string]string{"force-command": "id"}
+				cert.SignCert(rand.Reader, authority)
+			},
+			false,
+		},
+	}
+
+	for _, i := range cases {
+		config.Server.RevokedUserCerts = nil
+		if i.setup != nil {
+			i.setup(i.cert)
+		}
+		assert.Nil(t, config.validateServerTrustedUserCAKeys())
+
+		c := newConn()
+		_, err := c.publicKeyCallback(connMeta, i.cert)
+		if i.succeeded {
+			assert.Nil(t, err, i.name)
+			assert.Equal(t, "spikeekips", c.user.ID, i.name)
+		} else {
+			assert.NotNil(t, err, i.name)
+		}
+	}
+}
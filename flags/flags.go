@@ -404,7 +404,16 @@ func NewFlag(flagSet *flag.FlagSet, ft FlagTemplate) interface{} {
 		flagSet.Float64Var(&val, name, defaultValue.(float64), help)
 		return &val
 	default:
-		val := reflect.ValueOf(defaultValue).Interface().(flag.Value)
+		// the value of template is copied, so the parsed value is not shared
+		// with the other Flags from the same template.
+		v := reflect.ValueOf(defaultValue)
+		if v.Kind() == reflect.Ptr && !v.IsNil() {
+			n := reflect.New(v.Elem().Type())
+			n.Elem().Set(v.Elem())
+			v = n
+		}
+
+		val := v.Interface().(flag.Value)
 		flagSet.Var(val, name, help)
 
 		return val
@@ -120,6 +120,35 @@ func TestCustomFlag(t *testing.T) {
 	assert.Equal(t, lowercaseFlag(expectedValue), parsedValue.(lowercaseFlag))
 }
 
+func TestCustomFlagNotShared(t *testing.T) {
+	flagsTemplate := &FlagsTemplate{
+		Name: saultcommon.MakeRandomString(),
+		Flags: []FlagTemplate{
+			FlagTemplate{
+				Name:  "Lower",
+				Value: &defaultLowercaseFlag,
+			},
+		},
+	}
+
+	{
+		flags := NewFlags(flagsTemplate, nil)
+		err := flags.Parse([]string{"-lower", "MAKE-ME-LOWER"})
+		assert.Nil(t, err)
+		assert.Equal(t, lowercaseFlag("make-me-lower"), flags.Values["Lower"].(lowercaseFlag))
+	}
+
+	// the value of template is not changed by parsing
+	assert.Equal(t, lowercaseFlag(""), defaultLowercaseFlag)
+
+	{
+		flags := NewFlags(flagsTemplate, nil)
+		err := flags.Parse([]string{})
+		assert.Nil(t, err)
+		assert.Equal(t, lowercaseFlag(""), flags.Values["Lower"].(lowercaseFlag))
+	}
+}
+
 func TestParseFlag(t *testing.T) {
 	flagsTemplate := &FlagsTemplate{
 		Name: saultcommon.MakeRandomString(),
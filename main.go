@@ -102,6 +102,9 @@ func init() {
 		saultcommands.ServerFlagsTemplate,
 		saultcommands.UserFlagsTemplate,
 		saultcommands.HostFlagsTemplate,
+		saultcommands.ServiceFlagsTemplate,
+		saultcommands.SessionFlagsTemplate,
+		saultcommands.AccessFlagsTemplate,
 		saultcommands.VersionFlagsTemplate,
 	}
 
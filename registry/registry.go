@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"sync"
 
 	"github.com/naoina/toml"
 	"github.com/spikeekips/sault/common"
@@ -46,12 +47,19 @@ func LoadRegistrySourceFromConfig(data map[string]interface{}, config map[string
 	return
 }
 
-// Registry is the registry
+// Registry is the registry; the methods are safe to be called concurrently,
+// but Data should not be touched directly while the server is running.
 type Registry struct {
+	lock sync.RWMutex
+
 	Data *RegistryData
 
 	// the source, which is loaded without err will be used from first
 	Source []RegistrySource
+
+	// OnSourceError is called when the source is failed to be loaded or
+	// saved; operation is 'load' or 'save'.
+	OnSourceError func(operation string, source RegistrySource, err error)
 }
 
 // NewRegistry makes registry
@@ -80,6 +88,9 @@ func (s RegistryDataCmpByTimeUpdated) Swap(i, j int) {
 
 // AddSource add registry source to registry
 func (registry *Registry) AddSource(source ...RegistrySource) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	for _, s := range source {
 		if err = s.Validate(); err != nil {
 			return
@@ -92,6 +103,9 @@ func (registry *Registry) AddSource(source ...RegistrySource) (err error) {
 
 // Load loads registry from sources
 func (registry *Registry) Load() (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	if len(registry.Source) < 1 {
 		err = fmt.Errorf("sources are empty")
 		return
@@ -103,6 +117,9 @@ func (registry *Registry) Load() (err error) {
 		if err != nil {
 			jsoned, _ := json.Marshal(source)
 			log.Errorf("failed to load 'RegistryData' from source, '%s'", jsoned)
+			if registry.OnSourceError != nil {
+				registry.OnSourceError("load", source, err)
+			}
 			continue
 		}
 		allData = append(allData, data)
@@ -122,6 +139,13 @@ func (registry *Registry) Load() (err error) {
 
 // Bytes returns []byte of registry
 func (registry *Registry) Bytes() []byte {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.marshal()
+}
+
+func (registry *Registry) marshal() []byte {
 	var b bytes.Buffer
 	toml.NewEncoder(&b).Encode(registry.Data)
 
@@ -130,12 +154,15 @@ func (registry *Registry) Bytes() []byte {
 
 // Save will save registry to sources
 func (registry *Registry) Save() (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	if len(registry.Source) < 1 {
 		err = fmt.Errorf("sources are empty")
 		return
 	}
 
-	data := registry.Bytes()
+	data := registry.marshal()
 
 	var saved bool
 	for i := len(registry.Source) - 1; i >= 0; i-- {
@@ -144,6 +171,9 @@ func (registry *Registry) Save() (err error) {
 		if err != nil {
 			jsoned, _ := json.Marshal(source)
 			log.Errorf("failed to save registry to source, '%s'", jsoned)
+			if registry.OnSourceError != nil {
+				registry.OnSourceError("save", source, err)
+			}
 			continue
 		}
 
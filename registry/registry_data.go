@@ -66,6 +66,17 @@ type UserRegistry struct {
 	IsActive    bool
 	DateAdded   time.Time
 	DateUpdated time.Time
+
+	// TOTPSecret is the secret of the TOTP second factor and
+	// TOTPRecoveryCodes is the hashes of the one-time recovery codes; they
+	// are not sent to the clients.
+	TOTPSecret        string   `json:"-"`
+	TOTPRecoveryCodes []string `json:"-"`
+}
+
+// HasTOTP checks whether the user enrolled the TOTP
+func (r UserRegistry) HasTOTP() bool {
+	return len(r.TOTPSecret) > 0
 }
 
 func (r UserRegistry) String() string {
@@ -93,6 +104,79 @@ func (r UserRegistry) GetAuthorizedKey() string {
 type LinkAccountRegistry struct {
 	Accounts []string
 	All      bool
+
+	// DisableRecording disables the session recording for this link
+	DisableRecording bool
+
+	// AgentForwarding allows the ssh agent forwarding of client to the host
+	AgentForwarding bool
+
+	// RemoteForwarding allows the remote port forwarding of the host to the
+	// client, like 'ssh -R'
+	RemoteForwarding bool
+
+	// DisableUpload and DisableDownload block the file transfer of sftp and
+	// scp to and from the host
+	DisableUpload   bool
+	DisableDownload bool
+
+	// IdleTimeout and MaxDuration override the session timeouts of host and
+	// sault server, like '30m'; '0' disables it, if empty, the timeout of host
+	// is used.
+	IdleTimeout string
+	MaxDuration string
+
+	// DateExpires, if set, the link is not available after it
+	DateExpires time.Time
+
+	// AccountExpires is the expiry of the accounts, which are temporarily
+	// linked by the access request; the other accounts are not expired.
+	AccountExpires map[string]time.Time
+}
+
+// IsExpired checks whether the link is expired at the given time
+func (r LinkAccountRegistry) IsExpired(t time.Time) bool {
+	return !r.DateExpires.IsZero() && !t.Before(r.DateExpires)
+}
+
+// GetDateExpires returns the expiry of the account; the earlier one of the
+// link and the account is used and the zero time means it is not expired.
+func (r LinkAccountRegistry) GetDateExpires(account string) time.Time {
+	expires, ok := r.AccountExpires[account]
+	if !ok || (!r.DateExpires.IsZero() && r.DateExpires.Before(expires)) {
+		return r.DateExpires
+	}
+
+	return expires
+}
+
+// IsAccountExpired checks whether the account of link is expired at the given
+// time
+func (r LinkAccountRegistry) IsAccountExpired(account string, t time.Time) bool {
+	expires := r.GetDateExpires(account)
+	return !expires.IsZero() && !t.Before(expires)
+}
+
+// setAccountExpires sets the expiry of accounts; the zero time removes it.
+// The map is copied, because it is shared with the copies of link, which
+// were returned.
+func (r *LinkAccountRegistry) setAccountExpires(expires time.Time, accounts ...string) {
+	m := map[string]time.Time{}
+	for a, e := range r.AccountExpires {
+		m[a] = e
+	}
+	for _, a := range accounts {
+		if expires.IsZero() {
+			delete(m, a)
+		} else {
+			m[a] = expires
+		}
+	}
+
+	if len(m) < 1 {
+		m = nil
+	}
+	r.AccountExpires = m
 }
 
 type HostRegistry struct {
@@ -101,6 +185,23 @@ type HostRegistry struct {
 	Port     uint64
 	Accounts []string
 
+	// Addresses is the fallback addresses, '<hostname or ip>:<port>', which
+	// are tried in order after HostName and Port failed.
+	Addresses []string
+
+	// IdleTimeout and MaxDuration override the session timeouts of sault
+	// server, like '30m'; '0' disables it, if empty, the timeout of sault
+	// server is used.
+	IdleTimeout string
+	MaxDuration string
+
+	// Banner is shown to the pty sessions of this host with the banner of
+	// sault server; it can have the template variables and colors.
+	Banner string
+
+	// Sensitive host requires the stronger authentication, like TOTP
+	Sensitive bool
+
 	IsActive    bool
 	DateAdded   time.Time
 	DateUpdated time.Time
@@ -114,6 +215,26 @@ func (r HostRegistry) GetAddress() string {
 	)
 }
 
+// GetAddresses returns the primary address and the fallback addresses in
+// order
+func (r HostRegistry) GetAddresses() []string {
+	addresses := []string{r.GetAddress()}
+	for _, a := range r.Addresses {
+		var found bool
+		for _, b := range addresses {
+			if a == b {
+				found = true
+				break
+			}
+		}
+		if !found {
+			addresses = append(addresses, a)
+		}
+	}
+
+	return addresses
+}
+
 func (r HostRegistry) String() string {
 	return fmt.Sprintf(
 		"host=%s(%s)",
@@ -138,11 +259,116 @@ func (r HostRegistry) HasAccount(accounts ...string) bool {
 	return true
 }
 
+// ServiceRegistry is the non-ssh service, like database, which can be
+// reached by the port forwarding, 'direct-tcpip' thru sault.
+type ServiceRegistry struct {
+	Name    string
+	Address string
+	Port    uint64
+	Labels  map[string]string
+
+	DateAdded   time.Time
+	DateUpdated time.Time
+}
+
+func (r ServiceRegistry) GetAddress() string {
+	return fmt.Sprintf("%s:%d", r.Address, r.Port)
+}
+
+func (r ServiceRegistry) String() string {
+	return fmt.Sprintf("service=%s(%s)", r.Name, r.GetAddress())
+}
+
+// ServiceLinkRegistry is the link between user and service
+type ServiceLinkRegistry struct {
+	DateAdded time.Time
+}
+
+// ParseSessionTimeout parses the session timeout of host and link; if empty,
+// it returns -1, which means not set.
+func ParseSessionTimeout(s string) (d time.Duration, err error) {
+	if len(strings.TrimSpace(s)) < 1 {
+		return -1, nil
+	}
+
+	if d, err = time.ParseDuration(strings.TrimSpace(s)); err != nil || d < 0 {
+		return -1, fmt.Errorf("invalid session timeout, '%s'", s)
+	}
+
+	return
+}
+
+// AccessRequestStatus is the status of access request
+type AccessRequestStatus string
+
+const (
+	// AccessRequestPending is waiting for the decision of admin
+	AccessRequestPending AccessRequestStatus = "pending"
+	// AccessRequestApproved is approved and the temporary link is created
+	AccessRequestApproved AccessRequestStatus = "approved"
+	// AccessRequestDenied is denied by admin
+	AccessRequestDenied AccessRequestStatus = "denied"
+	// AccessRequestExpired is approved, but the link was expired
+	AccessRequestExpired AccessRequestStatus = "expired"
+)
+
+// AccessRequestRegistry is the request of user for the temporary link to the
+// host account; it is kept after the decision for the record, until the user
+// or the host is removed.
+type AccessRequestRegistry struct {
+	ID      string
+	UserID  string
+	HostID  string
+	Account string
+
+	// Duration is the requested duration of link, like '4h'; the link
+	// expires after the duration from the approval.
+	Duration string
+	Reason   string
+
+	Status        AccessRequestStatus
+	DateRequested time.Time
+	DateDecided   time.Time
+	DecidedBy     string
+	DateExpires   time.Time
+}
+
+// GetDuration returns the requested duration of link
+func (r AccessRequestRegistry) GetDuration() time.Duration {
+	d, _ := time.ParseDuration(r.Duration)
+	return d
+}
+
+func (r AccessRequestRegistry) String() string {
+	return fmt.Sprintf(
+		"access-request=%s(user=%s host=%s account=%s status=%s)",
+		r.ID,
+		r.UserID,
+		r.HostID,
+		r.Account,
+		r.Status,
+	)
+}
+
+// ExpiredLink is the link, which was removed after expired; if Account is
+// set, only the account was unlinked.
+type ExpiredLink struct {
+	UserID      string
+	HostID      string
+	Account     string
+	DateExpires time.Time
+}
+
 type RegistryData struct {
 	TimeUpdated time.Time
 	User        map[string]UserRegistry                   // map[<UserRegistry.ID>]UserRegistry
 	Host        map[string]HostRegistry                   // map[<hostRegistry.ID>]hostRegistry
 	Links       map[string]map[string]LinkAccountRegistry // map[hostRegistry.ID]map[<UserRegistry.ID>]<AccountRegistry>
+
+	Service      map[string]ServiceRegistry                // map[<ServiceRegistry.Name>]ServiceRegistry
+	ServiceLinks map[string]map[string]ServiceLinkRegistry // map[<ServiceRegistry.Name>]map[<UserRegistry.ID>]ServiceLinkRegistry
+
+	AccessRequests map[string]AccessRequestRegistry // map[<AccessRequestRegistry.ID>]AccessRequestRegistry
 }
 
 func (d *RegistryData) updated() {
@@ -160,6 +386,11 @@ func NewRegistryDataFromSource(source RegistrySource) (data *RegistryData, err e
 		User:  map[string]UserRegistry{},
 		Host:  map[string]HostRegistry{},
 		Links: map[string]map[string]LinkAccountRegistry{},
+
+		Service:      map[string]ServiceRegistry{},
+		ServiceLinks: map[string]map[string]ServiceLinkRegistry{},
+
+		AccessRequests: map[string]AccessRequestRegistry{},
 	}
 
 	if err = saultcommon.DefaultTOML.NewDecoder(bytes.NewBuffer(b)).Decode(data); err != nil {
@@ -170,6 +401,9 @@ func NewRegistryDataFromSource(source RegistrySource) (data *RegistryData, err e
 }
 
 func (registry *Registry) GetUserCount(f UserFilter) (c int) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	for _, u := range registry.Data.User {
 		if f&UserFilterIsActive == UserFilterIsActive && !u.IsActive {
 			continue
@@ -190,6 +424,13 @@ func (registry *Registry) GetUserCount(f UserFilter) (c int) {
 }
 
 func (registry *Registry) GetUser(id string, publicKey saultssh.PublicKey, f UserFilter) (user UserRegistry, err error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.findUser(id, publicKey, f)
+}
+
+func (registry *Registry) findUser(id string, publicKey saultssh.PublicKey, f UserFilter) (user UserRegistry, err error) {
 	user, err = registry.getUser(id, publicKey)
 	if err != nil {
 		return
@@ -307,6 +548,9 @@ func (registry *Registry) getUser(id string, publicKey saultssh.PublicKey) (user
 }
 
 func (registry *Registry) GetUsers(f UserFilter, userIDs ...string) (users []UserRegistry) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	for _, u := range registry.Data.User {
 		if len(userIDs) > 0 {
 			var found bool
@@ -340,6 +584,9 @@ func (registry *Registry) GetUsers(f UserFilter, userIDs ...string) (users []Use
 }
 
 func (registry *Registry) AddUser(id string, publicKey []byte) (user UserRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	if !saultcommon.CheckUserID(id) {
 		err = &saultcommon.InvalidUserIDError{ID: id}
 		return
@@ -351,7 +598,7 @@ func (registry *Registry) AddUser(id string, publicKey []byte) (user UserRegistr
 		return
 	}
 
-	user, _ = registry.GetUser(id, parsedPublicKey, UserFilterNone)
+	user, _ = registry.findUser(id, parsedPublicKey, UserFilterNone)
 	if user.ID != "" {
 		var eid string
 		var ePublicKey []byte
@@ -381,8 +628,11 @@ func (registry *Registry) AddUser(id string, publicKey []byte) (user UserRegistr
 }
 
 func (registry *Registry) UpdateUser(id string, newUser UserRegistry) (user UserRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	var oldUser UserRegistry
-	oldUser, err = registry.GetUser(id, nil, UserFilterNone)
+	oldUser, err = registry.findUser(id, nil, UserFilterNone)
 	if err != nil {
 		return
 	}
@@ -394,7 +644,7 @@ func (registry *Registry) UpdateUser(id string, newUser UserRegistry) (user User
 			return
 		}
 
-		_, err = registry.GetUser(newUser.ID, nil, UserFilterNone)
+		_, err = registry.findUser(newUser.ID, nil, UserFilterNone)
 		if err == nil {
 			err = &saultcommon.UserExistsError{ID: id}
 			return
@@ -403,7 +653,7 @@ func (registry *Registry) UpdateUser(id string, newUser UserRegistry) (user User
 	}
 
 	if !oldUser.HasPublicKey(newUser.GetPublicKey()) {
-		_, err = registry.GetUser("", newUser.GetPublicKey(), UserFilterNone)
+		_, err = registry.findUser("", newUser.GetPublicKey(), UserFilterNone)
 		if err == nil {
 			err = &saultcommon.UserExistsError{PublicKey: newUser.PublicKey}
 			return
@@ -441,14 +691,123 @@ func (registry *Registry) UpdateUser(id string, newUser UserRegistry) (user User
 		delete(registry.Data.Links[hostID], id)
 	}
 
+	for name, link := range registry.Data.ServiceLinks {
+		if _, ok := link[id]; !ok {
+			continue
+		}
+		registry.Data.ServiceLinks[name][newUser.ID] = link[id]
+		delete(registry.Data.ServiceLinks[name], id)
+	}
+
+	for requestID, request := range registry.Data.AccessRequests {
+		if request.UserID != id {
+			continue
+		}
+		request.UserID = newUser.ID
+		registry.Data.AccessRequests[requestID] = request
+	}
+
 	user = newUser
 	registry.Data.updated()
 
 	return
 }
 
+// TOTPRecoveryCodeCount is the number of recovery codes of new TOTP
+// enrollment
+const TOTPRecoveryCodeCount = 10
+
+// EnrollTOTP generates the new TOTP secret and recovery codes of user; the
+// previous enrollment is replaced. The recovery codes are stored as hashes.
+func (registry *Registry) EnrollTOTP(id string) (secret string, codes []string, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var user UserRegistry
+	if user, err = registry.findUser(id, nil, UserFilterNone); err != nil {
+		return
+	}
+
+	if secret, err = saultcommon.GenerateTOTPSecret(); err != nil {
+		return
+	}
+	if codes, err = saultcommon.GenerateRecoveryCodes(TOTPRecoveryCodeCount); err != nil {
+		return
+	}
+
+	var hashes []string
+	for _, c := range codes {
+		hashes = append(hashes, saultcommon.HashRecoveryCode(c))
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPRecoveryCodes = hashes
+	user.DateUpdated = time.Now().UTC()
+	registry.Data.User[id] = user
+	registry.Data.updated()
+
+	return
+}
+
+// DisableTOTP removes the TOTP enrollment of user
+func (registry *Registry) DisableTOTP(id string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var user UserRegistry
+	if user, err = registry.findUser(id, nil, UserFilterNone); err != nil {
+		return
+	}
+
+	if !user.HasTOTP() {
+		err = &saultcommon.UserNothingToUpdate{ID: id}
+		return
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPRecoveryCodes = nil
+	user.DateUpdated = time.Now().UTC()
+	registry.Data.User[id] = user
+	registry.Data.updated()
+
+	return
+}
+
+// UseTOTPRecoveryCode checks the recovery code of user; the matched code is
+// removed, so it can be used only once.
+func (registry *Registry) UseTOTPRecoveryCode(id, code string) bool {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	user, err := registry.findUser(id, nil, UserFilterNone)
+	if err != nil || !user.HasTOTP() {
+		return false
+	}
+
+	hash := saultcommon.HashRecoveryCode(code)
+	for i, c := range user.TOTPRecoveryCodes {
+		if c != hash {
+			continue
+		}
+
+		var codes []string
+		codes = append(codes, user.TOTPRecoveryCodes[:i]...)
+		codes = append(codes, user.TOTPRecoveryCodes[i+1:]...)
+		user.TOTPRecoveryCodes = codes
+		registry.Data.User[id] = user
+		registry.Data.updated()
+
+		return true
+	}
+
+	return false
+}
+
 func (registry *Registry) RemoveUser(id string) (err error) {
-	if _, err = registry.GetUser(id, nil, UserFilterNone); err != nil {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.findUser(id, nil, UserFilterNone); err != nil {
 		return
 	}
 
@@ -461,11 +820,27 @@ func (registry *Registry) RemoveUser(id string) (err error) {
 		delete(registry.Data.Links[hostID], id)
 	}
 
+	for name, link := range registry.Data.ServiceLinks {
+		if _, ok := link[id]; !ok {
+			continue
+		}
+		delete(registry.Data.ServiceLinks[name], id)
+	}
+
+	for requestID, request := range registry.Data.AccessRequests {
+		if request.UserID == id {
+			delete(registry.Data.AccessRequests, requestID)
+		}
+	}
+
 	registry.Data.updated()
 	return
 }
 
 func (registry *Registry) GetHostCount(f HostFilter) (c int) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	for _, h := range registry.Data.Host {
 		if f&HostFilterIsActive == HostFilterIsActive && !h.IsActive {
 			continue
@@ -480,6 +855,13 @@ func (registry *Registry) GetHostCount(f HostFilter) (c int) {
 }
 
 func (registry *Registry) GetHost(id string, f HostFilter) (host HostRegistry, err error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.getHost(id, f)
+}
+
+func (registry *Registry) getHost(id string, f HostFilter) (host HostRegistry, err error) {
 	var ok bool
 	if host, ok = registry.Data.Host[id]; !ok {
 		err = &saultcommon.HostDoesNotExistError{ID: id}
@@ -487,6 +869,7 @@ func (registry *Registry) GetHost(id string, f HostFilter) (host HostRegistry, e
 	}
 
 	if f&HostFilterIsActive == HostFilterIsActive && !host.IsActive {
+		err = &saultcommon.HostDoesNotExistError{Message: fmt.Sprintf("host, '%s' is not active", id)}
 		return
 	}
 	if f&HostFilterIsNotActive == HostFilterIsNotActive && host.IsActive {
@@ -498,6 +881,9 @@ func (registry *Registry) GetHost(id string, f HostFilter) (host HostRegistry, e
 }
 
 func (registry *Registry) GetHosts(f HostFilter, hostIDs ...string) (hosts []HostRegistry) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	for _, h := range registry.Data.Host {
 		if len(hostIDs) > 0 {
 			var found bool
@@ -512,7 +898,7 @@ func (registry *Registry) GetHosts(f HostFilter, hostIDs ...string) (hosts []Hos
 			}
 		}
 
-		if f&HostFilterIsNotActive == HostFilterIsNotActive && h.IsActive {
+		if f&HostFilterIsActive == HostFilterIsActive && !h.IsActive {
 			continue
 		}
 		if f&HostFilterIsNotActive == HostFilterIsNotActive && h.IsActive {
@@ -525,6 +911,9 @@ func (registry *Registry) GetHosts(f HostFilter, hostIDs ...string) (hosts []Hos
 }
 
 func (registry *Registry) AddHost(id, hostName string, port uint64, accounts []string) (host HostRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	if !saultcommon.CheckHostID(id) {
 		err = &saultcommon.InvalidHostIDError{ID: id}
 		return
@@ -538,7 +927,7 @@ func (registry *Registry) AddHost(id, hostName string, port uint64, accounts []s
 		return
 	}
 
-	if _, notFound := registry.GetHost(id, HostFilterNone); notFound == nil {
+	if _, notFound := registry.getHost(id, HostFilterNone); notFound == nil {
 		err = &saultcommon.HostExistError{ID: id}
 		return
 	}
@@ -582,6 +971,9 @@ func (registry *Registry) AddHost(id, hostName string, port uint64, accounts []s
 }
 
 func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host HostRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	var updated bool
 	if id != newHost.ID {
 		if !saultcommon.CheckHostID(newHost.ID) {
@@ -589,7 +981,7 @@ func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host Host
 			return
 		}
 
-		if _, notFound := registry.GetHost(newHost.ID, HostFilterNone); notFound == nil {
+		if _, notFound := registry.getHost(newHost.ID, HostFilterNone); notFound == nil {
 			err = &saultcommon.HostExistError{ID: newHost.ID}
 			return
 		}
@@ -602,7 +994,7 @@ func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host Host
 	}
 
 	var oldHost HostRegistry
-	oldHost, err = registry.GetHost(id, HostFilterNone)
+	oldHost, err = registry.getHost(id, HostFilterNone)
 	if err != nil {
 		return
 	}
@@ -655,6 +1047,37 @@ func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host Host
 		updated = true
 	}
 
+	if oldHost.Sensitive != newHost.Sensitive {
+		updated = true
+	}
+
+	{
+		var addresses []string
+		for _, a := range newHost.Addresses {
+			var hostName string
+			var port uint64
+			if hostName, port, err = saultcommon.SplitHostPort(a, uint64(22)); err != nil {
+				err = &saultcommon.InvalidHostAddressError{Address: a, Err: err}
+				return
+			}
+			addresses = append(addresses, fmt.Sprintf("%s:%d", hostName, port))
+		}
+		newHost.Addresses = addresses
+	}
+
+	if strings.Join(oldHost.Addresses, " ") != strings.Join(newHost.Addresses, " ") {
+		updated = true
+	}
+
+	for _, d := range []string{newHost.IdleTimeout, newHost.MaxDuration} {
+		if _, err = ParseSessionTimeout(d); err != nil {
+			return
+		}
+	}
+	if oldHost.IdleTimeout != newHost.IdleTimeout || oldHost.MaxDuration != newHost.MaxDuration || oldHost.Banner != newHost.Banner {
+		updated = true
+	}
+
 	if !updated {
 		host = oldHost
 		err = &saultcommon.HostNothingToUpdate{ID: id}
@@ -668,7 +1091,7 @@ func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host Host
 	newHost.DateUpdated = time.Now().UTC()
 	registry.Data.Host[newHost.ID] = newHost
 
-	if _, ok := registry.Data.Links[id]; ok {
+	if _, ok := registry.Data.Links[id]; ok && id != newHost.ID {
 		registry.Data.Links[newHost.ID] = registry.Data.Links[id]
 		delete(registry.Data.Links, id)
 	}
@@ -680,7 +1103,10 @@ func (registry *Registry) UpdateHost(id string, newHost HostRegistry) (host Host
 }
 
 func (registry *Registry) RemoveHost(id string) (err error) {
-	if _, err = registry.GetHost(id, HostFilterNone); err != nil {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.getHost(id, HostFilterNone); err != nil {
 		return
 	}
 
@@ -690,11 +1116,20 @@ func (registry *Registry) RemoveHost(id string) (err error) {
 		delete(registry.Data.Links, id)
 	}
 
+	for requestID, request := range registry.Data.AccessRequests {
+		if request.HostID == id {
+			delete(registry.Data.AccessRequests, requestID)
+		}
+	}
+
 	registry.Data.updated()
 	return
 }
 
 func (registry *Registry) GetLinksOfUser(id string) (links map[string]LinkAccountRegistry) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	links = map[string]LinkAccountRegistry{}
 	for hostID, link := range registry.Data.Links {
 		var userLinks LinkAccountRegistry
@@ -710,6 +1145,13 @@ func (registry *Registry) GetLinksOfUser(id string) (links map[string]LinkAccoun
 }
 
 func (registry *Registry) Link(userID, hostID string, accounts ...string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	return registry.linkAccounts(userID, hostID, accounts...)
+}
+
+func (registry *Registry) linkAccounts(userID, hostID string, accounts ...string) (err error) {
 	for _, a := range accounts {
 		if !saultcommon.CheckAccountName(a) {
 			err = &saultcommon.InvalidAccountNameError{Name: a}
@@ -717,12 +1159,12 @@ func (registry *Registry) Link(userID, hostID string, accounts ...string) (err e
 		}
 	}
 
-	if _, err = registry.GetUser(userID, nil, UserFilterNone); err != nil {
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
 		return
 	}
 
 	var host HostRegistry
-	if host, err = registry.GetHost(hostID, HostFilterNone); err != nil {
+	if host, err = registry.getHost(hostID, HostFilterNone); err != nil {
 		return
 	}
 
@@ -761,13 +1203,22 @@ func (registry *Registry) Link(userID, hostID string, accounts ...string) (err e
 
 	sort.Strings(existingAccounts)
 
-	registry.Data.Links[host.ID][userID] = LinkAccountRegistry{Accounts: existingAccounts}
+	link.Accounts = existingAccounts
+	link.setAccountExpires(time.Time{}, accounts...)
+	registry.Data.Links[host.ID][userID] = link
 
 	registry.Data.updated()
 	return
 }
 
 func (registry *Registry) IsLinked(userID, hostID, account string) bool {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.isLinked(userID, hostID, account)
+}
+
+func (registry *Registry) isLinked(userID, hostID, account string) bool {
 	if _, ok := registry.Data.Links[hostID]; !ok {
 		return false
 	}
@@ -779,26 +1230,87 @@ func (registry *Registry) IsLinked(userID, hostID, account string) bool {
 		return false
 	}
 
+	if link.IsExpired(time.Now()) {
+		return false
+	}
+
 	if link.All {
 		return true
 	}
 
 	for _, a := range registry.Data.Links[hostID][userID].Accounts {
 		if a == account {
-			return true
+			return !link.IsAccountExpired(a, time.Now())
 		}
 	}
 
 	return false
 }
 
+// GetLink returns the link of user and host
+func (registry *Registry) GetLink(userID, hostID string) (link LinkAccountRegistry, err error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.getLink(userID, hostID)
+}
+
+func (registry *Registry) getLink(userID, hostID string) (link LinkAccountRegistry, err error) {
+	if _, ok := registry.Data.Links[hostID]; !ok {
+		err = &saultcommon.HostAndUserNotLinked{UserID: userID, HostID: hostID}
+		return
+	}
+
+	var ok bool
+	if link, ok = registry.Data.Links[hostID][userID]; !ok {
+		err = &saultcommon.HostAndUserNotLinked{UserID: userID, HostID: hostID}
+		return
+	}
+
+	return
+}
+
+// UpdateLink updates the options of the existing link; the linked accounts
+// are not changed, use Link() and Unlink() for accounts.
+func (registry *Registry) UpdateLink(userID, hostID string, newLink LinkAccountRegistry) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	return registry.updateLink(userID, hostID, newLink)
+}
+
+func (registry *Registry) updateLink(userID, hostID string, newLink LinkAccountRegistry) (err error) {
+	var link LinkAccountRegistry
+	if link, err = registry.getLink(userID, hostID); err != nil {
+		return
+	}
+
+	for _, d := range []string{newLink.IdleTimeout, newLink.MaxDuration} {
+		if _, err = ParseSessionTimeout(d); err != nil {
+			return
+		}
+	}
+
+	newLink.Accounts = link.Accounts
+	newLink.All = link.All
+	newLink.AccountExpires = link.AccountExpires
+
+	registry.Data.Links[hostID][userID] = newLink
+
+	registry.Data.updated()
+	return
+}
+
 func (registry *Registry) LinkAll(userID, hostID string) (err error) {
-	if _, err = registry.GetUser(userID, nil, UserFilterNone); err != nil {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
 		return
 	}
 
 	var host HostRegistry
-	if host, err = registry.GetHost(hostID, HostFilterNone); err != nil {
+	if host, err = registry.getHost(hostID, HostFilterNone); err != nil {
 		return
 	}
 
@@ -806,13 +1318,20 @@ func (registry *Registry) LinkAll(userID, hostID string) (err error) {
 		registry.Data.Links[host.ID] = map[string]LinkAccountRegistry{}
 	}
 
-	registry.Data.Links[host.ID][userID] = LinkAccountRegistry{All: true}
+	link := registry.Data.Links[host.ID][userID]
+	link.Accounts = nil
+	link.All = true
+	link.AccountExpires = nil
+	registry.Data.Links[host.ID][userID] = link
 
 	registry.Data.updated()
 	return
 }
 
 func (registry *Registry) Unlink(userID, hostID string, accounts ...string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	for _, a := range accounts {
 		if !saultcommon.CheckAccountName(a) {
 			err = &saultcommon.InvalidAccountNameError{Name: a}
@@ -820,12 +1339,12 @@ func (registry *Registry) Unlink(userID, hostID string, accounts ...string) (err
 		}
 	}
 
-	if _, err = registry.GetUser(userID, nil, UserFilterNone); err != nil {
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
 		return
 	}
 
 	var host HostRegistry
-	if host, err = registry.GetHost(hostID, HostFilterNone); err != nil {
+	if host, err = registry.getHost(hostID, HostFilterNone); err != nil {
 		return
 	}
 
@@ -861,19 +1380,28 @@ func (registry *Registry) Unlink(userID, hostID string, accounts ...string) (err
 
 	sort.Strings(slicedAccounts)
 
-	registry.Data.Links[host.ID][userID] = LinkAccountRegistry{Accounts: slicedAccounts}
+	link.Accounts = slicedAccounts
+	link.setAccountExpires(time.Time{}, accounts...)
+	registry.Data.Links[host.ID][userID] = link
 
 	registry.Data.updated()
 	return
 }
 
 func (registry *Registry) UnlinkAll(userID, hostID string) (err error) {
-	if _, err = registry.GetUser(userID, nil, UserFilterNone); err != nil {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	return registry.unlinkAll(userID, hostID)
+}
+
+func (registry *Registry) unlinkAll(userID, hostID string) (err error) {
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
 		return
 	}
 
 	var host HostRegistry
-	if host, err = registry.GetHost(hostID, HostFilterNone); err != nil {
+	if host, err = registry.getHost(hostID, HostFilterNone); err != nil {
 		return
 	}
 
@@ -890,3 +1418,467 @@ func (registry *Registry) UnlinkAll(userID, hostID string) (err error) {
 	registry.Data.updated()
 	return
 }
+
+func (registry *Registry) GetService(name string) (service ServiceRegistry, err error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.getService(name)
+}
+
+func (registry *Registry) getService(name string) (service ServiceRegistry, err error) {
+	var ok bool
+	if service, ok = registry.Data.Service[name]; !ok {
+		err = &saultcommon.ServiceDoesNotExistError{Name: name}
+		return
+	}
+
+	return
+}
+
+func (registry *Registry) GetServices(names ...string) (services []ServiceRegistry) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	for _, s := range registry.Data.Service {
+		if len(names) > 0 {
+			var found bool
+			for _, a := range names {
+				if a == s.Name {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		services = append(services, s)
+	}
+
+	return
+}
+
+func (registry *Registry) AddService(name, address string, port uint64, labels map[string]string) (service ServiceRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if !saultcommon.CheckServiceName(name) {
+		err = &saultcommon.InvalidServiceNameError{Name: name}
+		return
+	}
+	if port < 1 {
+		err = fmt.Errorf("port must be set")
+		return
+	}
+
+	if address, port, err = saultcommon.SplitHostPort(fmt.Sprintf("%s:%d", address, port), port); err != nil {
+		return
+	}
+
+	if _, notFound := registry.getService(name); notFound == nil {
+		err = &saultcommon.ServiceExistError{Name: name}
+		return
+	}
+
+	for k := range labels {
+		if len(strings.TrimSpace(k)) < 1 || strings.ContainsAny(k, "= ") {
+			err = fmt.Errorf("invalid label, '%s'", k)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	service = ServiceRegistry{
+		Name:        name,
+		Address:     address,
+		Port:        port,
+		Labels:      labels,
+		DateAdded:   now,
+		DateUpdated: now,
+	}
+
+	registry.Data.Service[name] = service
+	registry.Data.updated()
+
+	return
+}
+
+func (registry *Registry) RemoveService(name string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.getService(name); err != nil {
+		return
+	}
+
+	delete(registry.Data.Service, name)
+	delete(registry.Data.ServiceLinks, name)
+
+	registry.Data.updated()
+	return
+}
+
+// GetServicesOfUser returns the names of the linked services
+func (registry *Registry) GetServicesOfUser(id string) (names []string) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	for name, link := range registry.Data.ServiceLinks {
+		if _, ok := link[id]; !ok {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return
+}
+
+func (registry *Registry) LinkService(userID, name string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
+		return
+	}
+
+	if _, err = registry.getService(name); err != nil {
+		return
+	}
+
+	if _, ok := registry.Data.ServiceLinks[name]; !ok {
+		registry.Data.ServiceLinks[name] = map[string]ServiceLinkRegistry{}
+	}
+
+	if _, ok := registry.Data.ServiceLinks[name][userID]; ok {
+		return
+	}
+
+	registry.Data.ServiceLinks[name][userID] = ServiceLinkRegistry{DateAdded: time.Now().UTC()}
+
+	registry.Data.updated()
+	return
+}
+
+func (registry *Registry) UnlinkService(userID, name string) (err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if _, err = registry.findUser(userID, nil, UserFilterNone); err != nil {
+		return
+	}
+
+	if _, err = registry.getService(name); err != nil {
+		return
+	}
+
+	if _, ok := registry.Data.ServiceLinks[name][userID]; !ok {
+		return
+	}
+
+	delete(registry.Data.ServiceLinks[name], userID)
+
+	registry.Data.updated()
+	return
+}
+
+func (registry *Registry) IsServiceLinked(userID, name string) bool {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	if _, ok := registry.Data.ServiceLinks[name]; !ok {
+		return false
+	}
+
+	_, ok := registry.Data.ServiceLinks[name][userID]
+	return ok
+}
+
+// GetAccessRequest returns the access request
+func (registry *Registry) GetAccessRequest(id string) (request AccessRequestRegistry, err error) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.getAccessRequest(id)
+}
+
+func (registry *Registry) getAccessRequest(id string) (request AccessRequestRegistry, err error) {
+	var ok bool
+	if request, ok = registry.Data.AccessRequests[id]; !ok {
+		err = &saultcommon.AccessRequestDoesNotExistError{ID: id}
+		return
+	}
+
+	return
+}
+
+// GetAccessRequests returns the access requests by the requested time; if
+// userID is empty, the requests of all users are returned.
+func (registry *Registry) GetAccessRequests(userID string, statuses ...AccessRequestStatus) (requests []AccessRequestRegistry) {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
+	return registry.getAccessRequests(userID, statuses...)
+}
+
+func (registry *Registry) getAccessRequests(userID string, statuses ...AccessRequestStatus) (requests []AccessRequestRegistry) {
+	for _, r := range registry.Data.AccessRequests {
+		if len(userID) > 0 && r.UserID != userID {
+			continue
+		}
+		if len(statuses) > 0 {
+			var found bool
+			for _, s := range statuses {
+				if r.Status == s {
+					found = true
+					break
+				}
+			}
+			if !found {
+				continue
+			}
+		}
+
+		requests = append(requests, r)
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].DateRequested.Before(requests[j].DateRequested)
+	})
+
+	return
+}
+
+// AddAccessRequest adds the pending request of user for the temporary link
+// to the host account.
+func (registry *Registry) AddAccessRequest(userID, hostID, account string, duration time.Duration, reason string) (request AccessRequestRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if !saultcommon.CheckAccountName(account) {
+		err = &saultcommon.InvalidAccountNameError{Name: account}
+		return
+	}
+	if duration <= 0 {
+		err = fmt.Errorf("duration must be set")
+		return
+	}
+	if len(strings.TrimSpace(reason)) < 1 {
+		err = fmt.Errorf("reason must be set")
+		return
+	}
+
+	if _, err = registry.findUser(userID, nil, UserFilterIsActive); err != nil {
+		return
+	}
+
+	var host HostRegistry
+	if host, err = registry.getHost(hostID, HostFilterIsActive); err != nil {
+		return
+	}
+	if !host.HasAccount(account) {
+		err = fmt.Errorf("unknown account, '%s' of host, '%s'", account, host.ID)
+		return
+	}
+
+	if registry.isLinked(userID, host.ID, account) {
+		err = fmt.Errorf("user, '%s' is already linked to '%s@%s'", userID, account, host.ID)
+		return
+	}
+
+	for _, r := range registry.getAccessRequests(userID, AccessRequestPending) {
+		if r.HostID == host.ID && r.Account == account {
+			err = fmt.Errorf("access request, '%s' for '%s@%s' is already pending", r.ID, account, host.ID)
+			return
+		}
+	}
+
+	var id string
+	for {
+		id = saultcommon.MakeRandomString()[:8]
+		if _, ok := registry.Data.AccessRequests[id]; !ok {
+			break
+		}
+	}
+
+	request = AccessRequestRegistry{
+		ID:            id,
+		UserID:        userID,
+		HostID:        host.ID,
+		Account:       account,
+		Duration:      duration.String(),
+		Reason:        strings.TrimSpace(reason),
+		Status:        AccessRequestPending,
+		DateRequested: time.Now().UTC(),
+	}
+
+	registry.Data.AccessRequests[id] = request
+	registry.Data.updated()
+
+	return
+}
+
+func (registry *Registry) getPendingAccessRequest(id string) (request AccessRequestRegistry, err error) {
+	if request, err = registry.getAccessRequest(id); err != nil {
+		return
+	}
+
+	if request.Status != AccessRequestPending {
+		err = fmt.Errorf("access request, '%s' is already %s", id, request.Status)
+		return
+	}
+
+	return
+}
+
+// ApproveAccessRequest links the user to the requested host account until
+// the requested duration passes; the expiry is kept by account, so the other
+// accounts of the existing link are not touched.
+func (registry *Registry) ApproveAccessRequest(id, decidedBy string) (request AccessRequestRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if request, err = registry.getPendingAccessRequest(id); err != nil {
+		return
+	}
+
+	var host HostRegistry
+	if host, err = registry.getHost(request.HostID, HostFilterNone); err != nil {
+		return
+	}
+	if !host.HasAccount(request.Account) {
+		err = fmt.Errorf("unknown account, '%s' of host, '%s'", request.Account, host.ID)
+		return
+	}
+
+	now := time.Now().UTC()
+	dateExpires := now.Add(request.GetDuration())
+
+	if link, linkErr := registry.getLink(request.UserID, host.ID); linkErr == nil {
+		switch {
+		case link.IsExpired(now):
+			if err = registry.unlinkAll(request.UserID, host.ID); err != nil {
+				return
+			}
+		case registry.isLinked(request.UserID, host.ID, request.Account) && link.AccountExpires[request.Account].IsZero():
+			err = fmt.Errorf(
+				"user, '%s' is already linked to '%s@%s'; use 'user link'",
+				request.UserID,
+				request.Account,
+				host.ID,
+			)
+			return
+		}
+	}
+
+	if err = registry.linkAccounts(request.UserID, host.ID, request.Account); err != nil {
+		return
+	}
+
+	link := registry.Data.Links[host.ID][request.UserID]
+	link.setAccountExpires(dateExpires, request.Account)
+	registry.Data.Links[host.ID][request.UserID] = link
+
+	request.Status = AccessRequestApproved
+	request.DateDecided = now
+	request.DecidedBy = decidedBy
+	request.DateExpires = dateExpires
+
+	registry.Data.AccessRequests[id] = request
+	registry.Data.updated()
+
+	return
+}
+
+// DenyAccessRequest denies the pending access request
+func (registry *Registry) DenyAccessRequest(id, decidedBy string) (request AccessRequestRegistry, err error) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	if request, err = registry.getPendingAccessRequest(id); err != nil {
+		return
+	}
+
+	request.Status = AccessRequestDenied
+	request.DateDecided = time.Now().UTC()
+	request.DecidedBy = decidedBy
+
+	registry.Data.AccessRequests[id] = request
+	registry.Data.updated()
+
+	return
+}
+
+// RemoveExpiredLinks removes the links and the accounts of links, which are
+// expired at the given time, and marks the approved access requests, which
+// are passed, as expired. The link, which has no account left, is removed.
+func (registry *Registry) RemoveExpiredLinks(t time.Time) (expired []ExpiredLink) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var changed bool
+	for hostID, links := range registry.Data.Links {
+		for userID, link := range links {
+			if link.IsExpired(t) {
+				delete(links, userID)
+				expired = append(
+					expired,
+					ExpiredLink{UserID: userID, HostID: hostID, DateExpires: link.DateExpires},
+				)
+				changed = true
+				continue
+			}
+
+			var accounts, expiredAccounts []string
+			for _, a := range link.Accounts {
+				if !link.IsAccountExpired(a, t) {
+					accounts = append(accounts, a)
+					continue
+				}
+
+				expiredAccounts = append(expiredAccounts, a)
+				expired = append(
+					expired,
+					ExpiredLink{UserID: userID, HostID: hostID, Account: a, DateExpires: link.GetDateExpires(a)},
+				)
+			}
+			if len(expiredAccounts) < 1 {
+				continue
+			}
+
+			changed = true
+			if len(accounts) < 1 && !link.All {
+				delete(links, userID)
+				continue
+			}
+
+			link.Accounts = accounts
+			link.setAccountExpires(time.Time{}, expiredAccounts...)
+			links[userID] = link
+		}
+	}
+
+	for id, r := range registry.Data.AccessRequests {
+		if r.Status != AccessRequestApproved || t.Before(r.DateExpires) {
+			continue
+		}
+
+		r.Status = AccessRequestExpired
+		registry.Data.AccessRequests[id] = r
+		changed = true
+	}
+
+	if changed {
+		registry.Data.updated()
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		return expired[i].DateExpires.Before(expired[j].DateExpires)
+	})
+
+	return
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -329,6 +330,65 @@ func TestRegistryRemoveUser(t *testing.T) {
 	}
 }
 
+func TestRegistryTOTP(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	{
+		_, _, err := registry.EnrollTOTP(saultcommon.MakeRandomString())
+		assert.Error(t, &saultcommon.UserDoesNotExistError{}, err)
+	}
+
+	var user UserRegistry
+	{
+		id := saultcommon.MakeRandomString()
+		encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+		user, _ = registry.AddUser(id, encoded)
+		assert.False(t, user.HasTOTP())
+	}
+
+	secret, codes, err := registry.EnrollTOTP(user.ID)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, secret)
+	assert.Equal(t, TOTPRecoveryCodeCount, len(codes))
+
+	{
+		user, _ = registry.GetUser(user.ID, nil, UserFilterNone)
+		assert.True(t, user.HasTOTP())
+		assert.Equal(t, secret, user.TOTPSecret)
+
+		// the plain recovery codes are not stored
+		for _, c := range user.TOTPRecoveryCodes {
+			assert.NotContains(t, codes, c)
+		}
+	}
+
+	// recovery code can be used only once
+	{
+		assert.True(t, registry.UseTOTPRecoveryCode(user.ID, strings.ToUpper(codes[0])))
+		assert.False(t, registry.UseTOTPRecoveryCode(user.ID, codes[0]))
+		assert.False(t, registry.UseTOTPRecoveryCode(user.ID, "00000-00000"))
+
+		user, _ = registry.GetUser(user.ID, nil, UserFilterNone)
+		assert.Equal(t, TOTPRecoveryCodeCount-1, len(user.TOTPRecoveryCodes))
+	}
+
+	// re-enroll replaces the secret and recovery codes
+	{
+		newSecret, _, _ := registry.EnrollTOTP(user.ID)
+		assert.NotEqual(t, secret, newSecret)
+		assert.False(t, registry.UseTOTPRecoveryCode(user.ID, codes[1]))
+	}
+
+	{
+		assert.Nil(t, registry.DisableTOTP(user.ID))
+		user, _ = registry.GetUser(user.ID, nil, UserFilterNone)
+		assert.False(t, user.HasTOTP())
+
+		err := registry.DisableTOTP(user.ID)
+		assert.Error(t, &saultcommon.UserNothingToUpdate{}, err)
+	}
+}
+
 func TestRegistryUpdateUser(t *testing.T) {
 	registry, _ := NewTestRegistryFromBytes([]byte{})
 
@@ -545,6 +605,85 @@ func TestRegistryUpdateHost(t *testing.T) {
 	}
 }
 
+func TestRegistryUpdateHostSessionTimeouts(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), []string{"ubuntu"})
+	registry.Link(user.ID, host.ID, "ubuntu")
+
+	{
+		host.IdleTimeout = "30m"
+		host.MaxDuration = "0"
+		newHost, err := registry.UpdateHost(host.ID, host)
+		assert.Nil(t, err)
+		assert.Equal(t, "30m", newHost.IdleTimeout)
+		assert.Equal(t, "0", newHost.MaxDuration)
+
+		// links are kept
+		assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+	}
+
+	{
+		host.IdleTimeout = "30x"
+		_, err := registry.UpdateHost(host.ID, host)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRegistryUpdateHostAddresses(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "10.0.0.2", uint64(22), []string{"ubuntu"})
+	assert.Equal(t, []string{"10.0.0.2:22"}, host.GetAddresses())
+
+	{
+		host.Addresses = []string{"10.0.1.2", "10.0.2.2:2222", "10.0.0.2:22"}
+		newHost, err := registry.UpdateHost(host.ID, host)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"10.0.1.2:22", "10.0.2.2:2222", "10.0.0.2:22"}, newHost.Addresses)
+
+		// the primary address is at first without duplication
+		assert.Equal(t, []string{"10.0.0.2:22", "10.0.1.2:22", "10.0.2.2:2222"}, newHost.GetAddresses())
+	}
+
+	{
+		host.Addresses = []string{"showme:::"}
+		_, err := registry.UpdateHost(host.ID, host)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestRegistryGetHostFilter(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), []string{"ubuntu"})
+
+	{
+		_, err := registry.GetHost(host.ID, HostFilterIsActive)
+		assert.Nil(t, err)
+		_, err = registry.GetHost(host.ID, HostFilterIsNotActive)
+		assert.NotNil(t, err)
+	}
+
+	host.IsActive = false
+	registry.UpdateHost(host.ID, host)
+
+	{
+		_, err := registry.GetHost(host.ID, HostFilterIsActive)
+		assert.NotNil(t, err)
+		_, err = registry.GetHost(host.ID, HostFilterIsNotActive)
+		assert.Nil(t, err)
+	}
+
+	{
+		assert.Equal(t, 0, len(registry.GetHosts(HostFilterIsActive)))
+		assert.Equal(t, 1, len(registry.GetHosts(HostFilterIsNotActive)))
+		assert.Equal(t, 1, len(registry.GetHosts(HostFilterNone)))
+	}
+}
+
 func TestRegistryRemoveHost(t *testing.T) {
 	registry, _ := NewTestRegistryFromBytes([]byte{})
 
@@ -599,6 +738,34 @@ func TestRegistryLink(t *testing.T) {
 	}
 }
 
+func TestRegistryLinkExpires(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+
+	accounts := []string{"ubuntu"}
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), accounts)
+
+	registry.Link(user.ID, host.ID, accounts[0])
+
+	link, _ := registry.GetLink(user.ID, host.ID)
+	assert.False(t, link.IsExpired(time.Now()))
+
+	{
+		link.DateExpires = time.Now().Add(time.Hour)
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, link))
+		assert.True(t, registry.IsLinked(user.ID, host.ID, accounts[0]))
+	}
+
+	{
+		link.DateExpires = time.Now().Add(-time.Second)
+		assert.Nil(t, registry.UpdateLink(user.ID, host.ID, link))
+		assert.True(t, link.IsExpired(time.Now()))
+		assert.False(t, registry.IsLinked(user.ID, host.ID, accounts[0]))
+	}
+}
+
 func TestRegistryUnlink(t *testing.T) {
 	registry, _ := NewTestRegistryFromBytes([]byte{})
 
@@ -616,6 +783,94 @@ func TestRegistryUnlink(t *testing.T) {
 	}
 }
 
+func TestRegistryUpdateLink(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+
+	accounts := []string{"ubuntu", "spike"}
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), accounts)
+
+	{
+		// not linked
+		_, err := registry.GetLink(user.ID, host.ID)
+		assert.NotNil(t, err)
+		assert.Error(t, &saultcommon.HostAndUserNotLinked{}, err)
+
+		err = registry.UpdateLink(user.ID, host.ID, LinkAccountRegistry{DisableRecording: true})
+		assert.NotNil(t, err)
+	}
+
+	registry.Link(user.ID, host.ID, accounts[0])
+
+	{
+		// accounts are not changed by UpdateLink
+		err := registry.UpdateLink(user.ID, host.ID, LinkAccountRegistry{DisableRecording: true})
+		assert.Nil(t, err)
+
+		link, err := registry.GetLink(user.ID, host.ID)
+		assert.Nil(t, err)
+		assert.True(t, link.DisableRecording)
+		assert.Equal(t, []string{accounts[0]}, link.Accounts)
+	}
+
+	{
+		// options are kept after Link and Unlink
+		registry.Link(user.ID, host.ID, accounts[1])
+		link, _ := registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.DisableRecording)
+
+		registry.Unlink(user.ID, host.ID, accounts[0])
+		link, _ = registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.DisableRecording)
+		assert.Equal(t, []string{accounts[1]}, link.Accounts)
+
+		registry.LinkAll(user.ID, host.ID)
+		link, _ = registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.DisableRecording)
+		assert.True(t, link.All)
+	}
+
+	{
+		// session timeouts
+		err := registry.UpdateLink(user.ID, host.ID, LinkAccountRegistry{IdleTimeout: "30m", MaxDuration: "0"})
+		assert.Nil(t, err)
+
+		link, _ := registry.GetLink(user.ID, host.ID)
+		assert.Equal(t, "30m", link.IdleTimeout)
+		assert.Equal(t, "0", link.MaxDuration)
+
+		err = registry.UpdateLink(user.ID, host.ID, LinkAccountRegistry{IdleTimeout: "30x"})
+		assert.NotNil(t, err)
+	}
+}
+
+func TestParseSessionTimeout(t *testing.T) {
+	{
+		d, err := ParseSessionTimeout("")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(-1), d)
+	}
+
+	{
+		d, err := ParseSessionTimeout("0")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Duration(0), d)
+	}
+
+	{
+		d, err := ParseSessionTimeout("1h30m")
+		assert.Nil(t, err)
+		assert.Equal(t, time.Minute*90, d)
+	}
+
+	{
+		_, err := ParseSessionTimeout("-1h")
+		assert.NotNil(t, err)
+	}
+}
+
 func TestRegistryToBytes(t *testing.T) {
 	registry, _ := NewTestRegistryFromBytes([]byte{})
 
@@ -758,3 +1013,304 @@ func TestRegistryLoadRegistryByTimeUpdated(t *testing.T) {
 		assert.True(t, parsed.Equal(registry.Data.TimeUpdated))
 	}
 }
+
+func TestRegistryService(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	{
+		_, err := registry.AddService("postgres", "db1", 0, nil)
+		assert.NotNil(t, err)
+
+		_, err = registry.AddService("postgres-", "db1", 5432, nil)
+		assert.IsType(t, &saultcommon.InvalidServiceNameError{}, err)
+
+		_, err = registry.AddService("postgres", "db1", 5432, map[string]string{"a=b": "c"})
+		assert.NotNil(t, err)
+	}
+
+	service, err := registry.AddService("postgres", "db1", 5432, map[string]string{"env": "prod"})
+	assert.Nil(t, err)
+	assert.Equal(t, "db1:5432", service.GetAddress())
+	assert.Equal(t, "prod", service.Labels["env"])
+
+	{
+		_, err := registry.AddService("postgres", "db2", 5432, nil)
+		assert.IsType(t, &saultcommon.ServiceExistError{}, err)
+	}
+
+	registry.AddService("grafana", "monitor", 3000, nil)
+	assert.Equal(t, 2, len(registry.GetServices()))
+	assert.Equal(t, 1, len(registry.GetServices("grafana")))
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+
+	{
+		assert.Nil(t, registry.LinkService(user.ID, "postgres"))
+		assert.Nil(t, registry.LinkService(user.ID, "grafana"))
+		assert.IsType(t, &saultcommon.ServiceDoesNotExistError{}, registry.LinkService(user.ID, "unknown"))
+
+		assert.True(t, registry.IsServiceLinked(user.ID, "postgres"))
+		assert.Equal(t, []string{"grafana", "postgres"}, registry.GetServicesOfUser(user.ID))
+
+		assert.Nil(t, registry.UnlinkService(user.ID, "grafana"))
+		assert.False(t, registry.IsServiceLinked(user.ID, "grafana"))
+	}
+
+	{
+		// the links are removed with service
+		assert.Nil(t, registry.RemoveService("postgres"))
+		assert.False(t, registry.IsServiceLinked(user.ID, "postgres"))
+		assert.Empty(t, registry.GetServicesOfUser(user.ID))
+
+		_, err := registry.GetService("postgres")
+		assert.IsType(t, &saultcommon.ServiceDoesNotExistError{}, err)
+	}
+
+	{
+		// the links follow the renamed user
+		registry.LinkService(user.ID, "grafana")
+
+		oldID := user.ID
+		user.ID = saultcommon.MakeRandomString()
+		_, err := registry.UpdateUser(oldID, user)
+		assert.Nil(t, err)
+
+		assert.True(t, registry.IsServiceLinked(user.ID, "grafana"))
+		assert.False(t, registry.IsServiceLinked(oldID, "grafana"))
+		assert.Equal(t, []string{"grafana"}, registry.GetServicesOfUser(user.ID))
+	}
+
+	{
+		// the links are removed with user
+		registry.RemoveUser(user.ID)
+		assert.False(t, registry.IsServiceLinked(user.ID, "grafana"))
+	}
+}
+
+func TestRegistryAccessRequest(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+
+	accounts := []string{"ubuntu", "root"}
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), accounts)
+
+	{
+		_, err := registry.AddAccessRequest(user.ID, host.ID, "unknown", time.Hour, "deploy")
+		assert.NotNil(t, err)
+
+		_, err = registry.AddAccessRequest(user.ID, host.ID, "ubuntu", 0, "deploy")
+		assert.NotNil(t, err)
+
+		_, err = registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour, " ")
+		assert.NotNil(t, err)
+	}
+
+	request, err := registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour*4, "deploy")
+	assert.Nil(t, err)
+	assert.Equal(t, AccessRequestPending, request.Status)
+	assert.Equal(t, time.Hour*4, request.GetDuration())
+	assert.False(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+
+	{
+		// same request is already pending
+		_, err := registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour, "deploy")
+		assert.NotNil(t, err)
+	}
+
+	assert.Equal(t, 1, len(registry.GetAccessRequests(user.ID, AccessRequestPending)))
+	assert.Equal(t, 0, len(registry.GetAccessRequests("findkeys")))
+
+	{
+		approved, err := registry.ApproveAccessRequest(request.ID, "admin")
+		assert.Nil(t, err)
+		assert.Equal(t, AccessRequestApproved, approved.Status)
+		assert.Equal(t, "admin", approved.DecidedBy)
+
+		assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+		link, _ := registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.DateExpires.IsZero())
+		assert.Equal(t, approved.DateExpires, link.GetDateExpires("ubuntu"))
+
+		// already decided
+		_, err = registry.DenyAccessRequest(request.ID, "admin")
+		assert.NotNil(t, err)
+	}
+
+	{
+		denied, _ := registry.AddAccessRequest(user.ID, host.ID, "root", time.Hour, "debug")
+		denied, err := registry.DenyAccessRequest(denied.ID, "admin")
+		assert.Nil(t, err)
+		assert.Equal(t, AccessRequestDenied, denied.Status)
+		assert.False(t, registry.IsLinked(user.ID, host.ID, "root"))
+	}
+
+	{
+		// the expired link is removed and the request is marked as expired
+		assert.Empty(t, registry.RemoveExpiredLinks(time.Now()))
+
+		expired := registry.RemoveExpiredLinks(time.Now().Add(time.Hour * 5))
+		assert.Equal(t, 1, len(expired))
+		assert.Equal(t, host.ID, expired[0].HostID)
+		assert.Equal(t, user.ID, expired[0].UserID)
+		assert.Equal(t, "ubuntu", expired[0].Account)
+
+		_, err := registry.GetLink(user.ID, host.ID)
+		assert.NotNil(t, err)
+
+		r, _ := registry.GetAccessRequest(request.ID)
+		assert.Equal(t, AccessRequestExpired, r.Status)
+	}
+
+	{
+		// the permanent account of link is not touched
+		registry.Link(user.ID, host.ID, "ubuntu")
+		r, _ := registry.AddAccessRequest(user.ID, host.ID, "root", time.Hour, "debug")
+		_, err := registry.ApproveAccessRequest(r.ID, "admin")
+		assert.Nil(t, err)
+		assert.True(t, registry.IsLinked(user.ID, host.ID, "root"))
+
+		expired := registry.RemoveExpiredLinks(time.Now().Add(time.Hour * 2))
+		assert.Equal(t, 1, len(expired))
+		assert.Equal(t, "root", expired[0].Account)
+		assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+		assert.False(t, registry.IsLinked(user.ID, host.ID, "root"))
+	}
+
+	{
+		// the expiry is kept by account
+		r, _ := registry.AddAccessRequest(user.ID, host.ID, "root", time.Hour, "debug")
+		registry.ApproveAccessRequest(r.ID, "admin")
+
+		link, _ := registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.GetDateExpires("ubuntu").IsZero())
+		assert.False(t, link.GetDateExpires("root").IsZero())
+
+		// the explicit link of account is permanent
+		registry.Link(user.ID, host.ID, "root")
+		link, _ = registry.GetLink(user.ID, host.ID)
+		assert.True(t, link.GetDateExpires("root").IsZero())
+		assert.Empty(t, registry.RemoveExpiredLinks(time.Now().Add(time.Hour*2)))
+	}
+}
+
+func TestRegistryAccessRequestExpiresByAccount(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), []string{"ubuntu", "root"})
+
+	app, _ := registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour*8, "deploy")
+	registry.ApproveAccessRequest(app.ID, "admin")
+	root, _ := registry.AddAccessRequest(user.ID, host.ID, "root", time.Hour, "debug")
+	registry.ApproveAccessRequest(root.ID, "admin")
+
+	// the longer expiry of the other account is not applied to root
+	link, _ := registry.GetLink(user.ID, host.ID)
+	assert.True(t, link.IsAccountExpired("root", time.Now().Add(time.Hour*2)))
+	assert.False(t, link.IsAccountExpired("ubuntu", time.Now().Add(time.Hour*2)))
+
+	registry.RemoveExpiredLinks(time.Now().Add(time.Hour * 2))
+	assert.False(t, registry.IsLinked(user.ID, host.ID, "root"))
+	assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+
+	// the expiry of account is saved
+	data, err := NewRegistryDataFromSource(bytesConfigRegistry{B: registry.Bytes()})
+	assert.Nil(t, err)
+	link = data.Links[host.ID][user.ID]
+	assert.Equal(t, []string{"ubuntu"}, link.Accounts)
+	assert.False(t, link.GetDateExpires("ubuntu").IsZero())
+
+	// the earlier expiry of link is used
+	link.DateExpires = time.Now().Add(time.Hour)
+	registry.UpdateLink(user.ID, host.ID, link)
+	assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+	link, _ = registry.GetLink(user.ID, host.ID)
+	assert.Equal(t, link.DateExpires, link.GetDateExpires("ubuntu"))
+}
+
+func TestRegistryAccessRequestOfUpdatedUserAndHost(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser(saultcommon.MakeRandomString(), encoded)
+	host, _ := registry.AddHost(saultcommon.MakeRandomString(), "new-server", uint64(22), []string{"ubuntu"})
+	other, _ := registry.AddHost(saultcommon.MakeRandomString(), "other-server", uint64(22), []string{"ubuntu"})
+
+	request, _ := registry.AddAccessRequest(user.ID, host.ID, "ubuntu", time.Hour, "deploy")
+	otherRequest, _ := registry.AddAccessRequest(user.ID, other.ID, "ubuntu", time.Hour, "deploy")
+
+	{
+		// the requests follow the renamed user
+		oldID := user.ID
+		user.ID = saultcommon.MakeRandomString()
+		_, err := registry.UpdateUser(oldID, user)
+		assert.Nil(t, err)
+
+		assert.Empty(t, registry.GetAccessRequests(oldID))
+		assert.Equal(t, 2, len(registry.GetAccessRequests(user.ID)))
+
+		updated, _ := registry.GetAccessRequest(request.ID)
+		assert.Equal(t, user.ID, updated.UserID)
+
+		// the approval links the renamed user
+		_, err = registry.ApproveAccessRequest(request.ID, "admin")
+		assert.Nil(t, err)
+		assert.True(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+	}
+
+	{
+		// the requests are removed with host
+		assert.Nil(t, registry.RemoveHost(host.ID))
+
+		_, err := registry.GetAccessRequest(request.ID)
+		assert.IsType(t, &saultcommon.AccessRequestDoesNotExistError{}, err)
+
+		_, err = registry.GetAccessRequest(otherRequest.ID)
+		assert.Nil(t, err)
+	}
+
+	{
+		// the requests are removed with user
+		assert.Nil(t, registry.RemoveUser(user.ID))
+
+		_, err := registry.GetAccessRequest(otherRequest.ID)
+		assert.IsType(t, &saultcommon.AccessRequestDoesNotExistError{}, err)
+		assert.Empty(t, registry.GetAccessRequests(""))
+	}
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	registry, _ := NewTestRegistryFromBytes([]byte{})
+
+	encoded, _ := saultcommon.EncodePublicKey(testRegistryGetPublicKey())
+	user, _ := registry.AddUser("spikeekips", encoded)
+	host, _ := registry.AddHost("prometeus", "prometeus.local", uint64(22), []string{"ubuntu"})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				registry.Link(user.ID, host.ID, "ubuntu")
+				registry.Unlink(user.ID, host.ID, "ubuntu")
+				registry.Save()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				registry.IsLinked(user.ID, host.ID, "ubuntu")
+				registry.GetLinksOfUser(user.ID)
+				registry.Bytes()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.False(t, registry.IsLinked(user.ID, host.ID, "ubuntu"))
+}
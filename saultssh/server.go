@@ -73,6 +73,10 @@ type ServerConfig struct {
 	// attempts.
 	AuthLogCallback func(conn ConnMetadata, method string, err error)
 
+	// BannerCallback, if present, is called and the return string is sent to
+	// the client after key exchange completed but before authentication.
+	BannerCallback func(conn ConnMetadata) string
+
 	// ServerVersion is the version identification string to announce in
 	// the public handshake.
 	// If empty, a reasonable default is used.
@@ -242,6 +246,12 @@ func isAcceptableAlgo(algo string) bool {
 	return false
 }
 
+// CheckSourceAddress checks whether the address is allowed by the
+// 'source-address' critical option of certificate.
+func CheckSourceAddress(addr net.Addr, sourceAddrs string) error {
+	return checkSourceAddress(addr, sourceAddrs)
+}
+
 func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
 	if addr == nil {
 		return errors.New("ssh: no address known for client, but source-address match required")
@@ -272,12 +282,41 @@ func checkSourceAddress(addr net.Addr, sourceAddrs string) error {
 	return fmt.Errorf("ssh: remote address %v is not allowed because of source-address restriction", addr)
 }
 
+// ServerAuthCallbacks defines the authentication callbacks, which are applied
+// to the further authentication steps after the partial success.
+type ServerAuthCallbacks struct {
+	PasswordCallback            func(conn ConnMetadata, password []byte) (*Permissions, error)
+	PublicKeyCallback           func(conn ConnMetadata, key PublicKey) (*Permissions, error)
+	KeyboardInteractiveCallback func(conn ConnMetadata, client KeyboardInteractiveChallenge) (*Permissions, error)
+}
+
+// PartialSuccessError can be returned by the authentication callbacks to
+// indicate that the authentication method succeeded, but the further
+// authentication is required, like the second factor. Only the callbacks of
+// Next are allowed for the further steps.
+type PartialSuccessError struct {
+	Next ServerAuthCallbacks
+}
+
+func (p *PartialSuccessError) Error() string {
+	return "ssh: authenticated with partial success"
+}
+
 func (s *connection) serverAuthenticate(config *ServerConfig) (*Permissions, error) {
 	sessionID := s.transport.getSessionID()
 	var cache pubKeyCache
 	var perms *Permissions
 
 	authFailures := 0
+	var displayedBanner bool
+
+	authConfig := ServerAuthCallbacks{
+		PasswordCallback:            config.PasswordCallback,
+		PublicKeyCallback:           config.PublicKeyCallback,
+		KeyboardInteractiveCallback: config.KeyboardInteractiveCallback,
+	}
+	noClientAuth := config.NoClientAuth
+	var partialSuccessUser string
 
 userAuthLoop:
 	for {
@@ -305,13 +344,30 @@ userAuthLoop:
 			return nil, errors.New("ssh: client attempted to negotiate for unknown service: " + userAuthReq.Service)
 		}
 
+		if len(partialSuccessUser) > 0 && partialSuccessUser != userAuthReq.User {
+			return nil, errors.New("ssh: client changed the user after partial success")
+		}
 		s.user = userAuthReq.User
+
+		if !displayedBanner && config.BannerCallback != nil {
+			displayedBanner = true
+			msg := config.BannerCallback(s)
+			if msg != "" {
+				bannerMsg := &userAuthBannerMsg{
+					Message: msg,
+				}
+				if err := s.transport.writePacket(Marshal(bannerMsg)); err != nil {
+					return nil, err
+				}
+			}
+		}
+
 		perms = nil
 		authErr := errors.New("no auth passed yet")
 
 		switch userAuthReq.Method {
 		case "none":
-			if config.NoClientAuth {
+			if noClientAuth {
 				authErr = nil
 			}
 
@@ -320,7 +376,7 @@ userAuthLoop:
 				authFailures--
 			}
 		case "password":
-			if config.PasswordCallback == nil {
+			if authConfig.PasswordCallback == nil {
 				authErr = errors.New("ssh: password auth not configured")
 				break
 			}
@@ -334,17 +390,17 @@ userAuthLoop:
 				return nil, parseError(msgUserAuthRequest)
 			}
 
-			perms, authErr = config.PasswordCallback(s, password)
+			perms, authErr = authConfig.PasswordCallback(s, password)
 		case "keyboard-interactive":
-			if config.KeyboardInteractiveCallback == nil {
+			if authConfig.KeyboardInteractiveCallback == nil {
 				authErr = errors.New("ssh: keyboard-interactive auth not configubred")
 				break
 			}
 
 			prompter := &sshClientKeyboardInteractive{s}
-			perms, authErr = config.KeyboardInteractiveCallback(s, prompter.Challenge)
+			perms, authErr = authConfig.KeyboardInteractiveCallback(s, prompter.Challenge)
 		case "publickey":
-			if config.PublicKeyCallback == nil {
+			if authConfig.PublicKeyCallback == nil {
 				authErr = errors.New("ssh: publickey auth not configured")
 				break
 			}
@@ -378,7 +434,7 @@ userAuthLoop:
 			if !ok {
 				candidate.user = s.user
 				candidate.pubKeyData = pubKeyData
-				candidate.perms, candidate.result = config.PublicKeyCallback(s, pubKey)
+				candidate.perms, candidate.result = authConfig.PublicKeyCallback(s, pubKey)
 				if candidate.result == nil && candidate.perms != nil && candidate.perms.CriticalOptions != nil && candidate.perms.CriticalOptions[sourceAddressCriticalOption] != "" {
 					candidate.result = checkSourceAddress(
 						s.RemoteAddr(),
@@ -395,7 +451,7 @@ userAuthLoop:
 					return nil, parseError(msgUserAuthRequest)
 				}
 
-				if candidate.result == nil {
+				if _, partialSuccess := candidate.result.(*PartialSuccessError); candidate.result == nil || partialSuccess {
 					okMsg := userAuthPubKeyOkMsg{
 						Algo:   algo,
 						PubKey: pubKeyData,
@@ -440,16 +496,25 @@ userAuthLoop:
 			break userAuthLoop
 		}
 
-		authFailures++
-
 		var failureMsg userAuthFailureMsg
-		if config.PasswordCallback != nil {
+		if partialSuccess, ok := authErr.(*PartialSuccessError); ok {
+			// the further steps are authenticated by the next callbacks
+			authConfig = partialSuccess.Next
+			noClientAuth = false
+			partialSuccessUser = s.user
+			cache = pubKeyCache{}
+			failureMsg.PartialSuccess = true
+		} else {
+			authFailures++
+		}
+
+		if authConfig.PasswordCallback != nil {
 			failureMsg.Methods = append(failureMsg.Methods, "password")
 		}
-		if config.PublicKeyCallback != nil {
+		if authConfig.PublicKeyCallback != nil {
 			failureMsg.Methods = append(failureMsg.Methods, "publickey")
 		}
-		if config.KeyboardInteractiveCallback != nil {
+		if authConfig.KeyboardInteractiveCallback != nil {
 			failureMsg.Methods = append(failureMsg.Methods, "keyboard-interactive")
 		}
 
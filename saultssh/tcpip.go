@@ -129,11 +129,61 @@ func (c *Client) ListenTCP(laddr *net.TCPAddr) (net.Listener, error) {
 	return &tcpListener{laddr, c, ch}, nil
 }
 
+// ForwardTCPIP requests the remote peer open a listening socket on addr and
+// port like ListenTCP, but the incoming forwarded-tcpip channels are not
+// accepted and passed thru as they are; it is for the proxy, which relays
+// them to the other connection. The addr is kept as requested. If port is 0,
+// the port allocated by the remote peer is returned.
+func (c *Client) ForwardTCPIP(addr string, port uint32) (uint32, <-chan NewChannel, error) {
+	m := channelForwardMsg{addr, port}
+	ok, resp, err := c.SendRequest("tcpip-forward", true, Marshal(&m))
+	if err != nil {
+		return 0, nil, err
+	}
+	if !ok {
+		return 0, nil, errors.New("ssh: tcpip-forward request denied by peer")
+	}
+
+	if port == 0 {
+		var p struct {
+			Port uint32
+		}
+		if err := Unmarshal(resp, &p); err != nil {
+			return 0, nil, err
+		}
+		port = p.Port
+	}
+
+	return port, c.forwards.addRaw(addr, port), nil
+}
+
+// CancelForwardTCPIP cancels the forward, which is requested by
+// ForwardTCPIP.
+func (c *Client) CancelForwardTCPIP(addr string, port uint32) error {
+	c.forwards.removeRaw(addr, port)
+
+	m := channelForwardMsg{addr, port}
+	ok, _, err := c.SendRequest("cancel-tcpip-forward", true, Marshal(&m))
+	if err == nil && !ok {
+		err = errors.New("ssh: cancel-tcpip-forward failed")
+	}
+	return err
+}
+
 // forwardList stores a mapping between remote
 // forward requests and the tcpListeners.
 type forwardList struct {
 	sync.Mutex
 	entries []forwardEntry
+	raw     []rawForwardEntry
+}
+
+// rawForwardEntry is the forward of ForwardTCPIP; the incoming channels are
+// matched by the requested address and port.
+type rawForwardEntry struct {
+	addr string
+	port uint32
+	c    chan NewChannel
 }
 
 // forwardEntry represents an established mapping of a laddr on a
@@ -162,6 +212,58 @@ func (l *forwardList) add(addr net.Addr) chan forward {
 	return f.c
 }
 
+func (l *forwardList) addRaw(addr string, port uint32) chan NewChannel {
+	l.Lock()
+	defer l.Unlock()
+	f := rawForwardEntry{
+		addr: addr,
+		port: port,
+		c:    make(chan NewChannel, 1),
+	}
+	l.raw = append(l.raw, f)
+	return f.c
+}
+
+func (l *forwardList) removeRaw(addr string, port uint32) {
+	l.Lock()
+	defer l.Unlock()
+	for i, f := range l.raw {
+		if f.addr == addr && f.port == port {
+			l.raw = append(l.raw[:i], l.raw[i+1:]...)
+			close(f.c)
+			return
+		}
+	}
+}
+
+// forwardRaw passes the channel to the raw forward; if the address does not
+// match, like the remote peer reports the resolved address, the forward of
+// the same port is used.
+func (l *forwardList) forwardRaw(addr string, port uint32, ch NewChannel) bool {
+	l.Lock()
+	defer l.Unlock()
+
+	var found *rawForwardEntry
+	for i, f := range l.raw {
+		if f.port != port {
+			continue
+		}
+		if f.addr == addr {
+			found = &l.raw[i]
+			break
+		}
+		if found == nil {
+			found = &l.raw[i]
+		}
+	}
+	if found == nil {
+		return false
+	}
+
+	found.c <- ch
+	return true
+}
+
 // See RFC 4254, section 7.2
 type forwardedTCPPayload struct {
 	Addr       string
@@ -197,6 +299,10 @@ func (l *forwardList) handleChannels(in <-chan NewChannel) {
 				continue
 			}
 
+			if l.forwardRaw(payload.Addr, payload.Port, ch) {
+				continue
+			}
+
 			// RFC 4254 section 7.2 specifies that incoming
 			// addresses should list the address, in string
 			// format. It is implied that this should be an IP
@@ -262,6 +368,10 @@ func (l *forwardList) closeAll() {
 		close(f.c)
 	}
 	l.entries = nil
+	for _, f := range l.raw {
+		close(f.c)
+	}
+	l.raw = nil
 }
 
 func (l *forwardList) forward(laddr, raddr net.Addr, ch NewChannel) bool {